@@ -0,0 +1,228 @@
+// Package appattesttest provides an HTTP server mimicking Apple's receipt exchange endpoint,
+// so receipt refresh pipelines can be tested end to end without reaching Apple, like
+// net/http/httptest. Receipts are issued by a testutil.Authority and only verify against its
+// roots.
+package appattesttest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jyrodrigues/appattest/receipt"
+	"github.com/jyrodrigues/appattest/testutil"
+)
+
+// Apple rejects tokens issued more than an hour ago.
+const tokenLifetime = time.Hour
+
+// TimeNow is an indirection to allow tests to replace the current time
+var TimeNow = time.Now
+
+// Response is a canned response of the server. A zero Status is 200 when Receipt is set.
+type Response struct {
+	Status int
+	// The receipt returned with a 200 response
+	Receipt []byte
+	// The Retry-After header of a 429 response, in seconds, if not zero
+	RetryAfter time.Duration
+}
+
+// Request is a request received by the server.
+type Request struct {
+	// The Authorization header
+	Token string
+	// The receipt sent for refresh, nil if the body was not base64
+	Receipt []byte
+	// The status the server responded with
+	Status int
+}
+
+// Server mimics Apple's receipt exchange endpoint. Unless a canned response is queued, it
+// answers like Apple: it responds 304 to receipts before their Not Before time and otherwise
+// returns a RECEIPT receipt for the same App ID, key and token, issued by the authority.
+type Server struct {
+	*httptest.Server
+
+	authority *testutil.Authority
+	keyID     string
+	teamID    string
+	key       *ecdsa.PublicKey
+
+	mu         sync.Mutex
+	riskMetric func(previous *receipt.Receipt) int
+	responses  []Response
+	requests   []Request
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithToken only accepts requests authenticated with an ES256 JWT of the team, signed by the
+// key with the key ID and issued in the last hour, and responds 401 to others. By default
+// the Authorization header is not checked.
+func WithToken(keyID, teamID string, key *ecdsa.PublicKey) Option {
+	return func(s *Server) {
+		s.keyID, s.teamID, s.key = keyID, teamID, key
+	}
+}
+
+// WithRiskMetric sets the risk metric of the issued receipts to the result of fn, called with
+// the receipt being refreshed. By default it is 1.
+func WithRiskMetric(fn func(previous *receipt.Receipt) int) Option {
+	return func(s *Server) {
+		s.riskMetric = fn
+	}
+}
+
+// NewServer starts a Server issuing receipts with the authority. The caller should call
+// Close when finished.
+func NewServer(authority *testutil.Authority, opts ...Option) *Server {
+	s := &Server{authority: authority}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Enqueue queues canned responses, returned in order to the next requests instead of the
+// default response.
+func (s *Server) Enqueue(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = append(s.responses, responses...)
+}
+
+// Throttle responds 429 with the Retry-After delay to the next n requests.
+func (s *Server) Throttle(n int, retryAfter time.Duration) {
+	for i := 0; i < n; i++ {
+		s.Enqueue(Response{Status: http.StatusTooManyRequests, RetryAfter: retryAfter})
+	}
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	req := Request{Token: r.Header.Get("Authorization")}
+	body, _ := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	req.Receipt, _ = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(body)))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := s.respond(r, &req)
+	req.Status = resp.Status
+	s.requests = append(s.requests, req)
+
+	if resp.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(resp.RetryAfter/time.Second)))
+	}
+	w.WriteHeader(resp.Status)
+	if resp.Status == http.StatusOK {
+		io.WriteString(w, base64.StdEncoding.EncodeToString(resp.Receipt))
+	}
+}
+
+// respond returns the response to the request, the next canned one or the default one.
+func (s *Server) respond(r *http.Request, req *Request) Response {
+	if r.Method != http.MethodPost {
+		return Response{Status: http.StatusMethodNotAllowed}
+	}
+	if s.key != nil {
+		if err := s.verifyToken(req.Token); err != nil {
+			return Response{Status: http.StatusUnauthorized}
+		}
+	}
+	if len(s.responses) > 0 {
+		resp := s.responses[0]
+		s.responses = s.responses[1:]
+		if resp.Status == 0 {
+			resp.Status = http.StatusOK
+		}
+		return resp
+	}
+
+	previous, err := receipt.Parse(req.Receipt)
+	if err != nil {
+		return Response{Status: http.StatusBadRequest}
+	}
+	now := TimeNow()
+	if now.Before(previous.NotBefore()) {
+		return Response{Status: http.StatusNotModified}
+	}
+	riskMetric := 1
+	if s.riskMetric != nil {
+		riskMetric = s.riskMetric(previous)
+	}
+	key, _ := previous.Field(receipt.FieldAttestedPublicKey)
+	refreshed, err := s.authority.Receipt(testutil.ReceiptOptions{
+		AppID:             previous.AppID(),
+		AttestedPublicKey: key,
+		Token:             previous.Token(),
+		Type:              receipt.TypeReceipt,
+		RiskMetric:        riskMetric,
+		CreationTime:      now,
+	})
+	if err != nil {
+		return Response{Status: http.StatusInternalServerError}
+	}
+	return Response{Status: http.StatusOK, Receipt: refreshed}
+}
+
+// verifyToken checks the ES256 signature, key ID, issuer and age of the JWT.
+func (s *Server) verifyToken(token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("not a JWT")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return errors.New("invalid signature encoding")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(s.key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return errors.New("invalid signature")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+	}
+	for i, v := range []interface{}{&header, &claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+	}
+	if header.Alg != "ES256" || header.Kid != s.keyID || claims.Iss != s.teamID {
+		return fmt.Errorf("token of key %q of team %q", header.Kid, claims.Iss)
+	}
+	if issuedAt := time.Unix(claims.Iat, 0); TimeNow().Sub(issuedAt) > tokenLifetime || issuedAt.After(TimeNow().Add(time.Minute)) {
+		return fmt.Errorf("token issued at %s", issuedAt)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package appattesttest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/applefraud"
+	"github.com/jyrodrigues/appattest/receipt"
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(authority,
+		WithToken("KEYID12345", "TEAMID1234", &key.PublicKey),
+		WithRiskMetric(func(previous *receipt.Receipt) int { return 7 }),
+	)
+	defer server.Close()
+	client := applefraud.NewClientWithKey("KEYID12345", "TEAMID1234", key, applefraud.WithURL(server.URL), applefraud.WithRetries(0))
+
+	previous, err := authority.Receipt(testutil.ReceiptOptions{
+		AppID:             "TEAMID1234.com.example.app",
+		AttestedPublicKey: authority.Intermediate.Raw,
+		Token:             "token",
+		CreationTime:      time.Now().AddDate(0, 0, -2),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := client.Refresh(ctx, previous)
+	if err != nil {
+		t.Fatalf("Refresh failed: %+v", err)
+	}
+	refreshed := result.Receipt
+	if risk, _ := refreshed.RiskMetric(); risk != 7 || refreshed.Token() != "token" {
+		t.Fatalf("Wrong refreshed receipt: %+v", refreshed)
+	}
+	if _, err := receipt.NewVerifier(receipt.WithRoots(authority.Roots())).Verify(refreshed.Raw); err != nil {
+		t.Fatalf("Refreshed receipt does not verify: %+v", err)
+	}
+
+	if result, err := client.Refresh(ctx, refreshed.Raw); err != nil || !result.NotModified {
+		t.Fatalf("Expected a receipt before its Not Before time not to be refreshed, got %+v", err)
+	}
+
+	server.Throttle(1, 30*time.Second)
+	var throttled *applefraud.ErrThrottled
+	if _, err := client.Refresh(ctx, previous); !errors.As(err, &throttled) || throttled.RetryAfter != 30*time.Second {
+		t.Fatalf("Expected the request to be throttled, got %+v", err)
+	}
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var verr *utils.Error
+	unauthorized := applefraud.NewClientWithKey("KEYID12345", "TEAMID1234", other, applefraud.WithURL(server.URL))
+	if _, err := unauthorized.Refresh(ctx, previous); !errors.As(err, &verr) || verr.Type != utils.ErrAppleServer.Type {
+		t.Fatalf("Expected a token of another key to be rejected, got %+v", err)
+	}
+
+	requests := server.Requests()
+	statuses := []int{http.StatusOK, http.StatusNotModified, http.StatusTooManyRequests, http.StatusUnauthorized}
+	if len(requests) != len(statuses) {
+		t.Fatalf("Expected %d requests, got %d", len(statuses), len(requests))
+	}
+	for i, req := range requests {
+		if req.Status != statuses[i] {
+			t.Errorf("Request %d: expected status %d, got %d", i, statuses[i], req.Status)
+		}
+	}
+	if string(requests[0].Receipt) != string(previous) {
+		t.Error("Wrong receipt recorded")
+	}
+}
@@ -0,0 +1,108 @@
+package applefraud
+
+import (
+	"context"
+	"sync"
+)
+
+// BulkRefreshResult aggregates the outcome of RefreshAll. Errors of single keys are only
+// counted, and reported to the error handler, so the result stays small for any number of
+// keys.
+type BulkRefreshResult struct {
+	Refreshed   int
+	NotModified int
+	// Keys without a stored receipt or whose receipt is not due for refresh
+	Skipped int
+	// Keys whose refresh failed
+	Failed int
+	// The error that stopped the run when Apple throttled the requests, such as an
+	// *ErrThrottled telling how long to wait
+	Throttled error
+	// The index of the first key ID that was not processed, len(keyIDs) once all were.
+	// Passing keyIDs[Resume:] to RefreshAll resumes an interrupted run.
+	Resume int
+}
+
+// RefreshAll refreshes the stored receipts of the key IDs that are due, like RunOnce, with
+// up to concurrency requests in flight. Requests still wait for the request delay between
+// each other. The run stops when ctx is done or when Apple throttles the requests, returning
+// the context's or the throttling error with a result telling where to resume from. Keys
+// after Resume may have been processed already and are skipped when resuming, as their
+// receipts are no longer due. The refresh and error handlers may be called concurrently.
+func (s *Scheduler) RefreshAll(ctx context.Context, keyIDs [][]byte, concurrency int) (*BulkRefreshResult, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var mu sync.Mutex
+	result := &BulkRefreshResult{Resume: len(keyIDs)}
+	unfinished := func(i int) {
+		if i < result.Resume {
+			result.Resume = i
+		}
+	}
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				outcome, err := s.refreshKeyID(runCtx, keyIDs[i])
+				mu.Lock()
+				switch {
+				case isRateLimited(err):
+					if result.Throttled == nil {
+						result.Throttled = err
+					}
+					stop()
+					unfinished(i)
+				case err != nil && runCtx.Err() != nil:
+					unfinished(i)
+				case outcome == outcomeRefreshed:
+					result.Refreshed++
+				case outcome == outcomeNotModified:
+					result.NotModified++
+				case outcome == outcomeSkipped:
+					result.Skipped++
+				default:
+					result.Failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	next := 0
+dispatch:
+	for ; next < len(keyIDs) && runCtx.Err() == nil; next++ {
+		select {
+		case indices <- next:
+		case <-runCtx.Done():
+			break dispatch
+		}
+	}
+	close(indices)
+	wg.Wait()
+	unfinished(next)
+
+	if result.Throttled != nil {
+		return result, result.Throttled
+	}
+	return result, ctx.Err()
+}
+
+// refreshKeyID refreshes the stored receipt of the key ID if there is one and it is due.
+func (s *Scheduler) refreshKeyID(ctx context.Context, keyID []byte) (refreshOutcome, error) {
+	stored, err := s.store.Load(ctx, keyID)
+	if err != nil {
+		return outcomeFailed, s.keyError(keyID, err)
+	}
+	if stored == nil {
+		return outcomeSkipped, nil
+	}
+	return s.refreshKey(ctx, keyID, *stored)
+}
@@ -0,0 +1,102 @@
+package applefraud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/receipt"
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// throttlingRefresher refreshes receipts until it was called limit times, and is throttled
+// after.
+type throttlingRefresher struct {
+	refreshed []byte
+	limit     int32
+	calls     int32
+}
+
+func (r *throttlingRefresher) Refresh(ctx context.Context, previous []byte) (*RefreshResult, error) {
+	if atomic.AddInt32(&r.calls, 1) > r.limit {
+		return nil, utils.ErrRateLimited
+	}
+	refreshed, err := receipt.Parse(r.refreshed)
+	return &RefreshResult{Receipt: refreshed}, err
+}
+
+func TestRefreshAll(t *testing.T) {
+	ctx := context.Background()
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	due, err := authority.Receipt(testutil.ReceiptOptions{CreationTime: time.Now().AddDate(0, 0, -2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := authority.Receipt(testutil.ReceiptOptions{Type: receipt.TypeReceipt, RiskMetric: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newStore := func() (*MemoryReceiptStore, [][]byte) {
+		store := NewMemoryReceiptStore()
+		var keyIDs [][]byte
+		for i := 0; i < 20; i++ {
+			keyID := []byte(fmt.Sprintf("key %d", i))
+			store.Save(ctx, keyID, StoredReceipt{Receipt: due})
+			keyIDs = append(keyIDs, keyID)
+		}
+		return store, append(keyIDs, []byte("unknown"))
+	}
+
+	t.Run("Refreshes every key", func(t *testing.T) {
+		store, keyIDs := newStore()
+		s := NewScheduler(&throttlingRefresher{refreshed: refreshed, limit: 100}, store, nil)
+		result, err := s.RefreshAll(ctx, keyIDs, 4)
+		if err != nil {
+			t.Fatalf("Run failed: %+v", err)
+		}
+		if result.Refreshed != 20 || result.Skipped != 1 || result.Failed != 0 || result.Resume != len(keyIDs) {
+			t.Fatalf("Wrong result: %+v", result)
+		}
+	})
+
+	t.Run("Resumes after throttling", func(t *testing.T) {
+		store, keyIDs := newStore()
+		s := NewScheduler(&throttlingRefresher{refreshed: refreshed, limit: 5}, store, nil)
+		result, err := s.RefreshAll(ctx, keyIDs, 4)
+		var verr *utils.Error
+		if !errors.As(err, &verr) || verr.Type != utils.ErrRateLimited.Type || result.Throttled == nil {
+			t.Fatalf("Expected the run to stop with ErrRateLimited, got %+v", err)
+		}
+		if result.Refreshed != 5 || result.Resume > 5 {
+			t.Fatalf("Wrong result: %+v", result)
+		}
+
+		s = NewScheduler(&throttlingRefresher{refreshed: refreshed, limit: 100}, store, nil)
+		if _, err := s.RefreshAll(ctx, keyIDs[result.Resume:], 4); err != nil {
+			t.Fatalf("Resumed run failed: %+v", err)
+		}
+		store.Range(ctx, func(keyID []byte, stored StoredReceipt) error {
+			if string(stored.Receipt) != string(refreshed) {
+				t.Errorf("Receipt of %s was not refreshed", keyID)
+			}
+			return nil
+		})
+	})
+
+	t.Run("Stops with the context", func(t *testing.T) {
+		store, keyIDs := newStore()
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		result, err := NewScheduler(&throttlingRefresher{refreshed: refreshed, limit: 100}, store, nil).RefreshAll(cancelled, keyIDs, 2)
+		if !errors.Is(err, context.Canceled) || result.Refreshed != 0 || result.Resume != 0 {
+			t.Fatalf("Expected nothing to be refreshed, got %+v %+v", result, err)
+		}
+	})
+}
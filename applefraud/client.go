@@ -0,0 +1,300 @@
+// Package applefraud exchanges App Attest receipts with Apple's server for refreshed receipts
+// carrying the fraud risk metric, see Apple's "Assessing Fraud Risk".
+package applefraud
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/receipt"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Endpoints of Apple's receipt exchange, for apps in the development and production App
+// Attest environments.
+const (
+	DevelopmentURL = "https://data-development.appattest.apple.com/v1/attestationData"
+	ProductionURL  = "https://data.appattest.apple.com/v1/attestationData"
+)
+
+// The largest response accepted from Apple's server.
+const maxResponseSize = 64 << 10
+
+// Apple accepts a token for an hour after it was issued.
+const tokenLifetime = time.Hour
+
+// How long before it expires a token is renewed.
+const tokenRenewal = 5 * time.Minute
+
+// The default difference allowed between the clocks of the client and Apple's server.
+const defaultClockSkew = time.Minute
+
+// Defaults of WithRetries and WithBackoff.
+const (
+	defaultRetries    = 3
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// TimeNow is an indirection to allow tests to replace the current time
+var TimeNow = time.Now
+
+// Client exchanges receipts with Apple's server, authenticating with a JWT signed by a
+// DeviceCheck private key of the team.
+type Client struct {
+	url        string
+	env        authenticator.Environment
+	keyID      string
+	teamID     string
+	key        *ecdsa.PrivateKey
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	clockSkew  time.Duration
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+	// No request is sent before this time after Apple throttled the client
+	retryAt time.Time
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithEnvironment sends requests to the endpoint of the App Attest environment the apps
+// attested their keys in: DevelopmentURL for authenticator.EnvironmentDevelopment and
+// ProductionURL, the default, otherwise.
+func WithEnvironment(env authenticator.Environment) Option {
+	return func(c *Client) {
+		c.env = env
+	}
+}
+
+// WithURL sends requests to url whatever the environment, e.g. to a test server.
+func WithURL(url string) Option {
+	return func(c *Client) {
+		c.url = url
+	}
+}
+
+// WithHTTPClient sends requests with client instead of http.DefaultClient, e.g. to go
+// through a proxy, trust a custom CA or set timeouts.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		if client != nil {
+			c.httpClient = client
+		}
+	}
+}
+
+// WithClockSkew sets the difference allowed between the clocks of the client and Apple's
+// server when dating and renewing tokens, a minute by default. The client signs a token and
+// reuses it until it is due for renewal.
+func WithClockSkew(d time.Duration) Option {
+	return func(c *Client) {
+		if d >= 0 && d < tokenLifetime/4 {
+			c.clockSkew = d
+		}
+	}
+}
+
+// NewClient returns a Client authenticating with the contents of the .p8 private key file,
+// the ID of the key and the team ID of the developer account.
+func NewClient(keyID, teamID string, p8 []byte, opts ...Option) (*Client, error) {
+	key, err := ParsePrivateKey(p8)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithKey(keyID, teamID, key, opts...), nil
+}
+
+// NewClientWithKey returns a Client like NewClient with a parsed private key.
+func NewClientWithKey(keyID, teamID string, key *ecdsa.PrivateKey, opts ...Option) *Client {
+	c := &Client{keyID: keyID, teamID: teamID, key: key, httpClient: http.DefaultClient,
+		retries: defaultRetries, backoff: defaultBackoff, maxBackoff: defaultMaxBackoff, clockSkew: defaultClockSkew}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.url == "" {
+		c.url = ProductionURL
+		if c.env == authenticator.EnvironmentDevelopment {
+			c.url = DevelopmentURL
+		}
+	}
+	return c
+}
+
+// URL returns the endpoint the client sends requests to.
+func (c *Client) URL() string {
+	return c.url
+}
+
+// RefreshResult is the outcome of a refresh.
+type RefreshResult struct {
+	// The refreshed receipt, or the previous receipt if NotModified is set
+	Receipt *receipt.Receipt
+	// Whether Apple did not issue a new receipt, because the previous one is not past its
+	// Not Before time, so the previous receipt remains current
+	NotModified bool
+}
+
+// Refresh exchanges the receipt for a new one, whose RiskMetric holds the number of
+// attestations of the device in the last 30 days. Apple only issues a new receipt once the
+// Not Before time of the previous receipt has passed, otherwise the result is NotModified. The new receipt is parsed and checked
+// to replace the previous one, see receipt.CheckRefresh, but its signature is not verified,
+// see receipt.Verifier.VerifyRefresh.
+//
+// Throttled requests and server errors are retried, see WithRetries. When Apple keeps
+// throttling the requests, an *ErrThrottled is returned, and also without sending a request
+// until the time given by Apple's Retry-After header has passed.
+func (c *Client) Refresh(ctx context.Context, previous []byte) (*RefreshResult, error) {
+	prev, err := receipt.Parse(previous)
+	if err != nil {
+		return nil, err
+	}
+	backoff := c.backoff
+	for retries := 0; ; retries++ {
+		if wait := c.throttledUntil().Sub(TimeNow()); wait > 0 {
+			if c.retries == 0 || wait > c.maxBackoff {
+				return nil, throttled(wait)
+			}
+			if err := sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+		}
+
+		r, retry, err := c.send(ctx, prev, backoff)
+		if !retry || retries >= c.retries || ctx.Err() != nil {
+			return r, err
+		}
+		var throttledErr *ErrThrottled
+		if !errors.As(err, &throttledErr) {
+			// Throttled requests wait for the Retry-After delay at the top of the loop.
+			if backoff > c.maxBackoff {
+				return nil, err
+			}
+			if err := sleep(ctx, backoff); err != nil {
+				return nil, err
+			}
+		}
+		backoff *= 2
+	}
+}
+
+// send sends a single request exchanging the receipt and reports whether it may be retried.
+// A 429 response without a Retry-After header throttles the client for backoff.
+func (c *Client) send(ctx context.Context, previous *receipt.Receipt, backoff time.Duration) (*RefreshResult, bool, error) {
+	token, err := c.authorization()
+	if err != nil {
+		return nil, false, err
+	}
+	body := base64.StdEncoding.EncodeToString(previous.Raw)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, false, utils.ErrBadRequest.WithDetails(fmt.Sprintf("Invalid receipt request: %v", err))
+	}
+	req.Header.Set("Authorization", token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	data, err := utils.ReadLimited(resp.Body, maxResponseSize)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return &RefreshResult{Receipt: previous, NotModified: true}, false, nil
+	case http.StatusTooManyRequests:
+		return nil, true, throttled(c.throttle(resp.Header.Get("Retry-After"), backoff))
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return nil, true, utils.ErrAppleServer.WithDetails(fmt.Sprintf("Apple's server responded %s: %s", resp.Status, bytes.TrimSpace(data)))
+	case http.StatusUnauthorized:
+		// The token may have expired by the server's clock, the next request signs a new one.
+		c.invalidate(token)
+		return nil, false, utils.ErrAppleServer.WithDetails(fmt.Sprintf("Apple's server responded %s: %s", resp.Status, bytes.TrimSpace(data)))
+	default:
+		return nil, false, utils.ErrAppleServer.WithDetails(fmt.Sprintf("Apple's server responded %s: %s", resp.Status, bytes.TrimSpace(data)))
+	}
+
+	refreshed, err := utils.DecodeBase64(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, false, utils.ErrParsingData.WithDetails(fmt.Sprintf("The refreshed receipt was not valid base64: %v", err))
+	}
+	r, err := receipt.Parse(refreshed)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := receipt.CheckRefresh(previous, r); err != nil {
+		return nil, false, err
+	}
+	return &RefreshResult{Receipt: r}, false, nil
+}
+
+// throttledUntil returns the time before which no request is sent.
+func (c *Client) throttledUntil() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.retryAt
+}
+
+// throttle holds back requests for the delay of the Retry-After header, in seconds or as an
+// HTTP date, or for fallback, and returns the delay.
+func (c *Client) throttle(retryAfter string, fallback time.Duration) time.Duration {
+	now := TimeNow()
+	delay := fallback
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		delay = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(retryAfter); err == nil && at.After(now) {
+		delay = at.Sub(now)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retryAt = now.Add(delay)
+	return delay
+}
+
+// authorization returns the cached JWT, signing a new one when it is due: tokens are dated
+// back by the clock skew, so a server whose clock is behind does not see them issued in the
+// future, and renewed before a server whose clock is ahead considers them expired.
+func (c *Client) authorization() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := TimeNow()
+	renewAt := c.issuedAt.Add(tokenLifetime - c.clockSkew - tokenRenewal)
+	if c.token != "" && now.Before(renewAt) && !now.Before(c.issuedAt) {
+		return c.token, nil
+	}
+	issuedAt := now.Add(-c.clockSkew)
+	token, err := signJWT(c.key, c.keyID, c.teamID, issuedAt)
+	if err != nil {
+		return "", err
+	}
+	c.token, c.issuedAt = token, issuedAt
+	return token, nil
+}
+
+// invalidate drops the cached token if it is still token, so the next request signs a new
+// one.
+func (c *Client) invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == token {
+		c.token = ""
+	}
+}
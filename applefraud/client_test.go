@@ -0,0 +1,267 @@
+package applefraud
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// verifyJWT checks the ES256 signature of the token and returns its header and claims.
+func verifyJWT(pub *ecdsa.PublicKey, token string) (header, claims map[string]interface{}, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, fmt.Errorf("invalid JWT %q", token)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return nil, nil, fmt.Errorf("invalid JWT signature %q", parts[2])
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, nil, errors.New("JWT signature does not verify")
+	}
+	for i, v := range []*map[string]interface{}{&header, &claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return nil, nil, err
+		}
+	}
+	return header, claims, nil
+}
+
+func TestRefresh(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := authority.Receipt(testutil.ReceiptOptions{AppID: "TEAMID1234.com.example.app", CreationTime: time.Now().AddDate(0, 0, -2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := authority.Receipt(testutil.ReceiptOptions{AppID: "TEAMID1234.com.example.app", Type: "RECEIPT", RiskMetric: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, response := http.StatusOK, refreshed
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header, claims, err := verifyJWT(&key.PublicKey, r.Header.Get("Authorization"))
+		if err != nil {
+			t.Error(err)
+		} else if header["alg"] != "ES256" || header["kid"] != "KEYID12345" || claims["iss"] != "TEAMID1234" {
+			t.Errorf("Wrong JWT: %v %v", header, claims)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if decoded, _ := base64.StdEncoding.DecodeString(string(body)); !bytes.Equal(decoded, previous) {
+			t.Error("Wrong receipt sent")
+		}
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "120")
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			io.WriteString(w, base64.StdEncoding.EncodeToString(response))
+		}
+	}))
+	defer server.Close()
+
+	var proxied int
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		proxied++
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	c, err := NewClient("KEYID12345", "TEAMID1234", p8, WithURL(server.URL), WithHTTPClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := c.Refresh(context.Background(), previous)
+	if err != nil {
+		t.Fatalf("Refresh failed: %+v", err)
+	}
+	if risk, ok := result.Receipt.RiskMetric(); !ok || risk != 4 || result.NotModified {
+		t.Fatalf("Wrong risk metric: %d", risk)
+	}
+	if proxied != 1 {
+		t.Fatalf("Expected the request to go through the client, got %d requests", proxied)
+	}
+
+	// The receipt of the attestation is not accepted as a refreshed receipt.
+	response = previous
+	var verr *utils.Error
+	if _, err := c.Refresh(context.Background(), previous); !errors.As(err, &verr) || verr.Type != utils.ErrReceipt.Type {
+		t.Fatalf("Expected a replayed receipt to be rejected, got %+v", err)
+	}
+	response = refreshed
+
+	status = http.StatusNotModified
+	if result, err := c.Refresh(context.Background(), previous); err != nil || !result.NotModified || !bytes.Equal(result.Receipt.Raw, previous) {
+		t.Fatalf("Expected the previous receipt to be kept, got %+v", err)
+	}
+
+	for _, test := range []struct {
+		status  int
+		errType string
+	}{
+		{http.StatusUnauthorized, utils.ErrAppleServer.Type},
+		{http.StatusTooManyRequests, utils.ErrRateLimited.Type},
+	} {
+		code, errType := test.status, test.errType
+		status = code
+		_, err := c.Refresh(context.Background(), previous)
+		var verr *utils.Error
+		if !errors.As(err, &verr) || verr.Type != errType {
+			t.Errorf("Status %d: expected %s, got %+v", code, errType, err)
+		}
+	}
+
+	// Throttled clients hold back requests until the Retry-After delay has passed.
+	status = http.StatusOK
+	if _, err := c.Refresh(context.Background(), previous); err == nil {
+		t.Fatal("Expected the throttled client to hold back the request")
+	}
+	TimeNow = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	defer func() { TimeNow = time.Now }()
+	if _, err := c.Refresh(context.Background(), previous); err != nil {
+		t.Fatalf("Refresh failed after the Retry-After delay: %+v", err)
+	}
+}
+
+func TestEnvironmentURL(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		opts []Option
+		url  string
+	}{
+		{nil, ProductionURL},
+		{[]Option{WithEnvironment(authenticator.EnvironmentProduction)}, ProductionURL},
+		{[]Option{WithEnvironment(authenticator.EnvironmentDevelopment)}, DevelopmentURL},
+		{[]Option{WithURL("http://localhost"), WithEnvironment(authenticator.EnvironmentDevelopment)}, "http://localhost"},
+	} {
+		if url := NewClientWithKey("KEYID12345", "TEAMID1234", key, test.opts...).URL(); url != test.url {
+			t.Errorf("Expected %s, got %s", test.url, url)
+		}
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	if _, err := ParsePrivateKey([]byte("not a key")); err == nil {
+		t.Fatal("Expected an error")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err == nil {
+		t.Fatal("Expected a P-384 key to be rejected")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestTokenCache(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1618394120, 0)
+	TimeNow = func() time.Time { return now }
+	defer func() { TimeNow = time.Now }()
+	c := NewClientWithKey("KEYID12345", "TEAMID1234", key)
+
+	token, err := c.authorization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, claims, err := verifyJWT(&key.PublicKey, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iat := int64(claims["iat"].(float64)); iat != now.Add(-time.Minute).Unix() {
+		t.Fatalf("Expected the token to be dated back by the clock skew, got iat %d", iat)
+	}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = c.authorization()
+		}(i)
+	}
+	wg.Wait()
+	for _, other := range tokens {
+		if other != token {
+			t.Fatal("Expected concurrent requests to reuse the token")
+		}
+	}
+
+	for _, test := range []struct {
+		elapsed time.Duration
+		renewed bool
+	}{
+		{50 * time.Minute, false},
+		{54 * time.Minute, true},
+	} {
+		now = now.Add(test.elapsed)
+		next, err := c.authorization()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if renewed := next != token; renewed != test.renewed {
+			t.Fatalf("After %s: expected renewed %v", test.elapsed, test.renewed)
+		}
+		token = next
+	}
+
+	c.invalidate(token)
+	if next, _ := c.authorization(); next == token {
+		t.Fatal("Expected an invalidated token to be renewed")
+	}
+}
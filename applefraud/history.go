@@ -0,0 +1,129 @@
+package applefraud
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// The number of days over which Apple counts the attestations of a risk metric.
+const riskMetricDays = 30
+
+// RiskMetricHistory keeps the risk metrics of each key over time, since a single metric says
+// little about how a device is used.
+type RiskMetricHistory interface {
+	// Record adds the metric of a refreshed receipt of metric.KeyID.
+	Record(ctx context.Context, metric RiskMetric) error
+	// Query returns the metrics of the key ID issued at or after since, oldest first.
+	Query(ctx context.Context, keyID []byte, since time.Time) ([]RiskMetric, error)
+}
+
+// WithRiskMetricHistory records the risk metric of every refreshed receipt in h, see
+// Scheduler.GetRiskMetricStats.
+func WithRiskMetricHistory(h RiskMetricHistory) SchedulerOption {
+	return func(s *Scheduler) {
+		s.history = h
+	}
+}
+
+// RiskMetricStats summarizes the risk metrics of a key over a period.
+type RiskMetricStats struct {
+	KeyID []byte
+	// The number of metrics recorded in the period
+	Samples int
+	// The most recent metric
+	Latest RiskMetric
+	Min    int
+	Max    int
+	Mean   float64
+	// The mean number of attestations per day, Mean over the 30 days Apple counts them for
+	DailyAverage float64
+}
+
+// GetRiskMetricStats summarizes the risk metrics of the key ID issued in the period up to
+// now. It fails with ErrNoRiskMetric if no history is recorded, see WithRiskMetricHistory,
+// or if no metric of the key ID was recorded in the period.
+func (s *Scheduler) GetRiskMetricStats(ctx context.Context, keyID []byte, period time.Duration) (*RiskMetricStats, error) {
+	if s.history == nil {
+		return nil, utils.ErrNoRiskMetric.WithDetails("No risk metric history is recorded")
+	}
+	metrics, err := s.history.Query(ctx, keyID, TimeNow().Add(-period))
+	if err != nil {
+		return nil, err
+	}
+	if len(metrics) == 0 {
+		return nil, utils.ErrNoRiskMetric.WithDetails(fmt.Sprintf("No risk metric of key ID %s was recorded in the last %s", utils.KeyIDString(keyID), period))
+	}
+
+	stats := &RiskMetricStats{KeyID: keyID, Samples: len(metrics), Latest: metrics[len(metrics)-1], Min: metrics[0].Count}
+	total := 0
+	for _, m := range metrics {
+		total += m.Count
+		if m.Count < stats.Min {
+			stats.Min = m.Count
+		}
+		if m.Count > stats.Max {
+			stats.Max = m.Count
+		}
+	}
+	stats.Mean = float64(total) / float64(len(metrics))
+	stats.DailyAverage = stats.Mean / riskMetricDays
+	return stats, nil
+}
+
+// MemoryRiskMetricHistory is a RiskMetricHistory keeping metrics in memory.
+type MemoryRiskMetricHistory struct {
+	retention time.Duration
+
+	mu      sync.Mutex
+	metrics map[string][]RiskMetric
+}
+
+// NewMemoryRiskMetricHistory returns an empty MemoryRiskMetricHistory dropping metrics
+// issued more than retention ago, or keeping them all if retention is zero.
+func NewMemoryRiskMetricHistory(retention time.Duration) *MemoryRiskMetricHistory {
+	return &MemoryRiskMetricHistory{retention: retention, metrics: make(map[string][]RiskMetric)}
+}
+
+// Record adds the metric, unless a metric issued at the same time is already recorded.
+func (h *MemoryRiskMetricHistory) Record(ctx context.Context, metric RiskMetric) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := string(metric.KeyID)
+	metrics := h.metrics[key]
+	for _, m := range metrics {
+		if m.IssuedAt.Equal(metric.IssuedAt) {
+			return nil
+		}
+	}
+	// Keep the metrics ordered by issue time, refreshes usually arrive in order.
+	i := len(metrics)
+	for i > 0 && metrics[i-1].IssuedAt.After(metric.IssuedAt) {
+		i--
+	}
+	metrics = append(metrics[:i], append([]RiskMetric{metric}, metrics[i:]...)...)
+	if h.retention > 0 {
+		cutoff := TimeNow().Add(-h.retention)
+		for len(metrics) > 0 && metrics[0].IssuedAt.Before(cutoff) {
+			metrics = metrics[1:]
+		}
+	}
+	h.metrics[key] = metrics
+	return nil
+}
+
+// Query returns the metrics of the key ID issued at or after since, oldest first.
+func (h *MemoryRiskMetricHistory) Query(ctx context.Context, keyID []byte, since time.Time) ([]RiskMetric, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var metrics []RiskMetric
+	for _, m := range h.metrics[string(keyID)] {
+		if !m.IssuedAt.Before(since) {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics, nil
+}
@@ -0,0 +1,71 @@
+package applefraud
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/receipt"
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestRiskMetricHistory(t *testing.T) {
+	ctx := context.Background()
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	TimeNow = func() time.Time { return now }
+	defer func() { TimeNow = time.Now }()
+
+	history := NewMemoryRiskMetricHistory(60 * 24 * time.Hour)
+	store := NewMemoryReceiptStore()
+	var verr *utils.Error
+	if _, err := NewScheduler(&stubRefresher{}, store, nil).GetRiskMetricStats(ctx, []byte("key"), time.Hour); !errors.As(err, &verr) || verr.Type != utils.ErrNoRiskMetric.Type {
+		t.Fatalf("Expected ErrNoRiskMetric without history, got %+v", err)
+	}
+
+	// A receipt is refreshed every day, with metrics 3, 6, 9 and 12.
+	created := now.AddDate(0, 0, -4)
+	previous, err := authority.Receipt(testutil.ReceiptOptions{CreationTime: created})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Save(ctx, []byte("key"), StoredReceipt{Receipt: previous})
+	for i := 1; i <= 4; i++ {
+		refreshed, err := authority.Receipt(testutil.ReceiptOptions{Type: receipt.TypeReceipt, RiskMetric: 3 * i, CreationTime: created.AddDate(0, 0, i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		now = created.AddDate(0, 0, i).Add(time.Minute)
+		s := NewScheduler(&stubRefresher{refreshed: refreshed}, store, nil, WithRiskMetricHistory(history))
+		if err := s.RunOnce(ctx); err != nil {
+			t.Fatalf("Pass failed: %+v", err)
+		}
+	}
+
+	s := NewScheduler(&stubRefresher{}, store, nil, WithRiskMetricHistory(history))
+	stats, err := s.GetRiskMetricStats(ctx, []byte("key"), 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("No stats: %+v", err)
+	}
+	if stats.Samples != 4 || stats.Min != 3 || stats.Max != 12 || stats.Mean != 7.5 || stats.DailyAverage != 0.25 || stats.Latest.Count != 12 {
+		t.Fatalf("Wrong stats: %+v", stats)
+	}
+	if stats, err := s.GetRiskMetricStats(ctx, []byte("key"), 36*time.Hour); err != nil || stats.Samples != 2 {
+		t.Fatalf("Expected the two metrics of the period, got %+v", stats)
+	}
+	if _, err := s.GetRiskMetricStats(ctx, []byte("other"), 30*24*time.Hour); !errors.As(err, &verr) || verr.Type != utils.ErrNoRiskMetric.Type {
+		t.Fatalf("Expected ErrNoRiskMetric for an unknown key, got %+v", err)
+	}
+
+	// Metrics past the retention are dropped.
+	now = now.AddDate(0, 0, 61)
+	history.Record(ctx, RiskMetric{KeyID: []byte("key"), Count: 1, IssuedAt: now})
+	if metrics, _ := history.Query(ctx, []byte("key"), time.Time{}); len(metrics) != 1 {
+		t.Fatalf("Expected old metrics to be dropped, got %d", len(metrics))
+	}
+}
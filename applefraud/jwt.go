@@ -0,0 +1,57 @@
+package applefraud
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// ParsePrivateKey parses the contents of a .p8 file downloaded from the developer account,
+// a PEM-encoded PKCS#8 P-256 private key.
+func ParsePrivateKey(p8 []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(p8)
+	if block == nil {
+		return nil, utils.ErrParsingData.WithDetails("Private key is not PEM-encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Invalid private key: %v", err))
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve.Params().Name != "P-256" {
+		return nil, utils.ErrParsingData.WithDetails("Private key is not a P-256 key")
+	}
+	return ecKey, nil
+}
+
+// signJWT returns an ES256 JWT issued by the team at the given time, identifying the key by
+// its key ID.
+func signJWT(key *ecdsa.PrivateKey, keyID, teamID string, issuedAt time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{"iss": teamID, "iat": issuedAt.Unix()})
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS uses the fixed-size concatenation of r and s rather than an ASN.1 signature.
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
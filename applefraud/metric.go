@@ -0,0 +1,58 @@
+package applefraud
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jyrodrigues/appattest/receipt"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// RiskMetric is the risk metric of a device, read from its latest refreshed receipt.
+type RiskMetric struct {
+	KeyID []byte
+	// The number of attestations Apple counted for the device in the last 30 days
+	Count int
+	// When Apple issued the receipt holding the metric
+	IssuedAt time.Time
+	// When the receipt can be refreshed, and so the metric updated
+	NextRefresh time.Time
+	// When the receipt was obtained from Apple's server
+	RefreshedAt time.Time
+}
+
+// GetRiskMetric returns the risk metric of the stored receipt of the key ID. It fails with
+// ErrUnknownCredential if no receipt is stored and with ErrNoRiskMetric if the receipt was not
+// refreshed yet, since only refreshed receipts have a risk metric.
+func (s *Scheduler) GetRiskMetric(ctx context.Context, keyID []byte) (*RiskMetric, error) {
+	stored, err := s.store.Load(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, utils.ErrUnknownCredential.WithDetails(fmt.Sprintf("No receipt is stored for key ID %s", utils.KeyIDString(keyID)))
+	}
+	r, err := receipt.Parse(stored.Receipt)
+	if err != nil {
+		return nil, err
+	}
+	metric, ok := newRiskMetric(keyID, r, *stored)
+	if !ok {
+		return nil, utils.ErrNoRiskMetric.WithDetails(fmt.Sprintf("The %s receipt of key ID %s has no risk metric", r.Type(), utils.KeyIDString(keyID)))
+	}
+	return metric, nil
+}
+
+// newRiskMetric returns the risk metric of the stored receipt, and false if it has none.
+func newRiskMetric(keyID []byte, r *receipt.Receipt, stored StoredReceipt) (*RiskMetric, bool) {
+	count, ok := r.RiskMetric()
+	if !ok {
+		return nil, false
+	}
+	next := r.NotBefore()
+	if stored.NextRefresh.After(next) {
+		next = stored.NextRefresh
+	}
+	return &RiskMetric{KeyID: keyID, Count: count, IssuedAt: r.CreationTime(), NextRefresh: next, RefreshedAt: stored.RefreshedAt}, true
+}
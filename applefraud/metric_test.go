@@ -0,0 +1,52 @@
+package applefraud
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/receipt"
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestGetRiskMetric(t *testing.T) {
+	ctx := context.Background()
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	refreshed, err := authority.Receipt(testutil.ReceiptOptions{Type: receipt.TypeReceipt, RiskMetric: 5, CreationTime: created})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attest, err := authority.Receipt(testutil.ReceiptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryReceiptStore()
+	store.Save(ctx, []byte("refreshed"), StoredReceipt{Receipt: refreshed})
+	store.Save(ctx, []byte("attested"), StoredReceipt{Receipt: attest})
+	s := NewScheduler(&stubRefresher{}, store, nil)
+
+	metric, err := s.GetRiskMetric(ctx, []byte("refreshed"))
+	if err != nil {
+		t.Fatalf("No risk metric: %+v", err)
+	}
+	if metric.Count != 5 || !metric.IssuedAt.Equal(created) || !metric.NextRefresh.Equal(created.Add(24*time.Hour)) {
+		t.Fatalf("Wrong risk metric: %+v", metric)
+	}
+
+	for keyID, errType := range map[string]string{
+		"attested": utils.ErrNoRiskMetric.Type,
+		"unknown":  utils.ErrUnknownCredential.Type,
+	} {
+		_, err := s.GetRiskMetric(ctx, []byte(keyID))
+		var verr *utils.Error
+		if !errors.As(err, &verr) || verr.Type != errType {
+			t.Errorf("%s: expected %s, got %+v", keyID, errType, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package applefraud
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// ErrThrottled is returned when Apple's server throttled the requests and the client gave up
+// waiting. It unwraps to an ErrRateLimited error.
+type ErrThrottled struct {
+	// How long to wait before sending requests again
+	RetryAfter time.Duration
+	err        *utils.Error
+}
+
+func throttled(wait time.Duration) *ErrThrottled {
+	retryAt := TimeNow().Add(wait)
+	return &ErrThrottled{
+		RetryAfter: wait,
+		err:        utils.ErrRateLimited.WithDetails(fmt.Sprintf("Apple's server throttled the requests until %s", retryAt.Format(time.RFC3339))),
+	}
+}
+
+func (e *ErrThrottled) Error() string {
+	return e.err.Error()
+}
+
+func (e *ErrThrottled) Unwrap() error {
+	return e.err
+}
+
+// WithRetries sets how many times a request is retried when Apple throttles it, when the
+// server fails or when it cannot be sent, 3 by default. Zero disables retries, and requests
+// held back by a previous Retry-After then fail right away.
+func WithRetries(retries int) Option {
+	return func(c *Client) {
+		if retries >= 0 {
+			c.retries = retries
+		}
+	}
+}
+
+// WithBackoff sets the delay before the first retry, doubled for every following retry, and
+// the longest delay the client waits, including the delay of Apple's Retry-After header.
+// Longer delays fail with ErrThrottled. By default the backoff starts at a second and the
+// client waits at most a minute.
+func WithBackoff(initial, maxDelay time.Duration) Option {
+	return func(c *Client) {
+		if initial > 0 {
+			c.backoff = initial
+		}
+		if maxDelay > 0 {
+			c.maxBackoff = maxDelay
+		}
+	}
+}
+
+// sleep is an indirection to allow tests to skip the waits between retries
+var sleep = sleepContext
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,114 @@
+package applefraud
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestRetry(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := authority.Receipt(testutil.ReceiptOptions{AppID: "TEAMID1234.com.example.app", CreationTime: time.Now().AddDate(0, 0, -2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := authority.Receipt(testutil.ReceiptOptions{AppID: "TEAMID1234.com.example.app", Type: "RECEIPT", RiskMetric: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statuses []int
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		requests++
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "5")
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			io.WriteString(w, base64.StdEncoding.EncodeToString(refreshed))
+		}
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	var slept []time.Duration
+	TimeNow = func() time.Time { return now }
+	sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		now = now.Add(d)
+		return nil
+	}
+	defer func() {
+		TimeNow = time.Now
+		sleep = sleepContext
+	}()
+
+	for _, test := range []struct {
+		name     string
+		opts     []Option
+		statuses []int
+		requests int
+		slept    []time.Duration
+		valid    bool
+	}{
+		{"Retries until success", nil, []int{503, 429, 200}, 3, []time.Duration{time.Second, 5 * time.Second}, true},
+		{"Backoff doubles", nil, []int{500, 502, 504, 200}, 4, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, true},
+		{"Retries are exhausted", []Option{WithRetries(2)}, []int{429}, 3, []time.Duration{5 * time.Second, 5 * time.Second}, false},
+		{"Client errors are not retried", nil, []int{401}, 1, nil, false},
+		{"Retry-After beyond the longest delay", []Option{WithBackoff(time.Second, 3*time.Second)}, []int{429}, 1, nil, false},
+		{"Retries disabled", []Option{WithRetries(0)}, []int{503}, 1, nil, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			statuses, requests, slept = test.statuses, 0, nil
+			opts := append([]Option{WithURL(server.URL)}, test.opts...)
+			c := NewClientWithKey("KEYID12345", "TEAMID1234", key, opts...)
+			_, err := c.Refresh(context.Background(), previous)
+			if (err == nil) != test.valid {
+				t.Fatalf("Expected valid %v, got %+v", test.valid, err)
+			}
+			if requests != test.requests || !reflect.DeepEqual(slept, test.slept) {
+				t.Fatalf("Expected %d requests after %v, got %d after %v", test.requests, test.slept, requests, slept)
+			}
+		})
+	}
+
+	statuses = []int{429}
+	c := NewClientWithKey("KEYID12345", "TEAMID1234", key, WithURL(server.URL), WithRetries(0))
+	_, err = c.Refresh(context.Background(), previous)
+	var throttledErr *ErrThrottled
+	if !errors.As(err, &throttledErr) || throttledErr.RetryAfter != 5*time.Second {
+		t.Fatalf("Expected ErrThrottled, got %+v", err)
+	}
+	var verr *utils.Error
+	if !errors.As(err, &verr) || verr.Type != utils.ErrRateLimited.Type {
+		t.Fatalf("Expected ErrThrottled to unwrap to ErrRateLimited, got %+v", err)
+	}
+	before := requests
+	if _, err := c.Refresh(context.Background(), previous); !errors.As(err, &throttledErr) || requests != before {
+		t.Fatalf("Expected the throttled client to hold back the request, got %+v", err)
+	}
+}
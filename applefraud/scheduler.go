@@ -0,0 +1,291 @@
+package applefraud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jyrodrigues/appattest/receipt"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// StoredReceipt is the latest receipt of an attested key.
+type StoredReceipt struct {
+	// The receipt as issued by Apple
+	Receipt []byte
+	// When the receipt was obtained from Apple's server, zero for the receipt of the
+	// attestation until it is refreshed
+	RefreshedAt time.Time
+	// The Scheduler does not refresh the receipt before this time, set when Apple did not
+	// issue a new receipt. Zero to refresh it once past its Not Before time.
+	NextRefresh time.Time
+}
+
+// ReceiptStore keeps the latest receipt of each attested key. Applications save the receipt
+// of every attestation, which the Scheduler then replaces with refreshed receipts.
+type ReceiptStore interface {
+	// Range calls fn with the key ID and receipt of every stored receipt, stopping at the
+	// first error fn returns.
+	Range(ctx context.Context, fn func(keyID []byte, stored StoredReceipt) error) error
+	// Load returns the receipt of the key ID, or nil if there is none.
+	Load(ctx context.Context, keyID []byte) (*StoredReceipt, error)
+	// Save stores the receipt of the key ID, replacing the previous one.
+	Save(ctx context.Context, keyID []byte, stored StoredReceipt) error
+}
+
+// Refresher exchanges a receipt for a new one, such as a Client.
+type Refresher interface {
+	Refresh(ctx context.Context, previous []byte) (*RefreshResult, error)
+}
+
+// RefreshFunc is called with every refreshed receipt after it was saved.
+type RefreshFunc func(ctx context.Context, keyID []byte, r *receipt.Receipt)
+
+// Scheduler refreshes the receipts of a ReceiptStore in the background, so the risk metric of
+// every device stays current.
+type Scheduler struct {
+	refresher Refresher
+	store     ReceiptStore
+	onRefresh RefreshFunc
+	onError   func(keyID []byte, err error)
+	interval  time.Duration
+	delay     time.Duration
+	history   RiskMetricHistory
+
+	mu sync.Mutex
+	// When the request delay allows the next request to Apple's server
+	nextRequest time.Time
+}
+
+// SchedulerOption configures a Scheduler.
+type SchedulerOption func(*Scheduler)
+
+// WithInterval sets the time between two passes over the store, an hour by default.
+func WithInterval(d time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.interval = d
+	}
+}
+
+// WithRequestDelay waits d between two requests to Apple's server.
+func WithRequestDelay(d time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.delay = d
+	}
+}
+
+// WithErrorHandler calls fn with the errors refreshing receipts, with a nil key ID for errors
+// not tied to a receipt, such as throttling or errors of the store.
+func WithErrorHandler(fn func(keyID []byte, err error)) SchedulerOption {
+	return func(s *Scheduler) {
+		s.onError = fn
+	}
+}
+
+// NewScheduler returns a Scheduler refreshing the receipts of the store with the refresher
+// and calling onRefresh, which may be nil, with the refreshed receipts.
+func NewScheduler(refresher Refresher, store ReceiptStore, onRefresh RefreshFunc, opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{refresher: refresher, store: store, onRefresh: onRefresh, interval: time.Hour}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Run refreshes the receipts every interval until ctx is done, and returns the context's
+// error. Errors of the passes are reported to the error handler.
+func (s *Scheduler) Run(ctx context.Context) error {
+	for {
+		s.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// RunOnce refreshes the stored receipts that are past their Not Before time. When Apple does
+// not issue a new receipt, the receipt is kept and its refresh is put off until its next Not
+// Before time, see StoredReceipt.NextRefresh. Once Apple throttles the requests the pass
+// stops with ErrRateLimited, the remaining receipts are refreshed by the next pass. Errors
+// refreshing other receipts do not stop the pass and are returned together.
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	var errs []error
+	err := s.store.Range(ctx, func(keyID []byte, stored StoredReceipt) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err := s.refreshKey(ctx, keyID, stored)
+		switch {
+		case isRateLimited(err):
+			return err
+		case err != nil:
+			errs = append(errs, err)
+		}
+		return nil
+	})
+	if err != nil {
+		if s.onError != nil && ctx.Err() == nil {
+			s.onError(nil, err)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Outcomes of refreshing the receipt of a key.
+type refreshOutcome int
+
+const (
+	// The receipt is not due for refresh
+	outcomeSkipped refreshOutcome = iota
+	outcomeRefreshed
+	outcomeNotModified
+	outcomeFailed
+)
+
+// refreshKey refreshes the stored receipt of the key ID if it is due. Errors of the key are
+// reported to the error handler and returned with the key ID, throttling errors are returned
+// as is.
+func (s *Scheduler) refreshKey(ctx context.Context, keyID []byte, stored StoredReceipt) (refreshOutcome, error) {
+	previous, err := receipt.Parse(stored.Receipt)
+	if err != nil {
+		return outcomeFailed, s.keyError(keyID, err)
+	}
+	if now := TimeNow(); now.Before(previous.NotBefore()) || now.Before(stored.NextRefresh) {
+		return outcomeSkipped, nil
+	}
+	if err := s.waitForRequest(ctx); err != nil {
+		return outcomeFailed, err
+	}
+
+	result, err := s.refresher.Refresh(ctx, stored.Receipt)
+	switch {
+	case isRateLimited(err):
+		return outcomeFailed, err
+	case err != nil:
+		return outcomeFailed, s.keyError(keyID, err)
+	case result.NotModified:
+		stored.NextRefresh = s.nextRefresh(previous)
+		if err := s.store.Save(ctx, keyID, stored); err != nil {
+			return outcomeFailed, s.keyError(keyID, err)
+		}
+		return outcomeNotModified, nil
+	}
+
+	refreshed := result.Receipt
+	stored = StoredReceipt{Receipt: refreshed.Raw, RefreshedAt: TimeNow()}
+	if err := s.store.Save(ctx, keyID, stored); err != nil {
+		return outcomeFailed, s.keyError(keyID, err)
+	}
+	if s.history != nil {
+		if metric, ok := newRiskMetric(keyID, refreshed, stored); ok {
+			if err := s.history.Record(ctx, *metric); err != nil {
+				return outcomeFailed, s.keyError(keyID, err)
+			}
+		}
+	}
+	if s.onRefresh != nil {
+		s.onRefresh(ctx, keyID, refreshed)
+	}
+	return outcomeRefreshed, nil
+}
+
+// waitForRequest waits until the request delay has passed since the previous request to
+// Apple's server, across concurrent refreshes.
+func (s *Scheduler) waitForRequest(ctx context.Context) error {
+	if s.delay <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	now := TimeNow()
+	at := s.nextRequest
+	if at.Before(now) {
+		at = now
+	}
+	s.nextRequest = at.Add(s.delay)
+	s.mu.Unlock()
+	if wait := at.Sub(now); wait > 0 {
+		return sleep(ctx, wait)
+	}
+	return nil
+}
+
+// isRateLimited reports whether err is an ErrRateLimited error, such as an ErrThrottled.
+func isRateLimited(err error) bool {
+	var verr *utils.Error
+	return errors.As(err, &verr) && verr.Type == utils.ErrRateLimited.Type
+}
+
+// nextRefresh returns when to try again to refresh a receipt Apple did not replace. Apple
+// considers the receipt not past its Not Before time, so the scheduler waits for the time
+// Apple leaves between the creation and the Not Before time of a receipt, or an interval if
+// the receipt does not tell.
+func (s *Scheduler) nextRefresh(r *receipt.Receipt) time.Time {
+	now := TimeNow()
+	if r.NotBefore().After(now) {
+		return r.NotBefore()
+	}
+	if gap := r.NotBefore().Sub(r.CreationTime()); gap > 0 {
+		return now.Add(gap)
+	}
+	return now.Add(s.interval)
+}
+
+// keyError reports the error of a key ID to the error handler and returns it with the key ID.
+func (s *Scheduler) keyError(keyID []byte, err error) error {
+	if s.onError != nil {
+		s.onError(keyID, err)
+	}
+	return fmt.Errorf("receipt of key %s: %w", utils.KeyIDString(keyID), err)
+}
+
+// MemoryReceiptStore is a ReceiptStore keeping receipts in memory.
+type MemoryReceiptStore struct {
+	mu       sync.Mutex
+	receipts map[string]StoredReceipt
+}
+
+// NewMemoryReceiptStore returns an empty MemoryReceiptStore.
+func NewMemoryReceiptStore() *MemoryReceiptStore {
+	return &MemoryReceiptStore{receipts: make(map[string]StoredReceipt)}
+}
+
+// Range calls fn with every stored receipt. The store may be modified while fn runs.
+func (s *MemoryReceiptStore) Range(ctx context.Context, fn func(keyID []byte, stored StoredReceipt) error) error {
+	s.mu.Lock()
+	snapshot := make(map[string]StoredReceipt, len(s.receipts))
+	for keyID, stored := range s.receipts {
+		snapshot[keyID] = stored
+	}
+	s.mu.Unlock()
+
+	for keyID, stored := range snapshot {
+		if err := fn([]byte(keyID), stored); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load returns the receipt of the key ID, or nil if there is none.
+func (s *MemoryReceiptStore) Load(ctx context.Context, keyID []byte) (*StoredReceipt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.receipts[string(keyID)]
+	if !ok {
+		return nil, nil
+	}
+	return &stored, nil
+}
+
+// Save stores the receipt of the key ID.
+func (s *MemoryReceiptStore) Save(ctx context.Context, keyID []byte, stored StoredReceipt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.receipts[string(keyID)] = stored
+	return nil
+}
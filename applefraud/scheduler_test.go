@@ -0,0 +1,116 @@
+package applefraud
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/receipt"
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+type stubRefresher struct {
+	refreshed   []byte
+	notModified bool
+	err         error
+	calls       int
+}
+
+func (r *stubRefresher) Refresh(ctx context.Context, previous []byte) (*RefreshResult, error) {
+	r.calls++
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.notModified {
+		prev, err := receipt.Parse(previous)
+		return &RefreshResult{Receipt: prev, NotModified: true}, err
+	}
+	refreshed, err := receipt.Parse(r.refreshed)
+	return &RefreshResult{Receipt: refreshed}, err
+}
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newReceipt := func(opts testutil.ReceiptOptions) []byte {
+		data, err := authority.Receipt(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	due := newReceipt(testutil.ReceiptOptions{CreationTime: time.Now().AddDate(0, 0, -2)})
+	notDue := newReceipt(testutil.ReceiptOptions{})
+	refreshed := newReceipt(testutil.ReceiptOptions{Type: receipt.TypeReceipt, RiskMetric: 2})
+
+	t.Run("Refreshes due receipts", func(t *testing.T) {
+		store := NewMemoryReceiptStore()
+		store.Save(ctx, []byte("due"), StoredReceipt{Receipt: due})
+		store.Save(ctx, []byte("not due"), StoredReceipt{Receipt: notDue})
+		refresher := &stubRefresher{refreshed: refreshed}
+		var updated []string
+		s := NewScheduler(refresher, store, func(ctx context.Context, keyID []byte, r *receipt.Receipt) {
+			if risk, _ := r.RiskMetric(); risk != 2 {
+				t.Errorf("Wrong risk metric %d", risk)
+			}
+			updated = append(updated, string(keyID))
+		})
+		if err := s.RunOnce(ctx); err != nil {
+			t.Fatalf("Pass failed: %+v", err)
+		}
+		if refresher.calls != 1 || len(updated) != 1 || updated[0] != "due" {
+			t.Fatalf("Expected only the due receipt to be refreshed, got %v", updated)
+		}
+		stored, err := store.Load(ctx, []byte("due"))
+		if err != nil || string(stored.Receipt) != string(refreshed) || stored.RefreshedAt.IsZero() {
+			t.Fatalf("Refreshed receipt was not saved: %+v", stored)
+		}
+		if stored, _ := store.Load(ctx, []byte("not due")); !stored.RefreshedAt.IsZero() {
+			t.Fatal("Receipt that was not refreshed has a refresh time")
+		}
+		if stored, _ := store.Load(ctx, []byte("unknown")); stored != nil {
+			t.Fatalf("Expected no receipt for an unknown key ID, got %+v", stored)
+		}
+	})
+
+	t.Run("Not modified", func(t *testing.T) {
+		store := NewMemoryReceiptStore()
+		store.Save(ctx, []byte("due"), StoredReceipt{Receipt: due})
+		refresher := &stubRefresher{notModified: true}
+		s := NewScheduler(refresher, store, nil)
+		if err := s.RunOnce(ctx); err != nil {
+			t.Fatalf("Expected a receipt that is not modified to be kept, got %+v", err)
+		}
+		stored, _ := store.Load(ctx, []byte("due"))
+		if string(stored.Receipt) != string(due) || !stored.NextRefresh.After(time.Now().Add(23*time.Hour)) {
+			t.Fatalf("Expected the refresh to be put off by a day, got %+v", stored.NextRefresh)
+		}
+		if err := s.RunOnce(ctx); err != nil || refresher.calls != 1 {
+			t.Fatalf("Expected the receipt not to be refreshed before its next refresh time, got %d calls", refresher.calls)
+		}
+	})
+
+	t.Run("Throttled", func(t *testing.T) {
+		store := NewMemoryReceiptStore()
+		store.Save(ctx, []byte("first"), StoredReceipt{Receipt: due})
+		store.Save(ctx, []byte("second"), StoredReceipt{Receipt: due})
+		refresher := &stubRefresher{err: utils.ErrRateLimited}
+		var reported []error
+		s := NewScheduler(refresher, store, nil, WithErrorHandler(func(keyID []byte, err error) {
+			reported = append(reported, err)
+		}))
+		err := s.RunOnce(ctx)
+		var verr *utils.Error
+		if !errors.As(err, &verr) || verr.Type != utils.ErrRateLimited.Type {
+			t.Fatalf("Expected the pass to stop with ErrRateLimited, got %+v", err)
+		}
+		if refresher.calls != 1 || len(reported) != 1 {
+			t.Fatalf("Expected one request and one reported error, got %d and %d", refresher.calls, len(reported))
+		}
+	})
+}
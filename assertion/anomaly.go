@@ -0,0 +1,82 @@
+package assertion
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Decisions an AnomalyDetector can take on an assertion.
+type Decision int
+
+const (
+	// DecisionAllow accepts the assertion.
+	DecisionAllow Decision = iota
+	// DecisionFlag accepts the assertion and sets AssertionResult.Flagged.
+	DecisionFlag
+	// DecisionDeny rejects the assertion with ErrAssertionDenied.
+	DecisionDeny
+)
+
+// AssertionMetadata describes a verified assertion for an AnomalyDetector. Durations are
+// zero when the credential does not record the time they are computed from.
+type AssertionMetadata struct {
+	KeyID           []byte
+	Counter         uint32
+	PreviousCounter uint32
+	// How much the counter increased since the previous assertion
+	CounterDelta uint32
+	// Time since Credential.LastUsedAt
+	SinceLastAssertion time.Duration
+	// Time since Credential.RegisteredAt
+	KeyAge time.Duration
+	Result *AssertionResult
+}
+
+// AnomalyDetector decides on assertions that passed every verification step, e.g. by
+// consulting a fraud engine. An error rejects the assertion.
+type AnomalyDetector interface {
+	Inspect(ctx context.Context, meta *AssertionMetadata) (Decision, error)
+}
+
+// WithAnomalyDetector runs the detector as the last verification step.
+func WithAnomalyDetector(d AnomalyDetector) Option {
+	return func(v *Verifier) {
+		v.anomaly = d
+	}
+}
+
+// inspect runs the anomaly detector on the result of a verified assertion.
+func (v *Verifier) inspect(ctx context.Context, cred *attestation.Credential, result *AssertionResult) error {
+	meta := &AssertionMetadata{
+		KeyID:           cred.KeyID,
+		Counter:         result.Counter,
+		PreviousCounter: cred.Counter,
+		CounterDelta:    result.Counter - cred.Counter,
+		Result:          result,
+	}
+	if !cred.LastUsedAt.IsZero() {
+		meta.SinceLastAssertion = result.VerifiedAt.Sub(cred.LastUsedAt)
+	}
+	if !cred.RegisteredAt.IsZero() {
+		meta.KeyAge = result.VerifiedAt.Sub(cred.RegisteredAt)
+	}
+
+	decision, err := v.anomaly.Inspect(ctx, meta)
+	if err != nil {
+		return err
+	}
+	switch decision {
+	case DecisionAllow:
+	case DecisionFlag:
+		result.Flagged = true
+	case DecisionDeny:
+		return utils.ErrAssertionDenied.WithDetails("Assertion was denied by the anomaly detector")
+	default:
+		return utils.ErrAssertionDenied.WithDetails(fmt.Sprintf("Unknown anomaly detector decision %d", decision))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package assertion
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+type stubDetector struct {
+	decision Decision
+	meta     *AssertionMetadata
+}
+
+func (d *stubDetector) Inspect(ctx context.Context, meta *AssertionMetadata) (Decision, error) {
+	d.meta = meta
+	return d.decision, nil
+}
+
+func TestAnomalyDetector(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	now := time.Date(2021, 4, 14, 10, 0, 0, 0, time.UTC)
+	TimeNow = func() time.Time { return now }
+	defer func() { TimeNow = time.Now }()
+
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := []byte(`{"challenge":"challenge"}`)
+	assertion, err := device.Assert(appID, clientData, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+	cred := &attestation.Credential{
+		KeyID:        device.KeyID,
+		PublicKey:    device.PublicKey(),
+		Counter:      4,
+		Algorithm:    authenticator.AlgES256,
+		RegisteredAt: now.Add(-48 * time.Hour),
+		LastUsedAt:   now.Add(-time.Minute),
+	}
+
+	t.Run("Metadata", func(t *testing.T) {
+		detector := &stubDetector{decision: DecisionAllow}
+		if _, err := NewVerifier(WithAnomalyDetector(detector)).VerifyCredential(aar, "challenge", appID, cred); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		meta := detector.meta
+		if meta.CounterDelta != 3 || meta.SinceLastAssertion != time.Minute || meta.KeyAge != 48*time.Hour {
+			t.Fatalf("Wrong metadata: %+v", meta)
+		}
+	})
+
+	t.Run("Flag", func(t *testing.T) {
+		v := NewVerifier(WithAnomalyDetector(&stubDetector{decision: DecisionFlag}))
+		result, err := v.VerifyResult(aar, "challenge", appID, 0, device.PublicKey())
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if !result.Flagged {
+			t.Fatal("Expected the result to be flagged")
+		}
+	})
+
+	t.Run("Deny", func(t *testing.T) {
+		var failed string
+		v := NewVerifier(WithAnomalyDetector(&stubDetector{decision: DecisionDeny}), WithOnStep(func(step string, ok bool, detail string) {
+			if !ok {
+				failed = step
+			}
+		}))
+		_, err := v.VerifyCredential(aar, "challenge", appID, cred)
+		var e *utils.Error
+		if !errors.As(err, &e) || e.Type != utils.ErrAssertionDenied.Type {
+			t.Fatalf("Expected the assertion to be denied, got %v", err)
+		}
+		if failed != StepAnomaly {
+			t.Fatalf("Expected step %s to fail, got %q", StepAnomaly, failed)
+		}
+	})
+}
@@ -1,17 +1,12 @@
 package assertion
 
 import (
-	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io"
 
 	"github.com/jyrodrigues/appattest/authenticator"
 	"github.com/jyrodrigues/appattest/utils"
-
-	"github.com/ugorji/go/codec"
 )
 
 type ClientData struct {
@@ -24,77 +19,87 @@ type AuthenticatorAssertionResponse struct {
 	Assertion      utils.URLEncodedBase64 `json:"assertion"`
 }
 
+// Assertion is a decoded assertion, the CBOR map generateAssertion returns.
 type Assertion struct {
 	AuthenticatorData    authenticator.AuthenticatorData
 	RawAuthenticatorData []byte `json:"authenticatorData"`
 	Signature            []byte `json:"signature"`
 }
 
-func (aar *AuthenticatorAssertionResponse) Verify(storedChallenge string, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
-	a, err := aar.parse()
+// Unmarshal decodes a CBOR-encoded assertion and its authenticator data without verifying it.
+// Both the signature and the authenticator data must be present, and no data may follow the
+// CBOR map. Apple sets the attested credential data flag on assertions, which have no attested
+// credential data, so the authenticator data is decoded with authenticator.CompatibilityApple.
+func (a *Assertion) Unmarshal(data []byte) error {
+	return a.UnmarshalWithMode(data, authenticator.CompatibilityApple)
+}
+
+// UnmarshalWithMode decodes the assertion like Unmarshal, decoding the authenticator data with
+// the given compatibility mode.
+func (a *Assertion) UnmarshalWithMode(data []byte, mode authenticator.CompatibilityMode) error {
+	var decoded Assertion
+	rest, err := utils.UnmarshalCBORFirst(data, &decoded)
 	if err != nil {
-		return 0, err
+		return utils.ErrParsingData.WithDetails(err.Error())
 	}
-
-	// 1. Compute clientDataHash as the SHA256 hash of clientData.
-	clientDataHash := sha256.Sum256(aar.RawClientData)
-
-	// 2. Concatenate authenticatorData and clientDataHash and apply a SHA256 hash over the result to form nonce.
-	nonceData := append(a.RawAuthenticatorData, clientDataHash[:]...)
-	nonce := sha256.Sum256(nonceData)
-
-	// 3. Use the public key that you stored from the attestation object to verify that the assertion’s signature is valid for nonce.
-	x, y := elliptic.Unmarshal(elliptic.P256(), publicKey)
-	if x == nil {
-		return 0, utils.ErrParsingData.WithDetails("Failed to parse the public key")
+	if len(rest) > 0 {
+		return utils.ErrParsingData.WithDetails(fmt.Sprintf("%d unexpected bytes after the assertion", len(rest)))
 	}
-	pubkey := &ecdsa.PublicKey{
-		Curve: elliptic.P256(),
-		X:     x,
-		Y:     y,
+	if len(decoded.Signature) == 0 {
+		return utils.ErrParsingData.WithDetails("Assertion has no signature")
 	}
-	nonceHash := sha256.Sum256(nonce[:])
-	valid := ecdsa.VerifyASN1(pubkey, nonceHash[:], a.Signature)
-	if !valid {
-		return 0, utils.ErrAssertionSignature.WithDetails("Error validating the assertion signature.\n")
+	if len(decoded.RawAuthenticatorData) == 0 {
+		return utils.ErrParsingData.WithDetails("Assertion has no authenticator data")
 	}
 
-	// 4. Compute the SHA256 hash of the client’s App ID, and verify that it matches the RP ID in the authenticator data.
-	rpIDHash := sha256.Sum256([]byte(relyingPartyID))
-	if !bytes.Equal(a.AuthenticatorData.RPIDHash[:], rpIDHash[:]) {
-		return 0, utils.ErrVerification.WithDetails(fmt.Sprintf("RP Hash mismatch. Expected %x and Received %x\n", a.AuthenticatorData.RPIDHash, rpIDHash))
+	err = decoded.AuthenticatorData.UnmarshalWithMode(decoded.RawAuthenticatorData, mode)
+	if err != nil {
+		return fmt.Errorf("error decoding auth data: %v", err)
 	}
+	*a = decoded
+	return nil
+}
 
-	// 5. Verify that the authenticator data’s counter value is greater than the value from the previous assertion, or greater than 0 on the first assertion.
-	if a.AuthenticatorData.Counter <= previousCounter {
-		return 0, utils.ErrVerification.WithDetails(fmt.Sprintf("Counter was not not greater than previous  %d\n", a.AuthenticatorData.Counter))
-	}
+// Verify verifies the assertion using a default Verifier and returns the new counter.
+func (aar *AuthenticatorAssertionResponse) Verify(storedChallenge string, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	return NewVerifier().Verify(aar, storedChallenge, relyingPartyID, previousCounter, publicKey)
+}
 
-	// 6. Verify that the challenge embedded in the client data matches the earlier challenge to the client.
-	if storedChallenge != aar.ClientDataJSON.Challenge {
-		err := utils.ErrChallengeMismatch.WithDetails("Error validating challenge")
-		return 0, err.WithDetails(fmt.Sprintf("Expected b Value: %#v\nReceived b: %#v\n", storedChallenge, aar.ClientDataJSON))
-	}
+// VerifyAssertion verifies a CBOR-encoded assertion using a default Verifier. See
+// Verifier.VerifyAssertion.
+func VerifyAssertion(assertion, clientData, publicKey []byte, previousCounter uint32, appID string) (*AssertionResult, error) {
+	return NewVerifier().VerifyAssertion(assertion, clientData, publicKey, previousCounter, appID)
+}
 
-	return a.AuthenticatorData.Counter, nil
+// VerifyAssertionBase64 verifies a base64-encoded assertion using a default Verifier. See
+// Verifier.VerifyBase64.
+func VerifyAssertionBase64(assertion string, clientData []byte, storedChallenge string, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	return NewVerifier().VerifyBase64(assertion, clientData, storedChallenge, relyingPartyID, previousCounter, publicKey)
 }
 
-func (aar *AuthenticatorAssertionResponse) parse() (*Assertion, error) {
-	var a Assertion
+// VerifyAssertionClientDataHash verifies a CBOR-encoded assertion against the hash of its
+// client data using a default Verifier. See Verifier.VerifyClientDataHash.
+func VerifyAssertionClientDataHash(assertion []byte, clientDataHash []byte, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	return NewVerifier().VerifyClientDataHash(assertion, clientDataHash, relyingPartyID, previousCounter, publicKey)
+}
 
-	cborHandler := codec.CborHandle{}
+// VerifyAssertionReader verifies a JSON-encoded assertion read from r using a default Verifier.
+// See Verifier.VerifyReader.
+func VerifyAssertionReader(r io.Reader, maxSize int64, storedChallenge string, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	return NewVerifier().VerifyReader(r, maxSize, storedChallenge, relyingPartyID, previousCounter, publicKey)
+}
 
-	// Decode the attestation data with unmarshalled auth data
-	err := codec.NewDecoderBytes(aar.Assertion, &cborHandler).Decode(&a)
-	if err != nil {
-		return nil, utils.ErrParsingData.WithDetails(err.Error())
+// parse decodes the assertion with the given compatibility mode and, if decodeClientData is
+// set, the JSON client data.
+func (aar *AuthenticatorAssertionResponse) parse(mode authenticator.CompatibilityMode, decodeClientData bool) (*Assertion, error) {
+	var a Assertion
+	if err := a.UnmarshalWithMode(aar.Assertion, mode); err != nil {
+		return nil, err
 	}
 
-	err = a.AuthenticatorData.Unmarshal(a.RawAuthenticatorData)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding auth data: %v", err)
+	if !decodeClientData {
+		return &a, nil
 	}
-
 	if err := json.Unmarshal(aar.RawClientData, &aar.ClientDataJSON); err != nil {
 		return nil, fmt.Errorf("error decoding client data: %v", err)
 	}
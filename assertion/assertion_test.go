@@ -1,9 +1,22 @@
 package assertion
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
+
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
 )
 
 func TestAssertionVerififcation(t *testing.T) {
@@ -24,8 +37,422 @@ func TestAssertionVerififcation(t *testing.T) {
 	})
 }
 
+func TestVerifierOnStep(t *testing.T) {
+	aar := AuthenticatorAssertionResponse{}
+	if err := json.Unmarshal([]byte(assertion), &aar); err != nil {
+		t.Fatal(err)
+	}
+	decodedPk, err := hex.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Could not decode public key: %+s", publicKey)
+	}
+
+	var steps []string
+	v := NewVerifier(WithOnStep(func(step string, ok bool, detail string) {
+		if !ok {
+			t.Errorf("Step %s failed: %s", step, detail)
+		}
+		steps = append(steps, step)
+	}))
+	if _, err := v.Verify(&aar, "assertion-test", "35MFYY2JY5.co.chiff.attestation-test", 0, decodedPk); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	expected := []string{StepParse, StepPublicKey, StepSignature, StepRPID, StepCounter, StepChallenge}
+	if strings.Join(steps, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Wrong steps: %v", steps)
+	}
+}
+
+func TestVerifyCredential(t *testing.T) {
+	decodedPk, err := hex.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Could not decode public key: %+s", publicKey)
+	}
+
+	tests := []struct {
+		name  string
+		alg   authenticator.COSEAlgorithmIdentifier
+		valid bool
+	}{
+		{"Attested algorithm", authenticator.AlgES256, true},
+		{"Mismatched algorithm", authenticator.AlgES384, false},
+		{"Unknown algorithm", -257, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aar := AuthenticatorAssertionResponse{}
+			if err := json.Unmarshal([]byte(assertion), &aar); err != nil {
+				t.Fatal(err)
+			}
+			cred := &attestation.Credential{PublicKey: decodedPk, Algorithm: tt.alg}
+			_, err := NewVerifier().VerifyCredential(&aar, "assertion-test", "35MFYY2JY5.co.chiff.attestation-test", cred)
+			if tt.valid && err != nil {
+				t.Fatalf("Not valid: %+v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("Expected an error")
+			}
+		})
+	}
+}
+
 const publicKey = "0437c404fa2bbf8fbcf4ee7080573d5fa80c4f6cc3a22f7db43af92c394e7cd1c880c95ab422972625e8e673af1bda2b096654e9b602895601f925bb5941c53082"
 const assertion = `{ 
 	"assertion": "omlzaWduYXR1cmVYRzBFAiEAyC5S3pcvtSpmTfNSd8aJRJCQ6PbN7Dnv_oPkZNMLeIwCIBmxCHXKYyGswzp_LwOxoL18puHooxudXWqDgtTvRomdcWF1dGhlbnRpY2F0b3JEYXRhWCV87ytV2nJBCLqRJ5b2df8AvnHVLa4mj6aI00ym0n9wdEAAAAAD",
 	"clientData": "eyJjaGFsbGVuZ2UiOiJhc3NlcnRpb24tdGVzdCJ9"
 }`
+
+func TestClientDataModes(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newResponse := func(clientData []byte) *AuthenticatorAssertionResponse {
+		assertion, err := device.Assert(appID, clientData, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+	}
+
+	t.Run("JSON client data", func(t *testing.T) {
+		aar := newResponse([]byte(`{"challenge":"json-challenge"}`))
+		if _, err := NewVerifier().Verify(aar, "json-challenge", appID, 0, device.PublicKey()); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if _, err := NewVerifier(WithRawChallenge()).Verify(aar, "json-challenge", appID, 0, device.PublicKey()); err == nil {
+			t.Fatal("Expected the JSON document not to match the raw challenge")
+		}
+	})
+
+	t.Run("Raw challenge", func(t *testing.T) {
+		aar := newResponse([]byte("raw-challenge"))
+		if _, err := NewVerifier(WithRawChallenge()).Verify(aar, "raw-challenge", appID, 0, device.PublicKey()); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if _, err := NewVerifier(WithRawChallenge()).Verify(aar, "other-challenge", appID, 0, device.PublicKey()); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if _, err := NewVerifier().Verify(aar, "raw-challenge", appID, 0, device.PublicKey()); err == nil {
+			t.Fatal("Expected the raw challenge to be rejected as JSON client data")
+		}
+	})
+
+	t.Run("Challenge field", func(t *testing.T) {
+		aar := newResponse([]byte(`{"nonce":"nonce-challenge","challenge":"other"}`))
+		v := NewVerifier(WithChallengeField("nonce"))
+		if _, err := v.Verify(aar, "nonce-challenge", appID, 0, device.PublicKey()); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if _, err := v.Verify(aar, "other", appID, 0, device.PublicKey()); err == nil {
+			t.Fatal("Expected the challenge field to be ignored")
+		}
+		for _, clientData := range []string{`{"challenge":"nonce-challenge"}`, `{"nonce":1}`, `nonce`} {
+			if _, err := v.Verify(newResponse([]byte(clientData)), "nonce-challenge", appID, 0, device.PublicKey()); err == nil {
+				t.Fatalf("Expected an error for %s", clientData)
+			}
+		}
+	})
+
+	t.Run("Raw client data", func(t *testing.T) {
+		clientData := []byte("POST|/transfer|amount=10&note=raw-bytes|raw-bytes")
+		aar := newResponse(clientData)
+		v := NewVerifier()
+		if _, err := v.VerifyRawClientData(aar.Assertion, clientData, []byte("raw-bytes"), appID, 0, device.PublicKey()); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if _, err := v.VerifyRawClientData(aar.Assertion, clientData, []byte("other-bytes"), appID, 0, device.PublicKey()); err == nil {
+			t.Fatal("Expected a different challenge to be rejected")
+		}
+		for _, challenge := range []string{"amount=10", "POST", "/transfer", "raw-byte", "x" + string(clientData)} {
+			if _, err := v.VerifyRawClientData(aar.Assertion, clientData, []byte(challenge), appID, 0, device.PublicKey()); err == nil {
+				t.Fatalf("Expected challenge %q, which does not end the client data, to be rejected", challenge)
+			}
+		}
+		if _, err := v.VerifyRawClientData(aar.Assertion, clientData, nil, appID, 0, device.PublicKey()); err == nil {
+			t.Fatal("Expected an empty challenge to be rejected")
+		}
+	})
+
+	t.Run("Challenge extractor", func(t *testing.T) {
+		aar := newResponse([]byte("v1:extracted"))
+		v := NewVerifier(WithChallengeExtractor(func(clientData []byte) (string, error) {
+			return strings.TrimPrefix(string(clientData), "v1:"), nil
+		}))
+		if _, err := v.Verify(aar, "extracted", appID, 0, device.PublicKey()); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+	})
+}
+
+func TestSignatureCoversRawAuthenticatorData(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Extensions {"b": 1, "a": 2} with keys out of canonical order and 1 in a non-minimal encoding.
+	extensions := []byte{0xa2, 0x61, 'b', 0x18, 0x01, 0x61, 'a', 0x02}
+	var decoded map[string]interface{}
+	if err := utils.UnmarshalCBOR(extensions, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	reencoded, err := utils.MarshalCBOR(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(reencoded, extensions) {
+		t.Fatal("Re-encoding the extensions should change their bytes")
+	}
+
+	authData := append(testutil.AuthData(appID, byte(authenticator.FlagHasExtensions), 1), extensions...)
+	clientData := []byte(`{"challenge":"raw-auth-data"}`)
+	clientDataHash := sha256.Sum256(clientData)
+	nonce := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	assertion, err := device.AssertNonce(authData, nonce[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+	if _, err := NewVerifier().Verify(aar, "raw-auth-data", appID, 0, device.PublicKey()); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	aar := AuthenticatorAssertionResponse{}
+	if err := json.Unmarshal([]byte(assertion), &aar); err != nil {
+		t.Fatal(err)
+	}
+	decodedPk, err := hex.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Could not decode public key: %+s", publicKey)
+	}
+	appID := "35MFYY2JY5.co.chiff.attestation-test"
+
+	result, err := VerifyAssertion(aar.Assertion, aar.RawClientData, decodedPk, 0, appID)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if _, err := VerifyAssertion(aar.Assertion, aar.RawClientData, decodedPk, result.Counter, appID); err == nil {
+		t.Fatal("Expected a counter that did not increase to be rejected")
+	}
+	if _, err := VerifyAssertion(aar.Assertion, aar.RawClientData, decodedPk, 0, "wrong.app.id"); err == nil {
+		t.Fatal("Expected a wrong App ID to be rejected")
+	}
+	if _, err := VerifyAssertion(aar.Assertion, []byte(`{"challenge":"other"}`), decodedPk, 0, appID); err == nil {
+		t.Fatal("Expected other client data to be rejected")
+	}
+}
+
+func TestAssertionUnmarshal(t *testing.T) {
+	aar := AuthenticatorAssertionResponse{}
+	if err := json.Unmarshal([]byte(assertion), &aar); err != nil {
+		t.Fatal(err)
+	}
+
+	var a Assertion
+	if err := a.Unmarshal(aar.Assertion); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if a.AuthenticatorData.Counter != 3 || len(a.Signature) == 0 {
+		t.Fatalf("Wrong assertion: %+v", a)
+	}
+
+	noSignature, err := utils.MarshalCBOR(map[string][]byte{"authenticatorData": a.RawAuthenticatorData})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"No signature":   noSignature,
+		"Trailing bytes": append(append([]byte{}, aar.Assertion...), 0x00),
+		"Not CBOR":       []byte("assertion"),
+	} {
+		if err := new(Assertion).Unmarshal(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestATFlagMode(t *testing.T) {
+	aar := AuthenticatorAssertionResponse{}
+	if err := json.Unmarshal([]byte(assertion), &aar); err != nil {
+		t.Fatal(err)
+	}
+	decodedPk, err := hex.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Could not decode public key: %+s", publicKey)
+	}
+	appID := "35MFYY2JY5.co.chiff.attestation-test"
+
+	result, err := NewVerifier().VerifyAssertion(aar.Assertion, aar.RawClientData, decodedPk, 0, appID)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if !result.ATFlagQuirk {
+		t.Fatal("Expected the AT flag quirk of Apple's assertion to be reported")
+	}
+	if _, err := NewVerifier(WithATFlagMode(ATFlagStrict)).VerifyAssertion(aar.Assertion, aar.RawClientData, decodedPk, 0, appID); err == nil {
+		t.Fatal("Expected the AT flag without attested credential data to be rejected in strict mode")
+	}
+
+	const testAppID = "TEAMID1234.com.example.app"
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := []byte(`{"challenge":"challenge"}`)
+	// An assertion as the specification describes it, without the AT flag.
+	authData := testutil.AuthData(testAppID, 0x01, 1)
+	clientDataHash := sha256.Sum256(clientData)
+	nonce := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signed, err := device.AssertNonce(authData, nonce[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = NewVerifier(WithATFlagMode(ATFlagStrict)).VerifyAssertion(signed, clientData, device.PublicKey(), 0, testAppID)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if result.ATFlagQuirk {
+		t.Fatal("Reported the AT flag quirk on an assertion without the AT flag")
+	}
+}
+
+func TestWithHash(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := []byte(`{"challenge":"challenge"}`)
+	authData := testutil.AuthData(appID, 0x40, 1)
+	clientDataHash := sha512.Sum384(clientData)
+	nonce := sha512.Sum384(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signed, err := device.AssertNonce(authData, nonce[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := NewVerifier(WithHash(crypto.SHA384))
+	if _, err := v.VerifyAssertion(signed, clientData, device.PublicKey(), 0, appID); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if _, err := v.VerifyClientDataHash(signed, clientDataHash[:], appID, 0, device.PublicKey()); err != nil {
+		t.Fatalf("Not valid with the client data hash: %+v", err)
+	}
+	if _, err := NewVerifier().VerifyAssertion(signed, clientData, device.PublicKey(), 0, appID); err == nil {
+		t.Fatal("Expected the SHA384 nonce to be rejected with SHA256")
+	}
+	if _, err := NewVerifier(WithHash(crypto.MD4)).VerifyAssertion(signed, clientData, device.PublicKey(), 0, appID); err == nil {
+		t.Fatal("Expected an unavailable hash function to be rejected")
+	}
+}
+
+func TestVerifyAssertionBase64(t *testing.T) {
+	var fields map[string]string
+	if err := json.Unmarshal([]byte(assertion), &fields); err != nil {
+		t.Fatal(err)
+	}
+	clientData, err := base64.RawURLEncoding.DecodeString(fields["clientData"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPk, err := hex.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Could not decode public key: %+s", publicKey)
+	}
+
+	urlSafe := fields["assertion"]
+	std := strings.NewReplacer("-", "+", "_", "/").Replace(urlSafe) + "="
+	for _, encoded := range []string{urlSafe, std} {
+		counter, err := VerifyAssertionBase64(encoded, clientData, "assertion-test", "35MFYY2JY5.co.chiff.attestation-test", 0, decodedPk)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if counter != 3 {
+			t.Fatalf("Wrong counter: %d", counter)
+		}
+	}
+	if _, err := VerifyAssertionBase64("not base64!", clientData, "assertion-test", "35MFYY2JY5.co.chiff.attestation-test", 0, decodedPk); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestVerifyAssertionReader(t *testing.T) {
+	decodedPk, err := hex.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Could not decode public key: %+s", publicKey)
+	}
+	appID := "35MFYY2JY5.co.chiff.attestation-test"
+	if _, err := VerifyAssertionReader(strings.NewReader(assertion), 1024, "assertion-test", appID, 0, decodedPk); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if _, err := VerifyAssertionReader(strings.NewReader(assertion), 64, "assertion-test", appID, 0, decodedPk); err == nil {
+		t.Fatal("Expected oversized input to be rejected")
+	}
+}
+
+func TestVerifyContext(t *testing.T) {
+	aar := AuthenticatorAssertionResponse{}
+	if err := json.Unmarshal([]byte(assertion), &aar); err != nil {
+		t.Fatal(err)
+	}
+	decodedPk, err := hex.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Could not decode public key: %+s", publicKey)
+	}
+	appID := "35MFYY2JY5.co.chiff.attestation-test"
+	if _, err := NewVerifier().VerifyContext(context.Background(), &aar, "assertion-test", appID, 0, decodedPk); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewVerifier().VerifyContext(ctx, &aar, "assertion-test", appID, 0, decodedPk); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestVerifyClientDataHash(t *testing.T) {
+	aar := AuthenticatorAssertionResponse{}
+	if err := json.Unmarshal([]byte(assertion), &aar); err != nil {
+		t.Fatal(err)
+	}
+	decodedPk, err := hex.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Could not decode public key: %+s", publicKey)
+	}
+	appID := "35MFYY2JY5.co.chiff.attestation-test"
+	clientDataHash := sha256.Sum256(aar.RawClientData)
+	if _, err := VerifyAssertionClientDataHash(aar.Assertion, clientDataHash[:], appID, 0, decodedPk); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+
+	otherHash := sha256.Sum256([]byte(`{"challenge":"other"}`))
+	if _, err := VerifyAssertionClientDataHash(aar.Assertion, otherHash[:], appID, 0, decodedPk); err == nil {
+		t.Fatal("Expected the signature not to match another client data hash")
+	}
+	if _, err := VerifyAssertionClientDataHash(aar.Assertion, aar.RawClientData, appID, 0, decodedPk); err == nil {
+		t.Fatal("Expected client data that is not a hash to be rejected")
+	}
+}
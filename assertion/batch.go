@@ -0,0 +1,40 @@
+package assertion
+
+import "context"
+
+// AssertionInput is an assertion of a batch with the client data it signs.
+type AssertionInput struct {
+	Assertion  []byte
+	ClientData []byte
+}
+
+// BatchResult is the outcome of verifying one assertion of a batch. Result may be set when
+// Err is, see VerifyResult.
+type BatchResult struct {
+	Result *AssertionResult
+	Err    error
+}
+
+// VerifyAssertions verifies a batch of assertions signed by the same key, such as assertions
+// made offline and uploaded together, in order. Each assertion is verified like
+// VerifyAssertion against the counter of the last accepted assertion of the batch, starting
+// from previousCounter, so counters must increase across the batch. A failing assertion does
+// not stop the batch. It returns a result per input and the counter to store.
+func (v *Verifier) VerifyAssertions(inputs []AssertionInput, publicKey []byte, previousCounter uint32, appID string) ([]BatchResult, uint32) {
+	return v.VerifyAssertionsContext(context.Background(), inputs, publicKey, previousCounter, appID)
+}
+
+// VerifyAssertionsContext is like VerifyAssertions, see VerifyContext. Assertions that are
+// not verified when ctx is done fail with the context's error.
+func (v *Verifier) VerifyAssertionsContext(ctx context.Context, inputs []AssertionInput, publicKey []byte, previousCounter uint32, appID string) ([]BatchResult, uint32) {
+	results := make([]BatchResult, len(inputs))
+	counter := previousCounter
+	for i, in := range inputs {
+		result, err := v.VerifyAssertionContext(ctx, in.Assertion, in.ClientData, publicKey, counter, appID)
+		results[i] = BatchResult{Result: result, Err: err}
+		if err == nil {
+			counter = result.Counter
+		}
+	}
+	return results, counter
+}
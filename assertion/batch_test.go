@@ -0,0 +1,46 @@
+package assertion
+
+import (
+	"testing"
+
+	"github.com/jyrodrigues/appattest/testutil"
+)
+
+func TestVerifyAssertions(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := []byte(`{"challenge":"offline"}`)
+	var inputs []AssertionInput
+	for _, counter := range []uint32{2, 3, 3, 1, 5} {
+		assertion, err := device.Assert(appID, clientData, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, AssertionInput{Assertion: assertion, ClientData: clientData})
+	}
+	// A tampered item fails on its own.
+	inputs[4].ClientData = []byte(`{"challenge":"tampered"}`)
+
+	results, counter := NewVerifier().VerifyAssertions(inputs, device.PublicKey(), 1, appID)
+	if len(results) != len(inputs) {
+		t.Fatalf("Expected %d results, got %d", len(inputs), len(results))
+	}
+	for i, valid := range []bool{true, true, false, false, false} {
+		if (results[i].Err == nil) != valid {
+			t.Errorf("Item %d: expected valid %v, got %v", i, valid, results[i].Err)
+		}
+	}
+	if !results[2].Result.PossiblyCloned {
+		t.Error("Expected the repeated counter to be flagged")
+	}
+	if counter != 3 {
+		t.Fatalf("Wrong counter: %d", counter)
+	}
+}
@@ -0,0 +1,42 @@
+package assertion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// ClientDataValidator enforces app-specific invariants on the client data of an assertion,
+// such as the user ID, a request timestamp or the API version. It receives the decoded JSON
+// client data, with numbers as json.Number. An error rejects the assertion.
+type ClientDataValidator func(ctx context.Context, clientData map[string]interface{}) error
+
+// WithClientDataValidator runs fn on the client data of assertions whose signature, App ID,
+// counter and challenge were verified, so the client data it sees is authentic. The client data
+// must then be a JSON object, and assertions verified with a client data hash only, see
+// VerifyClientDataHash, are rejected as there is no client data to validate.
+func WithClientDataValidator(fn ClientDataValidator) Option {
+	return func(v *Verifier) {
+		v.validate = fn
+	}
+}
+
+// validateClientData decodes the client data and runs the validator on it.
+func (v *Verifier) validateClientData(ctx context.Context, clientData []byte) error {
+	if len(clientData) == 0 {
+		return utils.ErrBadRequest.WithDetails("Missing client data to validate")
+	}
+	dec := json.NewDecoder(bytes.NewReader(clientData))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil {
+		return utils.ErrParsingData.WithDetails(fmt.Sprintf("Error decoding client data: %v", err))
+	}
+	if fields == nil {
+		return utils.ErrParsingData.WithDetails("Client data is not a JSON object")
+	}
+	return v.validate(ctx, fields)
+}
@@ -0,0 +1,52 @@
+package assertion
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/jyrodrigues/appattest/testutil"
+)
+
+type userKey struct{}
+
+func TestClientDataValidator(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := []byte(`{"challenge":"challenge","userId":"alice","version":2}`)
+	assertion, err := device.Assert(appID, clientData, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+	errWrongUser := errors.New("wrong user")
+	v := NewVerifier(WithClientDataValidator(func(ctx context.Context, clientData map[string]interface{}) error {
+		if clientData["userId"] != ctx.Value(userKey{}) || clientData["version"] != json.Number("2") {
+			return errWrongUser
+		}
+		return nil
+	}))
+
+	ctx := context.WithValue(context.Background(), userKey{}, "alice")
+	if _, err := v.VerifyResultContext(ctx, aar, "challenge", appID, 0, device.PublicKey()); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	ctx = context.WithValue(context.Background(), userKey{}, "bob")
+	if _, err := v.VerifyResultContext(ctx, aar, "challenge", appID, 0, device.PublicKey()); !errors.Is(err, errWrongUser) {
+		t.Fatalf("Expected the validator's error, got %v", err)
+	}
+
+	clientDataHash := sha256.Sum256(clientData)
+	if _, err := v.VerifyClientDataHash(assertion, clientDataHash[:], appID, 0, device.PublicKey()); err == nil {
+		t.Fatal("Expected an assertion without client data to be rejected")
+	}
+}
@@ -0,0 +1,89 @@
+package assertion
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// CounterStore keeps the counter of the last accepted assertion per key ID.
+type CounterStore interface {
+	// Get returns the stored counter of the key ID, or 0 if none is stored.
+	Get(ctx context.Context, keyID []byte) (uint32, error)
+	// CompareAndSet atomically stores next if the stored counter is still prev, where 0 also
+	// matches a key ID without a counter, and reports whether it did.
+	CompareAndSet(ctx context.Context, keyID []byte, prev, next uint32) (bool, error)
+}
+
+// VerifyCounted verifies the assertion like VerifyAssertion, against the counter stored for
+// the key ID, and stores its counter. The counter must be strictly greater than the stored
+// one when it is stored: of concurrent assertions with the same counter only one is accepted,
+// and an assertion is rejected if one with a higher counter was accepted in the meantime.
+// With a replay cache that can be queried without recording, such as an LRUReplayCache, a
+// cached assertion is rejected before the counter store is consulted.
+func (v *Verifier) VerifyCounted(ctx context.Context, counters CounterStore, keyID, assertion, clientData, publicKey []byte, appID string) (*AssertionResult, error) {
+	if assertionHash := sha256.Sum256(assertion); v.contains(assertionHash[:]) {
+		err := utils.ErrAssertionReplay.WithDetails("Assertion was already used")
+		return &AssertionResult{PossiblyCloned: true, CloneReason: CloneReasonReplay}, v.step(StepReplay, err)
+	}
+
+	prev, err := counters.Get(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	result, err := v.VerifyAssertionContext(ctx, assertion, clientData, publicKey, prev, appID)
+	if err != nil {
+		return result, err
+	}
+	counter := result.Counter
+
+	for {
+		swapped, err := counters.CompareAndSet(ctx, keyID, prev, counter)
+		if err != nil {
+			return nil, err
+		}
+		if swapped {
+			return result, nil
+		}
+		// Another assertion was accepted since Get, check against its counter.
+		if prev, err = counters.Get(ctx, keyID); err != nil {
+			return nil, err
+		}
+		if counter <= prev {
+			result.PossiblyCloned, result.CloneReason = true, CloneReasonCounter
+			return result, utils.ErrVerification.WithDetails(fmt.Sprintf("Counter %d was not greater than the stored counter %d", counter, prev))
+		}
+	}
+}
+
+// MemoryCounterStore is a CounterStore keeping counters in memory.
+type MemoryCounterStore struct {
+	mu       sync.Mutex
+	counters map[string]uint32
+}
+
+// NewMemoryCounterStore returns an empty MemoryCounterStore.
+func NewMemoryCounterStore() *MemoryCounterStore {
+	return &MemoryCounterStore{counters: make(map[string]uint32)}
+}
+
+// Get returns the stored counter of the key ID, or 0 if none is stored.
+func (s *MemoryCounterStore) Get(ctx context.Context, keyID []byte) (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counters[string(keyID)], nil
+}
+
+// CompareAndSet stores next if the stored counter is prev and reports whether it did.
+func (s *MemoryCounterStore) CompareAndSet(ctx context.Context, keyID []byte, prev, next uint32) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counters[string(keyID)] != prev {
+		return false, nil
+	}
+	s.counters[string(keyID)] = next
+	return true, nil
+}
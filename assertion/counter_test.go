@@ -0,0 +1,97 @@
+package assertion
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/testutil"
+)
+
+func TestVerifyCounted(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	ctx := context.Background()
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := []byte(`{"challenge":"challenge"}`)
+	assert := func(counter uint32) []byte {
+		assertion, err := device.Assert(appID, clientData, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return assertion
+	}
+
+	t.Run("Counter increases", func(t *testing.T) {
+		counters := NewMemoryCounterStore()
+		for _, c := range []struct {
+			counter uint32
+			valid   bool
+		}{{1, true}, {1, false}, {3, true}, {2, false}} {
+			_, err := NewVerifier().VerifyCounted(ctx, counters, device.KeyID, assert(c.counter), clientData, device.PublicKey(), appID)
+			if (err == nil) != c.valid {
+				t.Fatalf("Counter %d: expected valid %v, got %v", c.counter, c.valid, err)
+			}
+		}
+		if stored, _ := counters.Get(ctx, device.KeyID); stored != 3 {
+			t.Fatalf("Wrong stored counter: %d", stored)
+		}
+	})
+
+	t.Run("Concurrent assertions", func(t *testing.T) {
+		counters := NewMemoryCounterStore()
+		assertion := assert(5)
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		accepted := 0
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := NewVerifier().VerifyCounted(ctx, counters, device.KeyID, assertion, clientData, device.PublicKey(), appID); err == nil {
+					mu.Lock()
+					accepted++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if accepted != 1 {
+			t.Fatalf("Expected exactly one assertion to be accepted, got %d", accepted)
+		}
+	})
+
+	t.Run("Replay cache is checked first", func(t *testing.T) {
+		counters := &countingStore{MemoryCounterStore: NewMemoryCounterStore()}
+		v := NewVerifier(WithReplayCache(NewLRUReplayCache(100, time.Minute)))
+		assertion := assert(1)
+		if _, err := v.VerifyCounted(ctx, counters, device.KeyID, assertion, clientData, device.PublicKey(), appID); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		gets := counters.gets
+		result, err := v.VerifyCounted(ctx, counters, device.KeyID, assertion, clientData, device.PublicKey(), appID)
+		if err == nil || result.CloneReason != CloneReasonReplay {
+			t.Fatalf("Expected replay to be rejected, got %+v", err)
+		}
+		if counters.gets != gets {
+			t.Fatal("Counter store was consulted for a cached assertion")
+		}
+	})
+}
+
+type countingStore struct {
+	*MemoryCounterStore
+	gets int
+}
+
+func (s *countingStore) Get(ctx context.Context, keyID []byte) (uint32, error) {
+	s.gets++
+	return s.MemoryCounterStore.Get(ctx, keyID)
+}
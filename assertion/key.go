@@ -0,0 +1,63 @@
+package assertion
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/jyrodrigues/appattest/cose"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// ParsePublicKey parses a stored ECDSA public key in any of the formats a relying party may
+// keep it in: x963 (uncompressed point, as in Credential.PublicKey), a COSE key (as in the
+// attested credential data), PKIX DER or PEM. The format is detected from the data.
+func ParsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	if len(data) == 0 {
+		return nil, utils.ErrParsingData.WithDetails("Missing public key")
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("-----BEGIN")):
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, utils.ErrParsingData.WithDetails("Invalid PEM public key")
+		}
+		return parsePKIXPublicKey(block.Bytes)
+	case data[0] == 0x04:
+		for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+			if x, y := elliptic.Unmarshal(curve, data); x != nil {
+				return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+			}
+		}
+		return nil, utils.ErrParsingData.WithDetails("Failed to parse the public key")
+	case data[0] == 0x30:
+		return parsePKIXPublicKey(data)
+	case data[0]&0xe0 == 0xa0:
+		return parseCOSEPublicKey(data)
+	default:
+		return nil, utils.ErrParsingData.WithDetails("Unknown public key format")
+	}
+}
+
+func parsePKIXPublicKey(der []byte) (*ecdsa.PublicKey, error) {
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Failed to parse the public key: %v", err))
+	}
+	ecdsaPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Public key is a %T, not an ECDSA key", pub))
+	}
+	return ecdsaPub, nil
+}
+
+func parseCOSEPublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	key, err := cose.ParseKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return key.ECDSA()
+}
@@ -0,0 +1,79 @@
+package assertion
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/jyrodrigues/appattest/testutil"
+)
+
+func TestParsePublicKey(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &device.Key.PublicKey
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := []byte(`{"challenge":"challenge"}`)
+	assertion, err := device.Assert(appID, clientData, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formats := map[string][]byte{
+		"x963": device.PublicKey(),
+		"COSE": testutil.COSEKey(pub),
+		"PKIX": der,
+		"PEM":  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+	}
+	for name, data := range formats {
+		t.Run(name, func(t *testing.T) {
+			parsed, err := ParsePublicKey(data)
+			if err != nil {
+				t.Fatalf("Not valid: %+v", err)
+			}
+			if !parsed.Equal(pub) {
+				t.Fatal("Wrong public key")
+			}
+			if _, err := VerifyAssertion(assertion, clientData, data, 0, appID); err != nil {
+				t.Fatalf("Not valid: %+v", err)
+			}
+		})
+	}
+
+	t.Run("Parsed key", func(t *testing.T) {
+		if _, err := NewVerifier().VerifyAssertionKey(assertion, clientData, pub, 0, appID); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+	})
+
+	t.Run("Other curve", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewVerifier().VerifyAssertionKey(assertion, clientData, &key.PublicKey, 0, appID); err == nil {
+			t.Fatal("Expected a P-384 key to be rejected for ES256")
+		}
+	})
+
+	t.Run("Invalid keys", func(t *testing.T) {
+		for _, data := range [][]byte{nil, []byte("key"), {0x04, 0x01}, {0xa1, 0x01, 0x01}, []byte("-----BEGIN PUBLIC KEY-----")} {
+			if _, err := ParsePublicKey(data); err == nil {
+				t.Errorf("Expected an error for %x", data)
+			}
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package assertion
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"time"
+
+	"github.com/jyrodrigues/appattest/attestation"
+)
+
+// legacyAppIDs are former App IDs accepted for keys registered before a cutoff.
+type legacyAppIDs struct {
+	cutoff time.Time
+	appIDs []string
+	hashes [][]byte
+}
+
+// WithLegacyAppIDs accepts assertions whose RP ID hash is that of one of the given former App
+// IDs, e.g. after the bundle ID was renamed, for keys registered before cutoff. Apple keeps
+// the App ID the key was attested for, so such keys would otherwise have to be attested again.
+// The matched App ID is reported in AssertionResult.LegacyAppID.
+//
+// The registration time is Credential.RegisteredAt. Credentials without one, including the
+// keys passed to the verification methods that take a bare public key, may not use the legacy
+// App IDs: use VerifyCredential, or VerifyStored with a store that records the time.
+func WithLegacyAppIDs(cutoff time.Time, appIDs ...string) Option {
+	return func(v *Verifier) {
+		legacy := &legacyAppIDs{cutoff: cutoff, appIDs: appIDs}
+		for _, appID := range appIDs {
+			hash := sha256.Sum256([]byte(appID))
+			legacy.hashes = append(legacy.hashes, hash[:])
+		}
+		v.legacy = legacy
+	}
+}
+
+// match returns the legacy App ID whose hash is rpIDHash, if the credential may use one.
+func (l *legacyAppIDs) match(rpIDHash []byte, cred *attestation.Credential) string {
+	if l == nil || cred.RegisteredAt.IsZero() || !cred.RegisteredAt.Before(l.cutoff) {
+		return ""
+	}
+	for i, hash := range l.hashes {
+		if bytes.Equal(hash, rpIDHash) {
+			return l.appIDs[i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package assertion
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/testutil"
+)
+
+func TestLegacyAppIDs(t *testing.T) {
+	const (
+		oldAppID = "TEAMID1234.com.example.old"
+		newAppID = "TEAMID1234.com.example.app"
+	)
+	cutoff := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := []byte(`{"challenge":"challenge"}`)
+	assertion, err := device.Assert(oldAppID, clientData, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+	credential := func(registeredAt time.Time) *attestation.Credential {
+		return &attestation.Credential{PublicKey: device.PublicKey(), Algorithm: authenticator.AlgES256, RegisteredAt: registeredAt}
+	}
+	v := NewVerifier(WithLegacyAppIDs(cutoff, oldAppID))
+
+	t.Run("Registered before the cutoff", func(t *testing.T) {
+		if _, err := v.VerifyCredential(aar, "challenge", newAppID, credential(cutoff.Add(-time.Hour))); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		result, err := v.verify(context.Background(), aar, nil, "challenge", newAppID, credential(cutoff.Add(-time.Hour)))
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if result.LegacyAppID != oldAppID {
+			t.Fatalf("Wrong legacy App ID: %q", result.LegacyAppID)
+		}
+	})
+
+	t.Run("Registered after the cutoff", func(t *testing.T) {
+		if _, err := v.VerifyCredential(aar, "challenge", newAppID, credential(cutoff)); err == nil {
+			t.Fatal("Expected the legacy App ID to be rejected")
+		}
+	})
+
+	t.Run("Unknown registration time", func(t *testing.T) {
+		if _, err := v.VerifyCredential(aar, "challenge", newAppID, credential(time.Time{})); err == nil {
+			t.Fatal("Expected the legacy App ID to be rejected without a registration time")
+		}
+		if _, err := v.VerifyResult(aar, "challenge", newAppID, 0, device.PublicKey()); err == nil {
+			t.Fatal("Expected the legacy App ID to be rejected for a bare public key")
+		}
+	})
+
+	t.Run("Without legacy App IDs", func(t *testing.T) {
+		if _, err := NewVerifier().VerifyCredential(aar, "challenge", newAppID, credential(cutoff.Add(-time.Hour))); err == nil {
+			t.Fatal("Expected the old App ID to be rejected")
+		}
+	})
+}
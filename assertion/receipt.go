@@ -0,0 +1,41 @@
+package assertion
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/receipt"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// WithReceiptExpiration rejects assertions of credentials whose receipt expired more than
+// grace ago with ErrReceiptExpired, so the app can be prompted to attest its key again. Within
+// the grace period assertions are accepted with AssertionResult.ReceiptExpired set. The check
+// runs for credentials with a receipt, see VerifyCredential and VerifyStored.
+func WithReceiptExpiration(grace time.Duration) Option {
+	return func(v *Verifier) {
+		v.checkReceipt = true
+		v.receiptGrace = grace
+	}
+}
+
+// checkReceiptExpiration checks the expiration time of the credential's receipt.
+func (v *Verifier) checkReceiptExpiration(cred *attestation.Credential, result *AssertionResult) error {
+	r, err := receipt.Parse(cred.Receipt)
+	if err != nil {
+		return err
+	}
+	expiration := r.ExpirationTime()
+	if expiration.IsZero() {
+		return utils.ErrReceipt.WithDetails("Receipt has no expiration time")
+	}
+	if !result.VerifiedAt.After(expiration) {
+		return nil
+	}
+	result.ReceiptExpired = true
+	if result.VerifiedAt.After(expiration.Add(v.receiptGrace)) {
+		return utils.ErrReceiptExpired.WithDetails(fmt.Sprintf("Receipt expired at %s", expiration.Format(time.RFC3339)))
+	}
+	return nil
+}
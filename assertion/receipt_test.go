@@ -0,0 +1,123 @@
+package assertion
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestReceiptExpiration(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	defer func() { TimeNow = time.Now }()
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiration := time.Date(2021, 7, 13, 9, 55, 20, 0, time.UTC)
+	receiptData, err := authority.Receipt(testutil.ReceiptOptions{AppID: appID, ExpirationTime: expiration})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := []byte(`{"challenge":"challenge"}`)
+	assertion, err := device.Assert(appID, clientData, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+	cred := &attestation.Credential{PublicKey: device.PublicKey(), Algorithm: authenticator.AlgES256, Receipt: receiptData}
+	v := NewVerifier(WithReceiptExpiration(24 * time.Hour))
+
+	for _, test := range []struct {
+		name    string
+		now     time.Time
+		expired bool
+		err     *utils.Error
+	}{
+		{"Valid receipt", expiration.Add(-time.Hour), false, nil},
+		{"Within grace period", expiration.Add(time.Hour), true, nil},
+		{"Expired receipt", expiration.Add(25 * time.Hour), true, utils.ErrReceiptExpired},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			TimeNow = func() time.Time { return test.now }
+			result, err := v.verify(context.Background(), aar, nil, "challenge", appID, cred)
+			if test.err == nil && err != nil {
+				t.Fatalf("Not valid: %+v", err)
+			}
+			var verr *utils.Error
+			if test.err != nil && (!errors.As(err, &verr) || verr.Type != test.err.Type) {
+				t.Fatalf("Expected %s, got %+v", test.err.Type, err)
+			}
+			if result.ReceiptExpired != test.expired {
+				t.Fatalf("Expected ReceiptExpired %v", test.expired)
+			}
+		})
+	}
+}
+
+func TestVerifierClock(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	// Every time-dependent step uses the clock of the verifier instead of TimeNow.
+	TimeNow = func() time.Time {
+		t.Error("TimeNow was used instead of the clock")
+		return time.Now()
+	}
+	defer func() { TimeNow = time.Now }()
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiration := time.Date(2021, 7, 13, 9, 55, 20, 0, time.UTC)
+	receiptData, err := authority.Receipt(testutil.ReceiptOptions{AppID: appID, ExpirationTime: expiration})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := []byte(`{"challenge":"challenge"}`)
+	assertion, err := device.Assert(appID, clientData, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+	cred := &attestation.Credential{PublicKey: device.PublicKey(), Algorithm: authenticator.AlgES256, Receipt: receiptData}
+
+	now := expiration.Add(time.Hour)
+	clock := utils.ClockFunc(func() time.Time { return now })
+	v := NewVerifier(WithClock(clock), WithReceiptExpiration(24*time.Hour), WithReplayCache(NewLRUReplayCache(10, time.Minute)))
+	result, err := v.verify(context.Background(), aar, nil, "challenge", appID, cred)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if !result.VerifiedAt.Equal(now) || !result.ReceiptExpired {
+		t.Fatalf("Wrong result: %+v", result)
+	}
+	if _, err := v.verify(context.Background(), aar, nil, "challenge", appID, cred); err == nil {
+		t.Fatal("Expected the replay to be rejected within the TTL")
+	}
+	if _, err := v.VerifyCounted(context.Background(), NewMemoryCounterStore(), device.KeyID, assertion, clientData, device.PublicKey(), appID); err == nil {
+		t.Fatal("Expected the replay to be rejected within the TTL")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := v.verify(context.Background(), aar, nil, "challenge", appID, cred); err != nil {
+		t.Fatalf("Expected the replay cache to expire with the clock: %+v", err)
+	}
+	now = expiration.Add(25 * time.Hour)
+	_, err = v.verify(context.Background(), aar, nil, "challenge", appID, cred)
+	var verr *utils.Error
+	if !errors.As(err, &verr) || verr.Type != utils.ErrReceiptExpired.Type {
+		t.Fatalf("Expected the clock to expire the receipt, got %+v", err)
+	}
+}
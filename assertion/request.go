@@ -0,0 +1,64 @@
+package assertion
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// RequestCanonicalizer returns the client data an app signs for an HTTP request, given the
+// request and its body.
+type RequestCanonicalizer func(r *http.Request, body []byte) []byte
+
+// CanonicalRequest is the default RequestCanonicalizer. The client data is the method, the
+// escaped path and the hex-encoded SHA256 digest of the body, separated by newlines:
+//
+//	POST
+//	/v1/transfer
+//	9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+func CanonicalRequest(r *http.Request, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	var b bytes.Buffer
+	b.WriteString(r.Method)
+	b.WriteByte('\n')
+	b.WriteString(r.URL.EscapedPath())
+	b.WriteByte('\n')
+	b.WriteString(hex.EncodeToString(digest[:]))
+	return b.Bytes()
+}
+
+// WithRequestCanonicalizer replaces CanonicalRequest in VerifyRequest, e.g. to also cover
+// the query or selected headers.
+func WithRequestCanonicalizer(fn RequestCanonicalizer) Option {
+	return func(v *Verifier) {
+		v.canonicalize = fn
+	}
+}
+
+// VerifyRequest verifies an assertion made over an HTTP request, such as one sent in a
+// header, so that the signature protects the whole request rather than a challenge: the
+// client data is computed from the request with CanonicalRequest or the configured
+// RequestCanonicalizer, and the assertion is verified against it like VerifyAssertion. The
+// body, which must be at most maxBodySize bytes, is read and replaced so the handler can read
+// it again. Replays are caught by the counter.
+func (v *Verifier) VerifyRequest(r *http.Request, maxBodySize int64, assertion, publicKey []byte, previousCounter uint32, appID string) (*AssertionResult, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = utils.ReadLimited(r.Body, maxBodySize); err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	canonicalize := v.canonicalize
+	if canonicalize == nil {
+		canonicalize = CanonicalRequest
+	}
+	return v.VerifyAssertionContext(r.Context(), assertion, canonicalize(r, body), publicKey, previousCounter, appID)
+}
@@ -0,0 +1,72 @@
+package assertion
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jyrodrigues/appattest/testutil"
+)
+
+func TestVerifyRequest(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const body = `{"amount":10}`
+	signed := httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(body))
+	assertion, err := device.Assert(appID, CanonicalRequest(signed, []byte(body)), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Signed request", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(body))
+		if _, err := NewVerifier().VerifyRequest(r, 1024, assertion, device.PublicKey(), 0, appID); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if read, _ := io.ReadAll(r.Body); string(read) != body {
+			t.Fatalf("Body was not restored: %q", read)
+		}
+	})
+
+	t.Run("Tampered request", func(t *testing.T) {
+		for _, r := range []*http.Request{
+			httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(`{"amount":1000}`)),
+			httptest.NewRequest("POST", "/v1/withdraw", strings.NewReader(body)),
+			httptest.NewRequest("PUT", "/v1/transfer", strings.NewReader(body)),
+		} {
+			if _, err := NewVerifier().VerifyRequest(r, 1024, assertion, device.PublicKey(), 0, appID); err == nil {
+				t.Errorf("Expected %s %s to be rejected", r.Method, r.URL.Path)
+			}
+		}
+	})
+
+	t.Run("Oversized body", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/v1/transfer", strings.NewReader(body))
+		if _, err := NewVerifier().VerifyRequest(r, 4, assertion, device.PublicKey(), 0, appID); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("Custom canonicalizer", func(t *testing.T) {
+		canonicalize := func(r *http.Request, body []byte) []byte {
+			return []byte(r.URL.RawQuery)
+		}
+		assertion, err := device.Assert(appID, []byte("id=1"), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/v1/account?id=1", nil)
+		if _, err := NewVerifier(WithRequestCanonicalizer(canonicalize)).VerifyRequest(r, 1024, assertion, device.PublicKey(), 0, appID); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package assertion
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/jyrodrigues/appattest/authenticator"
+)
+
+// Reasons why an AssertionResult flags a possibly cloned device.
+const (
+	// The counter did not increase, so another device may be using the same key
+	CloneReasonCounter = "counter_regression"
+	// The exact same assertion was seen before
+	CloneReasonReplay = "replayed_assertion"
+)
+
+// AssertionResult is the outcome of an assertion verification.
+type AssertionResult struct {
+	// The counter of the assertion, to store for the next verification
+	Counter uint32
+	// Whether the assertion indicates that the key is used by more than one device
+	PossiblyCloned bool
+	// One of the CloneReason constants when PossiblyCloned is set
+	CloneReason string
+
+	// The RP ID hash of the authenticator data, and whether it matched the App ID
+	RPIDHash    []byte
+	RPIDMatched bool
+	// The legacy App ID the RP ID hash matched instead of the App ID, see WithLegacyAppIDs
+	LegacyAppID string
+	// The flags of the authenticator data
+	Flags        authenticator.AuthenticatorFlags
+	UserPresent  bool
+	UserVerified bool
+	// Whether the attested credential data flag was set without attested credential data, as
+	// Apple does on assertions, see ATFlagMode
+	ATFlagQuirk bool
+	// When the signature was verified
+	VerifiedAt time.Time
+	// Whether the AnomalyDetector flagged the assertion
+	Flagged bool
+	// Whether the receipt of the credential expired, but within the grace period of
+	// WithReceiptExpiration, so the app should attest the key again
+	ReceiptExpired bool
+}
+
+// TimeNow is an indirection to allow tests to replace the current time
+var TimeNow = time.Now
+
+// newResult returns the result for an assertion whose signature was verified at now.
+func newResult(a *Assertion, now time.Time) *AssertionResult {
+	flags := a.AuthenticatorData.Flags
+	return &AssertionResult{
+		Counter:      a.AuthenticatorData.Counter,
+		RPIDHash:     a.AuthenticatorData.RPIDHash,
+		Flags:        flags,
+		UserPresent:  flags.UserPresent(),
+		UserVerified: flags.UserVerified(),
+		ATFlagQuirk:  a.AuthenticatorData.HasATFlagWithoutData(),
+		VerifiedAt:   now,
+	}
+}
+
+// ReplayCache remembers assertions that were already verified.
+type ReplayCache interface {
+	// Seen records the hash of an assertion and reports whether it was recorded before.
+	Seen(hash []byte) bool
+}
+
+// WithReplayCache rejects assertions found in the cache as possibly cloned. Assertions are
+// recorded once their signature is valid. With a cache that can be queried without recording,
+// such as a MemoryReplayCache or an LRUReplayCache, a cached assertion is rejected before its
+// signature is verified.
+func WithReplayCache(cache ReplayCache) Option {
+	return func(v *Verifier) {
+		v.replay = cache
+	}
+}
+
+// replayChecker is implemented by caches that can report whether an assertion was seen
+// without recording it, which lets the verifier reject replays before verifying their
+// signature or consulting the CounterStore.
+type replayChecker interface {
+	Contains(hash []byte) bool
+}
+
+// timedReplayCache is implemented by caches whose entries expire, such as an LRUReplayCache,
+// so the verifier can pass the time of its clock.
+type timedReplayCache interface {
+	SeenAt(hash []byte, now time.Time) bool
+	ContainsAt(hash []byte, now time.Time) bool
+}
+
+// seen records the hash in the replay cache, at the time of the verifier's clock if the cache
+// expires entries.
+func (v *Verifier) seen(hash []byte) bool {
+	if cache, ok := v.replay.(timedReplayCache); ok {
+		return cache.SeenAt(hash, v.now())
+	}
+	return v.replay.Seen(hash)
+}
+
+// contains reports whether the replay cache holds the hash without recording it, false if the
+// cache cannot tell.
+func (v *Verifier) contains(hash []byte) bool {
+	if cache, ok := v.replay.(timedReplayCache); ok {
+		return cache.ContainsAt(hash, v.now())
+	}
+	if checker, ok := v.replay.(replayChecker); ok {
+		return checker.Contains(hash)
+	}
+	return false
+}
+
+// MemoryReplayCache is a ReplayCache keeping every assertion hash in memory.
+type MemoryReplayCache struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// NewMemoryReplayCache returns an empty MemoryReplayCache.
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{seen: make(map[string]struct{})}
+}
+
+// Seen records the hash and reports whether it was recorded before.
+func (c *MemoryReplayCache) Seen(hash []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.seen[string(hash)]; ok {
+		return true
+	}
+	c.seen[string(hash)] = struct{}{}
+	return false
+}
+
+// Contains reports whether the hash was recorded, without recording it.
+func (c *MemoryReplayCache) Contains(hash []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.seen[string(hash)]
+	return ok
+}
+
+// LRUReplayCache is a ReplayCache remembering assertion hashes for a limited time, and at
+// most a fixed number of them. It suits stateless nodes that only need to catch replays
+// within a short window, such as retries of the same request; the counter catches the rest.
+type LRUReplayCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type replayEntry struct {
+	hash    string
+	expires time.Time
+}
+
+// DefaultReplayCacheSize is the size of an LRUReplayCache created with a size of 0 or less.
+const DefaultReplayCacheSize = 10000
+
+// NewLRUReplayCache returns a cache holding at most size hashes, each for ttl after it was
+// last seen. When full, the least recently seen hash is evicted. A size of 0 or less is
+// replaced with DefaultReplayCacheSize, so the cache neither forgets every hash at once nor
+// grows without bound.
+func NewLRUReplayCache(size int, ttl time.Duration) *LRUReplayCache {
+	if size < 1 {
+		size = DefaultReplayCacheSize
+	}
+	return &LRUReplayCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Seen records the hash and reports whether it was recorded within the TTL.
+func (c *LRUReplayCache) Seen(hash []byte) bool {
+	return c.SeenAt(hash, TimeNow())
+}
+
+// SeenAt is like Seen at the time now, e.g. of a Verifier's clock.
+func (c *LRUReplayCache) SeenAt(hash []byte, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := c.lookup(string(hash), now)
+
+	if el, ok := c.entries[string(hash)]; ok {
+		el.Value.(*replayEntry).expires = now.Add(c.ttl)
+		c.order.MoveToFront(el)
+		return seen
+	}
+	c.entries[string(hash)] = c.order.PushFront(&replayEntry{hash: string(hash), expires: now.Add(c.ttl)})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*replayEntry).hash)
+	}
+	return false
+}
+
+// Contains reports whether the hash was recorded within the TTL, without recording it.
+func (c *LRUReplayCache) Contains(hash []byte) bool {
+	return c.ContainsAt(hash, TimeNow())
+}
+
+// ContainsAt is like Contains at the time now.
+func (c *LRUReplayCache) ContainsAt(hash []byte, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookup(string(hash), now)
+}
+
+// lookup reports whether the hash is cached and not expired, removing it if it expired.
+func (c *LRUReplayCache) lookup(hash string, now time.Time) bool {
+	el, ok := c.entries[hash]
+	if !ok {
+		return false
+	}
+	if now.After(el.Value.(*replayEntry).expires) {
+		c.order.Remove(el)
+		delete(c.entries, hash)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,159 @@
+package assertion
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAssertionResultCloneSignals(t *testing.T) {
+	decodedPk, err := hex.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Could not decode public key: %+s", publicKey)
+	}
+	appID := "35MFYY2JY5.co.chiff.attestation-test"
+	newResponse := func() *AuthenticatorAssertionResponse {
+		aar := AuthenticatorAssertionResponse{}
+		if err := json.Unmarshal([]byte(assertion), &aar); err != nil {
+			t.Fatal(err)
+		}
+		return &aar
+	}
+
+	t.Run("Valid assertion", func(t *testing.T) {
+		result, err := NewVerifier().VerifyResult(newResponse(), "assertion-test", appID, 0, decodedPk)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if result.PossiblyCloned || result.Counter != 3 {
+			t.Fatalf("Wrong result: %+v", result)
+		}
+	})
+
+	t.Run("Flags and RP ID", func(t *testing.T) {
+		now := time.Date(2021, 4, 14, 10, 0, 0, 0, time.UTC)
+		TimeNow = func() time.Time { return now }
+		defer func() { TimeNow = time.Now }()
+
+		result, err := NewVerifier().VerifyResult(newResponse(), "assertion-test", appID, 0, decodedPk)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		rpIDHash := sha256.Sum256([]byte(appID))
+		if !result.RPIDMatched || !bytes.Equal(result.RPIDHash, rpIDHash[:]) {
+			t.Fatalf("Wrong RP ID: %+v", result)
+		}
+		// Apple sets the attested credential data flag only; App Attest keys need no user gesture.
+		if result.Flags != 0x40 || result.UserPresent || result.UserVerified {
+			t.Fatalf("Wrong flags: %+v", result)
+		}
+		if !result.VerifiedAt.Equal(now) {
+			t.Fatalf("Wrong timestamp: %v", result.VerifiedAt)
+		}
+	})
+
+	t.Run("Counter regression", func(t *testing.T) {
+		result, err := NewVerifier().VerifyResult(newResponse(), "assertion-test", appID, 5, decodedPk)
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		if result == nil || !result.PossiblyCloned || result.CloneReason != CloneReasonCounter {
+			t.Fatalf("Expected clone signal, got %+v", result)
+		}
+	})
+
+	t.Run("Replayed signature", func(t *testing.T) {
+		v := NewVerifier(WithReplayCache(NewMemoryReplayCache()))
+		if _, err := v.VerifyResult(newResponse(), "assertion-test", appID, 0, decodedPk); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		// A node with a stale counter would accept the replay without the cache.
+		var steps []string
+		WithOnStep(func(step string, ok bool, detail string) { steps = append(steps, step) })(v)
+		result, err := v.VerifyResult(newResponse(), "assertion-test", appID, 0, decodedPk)
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		if result == nil || !result.PossiblyCloned || result.CloneReason != CloneReasonReplay {
+			t.Fatalf("Expected clone signal, got %+v", result)
+		}
+		// The replay is rejected before the signature is verified.
+		if !reflect.DeepEqual(steps, []string{StepParse, StepReplay}) {
+			t.Fatalf("Wrong steps: %v", steps)
+		}
+	})
+
+	t.Run("Unverified assertion is not recorded", func(t *testing.T) {
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherPk, err := other.PublicKey.ECDH()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := NewVerifier(WithReplayCache(NewMemoryReplayCache()))
+		if _, err := v.VerifyResult(newResponse(), "assertion-test", appID, 0, otherPk.Bytes()); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if _, err := v.VerifyResult(newResponse(), "assertion-test", appID, 0, decodedPk); err != nil {
+			t.Fatalf("Expected an assertion failing verification not to be recorded: %+v", err)
+		}
+	})
+}
+
+func TestLRUReplayCache(t *testing.T) {
+	now := time.Date(2021, 4, 14, 10, 0, 0, 0, time.UTC)
+	TimeNow = func() time.Time { return now }
+	defer func() { TimeNow = time.Now }()
+
+	t.Run("TTL", func(t *testing.T) {
+		cache := NewLRUReplayCache(10, time.Minute)
+		if cache.Seen([]byte("a")) {
+			t.Fatal("New hash reported as seen")
+		}
+		if !cache.Contains([]byte("a")) || !cache.Seen([]byte("a")) {
+			t.Fatal("Hash not reported as seen within the TTL")
+		}
+		now = now.Add(2 * time.Minute)
+		if cache.Contains([]byte("a")) || cache.Seen([]byte("a")) {
+			t.Fatal("Hash reported as seen after the TTL")
+		}
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		cache := NewLRUReplayCache(2, time.Minute)
+		cache.Seen([]byte("a"))
+		cache.Seen([]byte("b"))
+		cache.Seen([]byte("a"))
+		cache.Seen([]byte("c"))
+		if cache.Contains([]byte("b")) {
+			t.Fatal("Least recently seen hash was not evicted")
+		}
+		if !cache.Contains([]byte("a")) || !cache.Contains([]byte("c")) {
+			t.Fatal("Recently seen hashes were evicted")
+		}
+	})
+
+	t.Run("Non-positive size", func(t *testing.T) {
+		for _, size := range []int{0, -1} {
+			cache := NewLRUReplayCache(size, time.Minute)
+			if cache.Seen([]byte{0, 0}) || !cache.Seen([]byte{0, 0}) {
+				t.Fatalf("Size %d: hash not remembered", size)
+			}
+			for i := 1; i <= DefaultReplayCacheSize; i++ {
+				cache.Seen([]byte{byte(i >> 8), byte(i)})
+			}
+			if cache.Contains([]byte{0, 0}) || !cache.Contains([]byte{0, 1}) {
+				t.Fatalf("Size %d: cache not bounded to DefaultReplayCacheSize", size)
+			}
+		}
+	})
+}
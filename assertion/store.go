@@ -0,0 +1,30 @@
+package assertion
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// CredentialStore looks up the credentials stored after attestation, such as an
+// attestation.MemoryCredentialStore.
+type CredentialStore interface {
+	// Load returns the credential with the given key ID, or nil if there is none.
+	Load(ctx context.Context, keyID []byte) (*attestation.Credential, error)
+}
+
+// VerifyStored verifies the assertion against the credential stored for the key ID. The
+// signature is verified with the algorithm recorded at attestation, so an assertion signed with
+// another algorithm fails. The caller persists the returned counter.
+func (v *Verifier) VerifyStored(ctx context.Context, store CredentialStore, keyID []byte, aar *AuthenticatorAssertionResponse, storedChallenge string, relyingPartyID string) (*AssertionResult, error) {
+	cred, err := store.Load(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if cred == nil {
+		return nil, utils.ErrUnknownCredential.WithDetails(fmt.Sprintf("No credential is stored for key ID %x", keyID))
+	}
+	return v.verify(ctx, aar, nil, storedChallenge, relyingPartyID, cred)
+}
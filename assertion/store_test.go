@@ -0,0 +1,122 @@
+package assertion
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestVerifyStored(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	ctx := context.Background()
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := attestation.NewMemoryCredentialStore()
+	if err := store.Save(ctx, &attestation.Credential{
+		KeyID:     device.KeyID,
+		PublicKey: device.PublicKey(),
+		Counter:   1,
+		Algorithm: authenticator.AlgES256,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	clientData := []byte(`{"challenge":"stored"}`)
+
+	t.Run("Attested algorithm", func(t *testing.T) {
+		assertion, err := device.Assert(appID, clientData, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+		result, err := NewVerifier().VerifyStored(ctx, store, device.KeyID, aar, "stored", appID)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if result.Counter != 2 {
+			t.Fatalf("Wrong counter: %d", result.Counter)
+		}
+	})
+
+	t.Run("Different algorithm", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authData := testutil.AuthData(appID, 0x40, 2)
+		clientDataHash := sha256.Sum256(clientData)
+		nonce := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+		digest := sha512.Sum384(nonce[:])
+		signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertion, err := utils.MarshalCBOR(map[string]interface{}{
+			"signature":         signature,
+			"authenticatorData": authData,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+		var steps []string
+		v := NewVerifier(WithOnStep(func(step string, ok bool, detail string) {
+			steps = append(steps, step)
+		}))
+		_, err = v.VerifyStored(ctx, store, device.KeyID, aar, "stored", appID)
+		var e *utils.Error
+		if !errors.As(err, &e) || e.Type != utils.ErrAssertionSignature.Type {
+			t.Fatalf("Expected an invalid signature, got %v", err)
+		}
+		// The assertion is parsed once and the steps run in their usual order.
+		if !reflect.DeepEqual(steps, []string{StepParse, StepPublicKey, StepSignature}) {
+			t.Fatalf("Wrong steps: %v", steps)
+		}
+	})
+
+	t.Run("Key not on the curve of the algorithm", func(t *testing.T) {
+		other := attestation.NewMemoryCredentialStore()
+		if err := other.Save(ctx, &attestation.Credential{KeyID: device.KeyID, PublicKey: device.PublicKey(), Algorithm: authenticator.AlgES384}); err != nil {
+			t.Fatal(err)
+		}
+		assertion, err := device.Assert(appID, clientData, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+		_, err = NewVerifier().VerifyStored(ctx, other, device.KeyID, aar, "stored", appID)
+		var e *utils.Error
+		if !errors.As(err, &e) || e.Type != utils.ErrAlgorithmMismatch.Type {
+			t.Fatalf("Expected algorithm mismatch, got %v", err)
+		}
+	})
+
+	t.Run("Unknown credential", func(t *testing.T) {
+		assertion, err := device.Assert(appID, clientData, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+		_, err = NewVerifier().VerifyStored(ctx, store, []byte("unknown"), aar, "stored", appID)
+		var e *utils.Error
+		if !errors.As(err, &e) || e.Type != utils.ErrUnknownCredential.Type {
+			t.Fatalf("Expected unknown credential, got %v", err)
+		}
+	})
+}
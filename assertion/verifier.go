@@ -0,0 +1,533 @@
+package assertion
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Names of the verification steps reported to a StepFunc, in the order they run.
+const (
+	StepParse      = "parse"
+	StepPublicKey  = "public_key"
+	StepSignature  = "signature"
+	StepReplay     = "replay"
+	StepRPID       = "rp_id"
+	StepCounter    = "counter"
+	StepChallenge  = "challenge"
+	StepClientData = "client_data"
+	StepReceipt    = "receipt"
+	StepAnomaly    = "anomaly"
+)
+
+// StepFunc is invoked after each verification step. The detail is the error message
+// when the step failed and empty otherwise.
+type StepFunc func(step string, ok bool, detail string)
+
+// Verifier verifies assertions. The zero value is ready to use.
+//
+// The signature covers SHA256(authenticatorData || clientDataHash), where clientDataHash is
+// the SHA256 hash of the client data the app passed to generateAssertion. By default the
+// client data is a JSON document whose challenge field holds the one-time challenge. With
+// WithRawChallenge the client data is the challenge itself, as with attestations, and with
+// WithChallengeField or WithChallengeExtractor the challenge is read from elsewhere.
+type Verifier struct {
+	onStep StepFunc
+	replay ReplayCache
+	// Reads the challenge from the client data; nil reads the challenge field of ClientData
+	extractChallenge ChallengeExtractor
+	anomaly          AnomalyDetector
+	legacy           *legacyAppIDs
+	canonicalize     RequestCanonicalizer
+	atFlag           ATFlagMode
+	validate         ClientDataValidator
+	hash             crypto.Hash
+	// Whether the receipt of the credential must not have expired longer than receiptGrace ago
+	checkReceipt bool
+	receiptGrace time.Duration
+	clock        utils.Clock
+}
+
+// Option configures a Verifier.
+type Option func(*Verifier)
+
+// NewVerifier returns a Verifier configured with the given options.
+func NewVerifier(opts ...Option) *Verifier {
+	v := &Verifier{}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// WithOnStep registers a callback invoked after each verification step, which is
+// useful for tests and instrumentation. Verification stops at the first failing step.
+func WithOnStep(fn StepFunc) Option {
+	return func(v *Verifier) {
+		v.onStep = fn
+	}
+}
+
+// WithRawChallenge verifies assertions whose client data is the raw challenge rather than a
+// JSON document, so clientDataHash is the SHA256 hash of the challenge. The stored challenge
+// must then equal the client data byte for byte.
+func WithRawChallenge() Option {
+	return WithChallengeExtractor(func(clientData []byte) (string, error) {
+		return string(clientData), nil
+	})
+}
+
+// ChallengeExtractor returns the challenge embedded in the client data of an assertion.
+type ChallengeExtractor func(clientData []byte) (string, error)
+
+// WithChallengeExtractor reads the challenge from the client data with fn, which replaces
+// decoding ClientData. Of WithRawChallenge, WithChallengeField and WithChallengeExtractor the
+// last option given applies.
+func WithChallengeExtractor(fn ChallengeExtractor) Option {
+	return func(v *Verifier) {
+		v.extractChallenge = fn
+	}
+}
+
+// WithChallengeField reads the challenge from the string field name of the JSON client data,
+// e.g. "nonce", instead of the challenge field.
+func WithChallengeField(name string) Option {
+	return WithChallengeExtractor(func(clientData []byte) (string, error) {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(clientData, &fields); err != nil {
+			return "", utils.ErrParsingData.WithDetails(fmt.Sprintf("error decoding client data: %v", err))
+		}
+		raw, ok := fields[name]
+		if !ok {
+			return "", utils.ErrParsingData.WithDetails(fmt.Sprintf("Client data has no %s field", name))
+		}
+		var challenge string
+		if err := json.Unmarshal(raw, &challenge); err != nil {
+			return "", utils.ErrParsingData.WithDetails(fmt.Sprintf("Client data field %s is not a string", name))
+		}
+		return challenge, nil
+	})
+}
+
+// WithHash computes clientDataHash and the nonce with h instead of SHA256, which is what
+// Apple's App Attest uses. The hash function must be linked into the binary, e.g. by
+// importing crypto/sha512 for SHA384. The signature is still verified with the hash of the
+// credential's algorithm.
+func WithHash(h crypto.Hash) Option {
+	return func(v *Verifier) {
+		v.hash = h
+	}
+}
+
+// WithClock sets the clock telling the current time to the verifications: the VerifiedAt time
+// of results, the receipt expiration check and the TTL of an LRUReplayCache. By default the
+// system clock is used.
+func WithClock(clock utils.Clock) Option {
+	return func(v *Verifier) {
+		v.clock = clock
+	}
+}
+
+// now returns the time of the verifier's clock.
+func (v *Verifier) now() time.Time {
+	if v.clock != nil {
+		return v.clock.Now()
+	}
+	return TimeNow()
+}
+
+// hashFunc returns the hash function computing clientDataHash and the nonce.
+func (v *Verifier) hashFunc() (crypto.Hash, error) {
+	h := v.hash
+	if h == 0 {
+		h = crypto.SHA256
+	}
+	if !h.Available() {
+		return 0, utils.ErrVerification.WithDetails(fmt.Sprintf("Hash function %v is not available", h))
+	}
+	return h, nil
+}
+
+// ATFlagMode selects how assertions are handled whose authenticator data has the attested
+// credential data flag set without attested credential data. The WebAuthn specification does
+// not allow this, but Apple sets the flag on every assertion.
+type ATFlagMode int
+
+const (
+	// ATFlagTolerate accepts the flag without attested credential data and reports it in
+	// AssertionResult.ATFlagQuirk. This is the default.
+	ATFlagTolerate ATFlagMode = iota
+	// ATFlagStrict rejects the flag without attested credential data, as the specification
+	// requires.
+	ATFlagStrict
+)
+
+// WithATFlagMode selects how the attested credential data flag of assertions is handled. Apps
+// that want to know when Apple stops setting the flag keep the default mode and alert on
+// results where AssertionResult.ATFlagQuirk is not set.
+func WithATFlagMode(mode ATFlagMode) Option {
+	return func(v *Verifier) {
+		v.atFlag = mode
+	}
+}
+
+// compatibility returns the mode to decode the authenticator data of assertions with.
+func (v *Verifier) compatibility() authenticator.CompatibilityMode {
+	if v.atFlag == ATFlagStrict {
+		return authenticator.CompatibilityStrict
+	}
+	return authenticator.CompatibilityAssertionATFlag
+}
+
+// signatureAlgorithm describes how assertion signatures are verified for a COSE algorithm.
+type signatureAlgorithm struct {
+	curve elliptic.Curve
+	hash  crypto.Hash
+}
+
+var signatureAlgorithms = map[authenticator.COSEAlgorithmIdentifier]signatureAlgorithm{
+	authenticator.AlgES256: {elliptic.P256(), crypto.SHA256},
+	authenticator.AlgES384: {elliptic.P384(), crypto.SHA384},
+	authenticator.AlgES512: {elliptic.P521(), crypto.SHA512},
+}
+
+// Verify verifies the assertion against the stored challenge, App ID, counter and
+// x963-encoded ES256 public key, and returns the new counter.
+func (v *Verifier) Verify(aar *AuthenticatorAssertionResponse, storedChallenge string, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	return v.VerifyContext(context.Background(), aar, storedChallenge, relyingPartyID, previousCounter, publicKey)
+}
+
+// VerifyContext is like Verify. Verification stops with the context's error once it is done.
+func (v *Verifier) VerifyContext(ctx context.Context, aar *AuthenticatorAssertionResponse, storedChallenge string, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	result, err := v.verify(ctx, aar, nil, storedChallenge, relyingPartyID, es256Credential(previousCounter, publicKey))
+	if err != nil {
+		return 0, err
+	}
+	return result.Counter, nil
+}
+
+// VerifyBase64 verifies the assertion like Verify, taking the assertion as sent by the app in
+// standard or URL-safe base64, with or without padding, and the raw client data.
+func (v *Verifier) VerifyBase64(assertion string, clientData []byte, storedChallenge string, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	return v.VerifyBase64Context(context.Background(), assertion, clientData, storedChallenge, relyingPartyID, previousCounter, publicKey)
+}
+
+// VerifyBase64Context is like VerifyBase64, see VerifyContext.
+func (v *Verifier) VerifyBase64Context(ctx context.Context, assertion string, clientData []byte, storedChallenge string, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	decoded, err := utils.DecodeBase64(assertion)
+	if err != nil {
+		return 0, utils.ErrParsingData.WithDetails(fmt.Sprintf("The assertion was not valid base64: %v", err))
+	}
+	aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: decoded}
+	return v.VerifyContext(ctx, aar, storedChallenge, relyingPartyID, previousCounter, publicKey)
+}
+
+// VerifyAssertion runs the verification steps of Apple's "Validating Apps That Connect to Your
+// Server" on a CBOR-encoded assertion: it computes the nonce from the authenticator data and
+// the client data, verifies the signature with the stored x963-encoded ES256 public key,
+// checks the RP ID hash against the App ID and that the counter increased. It returns the
+// new counter along with the flags of the assertion; when the counter did not increase the
+// result is returned with the error, see VerifyResult. Checking the challenge embedded in
+// clientData is left to the caller.
+func (v *Verifier) VerifyAssertion(assertion, clientData, publicKey []byte, previousCounter uint32, appID string) (*AssertionResult, error) {
+	return v.VerifyAssertionContext(context.Background(), assertion, clientData, publicKey, previousCounter, appID)
+}
+
+// VerifyAssertionContext is like VerifyAssertion, see VerifyContext.
+func (v *Verifier) VerifyAssertionContext(ctx context.Context, assertion, clientData, publicKey []byte, previousCounter uint32, appID string) (*AssertionResult, error) {
+	h, err := v.hashFunc()
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sum(h, clientData)
+	aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+	return v.verify(ctx, aar, clientDataHash, "", appID, es256Credential(previousCounter, publicKey))
+}
+
+// VerifyAssertionKey verifies the assertion like VerifyAssertion with a parsed public key.
+func (v *Verifier) VerifyAssertionKey(assertion, clientData []byte, publicKey *ecdsa.PublicKey, previousCounter uint32, appID string) (*AssertionResult, error) {
+	return v.VerifyAssertionKeyContext(context.Background(), assertion, clientData, publicKey, previousCounter, appID)
+}
+
+// VerifyAssertionKeyContext is like VerifyAssertionKey, see VerifyContext.
+func (v *Verifier) VerifyAssertionKeyContext(ctx context.Context, assertion, clientData []byte, publicKey *ecdsa.PublicKey, previousCounter uint32, appID string) (*AssertionResult, error) {
+	if publicKey == nil || publicKey.X == nil || publicKey.Y == nil {
+		return nil, utils.ErrParsingData.WithDetails("Missing public key")
+	}
+	return v.VerifyAssertionContext(ctx, assertion, clientData, elliptic.Marshal(publicKey.Curve, publicKey.X, publicKey.Y), previousCounter, appID)
+}
+
+// VerifyClientDataHash verifies a CBOR-encoded assertion like Verify, taking the SHA256 hash
+// of the client data, or its hash with the function of WithHash, instead of the client data,
+// for apps that only send the hash. The
+// signature binds the assertion to clientDataHash, so the caller must make sure that it is
+// the hash of the expected client data, e.g. by computing it from the stored challenge; no
+// challenge step runs.
+func (v *Verifier) VerifyClientDataHash(assertion []byte, clientDataHash []byte, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	return v.VerifyClientDataHashContext(context.Background(), assertion, clientDataHash, relyingPartyID, previousCounter, publicKey)
+}
+
+// VerifyClientDataHashContext is like VerifyClientDataHash, see VerifyContext.
+func (v *Verifier) VerifyClientDataHashContext(ctx context.Context, assertion []byte, clientDataHash []byte, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	h, err := v.hashFunc()
+	if err != nil {
+		return 0, err
+	}
+	if len(clientDataHash) != h.Size() {
+		return 0, utils.ErrBadRequest.WithDetails(fmt.Sprintf("Client data hash must be %d bytes, got %d", h.Size(), len(clientDataHash)))
+	}
+	aar := &AuthenticatorAssertionResponse{Assertion: assertion}
+	result, err := v.verify(ctx, aar, clientDataHash, "", relyingPartyID, es256Credential(previousCounter, publicKey))
+	if err != nil {
+		return 0, err
+	}
+	return result.Counter, nil
+}
+
+// VerifyRawClientData verifies a CBOR-encoded assertion over arbitrary client data, such as
+// the bytes of a request, that end with the expected challenge, e.g. method|path|body|challenge.
+// Nothing else is assumed about the format of the client data; a challenge found anywhere but
+// in its last len(challenge) bytes is rejected. For client data that is only the challenge,
+// see WithRawChallenge.
+func (v *Verifier) VerifyRawClientData(assertion, clientData, challenge []byte, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	return v.VerifyRawClientDataContext(context.Background(), assertion, clientData, challenge, relyingPartyID, previousCounter, publicKey)
+}
+
+// VerifyRawClientDataContext is like VerifyRawClientData, see VerifyContext.
+func (v *Verifier) VerifyRawClientDataContext(ctx context.Context, assertion, clientData, challenge []byte, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	if len(challenge) == 0 {
+		return 0, utils.ErrBadRequest.WithDetails("Missing challenge")
+	}
+	// The challenge step compares the extracted challenge, which is only found as a suffix.
+	rv := *v
+	rv.extractChallenge = func(clientData []byte) (string, error) {
+		if len(clientData) < len(challenge) {
+			return "", nil
+		}
+		suffix := clientData[len(clientData)-len(challenge):]
+		if subtle.ConstantTimeCompare(suffix, challenge) != 1 {
+			return "", nil
+		}
+		return string(suffix), nil
+	}
+	aar := &AuthenticatorAssertionResponse{RawClientData: clientData, Assertion: assertion}
+	return rv.VerifyContext(ctx, aar, string(challenge), relyingPartyID, previousCounter, publicKey)
+}
+
+// VerifyReader decodes an AuthenticatorAssertionResponse from the JSON read from r, such as a
+// request body, and verifies it like Verify. Input larger than maxSize bytes is rejected
+// without being read completely.
+func (v *Verifier) VerifyReader(r io.Reader, maxSize int64, storedChallenge string, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	return v.VerifyReaderContext(context.Background(), r, maxSize, storedChallenge, relyingPartyID, previousCounter, publicKey)
+}
+
+// VerifyReaderContext is like VerifyReader, see VerifyContext.
+func (v *Verifier) VerifyReaderContext(ctx context.Context, r io.Reader, maxSize int64, storedChallenge string, relyingPartyID string, previousCounter uint32, publicKey []byte) (uint32, error) {
+	data, err := utils.ReadLimited(r, maxSize)
+	if err != nil {
+		return 0, err
+	}
+	var aar AuthenticatorAssertionResponse
+	if err := json.Unmarshal(data, &aar); err != nil {
+		return 0, utils.ErrBadRequest.WithDetails(err.Error())
+	}
+	return v.VerifyContext(ctx, &aar, storedChallenge, relyingPartyID, previousCounter, publicKey)
+}
+
+// VerifyResult verifies the assertion like Verify and returns an AssertionResult. When the
+// assertion is rejected because the device may have been cloned, the result is returned
+// along with the error.
+func (v *Verifier) VerifyResult(aar *AuthenticatorAssertionResponse, storedChallenge string, relyingPartyID string, previousCounter uint32, publicKey []byte) (*AssertionResult, error) {
+	return v.VerifyResultContext(context.Background(), aar, storedChallenge, relyingPartyID, previousCounter, publicKey)
+}
+
+// VerifyResultContext is like VerifyResult, see VerifyContext.
+func (v *Verifier) VerifyResultContext(ctx context.Context, aar *AuthenticatorAssertionResponse, storedChallenge string, relyingPartyID string, previousCounter uint32, publicKey []byte) (*AssertionResult, error) {
+	return v.verify(ctx, aar, nil, storedChallenge, relyingPartyID, es256Credential(previousCounter, publicKey))
+}
+
+// VerifyCredential verifies the assertion against the stored challenge, App ID and the
+// credential stored after attestation. The signature is verified with the algorithm of the
+// credential, and the new counter is returned.
+func (v *Verifier) VerifyCredential(aar *AuthenticatorAssertionResponse, storedChallenge string, relyingPartyID string, cred *attestation.Credential) (uint32, error) {
+	return v.VerifyCredentialContext(context.Background(), aar, storedChallenge, relyingPartyID, cred)
+}
+
+// VerifyCredentialContext is like VerifyCredential, see VerifyContext.
+func (v *Verifier) VerifyCredentialContext(ctx context.Context, aar *AuthenticatorAssertionResponse, storedChallenge string, relyingPartyID string, cred *attestation.Credential) (uint32, error) {
+	result, err := v.verify(ctx, aar, nil, storedChallenge, relyingPartyID, cred)
+	if err != nil {
+		return 0, err
+	}
+	return result.Counter, nil
+}
+
+// verify runs the verification steps. If clientDataHash is nil it is computed from the client
+// data and the challenge is verified, otherwise the caller has checked the client data.
+func (v *Verifier) verify(ctx context.Context, aar *AuthenticatorAssertionResponse, clientDataHash []byte, storedChallenge string, relyingPartyID string, cred *attestation.Credential) (*AssertionResult, error) {
+	previousCounter, publicKey, alg := cred.Counter, cred.PublicKey, cred.Algorithm
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h, err := v.hashFunc()
+	if err != nil {
+		return nil, err
+	}
+	checkChallenge := clientDataHash == nil
+	a, err := aar.parse(v.compatibility(), checkChallenge && v.extractChallenge == nil)
+	var challenge string
+	if err == nil && checkChallenge {
+		challenge, err = v.challenge(aar)
+	}
+	if err = v.step(StepParse, err); err != nil {
+		return nil, err
+	}
+
+	// A cached assertion is rejected before its signature is verified. It is only recorded
+	// once the signature is valid, so a forged assertion cannot block a genuine one.
+	assertionHash := sha256.Sum256(aar.Assertion)
+	if v.replay != nil && v.contains(assertionHash[:]) {
+		err = v.step(StepReplay, utils.ErrAssertionReplay.WithDetails("Assertion was already used"))
+		result := newResult(a, v.now())
+		result.PossiblyCloned, result.CloneReason = true, CloneReasonReplay
+		return result, err
+	}
+
+	// 1. Compute clientDataHash as the SHA256 hash of clientData.
+	if checkChallenge {
+		clientDataHash = sum(h, aar.RawClientData)
+	}
+
+	// 2. Concatenate authenticatorData and clientDataHash and apply a SHA256 hash over the result to form nonce.
+	// The authenticator data must be used exactly as received: re-encoding it could change the bytes of extensions.
+	nonce := sum(h, a.RawAuthenticatorData, clientDataHash)
+
+	// 3. Use the public key that you stored from the attestation object to verify that the assertion’s signature is valid for nonce.
+	sigAlg, supported := signatureAlgorithms[alg]
+	var pubkey *ecdsa.PublicKey
+	if !supported {
+		err = utils.ErrParsingData.WithDetails(fmt.Sprintf("Unsupported public key algorithm %d", alg))
+	} else if pubkey, err = ParsePublicKey(publicKey); err == nil && pubkey.Curve != sigAlg.curve {
+		err = utils.ErrAlgorithmMismatch.WithDetails(fmt.Sprintf("Public key is on curve %s, algorithm %d uses %s", pubkey.Curve.Params().Name, alg, sigAlg.curve.Params().Name))
+	}
+	if err = v.step(StepPublicKey, err); err != nil {
+		return nil, err
+	}
+	valid := ecdsa.VerifyASN1(pubkey, sum(sigAlg.hash, nonce), a.Signature)
+	if !valid {
+		err = utils.ErrAssertionSignature.WithDetails("Error validating the assertion signature.\n")
+	}
+	if err = v.step(StepSignature, err); err != nil {
+		return nil, err
+	}
+	result := newResult(a, v.now())
+
+	if v.replay != nil {
+		if v.seen(assertionHash[:]) {
+			err = utils.ErrAssertionReplay.WithDetails("Assertion was already used")
+		}
+		if err = v.step(StepReplay, err); err != nil {
+			result.PossiblyCloned, result.CloneReason = true, CloneReasonReplay
+			return result, err
+		}
+	}
+
+	// 4. Compute the SHA256 hash of the client’s App ID, and verify that it matches the RP ID in the authenticator data.
+	rpIDHash := sha256.Sum256([]byte(relyingPartyID))
+	if !bytes.Equal(a.AuthenticatorData.RPIDHash[:], rpIDHash[:]) {
+		if result.LegacyAppID = v.legacy.match(a.AuthenticatorData.RPIDHash, cred); result.LegacyAppID == "" {
+			err = utils.ErrVerification.WithDetails(fmt.Sprintf("RP Hash mismatch. Expected %x and Received %x\n", a.AuthenticatorData.RPIDHash, rpIDHash))
+		}
+	}
+	if err = v.step(StepRPID, err); err != nil {
+		return nil, err
+	}
+	result.RPIDMatched = true
+
+	// 5. Verify that the authenticator data’s counter value is greater than the value from the previous assertion, or greater than 0 on the first assertion.
+	if a.AuthenticatorData.Counter <= previousCounter {
+		err = utils.ErrVerification.WithDetails(fmt.Sprintf("Counter was not not greater than previous  %d\n", a.AuthenticatorData.Counter))
+	}
+	if err = v.step(StepCounter, err); err != nil {
+		result.PossiblyCloned, result.CloneReason = true, CloneReasonCounter
+		return result, err
+	}
+
+	if checkChallenge {
+		// 6. Verify that the challenge embedded in the client data matches the earlier challenge to the client.
+		if storedChallenge != challenge {
+			err = utils.ErrChallengeMismatch.WithDetails("Error validating challenge").
+				WithDetails(fmt.Sprintf("Expected b Value: %#v\nReceived b: %#v\n", storedChallenge, challenge))
+		}
+		if err = v.step(StepChallenge, err); err != nil {
+			return nil, err
+		}
+	}
+
+	if v.validate != nil {
+		if err = v.step(StepClientData, v.validateClientData(ctx, aar.RawClientData)); err != nil {
+			return nil, err
+		}
+	}
+
+	if v.checkReceipt && len(cred.Receipt) > 0 {
+		if err = v.step(StepReceipt, v.checkReceiptExpiration(cred, result)); err != nil {
+			return result, err
+		}
+	}
+
+	if v.anomaly != nil {
+		if err = v.step(StepAnomaly, v.inspect(ctx, cred, result)); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// sum returns the hash of the concatenated data.
+func sum(h crypto.Hash, data ...[]byte) []byte {
+	hash := h.New()
+	for _, d := range data {
+		hash.Write(d)
+	}
+	return hash.Sum(nil)
+}
+
+// es256Credential returns a credential for an ES256 public key stored without its metadata.
+func es256Credential(counter uint32, publicKey []byte) *attestation.Credential {
+	return &attestation.Credential{PublicKey: publicKey, Counter: counter, Algorithm: authenticator.AlgES256}
+}
+
+// challenge returns the challenge embedded in the client data.
+func (v *Verifier) challenge(aar *AuthenticatorAssertionResponse) (string, error) {
+	if v.extractChallenge == nil {
+		return aar.ClientDataJSON.Challenge, nil
+	}
+	return v.extractChallenge(aar.RawClientData)
+}
+
+// step reports the outcome of a verification step and passes the error through.
+func (v *Verifier) step(name string, err error) error {
+	if v.onStep == nil {
+		return err
+	}
+	if err != nil {
+		v.onStep(name, false, err.Error())
+	} else {
+		v.onStep(name, true, "")
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+package attestation
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Maximum size of a fetched certificate.
+const maxAIACertificateSize = 64 << 10
+
+// Maximum number of Authority Information Access URLs of a certificate that are fetched.
+const maxAIAURLs = 2
+
+// AppleAIAHost is the domain Apple publishes its CA certificates under, the only one the
+// AIAFetcher fetches from by default.
+const AppleAIAHost = "apple.com"
+
+// AIAFetcher downloads issuer certificates from the URLs in a certificate's Authority
+// Information Access extension and caches them by URL. The URLs come from the unverified
+// credential certificate, so only https URLs on allowed hosts are fetched.
+type AIAFetcher struct {
+	client    *http.Client
+	hosts     []string
+	cacheSize int
+
+	mu    sync.Mutex
+	cache map[string]*x509.Certificate
+	// URLs of the cache from the oldest
+	order []string
+}
+
+// AIAOption configures an AIAFetcher.
+type AIAOption func(*AIAFetcher)
+
+// WithAIAHosts fetches from the given hosts and their subdomains instead of AppleAIAHost.
+func WithAIAHosts(hosts ...string) AIAOption {
+	return func(f *AIAFetcher) {
+		f.hosts = hosts
+	}
+}
+
+// WithAIACacheSize keeps up to n fetched certificates, evicting the oldest, 16 by default.
+func WithAIACacheSize(n int) AIAOption {
+	return func(f *AIAFetcher) {
+		f.cacheSize = n
+	}
+}
+
+// NewAIAFetcher returns an AIAFetcher using the given client. A nil client uses a client
+// with a five second timeout.
+func NewAIAFetcher(client *http.Client, opts ...AIAOption) *AIAFetcher {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	f := &AIAFetcher{client: client, hosts: []string{AppleAIAHost}, cacheSize: 16, cache: make(map[string]*x509.Certificate)}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.cacheSize < 1 {
+		f.cacheSize = 1
+	}
+	return f
+}
+
+var defaultAIAFetcher = NewAIAFetcher(nil)
+
+// WithAIAFetch enables completing chains whose x5c lacks the intermediate certificate by
+// fetching it from the credential certificate's Authority Information Access URLs, when the
+// known intermediates do not complete it. Only https URLs under AppleAIAHost are fetched, set
+// an AIAFetcher on the X509ChainVerifier to allow other hosts. It only applies to the
+// X509ChainVerifier. It is disabled by default so verification works offline and
+// deterministically.
+func WithAIAFetch(enabled bool) Option {
+	return func(v *Verifier) {
+		v.aiaFetch = enabled
+	}
+}
+
+// WithHTTPClient sends the requests of the verifier, the AIA fetches, OCSP requests and CRL
+// downloads, with client, e.g. to go through a proxy, trust a custom CA or set timeouts. It
+// does not enable them, see WithAIAFetch, WithOCSP and WithCRL, and an AIAFetcher set on the
+// X509ChainVerifier keeps its own client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(v *Verifier) {
+		v.aiaFetcher = NewAIAFetcher(client)
+		v.ocspChecker = newOCSPChecker(client)
+		v.crlChecker = newCRLChecker(client)
+	}
+}
+
+// Fetch returns the certificate at url, from the cache if it was fetched before.
+func (f *AIAFetcher) Fetch(url string) (*x509.Certificate, error) {
+	return f.FetchContext(context.Background(), url)
+}
+
+// FetchContext is like Fetch, aborting the download when ctx is done.
+func (f *AIAFetcher) FetchContext(ctx context.Context, url string) (*x509.Certificate, error) {
+	if err := f.checkURL(url); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	cert, ok := f.cache[url]
+	f.mu.Unlock()
+	if ok {
+		return cert, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Error fetching issuer certificate: %v", err))
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Error fetching issuer certificate: %v", err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Error fetching issuer certificate: status %d", resp.StatusCode))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAIACertificateSize))
+	if err != nil {
+		return nil, utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Error fetching issuer certificate: %v", err))
+	}
+
+	// Issuer certificates are usually DER encoded, but PEM is accepted as well.
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	cert, err = x509.ParseCertificate(data)
+	if err != nil {
+		return nil, utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Error parsing fetched issuer certificate: %v", err))
+	}
+
+	f.mu.Lock()
+	if _, ok := f.cache[url]; !ok {
+		if len(f.order) >= f.cacheSize {
+			delete(f.cache, f.order[0])
+			f.order = f.order[1:]
+		}
+		f.order = append(f.order, url)
+	}
+	f.cache[url] = cert
+	f.mu.Unlock()
+	return cert, nil
+}
+
+// checkURL checks that rawURL is an https URL on an allowed host.
+func (f *AIAFetcher) checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Invalid issuer certificate URL: %v", err))
+	}
+	if u.Scheme != "https" {
+		return utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Issuer certificate URL %q is not https", rawURL))
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range f.hosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
+	}
+	return utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Issuer certificate host %q is not allowed", u.Hostname()))
+}
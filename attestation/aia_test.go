@@ -0,0 +1,201 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/testutil"
+)
+
+func TestAIAFetch(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	TimeNow = time.Now
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write(authority.Intermediate.Raw)
+	}))
+	defer server.Close()
+
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	att, err := authority.Attest(device, testutil.AttestOptions{
+		AppID:            appID,
+		ClientData:       []byte("challenge"),
+		OmitIntermediate: true,
+		Modify: func(leaf *x509.Certificate) {
+			leaf.IssuingCertificateURL = []string{server.URL + "/ca.der"}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal(att.JSON(), &aar); err != nil {
+		t.Fatal(err)
+	}
+	newFetcher := func(opts ...AIAOption) *AIAFetcher {
+		return NewAIAFetcher(server.Client(), append([]AIAOption{WithAIAHosts("127.0.0.1")}, opts...)...)
+	}
+
+	t.Run("Leaf-only chain fails without AIA", func(t *testing.T) {
+		v := NewVerifier(WithChainVerifier(X509ChainVerifier{Roots: authority.Roots()}))
+		if _, err := v.Verify(&aar, appID, false); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if requests != 0 {
+			t.Fatal("Fetched the intermediate without AIA enabled")
+		}
+	})
+
+	t.Run("Leaf-only chain is completed with AIA", func(t *testing.T) {
+		cv := X509ChainVerifier{Roots: authority.Roots(), AIA: newFetcher()}
+		v := NewVerifier(WithChainVerifier(cv), WithAIAFetch(true))
+		for i := 0; i < 2; i++ {
+			if _, err := v.Verify(&aar, appID, false); err != nil {
+				t.Fatalf("Not valid: %+v", err)
+			}
+		}
+		if requests != 1 {
+			t.Fatalf("Expected the intermediate to be fetched once, got %d requests", requests)
+		}
+		if _, err := v.VerifyPolicy(&aar, &Policy{AppID: appID, Offline: true}); err == nil {
+			t.Fatal("Expected offline verification not to fetch the intermediate")
+		}
+	})
+
+	t.Run("Fetch uses the configured client", func(t *testing.T) {
+		// Apple's hosts are allowed by default, the client answers in place of Apple.
+		apple, err := authority.Attest(device, testutil.AttestOptions{
+			AppID:            appID,
+			ClientData:       []byte("challenge"),
+			OmitIntermediate: true,
+			Modify: func(leaf *x509.Certificate) {
+				leaf.IssuingCertificateURL = []string{"https://certs.apple.com/appattestca1.der"}
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var proxied int32
+		client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&proxied, 1)
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(authority.Intermediate.Raw)), Request: r}, nil
+		})}
+		v := NewVerifier(WithChainVerifier(X509ChainVerifier{Roots: authority.Roots()}), WithAIAFetch(true), WithHTTPClient(client))
+		var appleAAR AuthenticatorAttestationResponse
+		if err := json.Unmarshal(apple.JSON(), &appleAAR); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Verify(&appleAAR, appID, false); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if proxied != 1 {
+			t.Fatalf("Expected the fetch to go through the client, got %d requests", proxied)
+		}
+	})
+
+	t.Run("Disallowed URLs are not fetched", func(t *testing.T) {
+		before := requests
+		for _, url := range []string{server.URL + "/ca.der", "http://certs.apple.com/ca.der", "https://apple.com.example.org/ca.der", "file:///etc/passwd"} {
+			if _, err := NewAIAFetcher(server.Client()).Fetch(url); err == nil {
+				t.Errorf("Expected %s to be rejected", url)
+			}
+		}
+		// The default fetcher only allows Apple's hosts, so the test server is not contacted.
+		cv := X509ChainVerifier{Roots: authority.Roots(), AIA: NewAIAFetcher(server.Client())}
+		if _, err := cv.Verify([][]byte{att.Leaf.Raw}, time.Now()); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if requests != before {
+			t.Fatal("Fetched from a host that is not allowed")
+		}
+	})
+
+	t.Run("Untrusted chain is not fetched again within the skew", func(t *testing.T) {
+		other, err := testutil.NewAuthority(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var attempts int32
+		failing := NewAIAFetcher(&http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&attempts, 1)
+			return nil, errors.New("unreachable")
+		})}, WithAIAHosts("127.0.0.1"))
+		cv := X509ChainVerifier{Roots: other.Roots(), AIA: failing}
+		if _, err := VerifyCertificateChain(&AttestationObject{AttStatement: map[string]interface{}{"x5c": []interface{}{att.Leaf.Raw}}}, cv, time.Hour); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if attempts != 1 {
+			t.Fatalf("Expected one fetch, got %d", attempts)
+		}
+	})
+
+	t.Run("Known intermediates are used before fetching", func(t *testing.T) {
+		before := requests
+		failing := NewAIAFetcher(&http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return nil, errors.New("unreachable")
+		})}, WithAIAHosts("127.0.0.1"))
+		cv := X509ChainVerifier{Roots: authority.Roots(), AIA: failing, Intermediates: []*x509.Certificate{authority.Intermediate}}
+		if _, err := cv.Verify([][]byte{att.Leaf.Raw}, time.Now()); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if requests != before {
+			t.Fatal("Fetched the intermediate although it is known")
+		}
+	})
+
+	t.Run("Cache is bounded", func(t *testing.T) {
+		f := newFetcher(WithAIACacheSize(2))
+		for i := 0; i < 5; i++ {
+			if _, err := f.Fetch(fmt.Sprintf("%s/ca%d.der", server.URL, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(f.cache) != 2 || len(f.order) != 2 {
+			t.Fatalf("Expected 2 cached certificates, got %d", len(f.cache))
+		}
+		before := requests
+		if _, err := f.Fetch(server.URL + "/ca0.der"); err != nil {
+			t.Fatal(err)
+		}
+		if requests != before+1 {
+			t.Fatal("Expected the oldest certificate to be evicted")
+		}
+	})
+
+	t.Run("Fetch is bounded by the context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		before := requests
+		cv := X509ChainVerifier{Roots: authority.Roots(), AIA: newFetcher()}
+		if _, err := cv.VerifyContext(ctx, [][]byte{att.Leaf.Raw}, time.Now()); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if requests != before {
+			t.Fatal("Fetched the intermediate with a cancelled context")
+		}
+	})
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
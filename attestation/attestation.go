@@ -2,18 +2,20 @@ package attestation
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/asn1"
-	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/jyrodrigues/appattest/authenticator"
 	"github.com/jyrodrigues/appattest/utils"
-	"github.com/ugorji/go/codec"
 )
 
 const APPLE_ROOT_CERT = `-----BEGIN CERTIFICATE-----
@@ -31,6 +33,24 @@ CgYIKoZIzj0EAwMDaAAwZQIwQgFGnByvsiVbpTKwSga0kP0e8EeDS4+sQmTvb7vn
 oyFraWVIyd/dganmrduC1bmTBGwD
 -----END CERTIFICATE-----`
 
+// appleIntermediateCert is Apple's App Attest intermediate certificate, AppleIntermediateCN,
+// valid until 2030.
+const appleIntermediateCert = `-----BEGIN CERTIFICATE-----
+MIICQzCCAcigAwIBAgIQCbrF4bxAGtnUU5W8OBoIVDAKBggqhkjOPQQDAzBSMSYw
+JAYDVQQDDB1BcHBsZSBBcHAgQXR0ZXN0YXRpb24gUm9vdCBDQTETMBEGA1UECgwK
+QXBwbGUgSW5jLjETMBEGA1UECAwKQ2FsaWZvcm5pYTAeFw0yMDAzMTgxODM5NTVa
+Fw0zMDAzMTMwMDAwMDBaME8xIzAhBgNVBAMMGkFwcGxlIEFwcCBBdHRlc3RhdGlv
+biBDQSAxMRMwEQYDVQQKDApBcHBsZSBJbmMuMRMwEQYDVQQIDApDYWxpZm9ybmlh
+MHYwEAYHKoZIzj0CAQYFK4EEACIDYgAErls3oHdNebI1j0Dn0fImJvHCX+8XgC3q
+s4JqWYdP+NKtFSV4mqJmBBkSSLY8uWcGnpjTY71eNw+/oI4ynoBzqYXndG6jWaL2
+bynbMq9FXiEWWNVnr54mfrJhTcIaZs6Zo2YwZDASBgNVHRMBAf8ECDAGAQH/AgEA
+MB8GA1UdIwQYMBaAFKyREFMzvb5oQf+nDKnl+url5YqhMB0GA1UdDgQWBBQ+410c
+BBmpybQx+IR01uHhV3LjmzAOBgNVHQ8BAf8EBAMCAQYwCgYIKoZIzj0EAwMDaQAw
+ZgIxALu+iI1zjQUCz7z9Zm0JV1A1vNaHLD+EMEkmKe3R+RToeZkcmui1rvjTqFQz
+97YNBgIxAKs47dDMge0ApFLDukT5k2NlU/7MKX8utN+fXr5aSsq2mVxLgg35BDhv
+eAe7WJQ5tw==
+-----END CERTIFICATE-----`
+
 const attestationKey = "apple-appattest"
 
 // TimeNow is an indirection to allow tests to replace the current time
@@ -43,6 +63,7 @@ type AuthenticatorAttestationResponse struct {
 	AttestationObject utils.URLEncodedBase64 `json:"attestationObject"`
 }
 
+// AttestationObject is a decoded attestation object.
 type AttestationObject struct {
 	AuthData     authenticator.AuthenticatorData
 	RawAuthData  []byte                 `json:"authData"`
@@ -50,164 +71,281 @@ type AttestationObject struct {
 	AttStatement map[string]interface{} `json:"attStmt,omitempty"`
 }
 
+// Verify verifies the attestation using a default Verifier and returns the x963-encoded
+// public key and the receipt.
 func (aar *AuthenticatorAttestationResponse) Verify(appID string, production bool) ([]byte, []byte, error) {
-	a, err := aar.parse()
+	result, err := NewVerifier().Verify(aar, appID, production)
 	if err != nil {
 		return nil, nil, err
 	}
+	return result.PublicKey, result.Receipt, nil
+}
 
-	// Compute clientDataHash as the SHA256 hash of clientData.
-	clientDataHash := sha256.Sum256(aar.ClientData)
+// AppleRootCertificate returns the parsed Apple App Attestation root certificate. The embedded
+// certificate is parsed once and the result is reused by every verification.
+func AppleRootCertificate() (*x509.Certificate, error) {
+	return appleRoot()
+}
 
-	// Check if we have the right format.
-	if a.Format != attestationKey {
-		return nil, nil, utils.ErrAttestationFormat.WithDetails(fmt.Sprintf("Wrong attestation format unsupported: %s", a.Format))
-	}
+// AppleIntermediateCertificate returns the parsed Apple App Attest intermediate certificate,
+// embedded for x5c arrays that lack it, see WithIntermediates.
+func AppleIntermediateCertificate() (*x509.Certificate, error) {
+	return appleIntermediate()
+}
 
-	// Decode the key ID
-	keyIdData, err := base64.StdEncoding.DecodeString(aar.KeyID)
+var appleIntermediate = sync.OnceValues(func() (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(appleIntermediateCert))
+	if block == nil {
+		return nil, utils.ErrAttestationFormat.WithDetails("Error decoding intermediate certificate.")
+	}
+	ct, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		return nil, nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("The KeyID was not valid base64: %s", aar.KeyID))
+		return nil, utils.ErrAttestationFormat.WithDetails(fmt.Sprintf("Error parsing intermediate certificate: %+v", err))
 	}
+	return ct, nil
+})
 
-	// Handle Steps 6 through 9
-	// 6. Compute the SHA256 hash of your app’s App ID
-	appIDHash := sha256.Sum256([]byte(appID))
-	authDataVerificationError := a.AuthData.Verify(appIDHash[:], keyIdData, production)
-	if authDataVerificationError != nil {
-		return nil, nil, authDataVerificationError
+var appleRoot = sync.OnceValues(func() (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(APPLE_ROOT_CERT))
+	if block == nil {
+		return nil, utils.ErrAttestationFormat.WithDetails("Error decoding root certificate.")
 	}
+	ct, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, utils.ErrAttestationFormat.WithDetails(fmt.Sprintf("Error parsing root certificate: %+v", err))
+	}
+	return ct, nil
+})
 
-	// Handle step 1 through 5
-	return verifyAttestation(*a, clientDataHash[:], keyIdData)
-}
-
-func (aar *AuthenticatorAttestationResponse) parse() (*AttestationObject, error) {
+// appleRoots returns the shared certificate pool holding the Apple root certificate.
+// The pool must not be modified.
+var appleRoots = sync.OnceValues(func() (*x509.CertPool, error) {
+	root, err := appleRoot()
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+	return pool, nil
+})
+
+// ParseAttestationObject decodes a CBOR-encoded attestation object and its authenticator data
+// without verifying anything, e.g. to log or inspect incoming attestations.
+func ParseAttestationObject(data []byte) (*AttestationObject, error) {
 	var a AttestationObject
 
-	cborHandler := codec.CborHandle{}
-
-	err := codec.NewDecoderBytes(aar.AttestationObject, &cborHandler).Decode(&a)
+	_, err := utils.UnmarshalCBORFirst(data, &a)
 	if err != nil {
 		return nil, utils.ErrParsingData.WithDetails(err.Error())
 	}
 
-	err = a.AuthData.Unmarshal(a.RawAuthData)
+	err = a.AuthData.UnmarshalAttested(a.RawAuthData)
 	if err != nil {
 		return nil, fmt.Errorf("error decoding auth data: %v", err)
 	}
 
-	if !a.AuthData.Flags.HasAttestedCredentialData() {
-		return nil, utils.ErrAttestationFormat.WithDetails("Attestation missing attested credential data flag")
-	}
-
 	return &a, nil
 }
 
-func verifyAttestation(att AttestationObject, clientDataHash, keyID []byte) ([]byte, []byte, error) {
-	// Validate according to https://developer.apple.com/documentation/devicecheck/validating_apps_that_connect_to_your_server
-	// Create certificate pool with the Apple Root cert.
-
-	roots := x509.NewCertPool()
-	intermediates := x509.NewCertPool()
-
-	// Add Apple root Cert
-	ok := roots.AppendCertsFromPEM([]byte(APPLE_ROOT_CERT))
-	if !ok {
-		return nil, nil, utils.ErrAttestationFormat.WithDetails("Error adding root certificate to pool.")
-	}
-
-	x5c, x509present := att.AttStatement["x5c"].([]interface{})
-	if !x509present {
-		return nil, nil, utils.ErrAttestationFormat.WithDetails("Error retrieving x5c value")
-	}
-
-	receipt, receiptPresent := att.AttStatement["receipt"].([]byte)
-	if !receiptPresent {
-		return nil, nil, utils.ErrAttestationFormat.WithDetails("Error retreiving receipt value")
+// certificateChain returns the DER-encoded certificates of the x5c array in the attestation statement.
+func certificateChain(attStmt map[string]interface{}) ([][]byte, error) {
+	x5c, x509present := attStmt["x5c"].([]interface{})
+	if !x509present || len(x5c) == 0 {
+		return nil, utils.ErrAttestationFormat.WithDetails("Error retrieving x5c value")
 	}
 
+	chain := make([][]byte, 0, len(x5c))
 	for _, c := range x5c {
 		cb, cv := c.([]byte)
 		if !cv {
-			return nil, nil, utils.ErrAttestationCertificate.WithDetails("Error getting certificate from x5c cert chain 1")
-		}
-		ct, err := x509.ParseCertificate(cb)
-		if err != nil {
-			return nil, nil, utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Error parsing certificate from ASN.1 data: %+v", err))
-		}
-		if ct.IsCA {
-			intermediates.AddCert(ct)
+			return nil, utils.ErrAttestationCertificate.WithDetails("Error getting certificate from x5c cert chain")
 		}
+		chain = append(chain, cb)
 	}
+	return chain, nil
+}
 
-	credCertBytes, valid := x5c[0].([]byte)
-	if !valid {
-		return nil, nil, utils.ErrAttestationCertificate.WithDetails("Error getting certificate from x5c cert chain 2")
-	}
+// VerifyCertificateChain verifies the x5c certificate chain of the attestation (step 1) and
+// returns the verified chain, starting with the credential certificate. When only the chain
+// verification failed, the parsed credential certificate is returned alone along with the
+// error. If a certificate is not valid at the current time, the chain is accepted if valid
+// within skew of it. A nil cv verifies the chain against the Apple root certificate.
+//
+// VerifyCertificateChain, VerifyNonce, VerifyKeyID and AuthenticatorData.VerifyWithAppID are
+// the individual steps of Verifier.Verify, for callers composing their own pipeline.
+func VerifyCertificateChain(att *AttestationObject, cv ChainVerifier, skew time.Duration) ([]*x509.Certificate, error) {
+	return VerifyCertificateChainContext(context.Background(), att, cv, skew)
+}
 
-	credCert, err := x509.ParseCertificate(credCertBytes)
-	if err != nil {
-		return nil, nil, utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Error parsing certificate from ASN.1 data: %+v", err))
-	}
+// VerifyCertificateChainContext is like VerifyCertificateChain, passing ctx to a
+// ContextChainVerifier. Both verify the chain at TimeNow, see Verifier.VerifyCertificateChain
+// to verify it with the clock of a Verifier.
+func VerifyCertificateChainContext(ctx context.Context, att *AttestationObject, cv ChainVerifier, skew time.Duration) ([]*x509.Certificate, error) {
+	return verifyCertificateChain(ctx, att, cv, TimeNow(), skew)
+}
 
-	// Create verification options.
-	verifyOptions := x509.VerifyOptions{
-		Roots:         roots,
-		Intermediates: intermediates,
-		CurrentTime:   TimeNow(),
+// VerifyCertificateChain is like VerifyCertificateChainContext with the chain verifier, clock
+// and clock skew of the verifier.
+func (v *Verifier) VerifyCertificateChain(ctx context.Context, att *AttestationObject) ([]*x509.Certificate, error) {
+	return verifyCertificateChain(ctx, att, v.chainVerifier(), v.now(), v.clockSkew)
+}
+
+// verifyCertificateChain verifies the chain at the time now, within skew of it.
+func verifyCertificateChain(ctx context.Context, att *AttestationObject, cv ChainVerifier, now time.Time, skew time.Duration) ([]*x509.Certificate, error) {
+	if cv == nil {
+		cv = X509ChainVerifier{}
+	}
+	x5c, err := certificateChain(att.AttStatement)
+	if err != nil {
+		return nil, err
 	}
 
 	// 1. Verify that the x5c array contains the intermediate and leaf certificates for App Attest,
 	// starting from the credential certificate stored in the first data buffer in the array (credcert).
 	// Verify the validity of the certificates using Apple’s root certificate.
-	_, err = credCert.Verify(verifyOptions)
+	chain, err := verifyChain(ctx, cv, x5c, now)
+	if err != nil && skew > 0 && isValidityError(err) {
+		for _, t := range []time.Time{now.Add(-skew), now.Add(skew)} {
+			if skewed, serr := verifyChain(ctx, cv, x5c, t); serr == nil {
+				chain, err = skewed, nil
+				break
+			}
+		}
+	}
 	if err != nil {
-		return nil, nil, utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Invalid certificate %+v", err))
+		if credCert, perr := x509.ParseCertificate(x5c[0]); perr == nil {
+			return []*x509.Certificate{credCert}, err
+		}
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, utils.ErrAttestationCertificate.WithDetails("Chain verifier returned an empty chain")
 	}
 
+	return chain, nil
+}
+
+// isValidityError reports whether the chain failed to verify only because a certificate is not
+// valid at the time of verification, which the clock skew may tolerate. Other failures are
+// not retried, so an untrusted chain does not cost more AIA fetches.
+func isValidityError(err error) bool {
+	var chainErr *ErrChain
+	if errors.As(err, &chainErr) {
+		return chainErr.Reason == ChainExpired
+	}
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &invalid) && invalid.Reason == x509.Expired
+}
+
+// ComputeNonce returns the nonce Apple embeds in the credential certificate, the SHA256 hash
+// of the authenticator data followed by the client data hash.
+func ComputeNonce(authData, clientDataHash []byte) []byte {
 	// 2. Create clientDataHash as the SHA256 hash of the one-time challenge sent to your app before performing the attestation,
 	// and append that hash to the end of the authenticator data (authData from the decoded object).
-	nonceData := append(att.RawAuthData, clientDataHash...)
+	h := sha256.New()
+	h.Write(authData)
+	h.Write(clientDataHash)
 
 	// 3. Generate a new SHA256 hash of the composite item to create nonce.
-	nonce := sha256.Sum256(nonceData)
+	return h.Sum(nil)
+}
+
+// oidNonce identifies the credential certificate extension holding the nonce.
+var oidNonce = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 2}
+
+// VerifyNonce checks that the nonce embedded in the credential certificate matches the
+// authenticator data and client data hash (steps 2 to 4).
+func VerifyNonce(credCert *x509.Certificate, rawAuthData, clientDataHash []byte) error {
+	nonce := ComputeNonce(rawAuthData, clientDataHash)
 
 	// 4. Obtain the value of the credCert extension with OID 1.2.840.113635.100.8.2, which is a DER-encoded ASN.1 sequence.
 	// Decode the sequence and extract the single octet string that it contains.
 	// Verify that the string equals nonce.
-	credCertOID := asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 2}
-	var credCertId []byte
+	certNonce, err := ExtractNonce(credCert)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(nonce, certNonce) {
+		return utils.ErrNonceMismatch.WithDetails("Certificate CredCert extension does not match nonce.").
+			WithInfo(fmt.Sprintf("Certificate nonce %x, expected %x", certNonce, nonce))
+	}
+
+	return nil
+}
+
+// ExtractNonce returns the nonce of the credential certificate, from its extension with OID
+// 1.2.840.113635.100.8.2. The extension is a sequence holding the nonce in an octet string
+// explicitly tagged [1]; an implicitly tagged or untagged octet string is accepted as well.
+// The nonce is only extracted, see VerifyNonce to check it.
+func ExtractNonce(credCert *x509.Certificate) ([]byte, error) {
 	for _, extension := range credCert.Extensions {
-		if extension.Id.Equal(credCertOID) {
-			credCertId = extension.Value
+		if extension.Id.Equal(oidNonce) {
+			return parseNonceExtension(extension.Value)
 		}
 	}
+	return nil, utils.ErrInvalidAttestation.WithDetails("Certificate did not contain credCert extension")
+}
 
-	if len(credCertId) <= 0 {
-		return nil, nil, utils.ErrInvalidAttestation.WithDetails("Certificate did not contain credCert extension")
+// parseNonceExtension returns the nonce of the DER-encoded value of the nonce extension.
+func parseNonceExtension(value []byte) ([]byte, error) {
+	var seq asn1.RawValue
+	if rest, err := asn1.Unmarshal(value, &seq); err != nil || len(rest) > 0 ||
+		seq.Class != asn1.ClassUniversal || seq.Tag != asn1.TagSequence || !seq.IsCompound {
+		return nil, utils.ErrInvalidAttestation.WithDetails("Certificate CredCert extension is not a valid sequence")
 	}
-	var unMarshalledCredCertOctet []asn1.RawValue
-	var unMarshalledCredCert asn1.RawValue
-	asn1.Unmarshal(credCertId, &unMarshalledCredCertOctet)
-	asn1.Unmarshal(unMarshalledCredCertOctet[0].Bytes, &unMarshalledCredCert)
-	if !bytes.Equal(nonce[:], unMarshalledCredCert.Bytes) {
-		return nil, nil, utils.ErrInvalidAttestation.WithDetails("Certificate CredCert extension does not match nonce.")
+
+	for elements := seq.Bytes; len(elements) > 0; {
+		var element asn1.RawValue
+		var err error
+		if elements, err = asn1.Unmarshal(elements, &element); err != nil {
+			return nil, utils.ErrInvalidAttestation.WithDetails(fmt.Sprintf("Certificate CredCert extension is malformed: %v", err))
+		}
+
+		var nonce []byte
+		switch {
+		case element.Class == asn1.ClassContextSpecific && element.Tag == 1 && element.IsCompound:
+			var octets asn1.RawValue
+			rest, err := asn1.Unmarshal(element.Bytes, &octets)
+			if err != nil || len(rest) > 0 || octets.Class != asn1.ClassUniversal || octets.Tag != asn1.TagOctetString || octets.IsCompound {
+				return nil, utils.ErrInvalidAttestation.WithDetails("Certificate CredCert extension does not contain an octet string")
+			}
+			nonce = octets.Bytes
+		case element.Class == asn1.ClassContextSpecific && element.Tag == 1,
+			element.Class == asn1.ClassUniversal && element.Tag == asn1.TagOctetString && !element.IsCompound:
+			nonce = element.Bytes
+		default:
+			// Other fields are left for future versions of the extension.
+			continue
+		}
+		if len(nonce) != sha256.Size {
+			return nil, utils.ErrInvalidAttestation.WithDetails(fmt.Sprintf("Certificate CredCert extension holds a %d byte nonce, expected %d", len(nonce), sha256.Size))
+		}
+		return nonce, nil
 	}
+	return nil, utils.ErrInvalidAttestation.WithDetails("Certificate CredCert extension does not contain an octet string")
+}
+
+// KeyIDFromPublicKey returns the key identifier of a public key, the SHA256 hash of its
+// x963 (uncompressed point) encoding. Storage layers can use it to index credentials.
+func KeyIDFromPublicKey(pub *ecdsa.PublicKey) []byte {
+	keyID := sha256.Sum256(elliptic.Marshal(pub.Curve, pub.X, pub.Y))
+	return keyID[:]
+}
 
+// VerifyKeyID checks that the key identifier is the SHA256 hash of the public key in the
+// credential certificate (step 5). It returns the x963-encoded public key.
+func VerifyKeyID(credCert *x509.Certificate, keyID []byte) ([]byte, error) {
 	// 5. Create the SHA256 hash of the public key in credCert, and verify that it matches the key identifier from your app.
 	var publicKeyBytes []byte
 	switch pub := credCert.PublicKey.(type) {
 	case *ecdsa.PublicKey:
 		publicKeyBytes = elliptic.Marshal(pub.Curve, pub.X, pub.Y)
-		pubKeyHash := sha256.Sum256(publicKeyBytes)
-		if !bytes.Equal(pubKeyHash[:], keyID) {
-			return nil, nil, utils.ErrInvalidAttestation.WithDetails("The key id is not a valid SHA256 hash of the certificate public key.")
+		if !bytes.Equal(KeyIDFromPublicKey(pub), keyID) {
+			return nil, utils.ErrInvalidAttestation.WithDetails("The key id is not a valid SHA256 hash of the certificate public key.")
 		}
 	default:
-		return nil, nil, utils.ErrInvalidAttestation.WithDetails("Wrong algorithm")
+		return nil, utils.ErrUnsupportedAlgorithm.WithDetails(fmt.Sprintf("Public key of the credential certificate is a %T, expected an ECDSA key", pub))
 	}
 
-	// Return x963-encoded public key and receipt.
-	return publicKeyBytes, receipt, nil
+	return publicKeyBytes, nil
 }
@@ -2,10 +2,26 @@ package attestation
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/receipt"
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
 )
 
 func TestAttestationVerification(t *testing.T) {
@@ -32,6 +48,555 @@ func TestAttestationVerification(t *testing.T) {
 	})
 }
 
+func TestVerifyAttestation(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	keyID, err := base64.StdEncoding.DecodeString(aar.KeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientDataHash := sha256.Sum256(aar.ClientData)
+	appID := "35MFYY2JY5.co.chiff.attestation-test"
+
+	result, err := VerifyAttestation(aar.AttestationObject, keyID, clientDataHash[:], appID, false)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	decodedPk, err := hex.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Could not decode public key: %+s", publicKey)
+	}
+	if !bytes.Equal(result.PublicKey, decodedPk) {
+		t.Fatalf("Wrong Public key : %x", result.PublicKey)
+	}
+	if result.Key == nil || !bytes.Equal(elliptic.Marshal(result.Key.Curve, result.Key.X, result.Key.Y), decodedPk) {
+		t.Fatalf("Wrong parsed public key: %+v", result.Key)
+	}
+	if !bytes.Equal(result.KeyID, keyID) || len(result.Receipt) == 0 {
+		t.Fatalf("Wrong result: %+v", result)
+	}
+	if result.Environment != authenticator.EnvironmentDevelopment || result.Counter != 0 {
+		t.Fatalf("Wrong environment %s or counter %d", result.Environment, result.Counter)
+	}
+
+	otherHash := sha256.Sum256([]byte("other-challenge"))
+	_, err = VerifyAttestation(aar.AttestationObject, keyID, otherHash[:], appID, false)
+	var e *utils.Error
+	if !errors.As(err, &e) || e.Type != utils.ErrNonceMismatch.Type {
+		t.Fatalf("Expected a nonce mismatch, got %v", err)
+	}
+	if _, err := VerifyAttestation(aar.AttestationObject, nil, clientDataHash[:], appID, false); err == nil {
+		t.Fatal("Expected a missing key ID to be rejected")
+	}
+	if _, err := VerifyAttestation(aar.AttestationObject, keyID, clientDataHash[:], appID, true); err == nil {
+		t.Fatal("Expected the wrong environment to be rejected")
+	}
+}
+
+func TestVerifyAttestationBase64(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	var fields map[string]string
+	if err := json.Unmarshal([]byte(attestation), &fields); err != nil {
+		t.Fatal(err)
+	}
+	clientData, err := base64.RawURLEncoding.DecodeString(fields["clientData"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientDataHash := sha256.Sum256(clientData)
+
+	keyID := strings.NewReplacer("+", "-", "/", "_").Replace(fields["keyID"])
+	result, err := VerifyAttestationBase64(fields["attestationObject"], keyID, clientDataHash[:], "35MFYY2JY5.co.chiff.attestation-test", false)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if utils.KeyIDString(result.KeyID) != strings.TrimRight(keyID, "=") {
+		t.Fatalf("Wrong key ID: %x", result.KeyID)
+	}
+	if _, err := VerifyAttestationBase64(fields["attestationObject"], "AAAA", clientDataHash[:], "35MFYY2JY5.co.chiff.attestation-test", false); err == nil {
+		t.Fatal("Expected a short key ID to be rejected")
+	}
+}
+
+func TestVerifyAttestationReader(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	appID := "35MFYY2JY5.co.chiff.attestation-test"
+	if _, err := VerifyAttestationReader(strings.NewReader(attestation), int64(len(attestation)), appID, false); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	_, err := VerifyAttestationReader(strings.NewReader(attestation), int64(len(attestation)-1), appID, false)
+	var e *utils.Error
+	if !errors.As(err, &e) || e.Type != utils.ErrBadRequest.Type {
+		t.Fatalf("Expected oversized input to be rejected, got %v", err)
+	}
+}
+
+func TestVerifyContext(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	appID := "35MFYY2JY5.co.chiff.attestation-test"
+	if _, err := NewVerifier().VerifyContext(context.Background(), &aar, appID, false); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewVerifier().VerifyContext(ctx, &aar, appID, false); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestVerifyAppIDs(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	appIDs := []string{"35MFYY2JY5.co.chiff.app", "35MFYY2JY5.co.chiff.attestation-test", "35MFYY2JY5.co.chiff.app.Clip"}
+
+	result, err := NewVerifier().VerifyAppIDs(&aar, appIDs, false)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if result.AppID != appIDs[1] {
+		t.Fatalf("Wrong App ID: %s", result.AppID)
+	}
+	if _, err := NewVerifier().VerifyAppIDs(&aar, []string{appIDs[0], appIDs[2]}, false); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if _, err := NewVerifier().VerifyAppIDs(&aar, nil, false); err == nil {
+		t.Fatal("Expected an error without App IDs")
+	}
+}
+
+func TestParseAttestationObject(t *testing.T) {
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	keyID, err := base64.StdEncoding.DecodeString(aar.KeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := ParseAttestationObject(aar.AttestationObject)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if a.Format != "apple-appattest" {
+		t.Fatalf("Wrong format: %s", a.Format)
+	}
+	if _, ok := a.AttStatement["x5c"]; !ok {
+		t.Fatal("Missing x5c in the attestation statement")
+	}
+	if !bytes.Equal(a.AuthData.AttData.CredentialID, keyID) {
+		t.Fatalf("Wrong credential ID: %x", a.AuthData.AttData.CredentialID)
+	}
+
+	if _, err := ParseAttestationObject(aar.AttestationObject[:100]); err == nil {
+		t.Fatal("Expected an error for a truncated attestation object")
+	}
+}
+
+func TestComputeNonce(t *testing.T) {
+	authData := make([]byte, 37, 64)
+	clientDataHash := sha256.Sum256([]byte("challenge"))
+	expected := sha256.Sum256(append(make([]byte, 37), clientDataHash[:]...))
+
+	if nonce := ComputeNonce(authData, clientDataHash[:]); !bytes.Equal(nonce, expected[:]) {
+		t.Fatalf("Wrong nonce: %x", nonce)
+	}
+	// The spare capacity of authData must not be written to.
+	if spare := authData[:cap(authData)][37:]; !bytes.Equal(spare, make([]byte, len(spare))) {
+		t.Fatal("ComputeNonce modified the authenticator data")
+	}
+}
+
+func TestExtractNonce(t *testing.T) {
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	att, err := ParseAttestationObject(aar.AttestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x5c, err := certificateChain(att.AttStatement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credCert, err := x509.ParseCertificate(x5c[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientDataHash := sha256.Sum256(aar.ClientData)
+	nonce, err := ExtractNonce(credCert)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if !bytes.Equal(nonce, ComputeNonce(att.RawAuthData, clientDataHash[:])) {
+		t.Fatalf("Wrong nonce: %x", nonce)
+	}
+
+	expected := sha256.Sum256([]byte("nonce"))
+	octets, _ := asn1.Marshal(expected[:])
+	tagged := func(class, tag int, compound bool, content []byte) []byte {
+		b, _ := asn1.Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: compound, Bytes: content})
+		return b
+	}
+	sequence := func(elements ...[]byte) []byte {
+		return tagged(asn1.ClassUniversal, asn1.TagSequence, true, bytes.Join(elements, nil))
+	}
+	tests := []struct {
+		name  string
+		value []byte
+		valid bool
+	}{
+		{"Explicit tag", sequence(tagged(asn1.ClassContextSpecific, 1, true, octets)), true},
+		{"Implicit tag", sequence(tagged(asn1.ClassContextSpecific, 1, false, expected[:])), true},
+		{"Untagged", sequence(octets), true},
+		{"Unknown field first", sequence(tagged(asn1.ClassContextSpecific, 0, false, []byte{1}), tagged(asn1.ClassContextSpecific, 1, true, octets)), true},
+		{"Not a sequence", octets, false},
+		{"Trailing bytes", append(sequence(octets), 0), false},
+		{"Empty sequence", sequence(), false},
+		{"Explicit tag without octet string", sequence(tagged(asn1.ClassContextSpecific, 1, true, tagged(asn1.ClassUniversal, asn1.TagInteger, false, []byte{1}))), false},
+		{"Short nonce", sequence(tagged(asn1.ClassContextSpecific, 1, false, expected[:16])), false},
+		{"Truncated", sequence(octets)[:10], false},
+	}
+	for _, tt := range tests {
+		nonce, err := parseNonceExtension(tt.value)
+		if tt.valid && (err != nil || !bytes.Equal(nonce, expected[:])) {
+			t.Errorf("%s: expected the nonce, got %x %v", tt.name, nonce, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestKeyIDFromPublicKey(t *testing.T) {
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	keyID, err := base64.StdEncoding.DecodeString(aar.KeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := ParseAttestationObject(aar.AttestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := a.AppleStatement()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, ok := s.Certificate.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		t.Fatalf("Unexpected public key type %T", s.Certificate.PublicKey)
+	}
+	if got := KeyIDFromPublicKey(pub); !bytes.Equal(got, keyID) {
+		t.Fatalf("Expected key ID %x, got %x", keyID, got)
+	}
+}
+
+func TestVerificationSteps(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	keyID, err := base64.StdEncoding.DecodeString(aar.KeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientDataHash := sha256.Sum256(aar.ClientData)
+	a, err := ParseAttestationObject(aar.AttestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.AuthData.VerifyWithAppID("35MFYY2JY5.co.chiff.attestation-test", keyID, authenticator.EnvironmentDevelopment); err != nil {
+		t.Fatalf("Authenticator data not valid: %+v", err)
+	}
+	chain, err := VerifyCertificateChain(a, nil, 0)
+	if err != nil {
+		t.Fatalf("Chain not valid: %+v", err)
+	}
+	if err := VerifyNonce(chain[0], a.RawAuthData, clientDataHash[:]); err != nil {
+		t.Fatalf("Nonce not valid: %+v", err)
+	}
+	pk, err := VerifyKeyID(chain[0], keyID)
+	if err != nil {
+		t.Fatalf("Key ID not valid: %+v", err)
+	}
+	decodedPk, err := hex.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Could not decode public key: %+s", publicKey)
+	}
+	if !bytes.Equal(pk, decodedPk) {
+		t.Fatalf("Wrong Public key : %x", pk)
+	}
+
+	if _, err := VerifyKeyID(chain[0], clientDataHash[:]); err == nil {
+		t.Fatal("Expected a wrong key ID to be rejected")
+	}
+}
+
+func TestVerifierOnStep(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+
+	t.Run("Successful verification reports every step", func(t *testing.T) {
+		aar := AuthenticatorAttestationResponse{}
+		if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+			t.Fatal(err)
+		}
+		var steps []string
+		v := NewVerifier(WithOnStep(func(step string, ok bool, detail string) {
+			if !ok {
+				t.Errorf("Step %s failed: %s", step, detail)
+			}
+			steps = append(steps, step)
+		}))
+		if _, err := v.Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		expected := []string{StepParse, StepFormat, StepKeyID, StepAuthData, StepCertificate, StepNonce, StepPublicKey, StepReceipt}
+		if strings.Join(steps, ",") != strings.Join(expected, ",") {
+			t.Fatalf("Wrong steps: %v", steps)
+		}
+	})
+
+	t.Run("Failing step is reported last", func(t *testing.T) {
+		aar := AuthenticatorAttestationResponse{}
+		if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+			t.Fatal(err)
+		}
+		var steps []string
+		var failed, detail string
+		v := NewVerifier(WithOnStep(func(step string, ok bool, d string) {
+			steps = append(steps, step)
+			if !ok {
+				failed, detail = step, d
+			}
+		}))
+		if _, err := v.Verify(&aar, "wrong.app.id", false); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if failed != StepAuthData || detail == "" {
+			t.Fatalf("Wrong failed step %q with detail %q", failed, detail)
+		}
+		if steps[len(steps)-1] != StepAuthData {
+			t.Fatalf("Steps continued after failure: %v", steps)
+		}
+	})
+}
+
+func TestMaxAttestationAge(t *testing.T) {
+	// The credential certificate of the test attestation is valid from this time on.
+	notBefore := time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	maxAge := time.Hour
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		stale bool
+	}{
+		{"At issuance", notBefore, false},
+		{"At maximum age", notBefore.Add(maxAge), false},
+		{"Just past maximum age", notBefore.Add(maxAge + time.Second), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			TimeNow = func() time.Time {
+				return tt.now
+			}
+			aar := AuthenticatorAttestationResponse{}
+			if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+				t.Fatal(err)
+			}
+			_, err := NewVerifier(WithMaxAttestationAge(maxAge)).Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false)
+			if tt.stale {
+				var verr *utils.Error
+				if !errors.As(err, &verr) || verr.Type != utils.ErrAttestationStale.Type {
+					t.Fatalf("Expected stale attestation error, got %+v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("Not valid: %+v", err)
+			}
+		})
+	}
+}
+
+func TestBlockedKeys(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	decodedPk, err := hex.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Could not decode public key: %+s", publicKey)
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), decodedPk)
+	blocked := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Blocked key", func(t *testing.T) {
+		_, err := NewVerifier(WithBlockedKeys([]crypto.PublicKey{other.Public(), blocked})).Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false)
+		var verr *utils.Error
+		if !errors.As(err, &verr) || verr.Type != utils.ErrBlockedKey.Type {
+			t.Fatalf("Expected blocked key error, got %+v", err)
+		}
+	})
+
+	t.Run("Other keys blocked", func(t *testing.T) {
+		_, err := NewVerifier(WithBlockedKeys([]crypto.PublicKey{other.Public()})).Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+	})
+}
+
+func TestReceiptVerification(t *testing.T) {
+	TimeNow = time.Now
+	const appID = "TEAMID1234.com.example.app"
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	attest := func(receiptAppID string) *AuthenticatorAttestationResponse {
+		receiptData, err := authority.Receipt(testutil.ReceiptOptions{AppID: receiptAppID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		att, err := authority.Attest(device, testutil.AttestOptions{AppID: appID, ClientData: []byte("challenge"), Receipt: receiptData})
+		if err != nil {
+			t.Fatal(err)
+		}
+		aar := &AuthenticatorAttestationResponse{}
+		if err := json.Unmarshal(att.JSON(), aar); err != nil {
+			t.Fatal(err)
+		}
+		return aar
+	}
+	v := NewVerifier(
+		WithChainVerifier(X509ChainVerifier{Roots: authority.Roots()}),
+		WithReceiptVerification(receipt.NewVerifier(receipt.WithRoots(authority.Roots()))),
+	)
+
+	if _, err := v.Verify(attest(appID), appID, false); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	_, err = v.Verify(attest("TEAMID1234.com.example.other"), appID, false)
+	var verr *utils.Error
+	if !errors.As(err, &verr) || verr.Type != utils.ErrReceipt.Type {
+		t.Fatalf("Expected a receipt for another App ID to be rejected, got %+v", err)
+	}
+
+	t.Run("Verifier clock", func(t *testing.T) {
+		now := time.Now()
+		receiptData, err := authority.Receipt(testutil.ReceiptOptions{AppID: appID, ExpirationTime: now.Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		att, err := authority.Attest(device, testutil.AttestOptions{AppID: appID, ClientData: []byte("challenge"), Receipt: receiptData})
+		if err != nil {
+			t.Fatal(err)
+		}
+		aar := &AuthenticatorAttestationResponse{}
+		if err := json.Unmarshal(att.JSON(), aar); err != nil {
+			t.Fatal(err)
+		}
+		clock := utils.ClockFunc(func() time.Time { return now.Add(2 * time.Hour) })
+		rv := receipt.NewVerifier(receipt.WithRoots(authority.Roots()))
+
+		_, err = NewVerifier(WithChainVerifier(X509ChainVerifier{Roots: authority.Roots()}), WithReceiptVerification(rv), WithClock(clock)).Verify(aar, appID, false)
+		if !errors.As(err, &verr) || verr.Type != utils.ErrReceiptExpired.Type {
+			t.Fatalf("Expected the receipt to expire at the verifier's clock, got %+v", err)
+		}
+		_, err = NewVerifier(WithChainVerifier(X509ChainVerifier{Roots: authority.Roots()}), WithReceiptVerification(rv), WithClock(clock), WithClockSkew(2*time.Hour)).Verify(aar, appID, false)
+		if err != nil {
+			t.Fatalf("Expected the receipt to be valid within the verifier's skew: %+v", err)
+		}
+	})
+}
+
+func TestAppleRootCertificate(t *testing.T) {
+	first, err := AppleRootCertificate()
+	if err != nil {
+		t.Fatalf("Could not parse root: %+v", err)
+	}
+	second, err := AppleRootCertificate()
+	if err != nil {
+		t.Fatalf("Could not parse root: %+v", err)
+	}
+	if first != second {
+		t.Fatal("Root certificate was parsed more than once")
+	}
+	if first.Subject.CommonName != "Apple App Attestation Root CA" {
+		t.Fatalf("Wrong root certificate: %s", first.Subject.CommonName)
+	}
+}
+
+// BenchmarkAppleRoots shows that retrieving the root pool does not parse or allocate per call.
+func BenchmarkAppleRoots(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := appleRoots(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	saved := TimeNow
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	b.Cleanup(func() { TimeNow = saved })
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := aar.Verify("35MFYY2JY5.co.chiff.attestation-test", false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 const publicKey = "0437c404fa2bbf8fbcf4ee7080573d5fa80c4f6cc3a22f7db43af92c394e7cd1c880c95ab422972625e8e673af1bda2b096654e9b602895601f925bb5941c53082"
 const attestation = `{ 
 	"attestationObject": "o2NmbXRvYXBwbGUtYXBwYXR0ZXN0Z2F0dFN0bXSiY3g1Y4JZAuswggLnMIICbaADAgECAgYBeNT03AYwCgYIKoZIzj0EAwIwTzEjMCEGA1UEAwwaQXBwbGUgQXBwIEF0dGVzdGF0aW9uIENBIDExEzARBgNVBAoMCkFwcGxlIEluYy4xEzARBgNVBAgMCkNhbGlmb3JuaWEwHhcNMjEwNDE0MDk1NTIwWhcNMjEwNDE3MDk1NTIwWjCBkTFJMEcGA1UEAwxAMDFjM2ZmYTY3YTY4MzU1M2M4MjU4NjRlYmU2MjJmNWIzMGVmOWIxOTA1YTEwMDg0ZTE0YmJiMzY0ZTk2ODgwMDEaMBgGA1UECwwRQUFBIENlcnRpZmljYXRpb24xEzARBgNVBAoMCkFwcGxlIEluYy4xEzARBgNVBAgMCkNhbGlmb3JuaWEwWTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAAQ3xAT6K7-PvPTucIBXPV-oDE9sw6IvfbQ6-Sw5TnzRyIDJWrQilyYl6OZzrxvaKwlmVOm2AolWAfklu1lBxTCCo4HxMIHuMAwGA1UdEwEB_wQCMAAwDgYDVR0PAQH_BAQDAgTwMH4GCSqGSIb3Y2QIBQRxMG-kAwIBCr-JMAMCAQG_iTEDAgEAv4kyAwIBAb-JMwMCAQG_iTQmBCQzNU1GWVkySlk1LmNvLmNoaWZmLmF0dGVzdGF0aW9uLXRlc3SlBgQEc2tzIL-JNgMCAQW_iTcDAgEAv4k5AwIBAL-JOgMCAQAwGQYJKoZIhvdjZAgHBAwwCr-KeAYEBDE0LjQwMwYJKoZIhvdjZAgCBCYwJKEiBCCOPSSk1ZLu7Zc9Zd2TmGO7tY5ktIclyAclmfTBJdpmjjAKBggqhkjOPQQDAgNoADBlAjEAzHk20GzLdZlaaJXKchriZkmJWhfTCgQHRpn3D6Y7Coit7UQABhIABVh6D4qwPysZAjAFDGuGqb796A9H-1UVCgui5ufZnWZHl1SVT-6iobxfS9av2ahGkLF8hYQXVT3pofxZAkcwggJDMIIByKADAgECAhAJusXhvEAa2dRTlbw4GghUMAoGCCqGSM49BAMDMFIxJjAkBgNVBAMMHUFwcGxlIEFwcCBBdHRlc3RhdGlvbiBSb290IENBMRMwEQYDVQQKDApBcHBsZSBJbmMuMRMwEQYDVQQIDApDYWxpZm9ybmlhMB4XDTIwMDMxODE4Mzk1NVoXDTMwMDMxMzAwMDAwMFowTzEjMCEGA1UEAwwaQXBwbGUgQXBwIEF0dGVzdGF0aW9uIENBIDExEzARBgNVBAoMCkFwcGxlIEluYy4xEzARBgNVBAgMCkNhbGlmb3JuaWEwdjAQBgcqhkjOPQIBBgUrgQQAIgNiAASuWzegd015sjWPQOfR8iYm8cJf7xeALeqzgmpZh0_40q0VJXiaomYEGRJItjy5ZwaemNNjvV43D7-gjjKegHOphed0bqNZovZvKdsyr0VeIRZY1WevniZ-smFNwhpmzpmjZjBkMBIGA1UdEwEB_wQIMAYBAf8CAQAwHwYDVR0jBBgwFoAUrJEQUzO9vmhB_6cMqeX66uXliqEwHQYDVR0OBBYEFD7jXRwEGanJtDH4hHTW4eFXcuObMA4GA1UdDwEB_wQEAwIBBjAKBggqhkjOPQQDAwNpADBmAjEAu76IjXONBQLPvP1mbQlXUDW81ocsP4QwSSYp7dH5FOh5mRya6LWu-NOoVDP3tg0GAjEAqzjt0MyB7QCkUsO6RPmTY2VT_swpfy60359evlpKyraZXEuCDfkEOG94B7tYlDm3Z3JlY2VpcHRZDl0wgAYJKoZIhvcNAQcCoIAwgAIBATEPMA0GCWCGSAFlAwQCAQUAMIAGCSqGSIb3DQEHAaCAJIAEggPoMYIEGDAsAgECAgEBBCQzNU1GWVkySlk1LmNvLmNoaWZmLmF0dGVzdGF0aW9uLXRlc3QwggL1AgEDAgEBBIIC6zCCAucwggJtoAMCAQICBgF41PTcBjAKBggqhkjOPQQDAjBPMSMwIQYDVQQDDBpBcHBsZSBBcHAgQXR0ZXN0YXRpb24gQ0EgMTETMBEGA1UECgwKQXBwbGUgSW5jLjETMBEGA1UECAwKQ2FsaWZvcm5pYTAeFw0yMTA0MTQwOTU1MjBaFw0yMTA0MTcwOTU1MjBaMIGRMUkwRwYDVQQDDEAwMWMzZmZhNjdhNjgzNTUzYzgyNTg2NGViZTYyMmY1YjMwZWY5YjE5MDVhMTAwODRlMTRiYmIzNjRlOTY4ODAwMRowGAYDVQQLDBFBQUEgQ2VydGlmaWNhdGlvbjETMBEGA1UECgwKQXBwbGUgSW5jLjETMBEGA1UECAwKQ2FsaWZvcm5pYTBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABDfEBPorv4-89O5wgFc9X6gMT2zDoi99tDr5LDlOfNHIgMlatCKXJiXo5nOvG9orCWZU6bYCiVYB-SW7WUHFMIKjgfEwge4wDAYDVR0TAQH_BAIwADAOBgNVHQ8BAf8EBAMCBPAwfgYJKoZIhvdjZAgFBHEwb6QDAgEKv4kwAwIBAb-JMQMCAQC_iTIDAgEBv4kzAwIBAb-JNCYEJDM1TUZZWTJKWTUuY28uY2hpZmYuYXR0ZXN0YXRpb24tdGVzdKUGBARza3Mgv4k2AwIBBb-JNwMCAQC_iTkDAgEAv4k6AwIBADAZBgkqhkiG92NkCAcEDDAKv4p4BgQEMTQuNDAzBgkqhkiG92NkCAIEJjAkoSIEII49JKTVku7tlz1l3ZOYY7u1jmS0hyXIByWZ9MEl2maOMAoGCCqGSM49BAMCA2gAMGUCMQDMeTbQbMt1mVpolcpyGuJmSYlaF9MKBAdGmfcPpjsKiK3tRAAGEgAFWHoPirA_KxkCMAUMa4apvv3oD0f7VRUKC6Lm59mdZkeXVJVP7qKhvF9L1q_ZqEaQsXyFhBdVPemh_DAoAgEEAgEBBCBsbdptlEbTsu5ktHjBTEiDsfbajKOKz4hxgskGW0mjojBgAgEFAgEBBFgxZzZKcm5JdXg5eHFzWDFzSDQ1ekUwUzVvWGJCM0Njenp3aVpZOXJxSkMxc2ZWa3J0T3ZIRk92UXF5Wjg1NE80Yk5zOEloWkV1eVRzNmZPQ01VMmtlZz09MA4CAQYCAQEEBkFUVEVTVDAPAgEHAgEBBAdzYW5kYm94MCACAQwCAQEEGDIwMjEtMAQ0NC0xNVQwOTo1NToyMC4yMDdaMCACARUCAQEEGDIwMjEtMDctMTRUMDk6NTU6MjAuMjA3WgAAAAAAAKCAMIIDrTCCA1SgAwIBAgIQWTNWreVZgs9EQjes30UbUzAKBggqhkjOPQQDAjB8MTAwLgYDVQQDDCdBcHBsZSBBcHBsaWNhdGlvbiBJbnRlZ3JhdGlvbiBDQSA1IC0gRzExJjAkBgNVBAsMHUFwcGxlIENlcnRpZmljYXRpb24gQXV0aG9yaXR5MRMwEQYDVQQKDApBcHBsZSBJbmMuMQswCQYDVQQGEwJVUzAeFw0yMDA1MTkxNzQ3MzFaFw0yMTA2MTgxNzQ3MzFaMFoxNjA0BgNVBAMMLUFwcGxpY2F0aW9uIEF0dGVzdGF0aW9uIEZyYXVkIFJlY2VpcHQgU2lnbmluZzETMBEGA1UECgwKQXBwbGUgSW5jLjELMAkGA1UEBhMCVVMwWTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAAR_6RU0bMOKe5g8k9HQQ1_Yq9pWcATTLFiGZVGVerR498sq-LpF9_p46sYsSeT5zcCEtQMU8QIz2pt2-kQqK7hyo4IB2DCCAdQwDAYDVR0TAQH_BAIwADAfBgNVHSMEGDAWgBTZF_5LZ5A4S5L0287VV4AUC489yTBDBggrBgEFBQcBAQQ3MDUwMwYIKwYBBQUHMAGGJ2h0dHA6Ly9vY3NwLmFwcGxlLmNvbS9vY3NwMDMtYWFpY2E1ZzEwMTCCARwGA1UdIASCARMwggEPMIIBCwYJKoZIhvdjZAUBMIH9MIHDBggrBgEFBQcCAjCBtgyBs1JlbGlhbmNlIG9uIHRoaXMgY2VydGlmaWNhdGUgYnkgYW55IHBhcnR5IGFzc3VtZXMgYWNjZXB0YW5jZSBvZiB0aGUgdGhlbiBhcHBsaWNhYmxlIHN0YW5kYXJkIHRlcm1zIGFuZCBjb25kaXRpb25zIG9mIHVzZSwgY2VydGlmaWNhdGUgcG9saWN5IGFuZCBjZXJ0aWZpY2F0aW9uIHByYWN0aWNlIHN0YXRlbWVudHMuMDUGCCsGAQUFBwIBFilodHRwOi8vd3d3LmFwcGxlLmNvbS9jZXJ0aWZpY2F0ZWF1dGhvcml0eTAdBgNVHQ4EFgQUaR7HD0fs443ddTdE8-nhWmwQViUwDgYDVR0PAQH_BAQDAgeAMA8GCSqGSIb3Y2QMDwQCBQAwCgYIKoZIzj0EAwIDRwAwRAIgJRgWXF4pnFn2hTmtXduZ9jc-9g7NCEWp_Xca1iQtLCICIF0qmypfq6NjgWWNGED3r0gL12uhlNg0IIf01pNbtRuuMIIC-TCCAn-gAwIBAgIQVvuD1Cv_jcM3mSO1Wq5uvTAKBggqhkjOPQQDAzBnMRswGQYDVQQDDBJBcHBsZSBSb290IENBIC0gRzMxJjAkBgNVBAsMHUFwcGxlIENlcnRpZmljYXRpb24gQXV0aG9yaXR5MRMwEQYDVQQKDApBcHBsZSBJbmMuMQswCQYDVQQGEwJVUzAeFw0xOTAzMjIxNzUzMzNaFw0zNDAzMjIwMDAwMDBaMHwxMDAuBgNVBAMMJ0FwcGxlIEFwcGxpY2F0aW9uIEludGVncmF0aW9uIENBIDUgLSBHMTEmMCQGA1UECwwdQXBwbGUgQ2VydGlmaWNhdGlvbiBBdXRob3JpdHkxEzARBgNVBAoMCkFwcGxlIEluYy4xCzAJBgNVBAYTAlVTMFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEks5jvX2GsasoCjsc4a_7BJSAkaz2Md-myyg1b0RL4SHlV90SjY26gnyVvkn6vjPKrs0EGfEvQyX69L6zy4N-uqOB9zCB9DAPBgNVHRMBAf8EBTADAQH_MB8GA1UdIwQYMBaAFLuw3qFYM4iapIqZ3r6966_ayySrMEYGCCsGAQUFBwEBBDowODA2BggrBgEFBQcwAYYqaHR0cDovL29jc3AuYXBwbGUuY29tL29jc3AwMy1hcHBsZXJvb3RjYWczMDcGA1UdHwQwMC4wLKAqoCiGJmh0dHA6Ly9jcmwuYXBwbGUuY29tL2FwcGxlcm9vdGNhZzMuY3JsMB0GA1UdDgQWBBTZF_5LZ5A4S5L0287VV4AUC489yTAOBgNVHQ8BAf8EBAMCAQYwEAYKKoZIhvdjZAYCAwQCBQAwCgYIKoZIzj0EAwMDaAAwZQIxAI1vpp-h4OTsW05zipJ_PXhTmI_02h9YHsN1Sv44qEwqgxoaqg2mZG3huZPo0VVM7QIwZzsstOHoNwd3y9XsdqgaOlU7PzVqyMXmkrDhYb6ASWnkXyupbOERAqrMYdk4t3NKMIICQzCCAcmgAwIBAgIILcX8iNLFS5UwCgYIKoZIzj0EAwMwZzEbMBkGA1UEAwwSQXBwbGUgUm9vdCBDQSAtIEczMSYwJAYDVQQLDB1BcHBsZSBDZXJ0aWZpY2F0aW9uIEF1dGhvcml0eTETMBEGA1UECgwKQXBwbGUgSW5jLjELMAkGA1UEBhMCVVMwHhcNMTQwNDMwMTgxOTA2WhcNMzkwNDMwMTgxOTA2WjBnMRswGQYDVQQDDBJBcHBsZSBSb290IENBIC0gRzMxJjAkBgNVBAsMHUFwcGxlIENlcnRpZmljYXRpb24gQXV0aG9yaXR5MRMwEQYDVQQKDApBcHBsZSBJbmMuMQswCQYDVQQGEwJVUzB2MBAGByqGSM49AgEGBSuBBAAiA2IABJjpLz1AcqTtkyJygRMc3RCV8cWjTnHcFBbZDuWmBSp3ZHtfTjjTuxxEtX_1H7YyYl3J6YRbTzBPEVoA_VhYDKX1DyxNB0cTddqXl5dvMVztK517IDvYuVTZXpmkOlEKMaNCMEAwHQYDVR0OBBYEFLuw3qFYM4iapIqZ3r6966_ayySrMA8GA1UdEwEB_wQFMAMBAf8wDgYDVR0PAQH_BAQDAgEGMAoGCCqGSM49BAMDA2gAMGUCMQCD6cHEFl4aXTQY2e3v9GwOAEZLuN-yRhHFD_3meoyhpmvOwgPUnPWTxnS4at-qIxUCMG1mihDK1A3UT82NQz60imOlM27jbdoXt2QfyFMm-YhidDkLF1vLUagM6BgD56KyKAAAMYH9MIH6AgEBMIGQMHwxMDAuBgNVBAMMJ0FwcGxlIEFwcGxpY2F0aW9uIEludGVncmF0aW9uIENBIDUgLSBHMTEmMCQGA1UECwwdQXBwbGUgQ2VydGlmaWNhdGlvbiBBdXRob3JpdHkxEzARBgNVBAoMCkFwcGxlIEluYy4xCzAJBgNVBAYTAlVTAhBZM1at5VmCz0RCN6zfRRtTMA0GCWCGSAFlAwQCAQUAMAoGCCqGSM49BAMCBEcwRQIgUEClatNpJhJevokCcdbzCvmLPTGKgCpqTcAqo75reeACIQD6mKXj7_E__f78hraVFpg1Bgu44k8zimIrwFpp_5YogAAAAAAAAGhhdXRoRGF0YVikfO8rVdpyQQi6kSeW9nX_AL5x1S2uJo-miNNMptJ_cHRAAAAAAGFwcGF0dGVzdGRldmVsb3AAIAHD_6Z6aDVTyCWGTr5iL1sw75sZBaEAhOFLuzZOlogApQECAyYgASFYIDfEBPorv4-89O5wgFc9X6gMT2zDoi99tDr5LDlOfNHIIlgggMlatCKXJiXo5nOvG9orCWZU6bYCiVYB-SW7WUHFMII",
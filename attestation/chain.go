@@ -0,0 +1,354 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// AppleIntermediateCN is the subject common name of Apple's App Attest intermediate certificate.
+const AppleIntermediateCN = "Apple App Attestation CA 1"
+
+// AppleIntermediateSPKIHash is the base64 encoded SPKIHash of Apple's App Attest intermediate
+// certificate, AppleIntermediateCN.
+const AppleIntermediateSPKIHash = "YIGkqT2GtRADhRxA6OtxL+ujui99faxmyzx0cbJGZRo="
+
+// Reasons of an ErrChain.
+const (
+	// The certificate cannot be parsed
+	ChainMalformed = "malformed"
+	// The certificate is not valid at the time of verification
+	ChainExpired = "expired"
+	// Neither x5c nor the roots hold the issuer of the certificate
+	ChainUnknownAuthority = "unknown_authority"
+	// The signature of the certificate does not verify with the key of its issuer
+	ChainBadSignature = "bad_signature"
+	// The certificate violates basic constraints, e.g. it issues certificates without being a CA
+	ChainBasicConstraints = "basic_constraints"
+	// The certificate is deeper in the chain than a path length constraint allows
+	ChainPathLength = "path_length"
+	// The certificate lacks a required key usage or extended key usage
+	ChainKeyUsage = "key_usage"
+	// The chain is invalid for another reason
+	ChainInvalid = "invalid"
+)
+
+// ErrChain is returned by X509ChainVerifier when the certificate chain does not verify. It
+// tells which certificate broke the chain and why, and unwraps to an
+// ErrAttestationCertificate error.
+type ErrChain struct {
+	// The position of the certificate in x5c, starting with the credential certificate at 0,
+	// or -1 for a certificate that is not in x5c, such as a root
+	Index int
+	// The certificate, nil if it cannot be parsed
+	Certificate *x509.Certificate
+	// One of the Chain reasons, such as ChainExpired
+	Reason string
+	err    *utils.Error
+}
+
+func chainError(index int, ct *x509.Certificate, reason, details string) *ErrChain {
+	var name string
+	switch {
+	case index == 0:
+		name = "Credential certificate"
+	case ct == nil:
+		name = fmt.Sprintf("Certificate %d", index)
+	case index < 0:
+		name = fmt.Sprintf("Certificate %q", ct.Subject.CommonName)
+	default:
+		name = fmt.Sprintf("Certificate %d %q", index, ct.Subject.CommonName)
+	}
+	if ct != nil {
+		name += fmt.Sprintf(" (serial %x)", ct.SerialNumber)
+	}
+	return &ErrChain{
+		Index:       index,
+		Certificate: ct,
+		Reason:      reason,
+		err:         utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("%s of the x5c chain %s", name, details)),
+	}
+}
+
+func (e *ErrChain) Error() string {
+	return e.err.Error()
+}
+
+func (e *ErrChain) Unwrap() error {
+	return e.err
+}
+
+// ChainVerifier verifies the x5c certificate chain of an attestation, allowing deployments
+// to plug in an alternative PKI. Verify receives the DER-encoded certificates starting with
+// the credential certificate and returns the verified chain, also starting with the
+// credential certificate.
+type ChainVerifier interface {
+	Verify(x5c [][]byte, now time.Time) ([]*x509.Certificate, error)
+}
+
+// ContextChainVerifier is a ChainVerifier that may do network requests, such as fetching
+// certificates or revocation status. When the configured ChainVerifier implements it,
+// VerifyContext is called with the context of the verification instead of Verify.
+type ContextChainVerifier interface {
+	ChainVerifier
+	VerifyContext(ctx context.Context, x5c [][]byte, now time.Time) ([]*x509.Certificate, error)
+}
+
+// verifyChain verifies x5c with cv, passing ctx if cv accepts one.
+func verifyChain(ctx context.Context, cv ChainVerifier, x5c [][]byte, now time.Time) ([]*x509.Certificate, error) {
+	if ccv, ok := cv.(ContextChainVerifier); ok {
+		return ccv.VerifyContext(ctx, x5c, now)
+	}
+	return cv.Verify(x5c, now)
+}
+
+// X509ChainVerifier verifies chains with crypto/x509, which enforces basic constraints: every
+// certificate that issues another one must be a CA, and the path length constraints of the
+// issuers must not be exceeded. Certificates of x5c that are not CAs are never used as
+// intermediates. App Attest certificates are not TLS certificates, so extended key usages are
+// not checked. Chains that do not verify fail with an *ErrChain.
+type X509ChainVerifier struct {
+	// Roots are the trusted root certificates. When nil the embedded Apple root is used.
+	Roots *x509.CertPool
+	// AIA, if set, fetches the intermediate certificate when x5c only holds the credential
+	// certificate and the known intermediates do not complete the chain.
+	AIA *AIAFetcher
+	// Intermediates are known intermediate certificates used in addition to those of x5c, for
+	// x5c arrays stripped of the intermediate, e.g. by a proxy.
+	Intermediates []*x509.Certificate
+	// StrictBasicConstraints rejects chains instead of ignoring unusable certificates: the
+	// credential certificate must not be a CA, every other certificate in x5c must be a CA
+	// allowed to sign certificates, and every intermediate must declare a path length constraint.
+	StrictBasicConstraints bool
+	// ExtKeyUsages the credential certificate must have one of, not checked if empty. Like in
+	// crypto/x509, certificates without extended key usages, such as Apple's, have them all.
+	ExtKeyUsages []x509.ExtKeyUsage
+}
+
+// Verify verifies that the credential certificate chains to a trusted root, using the other
+// certificates in x5c as intermediates.
+func (cv X509ChainVerifier) Verify(x5c [][]byte, now time.Time) ([]*x509.Certificate, error) {
+	return cv.VerifyContext(context.Background(), x5c, now)
+}
+
+// VerifyContext is like Verify, passing ctx to the AIAFetcher.
+func (cv X509ChainVerifier) VerifyContext(ctx context.Context, x5c [][]byte, now time.Time) ([]*x509.Certificate, error) {
+	if len(x5c) == 0 {
+		return nil, utils.ErrAttestationCertificate.WithDetails("Empty x5c cert chain")
+	}
+
+	roots := cv.Roots
+	if roots == nil {
+		var err error
+		if roots, err = appleRoots(); err != nil {
+			return nil, err
+		}
+	}
+
+	certs := make([]*x509.Certificate, len(x5c))
+	intermediates := x509.NewCertPool()
+	for i, cb := range x5c {
+		ct, err := x509.ParseCertificate(cb)
+		if err != nil {
+			return nil, chainError(i, nil, ChainMalformed, fmt.Sprintf("cannot be parsed: %v", err))
+		}
+		if cv.StrictBasicConstraints {
+			if err := checkBasicConstraints(i, ct); err != nil {
+				return nil, err
+			}
+		}
+		if i > 0 && ct.IsCA {
+			intermediates.AddCert(ct)
+		}
+		certs[i] = ct
+	}
+	credCert := certs[0]
+	if credCert.IsCA {
+		// A reordered x5c starts with an intermediate, the credential certificate is then the
+		// first certificate that is not a CA.
+		if i := slices.IndexFunc(certs, func(ct *x509.Certificate) bool { return !ct.IsCA }); i > 0 {
+			intermediates.AddCert(credCert)
+			credCert = certs[i]
+		}
+	}
+	for _, ct := range cv.Intermediates {
+		intermediates.AddCert(ct)
+	}
+
+	// Create verification options.
+	verifyOptions := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if len(cv.ExtKeyUsages) > 0 {
+		verifyOptions.KeyUsages = cv.ExtKeyUsages
+	}
+
+	chains, err := credCert.Verify(verifyOptions)
+	if err != nil && len(x5c) == 1 && cv.AIA != nil {
+		// The known intermediates do not complete the chain, so fetch the issuer.
+		urls := credCert.IssuingCertificateURL
+		if len(urls) > maxAIAURLs {
+			urls = urls[:maxAIAURLs]
+		}
+		var fetched bool
+		var fetchErr error
+		for _, url := range urls {
+			issuer, err := cv.AIA.FetchContext(ctx, url)
+			if err != nil {
+				fetchErr = err
+				continue
+			}
+			intermediates.AddCert(issuer)
+			fetched = true
+		}
+		if !fetched && fetchErr != nil {
+			return nil, fetchErr
+		}
+		chains, err = credCert.Verify(verifyOptions)
+	}
+	if err != nil {
+		return nil, locateChainError(certs, err, now)
+	}
+	if cv.StrictBasicConstraints {
+		// The last certificate of the chain is the trusted root.
+		for _, ct := range chains[0][1 : len(chains[0])-1] {
+			if ct.MaxPathLen < 0 || (ct.MaxPathLen == 0 && !ct.MaxPathLenZero) {
+				return nil, chainError(indexOf(certs, ct), ct, ChainPathLength, "has no path length constraint")
+			}
+		}
+	}
+	return chains[0], nil
+}
+
+// locateChainError turns the error of x509.Certificate.Verify into an *ErrChain pointing at
+// the certificate of x5c that broke the chain.
+func locateChainError(certs []*x509.Certificate, err error, now time.Time) *ErrChain {
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) {
+		ct := invalid.Cert
+		reason, details := ChainInvalid, fmt.Sprintf("is invalid: %v", err)
+		switch invalid.Reason {
+		case x509.Expired:
+			reason, details = ChainExpired, fmt.Sprintf("expired at %s, verified at %s", ct.NotAfter.Format(time.RFC3339), now.Format(time.RFC3339))
+			if now.Before(ct.NotBefore) {
+				details = fmt.Sprintf("is not valid before %s, verified at %s", ct.NotBefore.Format(time.RFC3339), now.Format(time.RFC3339))
+			}
+		case x509.NotAuthorizedToSign, x509.CANotAuthorizedForThisName:
+			reason = ChainBasicConstraints
+		case x509.TooManyIntermediates:
+			reason = ChainPathLength
+		case x509.IncompatibleUsage:
+			reason, details = ChainKeyUsage, fmt.Sprintf("lacks a required extended key usage: %v", err)
+		}
+		return chainError(indexOf(certs, ct), ct, reason, details)
+	}
+
+	// crypto/x509 only tells no path to a root was found, so follow x5c up to the first
+	// certificate its successor did not issue.
+	for i, ct := range certs[:len(certs)-1] {
+		issuer := certs[i+1]
+		if !bytes.Equal(ct.RawIssuer, issuer.RawSubject) {
+			break
+		}
+		serr := ct.CheckSignatureFrom(issuer)
+		var constraint x509.ConstraintViolationError
+		switch {
+		case errors.As(serr, &constraint):
+			return chainError(i+1, issuer, ChainBasicConstraints, "is not a CA allowed to sign certificates")
+		case serr != nil:
+			return chainError(i, ct, ChainBadSignature, fmt.Sprintf("has a signature that does not verify with the key of its issuer %q: %v", issuer.Subject.CommonName, serr))
+		}
+	}
+	for i, ct := range certs {
+		if i == len(certs)-1 || !bytes.Equal(ct.RawIssuer, certs[i+1].RawSubject) {
+			return chainError(i, ct, ChainUnknownAuthority, fmt.Sprintf("is issued by %q, which is neither in x5c nor a trusted root", ct.Issuer.CommonName))
+		}
+	}
+	return chainError(0, certs[0], ChainInvalid, fmt.Sprintf("is invalid: %v", err))
+}
+
+// indexOf returns the position of the certificate in certs, or -1.
+func indexOf(certs []*x509.Certificate, ct *x509.Certificate) int {
+	for i, c := range certs {
+		if ct != nil && c.Equal(ct) {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkBasicConstraints checks the basic constraints of the certificate of x5c at the index.
+func checkBasicConstraints(index int, ct *x509.Certificate) error {
+	if !ct.BasicConstraintsValid {
+		return chainError(index, ct, ChainBasicConstraints, "has no basic constraints")
+	}
+	if index == 0 && ct.IsCA {
+		return chainError(index, ct, ChainBasicConstraints, "must not be a CA")
+	}
+	if index > 0 && !ct.IsCA {
+		return chainError(index, ct, ChainBasicConstraints, "is not a CA")
+	}
+	if index > 0 && ct.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return chainError(index, ct, ChainKeyUsage, "lacks the key usage to sign certificates")
+	}
+	return nil
+}
+
+// verifyIntermediateCN checks the subject common name of the intermediate certificate, the
+// second certificate of the verified chain.
+func verifyIntermediateCN(chain []*x509.Certificate, cn string) error {
+	if len(chain) < 2 {
+		return utils.ErrVerification.WithDetails("Certificate chain has no intermediate certificate")
+	}
+	if got := chain[1].Subject.CommonName; got != cn {
+		return utils.ErrVerification.WithDetails(fmt.Sprintf("Intermediate certificate common name %q does not match the required %q", got, cn))
+	}
+	return nil
+}
+
+// SPKIHash returns the SHA-256 hash of the DER encoded subject public key info of the
+// certificate, as pinned by WithPinnedIntermediates.
+func SPKIHash(ct *x509.Certificate) []byte {
+	hash := sha256.Sum256(ct.RawSubjectPublicKeyInfo)
+	return hash[:]
+}
+
+// verifyIntermediatePin checks that the SPKIHash of the intermediate certificate, the second
+// certificate of the verified chain, is one of the pins.
+func verifyIntermediatePin(chain []*x509.Certificate, pins [][]byte) error {
+	if len(chain) < 2 {
+		return utils.ErrAttestationCertificate.WithDetails("Certificate chain has no intermediate certificate to match the pinned ones")
+	}
+	hash := SPKIHash(chain[1])
+	for _, pin := range pins {
+		if bytes.Equal(hash, pin) {
+			return nil
+		}
+	}
+	return utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Intermediate certificate %q with SPKI hash %s is not pinned", chain[1].Subject.CommonName, base64.StdEncoding.EncodeToString(hash)))
+}
+
+// chainSignatureAlgorithms are the signature algorithms of Apple's App Attest certificates.
+var chainSignatureAlgorithms = []x509.SignatureAlgorithm{x509.ECDSAWithSHA256, x509.ECDSAWithSHA384}
+
+// verifySignatureAlgorithms checks that every certificate of the verified chain is signed
+// with one of chainSignatureAlgorithms.
+func verifySignatureAlgorithms(chain []*x509.Certificate) error {
+	for i, ct := range chain {
+		if !slices.Contains(chainSignatureAlgorithms, ct.SignatureAlgorithm) {
+			return utils.ErrUnsupportedAlgorithm.WithDetails(fmt.Sprintf("Certificate %d %q of the chain is signed with %s, expected ECDSA with SHA-256 or SHA-384", i, ct.Subject.CommonName, ct.SignatureAlgorithm))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,509 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// stubChainVerifier parses the chain without validating it.
+type stubChainVerifier struct {
+	calls int
+	err   error
+}
+
+func (s *stubChainVerifier) Verify(x5c [][]byte, now time.Time) ([]*x509.Certificate, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	var chain []*x509.Certificate
+	for _, cb := range x5c {
+		ct, err := x509.ParseCertificate(cb)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, ct)
+	}
+	return chain, nil
+}
+
+func TestChainVerifier(t *testing.T) {
+	// The credential certificate has expired by then, so only a stub accepts it.
+	TimeNow = func() time.Time {
+		return time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Default verifier rejects expired chain", func(t *testing.T) {
+		if _, err := NewVerifier().Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("Stub verifier is used", func(t *testing.T) {
+		stub := &stubChainVerifier{}
+		result, err := NewVerifier(WithChainVerifier(stub)).Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if stub.calls != 1 {
+			t.Fatalf("Stub was called %d times", stub.calls)
+		}
+		if len(result.PublicKey) == 0 {
+			t.Fatal("Missing public key")
+		}
+	})
+
+	t.Run("Stub verifier error is returned", func(t *testing.T) {
+		stubErr := errors.New("untrusted chain")
+		stub := &stubChainVerifier{err: stubErr}
+		_, err := NewVerifier(WithChainVerifier(stub)).Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false)
+		if !errors.Is(err, stubErr) {
+			t.Fatalf("Expected stub error, got %+v", err)
+		}
+	})
+}
+
+func TestRootCAs(t *testing.T) {
+	TimeNow = time.Now
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	att, err := authority.Attest(device, testutil.AttestOptions{AppID: "TEAMID1234.com.example.app", ClientData: []byte("challenge")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal(att.JSON(), &aar); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewVerifier().Verify(&aar, "TEAMID1234.com.example.app", false); err == nil {
+		t.Fatal("Expected the test authority to be untrusted by the Apple root")
+	}
+	result, err := NewVerifier(WithRootCAs(authority.Roots())).Verify(&aar, "TEAMID1234.com.example.app", false)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if result.TestRoots || len(result.Warnings) > 0 {
+		t.Fatalf("Expected the result not to be marked: %+v", result)
+	}
+	result, err = NewVerifier(WithTestRootCAs(authority.Roots())).Verify(&aar, "TEAMID1234.com.example.app", false)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if !result.TestRoots || len(result.Warnings) != 1 {
+		t.Fatalf("Expected the result to be marked as verified against test roots: %+v", result)
+	}
+	other, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Roots set on the chain verifier take precedence.
+	v := NewVerifier(WithChainVerifier(X509ChainVerifier{Roots: other.Roots()}), WithRootCAs(authority.Roots()))
+	if _, err := v.Verify(&aar, "TEAMID1234.com.example.app", false); err == nil {
+		t.Fatal("Expected the roots of the chain verifier to be used")
+	}
+}
+
+func TestRequiredIntermediateCN(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		cn    string
+		valid bool
+	}{
+		{"Default Apple intermediate", "", true},
+		{"Matching intermediate", AppleIntermediateCN, true},
+		{"Non-matching intermediate", "Apple App Attestation CA 2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewVerifier(WithRequiredIntermediateCN(tt.cn)).Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false)
+			if tt.valid && err != nil {
+				t.Fatalf("Not valid: %+v", err)
+			}
+			var verr *utils.Error
+			if !tt.valid && (!errors.As(err, &verr) || verr.Type != utils.ErrVerification.Type) {
+				t.Fatalf("Expected a verification error, got %+v", err)
+			}
+		})
+	}
+}
+
+func TestPinnedIntermediates(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	apple, err := base64.StdEncoding.DecodeString(AppleIntermediateSPKIHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := make([]byte, len(apple))
+
+	tests := []struct {
+		name  string
+		pins  [][]byte
+		valid bool
+	}{
+		{"Default Apple intermediate", nil, true},
+		{"One of the pins matches", [][]byte{other, apple}, true},
+		{"Unexpected intermediate", [][]byte{other}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewVerifier(WithPinnedIntermediates(tt.pins...)).Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false)
+			if tt.valid && err != nil {
+				t.Fatalf("Not valid: %+v", err)
+			}
+			var verr *utils.Error
+			if !tt.valid && (!errors.As(err, &verr) || verr.Type != utils.ErrAttestationCertificate.Type || !strings.Contains(err.Error(), AppleIntermediateSPKIHash)) {
+				t.Fatalf("Expected a certificate error naming the intermediate's hash, got %+v", err)
+			}
+		})
+	}
+}
+
+// testChain issues a root, an intermediate and a credential certificate, letting the caller
+// adjust the intermediate and credential certificate templates.
+func testChain(t *testing.T, intermediate, leaf func(*x509.Certificate)) (*x509.CertPool, [][]byte) {
+	t.Helper()
+	now := time.Now()
+	issue := func(template, parent *x509.Certificate, pub, priv *ecdsa.PrivateKey) *x509.Certificate {
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &pub.PublicKey, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	rootKey, intermediateKey, leafKey := newKey(), newKey(), newKey()
+
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	root := issue(rootTemplate, rootTemplate, rootKey, rootKey)
+
+	intermediateTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "Intermediate"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	if intermediate != nil {
+		intermediate(intermediateTemplate)
+	}
+	intermediateCert := issue(intermediateTemplate, root, intermediateKey, rootKey)
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(3),
+		Subject:               pkix.Name{CommonName: "Credential"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	if leaf != nil {
+		leaf(leafTemplate)
+	}
+	leafCert := issue(leafTemplate, intermediateCert, leafKey, intermediateKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	return roots, [][]byte{leafCert.Raw, intermediateCert.Raw}
+}
+
+func TestBasicConstraints(t *testing.T) {
+	t.Run("Valid chain", func(t *testing.T) {
+		roots, x5c := testChain(t, nil, nil)
+		for _, strict := range []bool{false, true} {
+			cv := X509ChainVerifier{Roots: roots, StrictBasicConstraints: strict}
+			if _, err := cv.Verify(x5c, time.Now()); err != nil {
+				t.Fatalf("Not valid (strict %t): %+v", strict, err)
+			}
+		}
+	})
+
+	t.Run("Non-CA intermediate is rejected", func(t *testing.T) {
+		roots, x5c := testChain(t, func(c *x509.Certificate) {
+			c.IsCA = false
+			c.MaxPathLenZero = false
+		}, nil)
+		for _, strict := range []bool{false, true} {
+			cv := X509ChainVerifier{Roots: roots, StrictBasicConstraints: strict}
+			if _, err := cv.Verify(x5c, time.Now()); err == nil {
+				t.Fatalf("Expected an error (strict %t)", strict)
+			}
+		}
+	})
+
+	t.Run("CA credential certificate", func(t *testing.T) {
+		roots, x5c := testChain(t, nil, func(c *x509.Certificate) {
+			c.IsCA = true
+			c.KeyUsage |= x509.KeyUsageCertSign
+		})
+		if _, err := (X509ChainVerifier{Roots: roots, StrictBasicConstraints: true}).Verify(x5c, time.Now()); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("Intermediate without path length constraint", func(t *testing.T) {
+		roots, x5c := testChain(t, func(c *x509.Certificate) {
+			c.MaxPathLenZero = false
+			c.MaxPathLen = -1
+		}, nil)
+		if _, err := (X509ChainVerifier{Roots: roots}).Verify(x5c, time.Now()); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if _, err := (X509ChainVerifier{Roots: roots, StrictBasicConstraints: true}).Verify(x5c, time.Now()); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("Apple chain satisfies strict constraints", func(t *testing.T) {
+		TimeNow = func() time.Time {
+			return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+		}
+		aar := AuthenticatorAttestationResponse{}
+		if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewVerifier(WithStrictBasicConstraints()).Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+	})
+}
+
+func TestIntermediates(t *testing.T) {
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	att, err := ParseAttestationObject(aar.AttestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x5c, err := certificateChain(att.AttStatement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apple, err := AppleIntermediateCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(apple.Raw, x5c[1]) {
+		t.Fatal("Embedded intermediate differs from the one of the attestation")
+	}
+	now := time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	if _, err := (X509ChainVerifier{}).Verify(x5c[:1], now); err == nil {
+		t.Fatal("Expected a leaf-only chain to fail without known intermediates")
+	}
+	if _, err := (X509ChainVerifier{Intermediates: []*x509.Certificate{apple}}).Verify(x5c[:1], now); err != nil {
+		t.Fatalf("Not valid with the embedded intermediate: %+v", err)
+	}
+
+	t.Run("Stripped x5c", func(t *testing.T) {
+		TimeNow = time.Now
+		authority, err := testutil.NewAuthority(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		device, err := authority.NewDevice()
+		if err != nil {
+			t.Fatal(err)
+		}
+		att, err := authority.Attest(device, testutil.AttestOptions{AppID: "TEAMID1234.com.example.app", ClientData: []byte("challenge"), OmitIntermediate: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		aar := AuthenticatorAttestationResponse{}
+		if err := json.Unmarshal(att.JSON(), &aar); err != nil {
+			t.Fatal(err)
+		}
+		v := NewVerifier(WithRootCAs(authority.Roots()), WithIntermediates(authority.Intermediate))
+		if _, err := v.Verify(&aar, "TEAMID1234.com.example.app", false); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+	})
+
+	t.Run("Reordered x5c", func(t *testing.T) {
+		roots, x5c := testChain(t, nil, nil)
+		chain, err := X509ChainVerifier{Roots: roots}.Verify([][]byte{x5c[1], x5c[0]}, time.Now())
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if !bytes.Equal(chain[0].Raw, x5c[0]) {
+			t.Fatal("Expected the chain to start with the credential certificate")
+		}
+	})
+}
+
+func TestSignatureAlgorithms(t *testing.T) {
+	for _, alg := range []x509.SignatureAlgorithm{x509.ECDSAWithSHA256, x509.ECDSAWithSHA512} {
+		roots, x5c := testChain(t, nil, func(c *x509.Certificate) { c.SignatureAlgorithm = alg })
+		chain, err := X509ChainVerifier{Roots: roots}.Verify(x5c, time.Now())
+		if err != nil {
+			t.Fatalf("%s: not valid: %+v", alg, err)
+		}
+		err = verifySignatureAlgorithms(chain)
+		var verr *utils.Error
+		if valid := alg == x509.ECDSAWithSHA256; valid != (err == nil) || !valid && (!errors.As(err, &verr) || verr.Type != utils.ErrUnsupportedAlgorithm.Type) {
+			t.Fatalf("%s: unexpected result %+v", alg, err)
+		}
+	}
+}
+
+func TestChainError(t *testing.T) {
+	tests := []struct {
+		name         string
+		intermediate func(*x509.Certificate)
+		leaf         func(*x509.Certificate)
+		tamper       func(x5c [][]byte)
+		index        int
+		reason       string
+	}{
+		{
+			name:  "Expired credential certificate",
+			leaf:  func(c *x509.Certificate) { c.NotAfter = time.Now().Add(-time.Minute) },
+			index: 0, reason: ChainExpired,
+		},
+		{
+			name:         "Expired intermediate",
+			intermediate: func(c *x509.Certificate) { c.NotAfter = time.Now().Add(-time.Minute) },
+			index:        1, reason: ChainExpired,
+		},
+		{
+			name:         "Non-CA intermediate",
+			intermediate: func(c *x509.Certificate) { c.IsCA, c.MaxPathLenZero = false, false },
+			index:        1, reason: ChainBasicConstraints,
+		},
+		{
+			name:   "Tampered signature",
+			tamper: func(x5c [][]byte) { x5c[0][len(x5c[0])-1] ^= 0xff },
+			index:  0, reason: ChainBadSignature,
+		},
+		{
+			name:   "Malformed intermediate",
+			tamper: func(x5c [][]byte) { x5c[1] = []byte{0x30, 0x03, 0x01} },
+			index:  1, reason: ChainMalformed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roots, x5c := testChain(t, tt.intermediate, tt.leaf)
+			if tt.tamper != nil {
+				tt.tamper(x5c)
+			}
+			_, err := (X509ChainVerifier{Roots: roots}).Verify(x5c, time.Now())
+			var cerr *ErrChain
+			if !errors.As(err, &cerr) {
+				t.Fatalf("Expected an *ErrChain, got %+v", err)
+			}
+			if cerr.Index != tt.index || cerr.Reason != tt.reason {
+				t.Fatalf("Expected certificate %d to fail with %s, got %d with %s: %v", tt.index, tt.reason, cerr.Index, cerr.Reason, err)
+			}
+			var verr *utils.Error
+			if !errors.As(err, &verr) || verr.Type != utils.ErrAttestationCertificate.Type {
+				t.Fatalf("Expected an ErrAttestationCertificate error, got %+v", err)
+			}
+		})
+	}
+
+	t.Run("Untrusted root", func(t *testing.T) {
+		_, x5c := testChain(t, nil, nil)
+		other, _ := testChain(t, nil, nil)
+		_, err := (X509ChainVerifier{Roots: other}).Verify(x5c, time.Now())
+		var cerr *ErrChain
+		if !errors.As(err, &cerr) || cerr.Index != 1 || cerr.Reason != ChainUnknownAuthority {
+			t.Fatalf("Expected the intermediate to have an unknown authority, got %+v", err)
+		}
+	})
+
+	t.Run("Details name the certificate", func(t *testing.T) {
+		notBefore := time.Now().Add(time.Hour)
+		var serial *big.Int
+		roots, x5c := testChain(t, func(c *x509.Certificate) { c.NotBefore, serial = notBefore, c.SerialNumber }, nil)
+		_, err := (X509ChainVerifier{Roots: roots}).Verify(x5c, time.Now())
+		var cerr *ErrChain
+		if !errors.As(err, &cerr) || cerr.Reason != ChainExpired {
+			t.Fatalf("Expected the intermediate not to be valid yet, got %+v", err)
+		}
+		for _, want := range []string{"Certificate 1", fmt.Sprintf("serial %x", serial), "not valid before " + notBefore.UTC().Format(time.RFC3339)} {
+			if !strings.Contains(err.Error(), want) {
+				t.Fatalf("Expected %q in %q", want, err)
+			}
+		}
+	})
+
+	t.Run("Required extended key usage", func(t *testing.T) {
+		roots, x5c := testChain(t, nil, func(c *x509.Certificate) { c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth} })
+		_, err := (X509ChainVerifier{Roots: roots, ExtKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}).Verify(x5c, time.Now())
+		var cerr *ErrChain
+		if !errors.As(err, &cerr) || cerr.Reason != ChainKeyUsage {
+			t.Fatalf("Expected a key usage error, got %+v", err)
+		}
+	})
+
+	t.Run("Extended key usages are not checked", func(t *testing.T) {
+		roots, x5c := testChain(t, func(c *x509.Certificate) {
+			c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		}, nil)
+		if _, err := (X509ChainVerifier{Roots: roots}).Verify(x5c, time.Now()); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package attestation
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// VerifierConfig is the serializable part of a Verifier's configuration, so it can be kept
+// in configuration files or environment variables. Callbacks, sinks and other values that
+// cannot be serialized are set with options when creating the Verifier.
+type VerifierConfig struct {
+	// Environment the verifier accepts attestations for, any environment if empty
+	Environment authenticator.Environment `json:"environment,omitempty"`
+	// See WithAcceptedEnvironments
+	AcceptedEnvironments []authenticator.Environment `json:"accepted_environments,omitempty"`
+	// Algorithms the attested key may use, ES256 if empty
+	AllowedAlgorithms []authenticator.COSEAlgorithmIdentifier `json:"allowed_algorithms,omitempty"`
+	// Tolerance for certificate validity periods
+	ClockSkew Duration `json:"clock_skew,omitempty"`
+	// See WithMaxAttestationAge
+	MaxAttestationAge Duration `json:"max_attestation_age,omitempty"`
+	// See WithRequiredIntermediateCN
+	RequiredIntermediateCN string `json:"required_intermediate_cn,omitempty"`
+	// See WithPinnedIntermediates, base64 encoded
+	PinnedIntermediates [][]byte `json:"pinned_intermediates,omitempty"`
+	// See WithStrictBasicConstraints
+	StrictBasicConstraints bool `json:"strict_basic_constraints,omitempty"`
+	// See WithAIAFetch
+	AIAFetch bool `json:"aia_fetch,omitempty"`
+	// See WithOffline
+	Offline bool `json:"offline,omitempty"`
+	// See WithOCSP and WithCRL, no revocation check if empty
+	Revocation RevocationMethod `json:"revocation,omitempty"`
+	// See WithRevocationPolicy, "hard-fail" or "soft-fail"
+	RevocationPolicy RevocationPolicy `json:"revocation_policy,omitempty"`
+}
+
+// Duration is a time.Duration that is serialized as a string such as "5m".
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration string such as "5m".
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// WithEnvironment restricts the verifier to attestations for the given environment, so that a
+// production service cannot accidentally verify development attestations.
+func WithEnvironment(env authenticator.Environment) Option {
+	return func(v *Verifier) {
+		v.environment = env
+	}
+}
+
+// WithAcceptedEnvironments sets the environments attestations are accepted for, e.g. both
+// environments on a staging server. It takes precedence over WithEnvironment.
+func WithAcceptedEnvironments(envs ...authenticator.Environment) Option {
+	return func(v *Verifier) {
+		v.acceptedEnvs = envs
+	}
+}
+
+// WithAllowedAlgorithms sets the COSE algorithms the attested key may use, ES256 by default
+// as App Attest keys are P-256 keys. Only the ECDSA algorithms are supported.
+func WithAllowedAlgorithms(algs ...authenticator.COSEAlgorithmIdentifier) Option {
+	return func(v *Verifier) {
+		v.allowedAlgs = algs
+	}
+}
+
+// WithClockSkew accepts certificates, OCSP responses, CRLs and receipts that are valid within
+// d of the current time, for devices and servers whose clocks are slightly off.
+func WithClockSkew(d time.Duration) Option {
+	return func(v *Verifier) {
+		v.clockSkew = d
+	}
+}
+
+// WithClock sets the clock telling the current time to the time-dependent checks, such as
+// certificate validity, revocation status, attestation age and receipt expiry. By default
+// the system clock is used.
+func WithClock(clock utils.Clock) Option {
+	return func(v *Verifier) {
+		v.clock = clock
+	}
+}
+
+// Config returns the serializable configuration of the verifier.
+func (v *Verifier) Config() VerifierConfig {
+	return VerifierConfig{
+		Environment:            v.environment,
+		AcceptedEnvironments:   v.acceptedEnvs,
+		AllowedAlgorithms:      v.allowedAlgs,
+		ClockSkew:              Duration(v.clockSkew),
+		MaxAttestationAge:      Duration(v.maxAge),
+		RequiredIntermediateCN: v.intermediateCN,
+		PinnedIntermediates:    v.intermediatePins,
+		StrictBasicConstraints: v.strictBasicConstraints,
+		AIAFetch:               v.aiaFetch,
+		Offline:                v.offline,
+		Revocation:             v.revocation,
+		RevocationPolicy:       v.revocationPolicy,
+	}
+}
+
+// MarshalConfig returns the JSON encoded configuration of the verifier.
+func (v *Verifier) MarshalConfig() ([]byte, error) {
+	return json.Marshal(v.Config())
+}
+
+// NewVerifierFromConfig returns a Verifier configured from JSON produced by MarshalConfig.
+// The options are applied after the configuration.
+func NewVerifierFromConfig(data []byte, opts ...Option) (*Verifier, error) {
+	var config VerifierConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, utils.ErrBadRequest.WithDetails(fmt.Sprintf("Invalid verifier configuration: %v", err))
+	}
+	for _, env := range append([]authenticator.Environment{config.Environment}, config.AcceptedEnvironments...) {
+		switch env {
+		case "", authenticator.EnvironmentDevelopment, authenticator.EnvironmentProduction:
+		default:
+			return nil, utils.ErrBadRequest.WithDetails(fmt.Sprintf("Invalid verifier configuration: unknown environment %q", env))
+		}
+	}
+	switch config.Revocation {
+	case "", RevocationNone, RevocationOCSP, RevocationCRL:
+	default:
+		return nil, utils.ErrBadRequest.WithDetails(fmt.Sprintf("Invalid verifier configuration: unknown revocation method %q", config.Revocation))
+	}
+
+	configOpts := []Option{
+		WithEnvironment(config.Environment),
+		WithAcceptedEnvironments(config.AcceptedEnvironments...),
+		WithAllowedAlgorithms(config.AllowedAlgorithms...),
+		WithClockSkew(time.Duration(config.ClockSkew)),
+		WithMaxAttestationAge(time.Duration(config.MaxAttestationAge)),
+		WithAIAFetch(config.AIAFetch),
+		WithOffline(config.Offline),
+		WithOCSP(config.Revocation == RevocationOCSP),
+		WithCRL(config.Revocation == RevocationCRL),
+		WithRevocationPolicy(config.RevocationPolicy),
+	}
+	if config.StrictBasicConstraints {
+		configOpts = append(configOpts, WithStrictBasicConstraints())
+	}
+	if config.RequiredIntermediateCN != "" {
+		configOpts = append(configOpts, WithRequiredIntermediateCN(config.RequiredIntermediateCN))
+	}
+	if len(config.PinnedIntermediates) > 0 {
+		configOpts = append(configOpts, WithPinnedIntermediates(config.PinnedIntermediates...))
+	}
+	return NewVerifier(append(configOpts, opts...)...), nil
+}
@@ -0,0 +1,228 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestVerifierConfig(t *testing.T) {
+	t.Run("Round trip", func(t *testing.T) {
+		v := NewVerifier(
+			WithEnvironment(authenticator.EnvironmentProduction),
+			WithAllowedAlgorithms(authenticator.AlgES256),
+			WithClockSkew(5*time.Minute),
+			WithMaxAttestationAge(time.Hour),
+			WithRequiredIntermediateCN(""),
+			WithPinnedIntermediates(),
+			WithStrictBasicConstraints(),
+			WithAIAFetch(true),
+			WithOffline(true),
+			WithCRL(true),
+			WithRevocationPolicy(RevocationSoftFail),
+		)
+		data, err := v.MarshalConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := NewVerifierFromConfig(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(loaded.Config(), v.Config()) {
+			t.Fatalf("Config changed: %+v != %+v", loaded.Config(), v.Config())
+		}
+		var raw map[string]interface{}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			t.Fatal(err)
+		}
+		if raw["clock_skew"] != "5m0s" || raw["environment"] != "production" {
+			t.Fatalf("Unexpected encoding: %s", data)
+		}
+		if raw["strict_basic_constraints"] != true || raw["aia_fetch"] != true || raw["offline"] != true || raw["revocation"] != "crl" || raw["revocation_policy"] != "soft-fail" {
+			t.Fatalf("Unexpected encoding of the flags: %s", data)
+		}
+	})
+
+	t.Run("Offline without revocation", func(t *testing.T) {
+		loaded, err := NewVerifierFromConfig([]byte(`{"offline": true, "revocation": "none", "clock_skew": "5m"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config := loaded.Config(); !config.Offline || config.Revocation != "" || config.ClockSkew != Duration(5*time.Minute) {
+			t.Fatalf("Wrong config: %+v", config)
+		}
+		loaded, err = NewVerifierFromConfig([]byte(`{"revocation": "ocsp"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config := loaded.Config(); config.Revocation != RevocationOCSP || config.RevocationPolicy != RevocationHardFail {
+			t.Fatalf("Wrong config: %+v", config)
+		}
+	})
+
+	t.Run("Options apply after config", func(t *testing.T) {
+		loaded, err := NewVerifierFromConfig([]byte(`{"clock_skew": "1m"}`), WithClockSkew(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Config().ClockSkew != Duration(time.Second) {
+			t.Fatalf("Wrong skew: %v", loaded.Config().ClockSkew)
+		}
+	})
+
+	t.Run("Invalid config", func(t *testing.T) {
+		for _, data := range []string{`{"clock_skew": "soon"}`, `{"environment": "staging"}`, `{"revocation": "dns"}`, `{"revocation_policy": "maybe"}`, `[`} {
+			if _, err := NewVerifierFromConfig([]byte(data)); err == nil {
+				t.Fatalf("Expected an error for %s", data)
+			}
+		}
+	})
+}
+
+func TestVerifierClock(t *testing.T) {
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	appID := "35MFYY2JY5.co.chiff.attestation-test"
+	// Every time-dependent step uses the clock of the verifier instead of TimeNow.
+	saved := TimeNow
+	TimeNow = func() time.Time {
+		t.Error("TimeNow was used instead of the clock")
+		return time.Now()
+	}
+	defer func() { TimeNow = saved }()
+
+	now := time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	var sink bytes.Buffer
+	v := NewVerifier(WithClock(utils.ClockFunc(func() time.Time { return now })), WithEventSink(&sink))
+	result, err := v.Verify(&aar, appID, false)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if !result.VerifiedAt.Equal(now) || !result.Credential().RegisteredAt.Equal(now) {
+		t.Fatalf("Wrong times: %v %v", result.VerifiedAt, result.Credential().RegisteredAt)
+	}
+	var event Event
+	if err := json.Unmarshal(sink.Bytes(), &event); err != nil {
+		t.Fatal(err)
+	}
+	if !event.Time.Equal(now) {
+		t.Fatalf("Wrong event time: %v", event.Time)
+	}
+
+	object, err := ParseAttestationObject(aar.AttestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.VerifyCertificateChain(context.Background(), object); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+}
+
+func TestVerifierConfigChecks(t *testing.T) {
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	appID := "35MFYY2JY5.co.chiff.attestation-test"
+	// One minute after the credential certificate expired
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 17, 9, 56, 20, 0, time.UTC)
+	}
+
+	t.Run("Clock skew", func(t *testing.T) {
+		if _, err := NewVerifier().Verify(&aar, appID, false); err == nil {
+			t.Fatal("Expected expired certificate to be rejected")
+		}
+		if _, err := NewVerifier(WithClockSkew(5*time.Minute)).Verify(&aar, appID, false); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+	})
+
+	t.Run("Clock", func(t *testing.T) {
+		clock := utils.ClockFunc(func() time.Time {
+			return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+		})
+		if _, err := NewVerifier(WithClock(clock)).Verify(&aar, appID, false); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		later := utils.ClockFunc(func() time.Time {
+			return time.Date(2021, 4, 16, 9, 55, 20, 0, time.UTC)
+		})
+		stale := NewVerifier(WithClock(later), WithMaxAttestationAge(time.Hour))
+		if _, err := stale.Verify(&aar, appID, false); err == nil {
+			t.Fatal("Expected the clock to be used for the attestation age")
+		}
+	})
+
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+
+	t.Run("Environment", func(t *testing.T) {
+		if _, err := NewVerifier(WithEnvironment(authenticator.EnvironmentProduction)).Verify(&aar, appID, false); err == nil {
+			t.Fatal("Expected development attestation to be rejected")
+		}
+		if _, err := NewVerifier(WithEnvironment(authenticator.EnvironmentDevelopment)).Verify(&aar, appID, false); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+	})
+
+	t.Run("Detected environment", func(t *testing.T) {
+		if _, err := NewVerifier().VerifyDetect(&aar, appID); err == nil {
+			t.Fatal("Expected development attestation to be rejected by default")
+		}
+		v := NewVerifier(WithAcceptedEnvironments(authenticator.EnvironmentDevelopment, authenticator.EnvironmentProduction))
+		result, err := v.VerifyDetect(&aar, appID)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if result.Environment != authenticator.EnvironmentDevelopment {
+			t.Fatalf("Wrong environment: %s", result.Environment)
+		}
+		if _, err := NewVerifier(WithAcceptedEnvironments(authenticator.EnvironmentProduction)).Verify(&aar, appID, false); err == nil {
+			t.Fatal("Expected development attestation to be rejected")
+		}
+	})
+
+	t.Run("Allowed algorithms", func(t *testing.T) {
+		_, err := NewVerifier(WithAllowedAlgorithms(authenticator.AlgES384)).Verify(&aar, appID, false)
+		var verr *utils.Error
+		if !errors.As(err, &verr) || verr.Type != utils.ErrUnsupportedAlgorithm.Type {
+			t.Fatalf("Expected ES256 key to be rejected, got %+v", err)
+		}
+
+		key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			allowed []authenticator.COSEAlgorithmIdentifier
+			alg     authenticator.COSEAlgorithmIdentifier
+			valid   bool
+		}{
+			{nil, authenticator.AlgES384, false},
+			{[]authenticator.COSEAlgorithmIdentifier{authenticator.AlgES384}, authenticator.AlgES384, true},
+			{[]authenticator.COSEAlgorithmIdentifier{authenticator.AlgES256}, authenticator.AlgES256, false},
+			{[]authenticator.COSEAlgorithmIdentifier{-8}, -8, false},
+		}
+		for _, tt := range tests {
+			err := NewVerifier(WithAllowedAlgorithms(tt.allowed...)).verifyKeyAlgorithm(&key.PublicKey, tt.alg)
+			if tt.valid != (err == nil) {
+				t.Fatalf("P-384 key with algorithm %d allowing %v: expected valid %v, got %+v", tt.alg, tt.allowed, tt.valid, err)
+			}
+		}
+	})
+}
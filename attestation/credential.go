@@ -0,0 +1,62 @@
+package attestation
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Credential is the data a relying party stores after a successful attestation and
+// uses to verify later assertions.
+type Credential struct {
+	KeyID     []byte `json:"keyID"`
+	PublicKey []byte `json:"publicKey"`
+	Receipt   []byte `json:"receipt"`
+	Counter   uint32 `json:"counter"`
+	// The COSE algorithm of the public key, which assertions must be signed with
+	Algorithm authenticator.COSEAlgorithmIdentifier `json:"alg"`
+	// When the key was registered, and when the last assertion was accepted. The caller
+	// updates LastUsedAt when it stores the counter of an assertion.
+	RegisteredAt time.Time `json:"registeredAt"`
+	LastUsedAt   time.Time `json:"lastUsedAt"`
+	// Details of the credential certificate of the attestation, if known
+	Certificate *CertificateInfo `json:"certificate,omitempty"`
+}
+
+// Credential returns the credential to store for the verified attestation, registered when it
+// was verified.
+func (r *AttestationResult) Credential() *Credential {
+	registeredAt := r.VerifiedAt
+	if registeredAt.IsZero() {
+		registeredAt = TimeNow()
+	}
+	var info *CertificateInfo
+	if r.Certificate != nil {
+		certInfo := r.CertificateInfo
+		info = &certInfo
+	}
+	return &Credential{
+		KeyID:        r.KeyID,
+		PublicKey:    r.PublicKey,
+		Receipt:      r.Receipt,
+		Counter:      r.Counter,
+		Algorithm:    r.Algorithm,
+		RegisteredAt: registeredAt,
+		Certificate:  info,
+	}
+}
+
+// MatchesAttestation checks that a re-attestation for the same key ID attested the stored
+// public key. Since the key ID is the hash of the public key a mismatch indicates data
+// corruption or an attack.
+func (c *Credential) MatchesAttestation(result *AttestationResult) error {
+	if !bytes.Equal(c.KeyID, result.KeyID) {
+		return utils.ErrCredentialMismatch.WithDetails("Attestation is for a different key ID")
+	}
+	if !bytes.Equal(c.PublicKey, result.PublicKey) {
+		return utils.ErrCredentialMismatch.WithDetails("Attested public key does not match the stored public key")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package attestation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/authenticator"
+)
+
+func TestCredentialMatchesAttestation(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	result, err := NewVerifier().Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+
+	t.Run("Credential records the algorithm", func(t *testing.T) {
+		if result.Credential().Algorithm != authenticator.AlgES256 {
+			t.Fatalf("Wrong algorithm: %d", result.Credential().Algorithm)
+		}
+	})
+
+	t.Run("Credential records the certificate", func(t *testing.T) {
+		info := result.CertificateInfo
+		if result.Certificate == nil || info.SerialNumber != result.Certificate.SerialNumber.Text(16) {
+			t.Fatalf("Wrong serial number: %q", info.SerialNumber)
+		}
+		if info.NotBefore.After(TimeNow()) || !info.NotAfter.After(TimeNow()) || info.Issuer == "" {
+			t.Fatalf("Wrong certificate details: %+v", info)
+		}
+		if stored := result.Credential(); stored.Certificate == nil || *stored.Certificate != info {
+			t.Fatalf("Wrong stored certificate details: %+v", stored.Certificate)
+		}
+	})
+
+	t.Run("Matching key", func(t *testing.T) {
+		stored := result.Credential()
+		if err := stored.MatchesAttestation(result); err != nil {
+			t.Fatalf("Expected credential to match: %+v", err)
+		}
+	})
+
+	t.Run("Mismatching key", func(t *testing.T) {
+		stored := result.Credential()
+		stored.PublicKey = append([]byte{}, result.PublicKey...)
+		stored.PublicKey[len(stored.PublicKey)-1] ^= 0xff
+		if err := stored.MatchesAttestation(result); err == nil {
+			t.Fatal("Expected mismatching public key to be rejected")
+		}
+	})
+}
+
+func TestAttestationResultKeyID(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	result, err := NewVerifier().Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+
+	keyID, err := base64.StdEncoding.DecodeString(aar.KeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response struct {
+		KeyID string `json:"keyID"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatal(err)
+	}
+	if expected := base64.RawURLEncoding.EncodeToString(keyID); response.KeyID != expected {
+		t.Fatalf("Expected key ID %s, got %s", expected, response.KeyID)
+	}
+}
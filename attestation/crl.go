@@ -0,0 +1,158 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Maximum size of a fetched CRL.
+const maxCRLSize = 4 << 20
+
+// WithCRL enables checking the revocation status of the verified certificate chain against
+// CRLs, for deployments that cannot reach OCSP responders, instead of another revocation
+// method. The CRLs are fetched from the distribution points named in the certificates and
+// cached until their next update. Certificates without a distribution point are only checked
+// against the CRLs of WithCRLs, and their status is undetermined without one, see
+// WithRevocationPolicy. It is disabled by default, and Policy.Offline only uses the
+// CRLs of WithCRLs.
+func WithCRL(enabled bool) Option {
+	return func(v *Verifier) {
+		v.setRevocation(RevocationCRL, enabled)
+	}
+}
+
+// WithCRLs provides preloaded CRLs, e.g. downloaded by a periodic job. A CRL of the issuer of
+// a certificate that has not passed its next update is used instead of fetching one.
+func WithCRLs(crls ...*x509.RevocationList) Option {
+	return func(v *Verifier) {
+		v.crls = append(v.crls, crls...)
+	}
+}
+
+// crlChecker downloads CRLs and caches them by URL.
+type crlChecker struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*x509.RevocationList
+}
+
+// newCRLChecker returns a crlChecker using the given client. A nil client uses a client with
+// a ten second timeout.
+func newCRLChecker(client *http.Client) *crlChecker {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &crlChecker{client: client, cache: make(map[string]*x509.RevocationList)}
+}
+
+var defaultCRLChecker = newCRLChecker(nil)
+
+// check looks up the certificate in a preloaded CRL of its issuer or, if fetch is set, in
+// the CRL of one of its distribution points.
+func (c *crlChecker) check(ctx context.Context, ct, issuer *x509.Certificate, preloaded []*x509.RevocationList, fetch bool, now time.Time, skew time.Duration) error {
+	var unusable error
+	for _, crl := range preloaded {
+		if !bytes.Equal(crl.RawIssuer, ct.RawIssuer) {
+			continue
+		}
+		if unusable = verifyCRL(crl, issuer, now, skew); unusable == nil {
+			return checkCRL(crl, ct)
+		}
+	}
+	if len(ct.CRLDistributionPoints) == 0 {
+		if unusable != nil {
+			return revocationUnavailable("CRL", ct, "preloaded CRL %v", unusable)
+		}
+		return revocationUnavailable("CRL", ct, "no usable preloaded CRL and the certificate names no distribution point")
+	}
+	if !fetch {
+		return revocationUnavailable("CRL", ct, "no usable preloaded CRL and verification is offline")
+	}
+
+	var err error
+	for _, url := range ct.CRLDistributionPoints {
+		var crl *x509.RevocationList
+		if crl, err = c.fetch(ctx, url, issuer, now, skew); err != nil {
+			err = revocationUnavailable("CRL", ct, "%v", err)
+			continue
+		}
+		return checkCRL(crl, ct)
+	}
+	return err
+}
+
+// fetch returns the verified CRL at url, from the cache until its next update.
+func (c *crlChecker) fetch(ctx context.Context, url string, issuer *x509.Certificate, now time.Time, skew time.Duration) (*x509.RevocationList, error) {
+	c.mu.Lock()
+	crl, ok := c.cache[url]
+	c.mu.Unlock()
+	if ok && verifyCRL(crl, issuer, now, skew) == nil {
+		return crl, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCRLSize))
+	if err != nil {
+		return nil, err
+	}
+	// CRLs are usually DER encoded, but PEM is accepted as well.
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	if crl, err = x509.ParseRevocationList(data); err != nil {
+		return nil, fmt.Errorf("malformed CRL at %s: %v", url, err)
+	}
+	if err := verifyCRL(crl, issuer, now, skew); err != nil {
+		return nil, fmt.Errorf("CRL at %s: %v", url, err)
+	}
+
+	c.mu.Lock()
+	c.cache[url] = crl
+	c.mu.Unlock()
+	return crl, nil
+}
+
+// verifyCRL checks that the CRL is signed by the issuer and has not passed its next update.
+func verifyCRL(crl *x509.RevocationList, issuer *x509.Certificate, now time.Time, skew time.Duration) error {
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	if crl.ThisUpdate.After(now.Add(skew)) {
+		return fmt.Errorf("not valid before %s", crl.ThisUpdate.Format(time.RFC3339))
+	}
+	if !crl.NextUpdate.IsZero() && crl.NextUpdate.Before(now.Add(-skew)) {
+		return fmt.Errorf("expired at %s", crl.NextUpdate.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkCRL returns an error if the CRL lists the certificate.
+func checkCRL(crl *x509.RevocationList, ct *x509.Certificate) error {
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(ct.SerialNumber) == 0 {
+			return utils.ErrCertificateRevoked.WithDetails(fmt.Sprintf("Certificate %q was revoked at %s", ct.Subject.CommonName, entry.RevocationTime.Format(time.RFC3339)))
+		}
+	}
+	return nil
+}
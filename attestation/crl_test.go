@@ -0,0 +1,144 @@
+package attestation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// crlTestList returns a CRL of the issuer revoking the given serial numbers.
+func crlTestList(t *testing.T, issuer *x509.Certificate, key *ecdsa.PrivateKey, nextUpdate time.Time, revoked ...*big.Int) []byte {
+	t.Helper()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: nextUpdate.Add(-2 * time.Hour),
+		NextUpdate: nextUpdate,
+	}
+	for _, serial := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: time.Now().Add(-time.Hour),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, issuer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestCRL(t *testing.T) {
+	TimeNow = time.Now
+	var crl atomic.Value
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		data, _ := crl.Load().([]byte)
+		if len(data) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+	leaf, issuer, issuerKey := revocationTestChain(t, false, func(c *x509.Certificate) {
+		c.CRLDistributionPoints = []string{server.URL}
+	})
+	chain := []*x509.Certificate{leaf, issuer}
+	nextUpdate := time.Now().Add(time.Hour)
+
+	t.Run("Fetched CRL is cached", func(t *testing.T) {
+		crl.Store(crlTestList(t, issuer, issuerKey, nextUpdate, big.NewInt(7)))
+		v := NewVerifier(WithCRL(true), WithHTTPClient(server.Client()))
+		for i := 0; i < 2; i++ {
+			if warnings, err := v.checkRevocation(context.Background(), chain); err != nil || len(warnings) > 0 {
+				t.Fatalf("Not valid: %+v %v", err, warnings)
+			}
+		}
+		if n := atomic.LoadInt32(&requests); n != 1 {
+			t.Fatalf("Expected 1 request, got %d", n)
+		}
+	})
+
+	t.Run("Revoked certificate is rejected", func(t *testing.T) {
+		crl.Store(crlTestList(t, issuer, issuerKey, nextUpdate, leaf.SerialNumber))
+		v := NewVerifier(WithCRL(true), WithRevocationPolicy(RevocationSoftFail), WithHTTPClient(server.Client()))
+		_, err := v.checkRevocation(context.Background(), chain)
+		var verr *utils.Error
+		if !errors.As(err, &verr) || verr.Type != utils.ErrCertificateRevoked.Type {
+			t.Fatalf("Expected a revocation error, got %+v", err)
+		}
+	})
+
+	t.Run("Unavailable CRL and failure policy", func(t *testing.T) {
+		_, _, otherKey := revocationTestChain(t, false, func(*x509.Certificate) {})
+		for name, data := range map[string][]byte{
+			"server error": nil,
+			"malformed":    []byte("not a CRL"),
+			"expired":      crlTestList(t, issuer, issuerKey, time.Now().Add(-time.Minute)),
+			"wrong signer": crlTestList(t, issuer, otherKey, nextUpdate),
+		} {
+			crl.Store(data)
+			hard := NewVerifier(WithCRL(true), WithHTTPClient(server.Client()))
+			if _, err := hard.checkRevocation(context.Background(), chain); !errors.Is(err, errRevocationUnavailable) {
+				t.Fatalf("%s: expected an unavailable status with hard-fail, got %+v", name, err)
+			}
+			soft := NewVerifier(WithCRL(true), WithRevocationPolicy(RevocationSoftFail), WithHTTPClient(server.Client()))
+			if warnings, err := soft.checkRevocation(context.Background(), chain); err != nil || len(warnings) != 1 {
+				t.Fatalf("%s: expected a warning with soft-fail, got %+v %v", name, err, warnings)
+			}
+		}
+	})
+
+	t.Run("No distribution point", func(t *testing.T) {
+		leaf, issuer, _ := revocationTestChain(t, false, func(*x509.Certificate) {})
+		chain := []*x509.Certificate{leaf, issuer}
+		hard := NewVerifier(WithCRL(true), WithHTTPClient(server.Client()))
+		if _, err := hard.checkRevocation(context.Background(), chain); !errors.Is(err, errRevocationUnavailable) {
+			t.Fatalf("Expected an unavailable status with hard-fail, got %+v", err)
+		}
+		soft := NewVerifier(WithCRL(true), WithRevocationPolicy(RevocationSoftFail), WithHTTPClient(server.Client()))
+		if warnings, err := soft.checkRevocation(context.Background(), chain); err != nil || len(warnings) != 1 {
+			t.Fatalf("Expected a warning with soft-fail, got %+v %v", err, warnings)
+		}
+	})
+
+	t.Run("Preloaded CRL", func(t *testing.T) {
+		crl.Store([]byte(nil))
+		parse := func(der []byte) *x509.RevocationList {
+			list, err := x509.ParseRevocationList(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return list
+		}
+		policy := &Policy{Offline: true, Revocation: RevocationCRL}
+		before := atomic.LoadInt32(&requests)
+
+		v := NewVerifier(WithCRLs(parse(crlTestList(t, issuer, issuerKey, nextUpdate)))).withPolicy(policy)
+		if _, err := v.checkRevocation(context.Background(), chain); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		v = NewVerifier(WithCRLs(parse(crlTestList(t, issuer, issuerKey, nextUpdate, leaf.SerialNumber)))).withPolicy(policy)
+		if _, err := v.checkRevocation(context.Background(), chain); err == nil || errors.Is(err, errRevocationUnavailable) {
+			t.Fatalf("Expected a revocation error, got %+v", err)
+		}
+		v = NewVerifier(WithCRLs(parse(crlTestList(t, issuer, issuerKey, time.Now().Add(-time.Minute))))).withPolicy(policy)
+		if _, err := v.checkRevocation(context.Background(), chain); !errors.Is(err, errRevocationUnavailable) {
+			t.Fatalf("Expected an expired CRL to be unusable offline, got %+v", err)
+		}
+		if atomic.LoadInt32(&requests) != before {
+			t.Fatal("Expected no CRL to be fetched offline")
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package attestation
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// oidOSVersion identifies the credential certificate extension holding the OS version.
+var oidOSVersion = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 7}
+
+// The context-specific tag of the OS version within its extension.
+const tagOSVersion = 1400
+
+// DeviceInfo holds the hints about the device that Apple puts in the credential certificate.
+// Apple does not document these extensions, so fields are empty when a certificate does not
+// carry them. Apple's certificates do not tell the device class.
+type DeviceInfo struct {
+	// Version of the operating system that generated the key, such as "14.4"
+	OSVersion string `json:"osVersion,omitempty"`
+}
+
+// ParseDeviceInfo returns the device hints of the credential certificate. The OS version is
+// read from the extension with OID 1.2.840.113635.100.8.7, a sequence holding the version in
+// an octet string explicitly tagged [1400].
+func ParseDeviceInfo(credCert *x509.Certificate) (*DeviceInfo, error) {
+	info := &DeviceInfo{}
+	for _, extension := range credCert.Extensions {
+		if !extension.Id.Equal(oidOSVersion) {
+			continue
+		}
+		version, err := parseOSVersionExtension(extension.Value)
+		if err != nil {
+			return nil, err
+		}
+		info.OSVersion = version
+	}
+	return info, nil
+}
+
+// parseOSVersionExtension returns the OS version of the DER-encoded value of the OS version
+// extension, empty if the extension does not hold one.
+func parseOSVersionExtension(value []byte) (string, error) {
+	var seq asn1.RawValue
+	if rest, err := asn1.Unmarshal(value, &seq); err != nil || len(rest) > 0 ||
+		seq.Class != asn1.ClassUniversal || seq.Tag != asn1.TagSequence || !seq.IsCompound {
+		return "", utils.ErrInvalidAttestation.WithDetails("Certificate OS version extension is not a valid sequence")
+	}
+
+	for elements := seq.Bytes; len(elements) > 0; {
+		var element asn1.RawValue
+		var err error
+		if elements, err = asn1.Unmarshal(elements, &element); err != nil {
+			return "", utils.ErrInvalidAttestation.WithDetails(fmt.Sprintf("Certificate OS version extension is malformed: %v", err))
+		}
+		if element.Class != asn1.ClassContextSpecific || element.Tag != tagOSVersion || !element.IsCompound {
+			// Other fields are left for future versions of the extension.
+			continue
+		}
+		var version []byte
+		if rest, err := asn1.Unmarshal(element.Bytes, &version); err != nil || len(rest) > 0 {
+			return "", utils.ErrInvalidAttestation.WithDetails("Certificate OS version extension does not contain an octet string")
+		}
+		return string(version), nil
+	}
+	return "", nil
+}
+
+// OSVersionAtLeast reports whether the OS version is at least the given dotted version, e.g.
+// "15" or "15.2", comparing the components numerically and treating missing ones as 0. It is
+// false if either version is empty or not numeric.
+func (d *DeviceInfo) OSVersionAtLeast(version string) bool {
+	have, ok := parseVersion(d.OSVersion)
+	if !ok {
+		return false
+	}
+	want, ok := parseVersion(version)
+	if !ok {
+		return false
+	}
+	for i := 0; i < max(len(have), len(want)); i++ {
+		var h, w int
+		if i < len(have) {
+			h = have[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+		if h != w {
+			return h > w
+		}
+	}
+	return true
+}
+
+// parseVersion splits a dotted version into its numeric components.
+func parseVersion(version string) ([]int, bool) {
+	if version == "" {
+		return nil, false
+	}
+	parts := strings.Split(version, ".")
+	components := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		components[i] = n
+	}
+	return components, true
+}
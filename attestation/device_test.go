@@ -0,0 +1,80 @@
+package attestation
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDeviceInfo(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	result, err := NewVerifier().Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if result.Device == nil || result.Device.OSVersion != "14.4" {
+		t.Fatalf("Wrong device info: %+v", result.Device)
+	}
+
+	t.Run("Extension", func(t *testing.T) {
+		tagged := func(class, tag int, compound bool, content []byte) []byte {
+			b, _ := asn1.Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: compound, Bytes: content})
+			return b
+		}
+		sequence := func(elements ...[]byte) []byte {
+			return tagged(asn1.ClassUniversal, asn1.TagSequence, true, bytes.Join(elements, nil))
+		}
+		octets, _ := asn1.Marshal([]byte("17.1"))
+		tests := []struct {
+			name    string
+			value   []byte
+			version string
+			valid   bool
+		}{
+			{"Version", sequence(tagged(asn1.ClassContextSpecific, tagOSVersion, true, octets)), "17.1", true},
+			{"Unknown field first", sequence(tagged(asn1.ClassContextSpecific, 1401, true, octets), tagged(asn1.ClassContextSpecific, tagOSVersion, true, octets)), "17.1", true},
+			{"No version", sequence(), "", true},
+			{"Not a sequence", octets, "", false},
+			{"Version without octet string", sequence(tagged(asn1.ClassContextSpecific, tagOSVersion, true, tagged(asn1.ClassUniversal, asn1.TagInteger, false, []byte{1}))), "", false},
+			{"Truncated", sequence(tagged(asn1.ClassContextSpecific, tagOSVersion, true, octets))[:6], "", false},
+		}
+		for _, tt := range tests {
+			version, err := parseOSVersionExtension(tt.value)
+			if tt.valid && (err != nil || version != tt.version) {
+				t.Fatalf("%s: expected %q, got %q %+v", tt.name, tt.version, version, err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatalf("%s: expected an error", tt.name)
+			}
+		}
+	})
+
+	t.Run("Minimum version", func(t *testing.T) {
+		tests := []struct {
+			have, want string
+			atLeast    bool
+		}{
+			{"14.4", "14", true},
+			{"14.4", "14.4.0", true},
+			{"14.4", "14.10", false},
+			{"15.0.1", "15", true},
+			{"9.3", "15", false},
+			{"", "15", false},
+			{"15.0", "", false},
+			{"15.beta", "15", false},
+		}
+		for _, tt := range tests {
+			if got := (&DeviceInfo{OSVersion: tt.have}).OSVersionAtLeast(tt.want); got != tt.atLeast {
+				t.Fatalf("%q at least %q: expected %v, got %v", tt.have, tt.want, tt.atLeast, got)
+			}
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package attestation
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Names of the decoding steps reported by Diagnose before the verification steps.
+const (
+	StepEnvelope  = "envelope"
+	StepBase64    = "base64"
+	StepChallenge = "challenge"
+)
+
+// InspectionStep is the outcome of a single step of Diagnose.
+type InspectionStep struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// InspectionReport lists the steps Diagnose ran, in order, up to and including the first failure.
+type InspectionReport struct {
+	Steps []InspectionStep `json:"steps"`
+	Valid bool             `json:"valid"`
+}
+
+// Failed returns the failed step, or nil if all steps succeeded.
+func (r *InspectionReport) Failed() *InspectionStep {
+	for i := range r.Steps {
+		if !r.Steps[i].OK {
+			return &r.Steps[i]
+		}
+	}
+	return nil
+}
+
+func (r *InspectionReport) add(name string, err error) error {
+	step := InspectionStep{Name: name, OK: err == nil}
+	if err != nil {
+		step.Detail = err.Error()
+	}
+	r.Steps = append(r.Steps, step)
+	return err
+}
+
+// Diagnose decodes and verifies a registration request body, i.e. a JSON encoded
+// AuthenticatorAttestationResponse, and reports each step: JSON envelope, base64 decoding
+// of the fields, the expected challenge and then every verification step. The report is
+// returned even when a step fails, in which case the error of that step is returned too.
+// It is meant as a debugging aid and does not panic on malformed input.
+func Diagnose(body []byte, challenge []byte, policy *Policy) (*InspectionReport, error) {
+	report := &InspectionReport{}
+	if policy == nil {
+		return report, report.add(StepEnvelope, utils.ErrBadRequest.WithDetails("Missing policy"))
+	}
+
+	var envelope struct {
+		ClientData        *string `json:"clientData"`
+		KeyID             *string `json:"keyID"`
+		AttestationObject *string `json:"attestationObject"`
+	}
+	err := json.Unmarshal(body, &envelope)
+	if err != nil {
+		err = utils.ErrBadRequest.WithDetails(fmt.Sprintf("Body is not a valid JSON object: %v", err))
+	} else if envelope.ClientData == nil || envelope.KeyID == nil || envelope.AttestationObject == nil {
+		err = utils.ErrBadRequest.WithDetails("Body must contain clientData, keyID and attestationObject")
+	}
+	if err = report.add(StepEnvelope, err); err != nil {
+		return report, err
+	}
+
+	aar := AuthenticatorAttestationResponse{KeyID: *envelope.KeyID}
+	if aar.ClientData, err = decodeURLBase64("clientData", *envelope.ClientData); err == nil {
+		aar.AttestationObject, err = decodeURLBase64("attestationObject", *envelope.AttestationObject)
+	}
+	if err == nil {
+		_, err = base64.StdEncoding.DecodeString(aar.KeyID)
+		if err != nil {
+			err = utils.ErrParsingData.WithDetails(fmt.Sprintf("keyID is not valid base64: %v", err))
+		}
+	}
+	if err = report.add(StepBase64, err); err != nil {
+		return report, err
+	}
+
+	if !bytes.Equal(aar.ClientData, challenge) {
+		err = utils.ErrChallengeMismatch.WithDetails("Client data does not match the expected challenge")
+	}
+	if err = report.add(StepChallenge, err); err != nil {
+		return report, err
+	}
+
+	v := NewVerifier(WithOnStep(func(step string, ok bool, detail string) {
+		report.Steps = append(report.Steps, InspectionStep{Name: step, OK: ok, Detail: detail})
+	}))
+	if _, err = v.VerifyPolicy(&aar, policy); err != nil {
+		return report, err
+	}
+
+	report.Valid = true
+	return report, nil
+}
+
+// decodeURLBase64 decodes a URL-safe base64 field, with or without padding.
+func decodeURLBase64(field, value string) ([]byte, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("%s is not valid URL-safe base64: %v", field, err))
+	}
+	return decoded, nil
+}
@@ -0,0 +1,158 @@
+package attestation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestDiagnose(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	policy := &Policy{AppID: "35MFYY2JY5.co.chiff.attestation-test"}
+	challenge := []byte("attestation-test")
+
+	var fields map[string]string
+	if err := json.Unmarshal([]byte(attestation), &fields); err != nil {
+		t.Fatal(err)
+	}
+	body := func(override map[string]string) []byte {
+		b := map[string]string{}
+		for k, v := range fields {
+			b[k] = v
+		}
+		for k, v := range override {
+			b[k] = v
+		}
+		encoded, _ := json.Marshal(b)
+		return encoded
+	}
+
+	tests := []struct {
+		name      string
+		body      []byte
+		challenge []byte
+		failed    string
+	}{
+		{"Valid", []byte(attestation), challenge, ""},
+		{"Invalid JSON", []byte(`{"keyID": `), challenge, StepEnvelope},
+		{"Missing field", []byte(`{"keyID": "AA=="}`), challenge, StepEnvelope},
+		{"Invalid base64", body(map[string]string{"attestationObject": "!!!"}), challenge, StepBase64},
+		{"Invalid key ID", body(map[string]string{"keyID": "not base64"}), challenge, StepBase64},
+		{"Wrong challenge", []byte(attestation), []byte("other"), StepChallenge},
+		{"Invalid CBOR", body(map[string]string{"attestationObject": "o2NmbXQ"}), challenge, StepParse},
+		{"Wrong key ID", body(map[string]string{"keyID": "AAAA"}), challenge, StepAuthData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Diagnose(tt.body, tt.challenge, policy)
+			if report == nil {
+				t.Fatal("Missing report")
+			}
+			failed := report.Failed()
+			if tt.failed == "" {
+				if err != nil || failed != nil || !report.Valid {
+					t.Fatalf("Not valid: %+v %+v", err, report)
+				}
+				return
+			}
+			if err == nil || failed == nil || report.Valid {
+				t.Fatalf("Expected failure at %s: %+v", tt.failed, report)
+			}
+			if failed.Name != tt.failed || failed.Detail == "" {
+				t.Fatalf("Expected failure at %s, got %+v", tt.failed, failed)
+			}
+		})
+	}
+
+	t.Run("Truncated attestation objects", func(t *testing.T) {
+		raw, err := base64.RawURLEncoding.DecodeString(fields["attestationObject"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < len(raw); n += 7 {
+			truncated := base64.RawURLEncoding.EncodeToString(raw[:n])
+			report, err := Diagnose(body(map[string]string{"attestationObject": truncated}), challenge, policy)
+			if err == nil || report.Failed() == nil {
+				t.Fatalf("Expected truncation at %d to fail", n)
+			}
+		}
+	})
+
+	t.Run("Malformed attestation objects", func(t *testing.T) {
+		raw, err := base64.RawURLEncoding.DecodeString(fields["attestationObject"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var object AttestationObject
+		if err := utils.UnmarshalCBOR(raw, &object); err != nil {
+			t.Fatal(err)
+		}
+		attStmt, authData := object.AttStatement, object.RawAuthData
+
+		variants := map[string]map[string]interface{}{
+			"No attStmt":           {"fmt": "apple-appattest", "authData": authData},
+			"Empty attStmt":        {"fmt": "apple-appattest", "authData": authData, "attStmt": map[string]interface{}{}},
+			"attStmt not a map":    {"fmt": "apple-appattest", "authData": authData, "attStmt": []byte{1}},
+			"Empty x5c":            {"fmt": "apple-appattest", "authData": authData, "attStmt": map[string]interface{}{"x5c": []interface{}{}, "receipt": attStmt["receipt"]}},
+			"x5c of integers":      {"fmt": "apple-appattest", "authData": authData, "attStmt": map[string]interface{}{"x5c": []interface{}{1, 2}, "receipt": attStmt["receipt"]}},
+			"x5c of garbage":       {"fmt": "apple-appattest", "authData": authData, "attStmt": map[string]interface{}{"x5c": []interface{}{[]byte{0x30, 0x00}}, "receipt": attStmt["receipt"]}},
+			"Receipt not bytes":    {"fmt": "apple-appattest", "authData": authData, "attStmt": map[string]interface{}{"x5c": attStmt["x5c"], "receipt": "receipt"}},
+			"No authData":          {"fmt": "apple-appattest", "attStmt": attStmt},
+			"Short authData":       {"fmt": "apple-appattest", "authData": authData[:40], "attStmt": attStmt},
+			"authData not bytes":   {"fmt": "apple-appattest", "authData": "authData", "attStmt": attStmt},
+			"Truncated credential": {"fmt": "apple-appattest", "authData": authData[:len(authData)-20], "attStmt": attStmt},
+			"Wrong fmt type":       {"fmt": 1, "authData": authData, "attStmt": attStmt},
+		}
+		for name, variant := range variants {
+			encoded, err := utils.MarshalCBOR(variant)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report, err := Diagnose(body(map[string]string{"attestationObject": base64.RawURLEncoding.EncodeToString(encoded)}), challenge, policy)
+			if err == nil || report.Valid || report.Failed() == nil {
+				t.Errorf("%s: expected a failure, got %+v", name, report)
+			}
+		}
+	})
+}
+
+func FuzzDiagnose(f *testing.F) {
+	saved := TimeNow
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	defer func() { TimeNow = saved }()
+
+	var fields map[string]string
+	if err := json.Unmarshal([]byte(attestation), &fields); err != nil {
+		f.Fatal(err)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(fields["attestationObject"])
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(raw)
+	f.Add([]byte{})
+	f.Add([]byte{0xa0})
+	policy := &Policy{AppID: "35MFYY2JY5.co.chiff.attestation-test"}
+	f.Fuzz(func(t *testing.T, attestationObject []byte) {
+		fields := map[string]string{
+			"keyID":             fields["keyID"],
+			"clientData":        fields["clientData"],
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+		}
+		body, _ := json.Marshal(fields)
+		report, err := Diagnose(body, []byte("attestation-test"), policy)
+		if report == nil {
+			t.Fatal("Missing report")
+		}
+		if (err == nil) != report.Valid || (err == nil) != (report.Failed() == nil) {
+			t.Fatalf("Inconsistent report: %v %+v", err, report)
+		}
+	})
+}
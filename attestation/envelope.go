@@ -0,0 +1,75 @@
+package attestation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Envelope is the JSON body that most App Attest sample code posts to register a key:
+//
+//	{"keyId": "<base64>", "attestation": "<base64>", "challenge": "<challenge>"}
+//
+// The key ID and attestation object may use any base64 variant. The challenge is the client
+// data the app passed to attestKey, as a string.
+type Envelope struct {
+	KeyID       []byte
+	Attestation []byte
+	Challenge   string
+}
+
+// UnmarshalJSON decodes the envelope, rejecting missing fields and invalid base64.
+func (e *Envelope) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		KeyID       string `json:"keyId"`
+		Attestation string `json:"attestation"`
+		Challenge   string `json:"challenge"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return utils.ErrBadRequest.WithDetails(err.Error())
+	}
+	if raw.KeyID == "" || raw.Attestation == "" || raw.Challenge == "" {
+		return utils.ErrBadRequest.WithDetails("Envelope must have keyId, attestation and challenge")
+	}
+
+	keyID, err := utils.DecodeKeyID(raw.KeyID)
+	if err != nil {
+		return err
+	}
+	object, err := utils.DecodeBase64(raw.Attestation)
+	if err != nil {
+		return utils.ErrParsingData.WithDetails(fmt.Sprintf("The attestation object was not valid base64: %v", err))
+	}
+	*e = Envelope{KeyID: keyID, Attestation: object, Challenge: raw.Challenge}
+	return nil
+}
+
+// Response returns the envelope as an AuthenticatorAttestationResponse to verify.
+func (e *Envelope) Response() *AuthenticatorAttestationResponse {
+	return &AuthenticatorAttestationResponse{
+		ClientData:        []byte(e.Challenge),
+		KeyID:             base64.StdEncoding.EncodeToString(e.KeyID),
+		AttestationObject: e.Attestation,
+	}
+}
+
+// ParseEnvelopeRequest decodes an Envelope from the body of r, which must be at most maxSize
+// bytes, and returns it as an AuthenticatorAttestationResponse. The caller still checks the
+// challenge, e.g. by passing aar.ClientData to a ChallengeStore.
+func ParseEnvelopeRequest(r *http.Request, maxSize int64) (*AuthenticatorAttestationResponse, error) {
+	if r.Body == nil {
+		return nil, utils.ErrBadRequest.WithDetails("Missing request body")
+	}
+	data, err := utils.ReadLimited(r.Body, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	var e Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return e.Response(), nil
+}
@@ -0,0 +1,61 @@
+package attestation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEnvelopeRequest(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	keyID, _ := base64.StdEncoding.DecodeString(aar.KeyID)
+	body, _ := json.Marshal(map[string]string{
+		"keyId":       base64.RawURLEncoding.EncodeToString(keyID),
+		"attestation": base64.StdEncoding.EncodeToString(aar.AttestationObject),
+		"challenge":   string(aar.ClientData),
+	})
+
+	t.Run("Valid envelope", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/attest", strings.NewReader(string(body)))
+		parsed, err := ParseEnvelopeRequest(req, 1<<16)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if parsed.KeyID != aar.KeyID {
+			t.Fatalf("Wrong key ID: %s", parsed.KeyID)
+		}
+		if _, err := NewVerifier().Verify(parsed, "35MFYY2JY5.co.chiff.attestation-test", false); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+	})
+
+	t.Run("Oversized body", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/attest", strings.NewReader(string(body)))
+		if _, err := ParseEnvelopeRequest(req, int64(len(body)-1)); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("Invalid envelopes", func(t *testing.T) {
+		for _, body := range []string{
+			`{`,
+			`{"keyId": "AcP/pnpoNVPIJYZOvmIvWzDvmxkFoQCE4Uu7Nk6WiAA=", "attestation": "o2Nm"}`,
+			`{"keyId": "AAAA", "attestation": "o2Nm", "challenge": "c"}`,
+			`{"keyId": "AcP/pnpoNVPIJYZOvmIvWzDvmxkFoQCE4Uu7Nk6WiAA=", "attestation": "!", "challenge": "c"}`,
+		} {
+			var e Envelope
+			if err := json.Unmarshal([]byte(body), &e); err == nil {
+				t.Errorf("Expected an error for %s", body)
+			}
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package attestation
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Outcomes reported in an Event.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	// A registered key was attested again and its stored counter was reset
+	OutcomeReattested = "reattested"
+)
+
+// Event is a security event describing the outcome of a single verification. Events are
+// written as newline-delimited JSON to the sink configured with WithEventSink.
+type Event struct {
+	Time time.Time `json:"timestamp"`
+	// Hex encoded prefix of the SHA256 hash of the decoded key ID, so events can be correlated
+	// without logging the key ID itself, whichever encoding of the key ID was verified
+	KeyIDFingerprint string `json:"key_id_fingerprint"`
+	AppID            string `json:"app_id"`
+	Environment      string `json:"environment"`
+	Outcome          string `json:"outcome"`
+	// The type of the error when verification failed
+	FailureCode string `json:"failure_code,omitempty"`
+	// Hex encoded serial number of the credential certificate, when it could be parsed
+	LeafSerial string `json:"leaf_serial,omitempty"`
+}
+
+type eventSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// WithEventSink writes an Event to w after each verification.
+func WithEventSink(w io.Writer) Option {
+	return func(v *Verifier) {
+		v.events = &eventSink{w: w}
+	}
+}
+
+// emit writes the event for a verification to the configured sink, if any. Write errors
+// are ignored so that logging never changes the verification outcome.
+func (v *Verifier) emit(keyID, appID string, env authenticator.Environment, leaf *x509.Certificate, err error) {
+	if v.events == nil {
+		return
+	}
+
+	event := v.newEvent(keyID, appID, env, OutcomeSuccess)
+	if err != nil {
+		event.Outcome = OutcomeFailure
+		event.FailureCode = "unknown"
+		var verr *utils.Error
+		if errors.As(err, &verr) {
+			event.FailureCode = verr.Type
+		}
+	}
+	if leaf != nil && leaf.SerialNumber != nil {
+		event.LeafSerial = leaf.SerialNumber.Text(16)
+	}
+	v.write(event)
+}
+
+func (v *Verifier) newEvent(keyID, appID string, env authenticator.Environment, outcome string) Event {
+	return Event{
+		Time:             v.now().UTC(),
+		KeyIDFingerprint: keyIDFingerprint(keyID),
+		AppID:            appID,
+		Environment:      string(env),
+		Outcome:          outcome,
+	}
+}
+
+// keyIDFingerprint returns the fingerprint of an Event. The key ID is decoded from base64 or
+// hex first, so that all encodings of a key ID have the same fingerprint. A key ID that
+// decodes to neither is hashed as is.
+func keyIDFingerprint(keyID string) string {
+	data, err := utils.DecodeKeyID(keyID)
+	if err != nil {
+		if data, err = hex.DecodeString(keyID); err != nil || len(data) != utils.KeyIDLength {
+			data = []byte(keyID)
+		}
+	}
+	fingerprint := sha256.Sum256(data)
+	return hex.EncodeToString(fingerprint[:8])
+}
+
+func (v *Verifier) write(event Event) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	v.events.mu.Lock()
+	defer v.events.mu.Unlock()
+	v.events.w.Write(append(line, '\n'))
+}
+
+// emitReattestation writes the event for a re-attestation of a registered key. It follows the
+// success event of the verification itself.
+func (v *Verifier) emitReattestation(keyID, appID string, env authenticator.Environment) {
+	if v.events == nil {
+		return
+	}
+	v.write(v.newEvent(keyID, appID, env, OutcomeReattested))
+}
@@ -0,0 +1,91 @@
+package attestation
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestEventSink(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+
+	var sink bytes.Buffer
+	v := NewVerifier(WithEventSink(&sink))
+	if _, err := v.Verify(&aar, "35MFYY2JY5.co.chiff.attestation-test", false); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if _, err := v.Verify(&aar, "wrong.app.id", true); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	lines := strings.Split(strings.TrimSpace(sink.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 events, got %d: %s", len(lines), sink.String())
+	}
+
+	var success map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &success); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"timestamp":          "2021-04-14T09:55:20Z",
+		"key_id_fingerprint": success["key_id_fingerprint"],
+		"app_id":             "35MFYY2JY5.co.chiff.attestation-test",
+		"environment":        "development",
+		"outcome":            OutcomeSuccess,
+		"leaf_serial":        "178d4f4dc06",
+	}
+	if len(success) != len(expected) {
+		t.Fatalf("Unexpected event shape: %s", lines[0])
+	}
+	for k, want := range expected {
+		if success[k] != want {
+			t.Fatalf("Wrong %s: %v", k, success[k])
+		}
+	}
+	if fp, _ := success["key_id_fingerprint"].(string); len(fp) != 16 {
+		t.Fatalf("Wrong fingerprint: %v", success["key_id_fingerprint"])
+	}
+
+	var failure Event
+	if err := json.Unmarshal([]byte(lines[1]), &failure); err != nil {
+		t.Fatal(err)
+	}
+	if failure.Outcome != OutcomeFailure || failure.FailureCode != "verification_error" || failure.Environment != "production" {
+		t.Fatalf("Wrong failure event: %s", lines[1])
+	}
+}
+
+func TestKeyIDFingerprint(t *testing.T) {
+	keyID := make([]byte, utils.KeyIDLength)
+	for i := range keyID {
+		keyID[i] = byte(i * 7)
+	}
+	fingerprint := keyIDFingerprint(base64.StdEncoding.EncodeToString(keyID))
+	for _, encoded := range []string{
+		base64.RawStdEncoding.EncodeToString(keyID),
+		base64.URLEncoding.EncodeToString(keyID),
+		utils.KeyIDString(keyID),
+		hex.EncodeToString(keyID),
+		strings.ToUpper(hex.EncodeToString(keyID)),
+	} {
+		if got := keyIDFingerprint(encoded); got != fingerprint {
+			t.Fatalf("Fingerprint of %s is %s, expected %s", encoded, got, fingerprint)
+		}
+	}
+	if keyIDFingerprint("not a key ID") == fingerprint {
+		t.Fatal("Expected another key ID to have another fingerprint")
+	}
+}
@@ -0,0 +1,352 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Maximum size of an OCSP response.
+const maxOCSPResponseSize = 64 << 10
+
+var (
+	oidOCSPBasic  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidSHA1       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	ocspSignature = map[string]x509.SignatureAlgorithm{
+		"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+		"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+		"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+		"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+		"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+		"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+	}
+)
+
+// The ASN.1 structures of RFC 6960.
+type ocspCertID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspTBSRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []ocspSingleRequest
+}
+
+type ocspSingleRequest struct {
+	CertID ocspCertID
+}
+
+type ocspResponse struct {
+	Status        asn1.Enumerated
+	ResponseBytes struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+	Extensions  []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// WithOCSP enables checking the revocation status of the verified certificate chain with
+// OCSP, using the responders named in the certificates, instead of another revocation
+// method. The status of a certificate without a responder or a stapled response is
+// undetermined, see WithRevocationPolicy. The responses of intermediate certificates are
+// cached until their next update. It is disabled by default, and Policy.Offline only uses
+// stapled responses, see WithStapledOCSP.
+func WithOCSP(enabled bool) Option {
+	return func(v *Verifier) {
+		v.setRevocation(RevocationOCSP, enabled)
+	}
+}
+
+// WithStapledOCSP provides DER-encoded OCSP responses obtained out of band, e.g. prefetched
+// for Apple's intermediate certificate. A valid response for a certificate is used instead of
+// querying its responder.
+func WithStapledOCSP(responses ...[]byte) Option {
+	return func(v *Verifier) {
+		v.stapledOCSP = append(v.stapledOCSP, responses...)
+	}
+}
+
+// ocspChecker queries OCSP responders and caches their responses for CA certificates.
+type ocspChecker struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+// newOCSPChecker returns an ocspChecker using the given client. A nil client uses a client
+// with a five second timeout.
+func newOCSPChecker(client *http.Client) *ocspChecker {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	return &ocspChecker{client: client, cache: make(map[string][]byte)}
+}
+
+var defaultOCSPChecker = newOCSPChecker(nil)
+
+// check checks the status of the certificate with a stapled response, a cached one or, if
+// fetch is set, one of its responder.
+func (c *ocspChecker) check(ctx context.Context, ct, issuer *x509.Certificate, stapled [][]byte, fetch bool, now time.Time, skew time.Duration) error {
+	// Stapled responses that cannot be used, e.g. expired ones, are ignored.
+	for _, resp := range stapled {
+		if err := verifyOCSPResponse(resp, ct, issuer, now, skew); !errors.Is(err, errRevocationUnavailable) {
+			return err
+		}
+	}
+
+	id, err := newOCSPCertID(ct, issuer)
+	if err != nil {
+		return revocationUnavailable("OCSP", ct, "%v", err)
+	}
+	key := string(id.IssuerNameHash) + string(id.IssuerKeyHash) + ct.SerialNumber.String()
+	c.mu.Lock()
+	cached, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok {
+		if err := verifyOCSPResponse(cached, ct, issuer, now, skew); !errors.Is(err, errRevocationUnavailable) {
+			return err
+		}
+	}
+
+	if len(ct.OCSPServer) == 0 {
+		return revocationUnavailable("OCSP", ct, "no usable stapled response and the certificate names no responder")
+	}
+	if !fetch {
+		return revocationUnavailable("OCSP", ct, "no usable stapled response and verification is offline")
+	}
+	request, err := asn1.Marshal(ocspRequest{TBSRequest: ocspTBSRequest{RequestList: []ocspSingleRequest{{CertID: id}}}})
+	if err != nil {
+		return revocationUnavailable("OCSP", ct, "%v", err)
+	}
+
+	err = revocationUnavailable("OCSP", ct, "no responder")
+	for _, url := range ct.OCSPServer {
+		var resp []byte
+		if resp, err = c.fetch(ctx, url, request); err != nil {
+			err = revocationUnavailable("OCSP", ct, "%v", err)
+			continue
+		}
+		if err = verifyOCSPResponse(resp, ct, issuer, now, skew); errors.Is(err, errRevocationUnavailable) {
+			continue
+		}
+		// Credential certificates are checked once, only the responses of the few
+		// intermediates are worth keeping.
+		if ct.IsCA {
+			c.mu.Lock()
+			c.cache[key] = resp
+			c.mu.Unlock()
+		}
+		return err
+	}
+	return err
+}
+
+// fetch posts the DER-encoded request to the responder.
+func (c *ocspChecker) fetch(ctx context.Context, url string, request []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("responder %s returned status %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize))
+}
+
+// errOCSPNoMatch is returned for a response that holds no status of the certificate.
+var errOCSPNoMatch = &revocationError{utils.ErrAttestationCertificate.WithDetails("OCSP response does not cover the certificate")}
+
+// verifyOCSPResponse verifies the signature and validity of a DER-encoded OCSP response and
+// returns an error unless it reports the certificate as good.
+func verifyOCSPResponse(der []byte, ct, issuer *x509.Certificate, now time.Time, skew time.Duration) error {
+	var resp ocspResponse
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil || len(rest) > 0 {
+		return revocationUnavailable("OCSP", ct, "malformed response")
+	}
+	if resp.Status != 0 {
+		return revocationUnavailable("OCSP", ct, "responder status %d", resp.Status)
+	}
+	if !resp.ResponseBytes.ResponseType.Equal(oidOCSPBasic) {
+		return revocationUnavailable("OCSP", ct, "unsupported response type %s", resp.ResponseBytes.ResponseType)
+	}
+	var basic ocspBasicResponse
+	if rest, err := asn1.Unmarshal(resp.ResponseBytes.Response, &basic); err != nil || len(rest) > 0 {
+		return revocationUnavailable("OCSP", ct, "malformed basic response")
+	}
+	var data ocspResponseData
+	if rest, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil || len(rest) > 0 {
+		return revocationUnavailable("OCSP", ct, "malformed response data")
+	}
+
+	var single *ocspSingleResponse
+	for i, r := range data.Responses {
+		if matchesOCSPCertID(r.CertID, ct, issuer) {
+			single = &data.Responses[i]
+			break
+		}
+	}
+	if single == nil {
+		return errOCSPNoMatch
+	}
+
+	if err := verifyOCSPSignature(&basic, issuer); err != nil {
+		return revocationUnavailable("OCSP", ct, "%v", err)
+	}
+	if single.ThisUpdate.After(now.Add(skew)) {
+		return revocationUnavailable("OCSP", ct, "response is not valid before %s", single.ThisUpdate.Format(time.RFC3339))
+	}
+	if !single.NextUpdate.IsZero() && single.NextUpdate.Before(now.Add(-skew)) {
+		return revocationUnavailable("OCSP", ct, "response expired at %s", single.NextUpdate.Format(time.RFC3339))
+	}
+
+	switch {
+	case bool(single.Good):
+		return nil
+	case !single.Revoked.RevocationTime.IsZero():
+		return utils.ErrCertificateRevoked.WithDetails(fmt.Sprintf("Certificate %q was revoked at %s", ct.Subject.CommonName, single.Revoked.RevocationTime.Format(time.RFC3339)))
+	default:
+		return revocationUnavailable("OCSP", ct, "responder does not know the certificate")
+	}
+}
+
+// verifyOCSPSignature checks that the response is signed by the issuer or by a responder
+// certificate the issuer delegated OCSP signing to.
+func verifyOCSPSignature(basic *ocspBasicResponse, issuer *x509.Certificate) error {
+	alg, ok := ocspSignature[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	signer := issuer
+	for _, raw := range basic.Certificates {
+		responder, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return fmt.Errorf("malformed responder certificate: %v", err)
+		}
+		if responder.Equal(issuer) {
+			continue
+		}
+		if err := responder.CheckSignatureFrom(issuer); err != nil {
+			return fmt.Errorf("responder certificate not issued by the issuer: %v", err)
+		}
+		delegated := false
+		for _, usage := range responder.ExtKeyUsage {
+			delegated = delegated || usage == x509.ExtKeyUsageOCSPSigning
+		}
+		if !delegated {
+			return errors.New("responder certificate is not authorized to sign OCSP responses")
+		}
+		signer = responder
+		break
+	}
+	if err := signer.CheckSignature(alg, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()); err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	return nil
+}
+
+// newOCSPCertID identifies the certificate with SHA-1 hashes of its issuer, as responders
+// are required to support.
+func newOCSPCertID(ct, issuer *x509.Certificate) (ocspCertID, error) {
+	nameHash, keyHash, err := issuerHashes(issuer, crypto.SHA1)
+	if err != nil {
+		return ocspCertID{}, err
+	}
+	return ocspCertID{
+		HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		IssuerNameHash: nameHash,
+		IssuerKeyHash:  keyHash,
+		SerialNumber:   ct.SerialNumber,
+	}, nil
+}
+
+// matchesOCSPCertID reports whether id identifies the certificate.
+func matchesOCSPCertID(id ocspCertID, ct, issuer *x509.Certificate) bool {
+	hash := crypto.SHA1
+	if id.HashAlgorithm.Algorithm.Equal(oidSHA256) {
+		hash = crypto.SHA256
+	} else if !id.HashAlgorithm.Algorithm.Equal(oidSHA1) {
+		return false
+	}
+	nameHash, keyHash, err := issuerHashes(issuer, hash)
+	return err == nil && id.SerialNumber != nil && id.SerialNumber.Cmp(ct.SerialNumber) == 0 &&
+		bytes.Equal(id.IssuerNameHash, nameHash) && bytes.Equal(id.IssuerKeyHash, keyHash)
+}
+
+// issuerHashes returns the hashes of the issuer's name and public key of an OCSP CertID.
+func issuerHashes(issuer *x509.Certificate, hash crypto.Hash) ([]byte, []byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, nil, fmt.Errorf("malformed issuer public key: %v", err)
+	}
+	sum := func(data []byte) []byte {
+		if hash == crypto.SHA256 {
+			h := sha256.Sum256(data)
+			return h[:]
+		}
+		h := sha1.Sum(data)
+		return h[:]
+	}
+	return sum(issuer.RawSubject), sum(spki.PublicKey.RightAlign()), nil
+}
@@ -0,0 +1,200 @@
+package attestation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// ocspTestResponse returns a response signed by key telling the status of the certificate:
+// good, revoked or unknown.
+func ocspTestResponse(t *testing.T, leaf, issuer *x509.Certificate, key *ecdsa.PrivateKey, status string, nextUpdate time.Time) []byte {
+	t.Helper()
+	id, err := newOCSPCertID(leaf, issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	single := ocspSingleResponse{CertID: id, ThisUpdate: now.Add(-time.Minute), NextUpdate: nextUpdate.UTC().Truncate(time.Second)}
+	switch status {
+	case "good":
+		single.Good = true
+	case "revoked":
+		single.Revoked.RevocationTime = now.Add(-time.Hour)
+	default:
+		single.Unknown = true
+	}
+	keyHash, err := asn1.Marshal(id.IssuerKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbs, err := asn1.Marshal(ocspResponseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHash},
+		ProducedAt:  now,
+		Responses:   []ocspSingleResponse{single},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic, err := asn1.Marshal(ocspBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp ocspResponse
+	resp.ResponseBytes.ResponseType = oidOCSPBasic
+	resp.ResponseBytes.Response = basic
+	der, err := asn1.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// ocspTestServer serves the response returned by respond and counts the requests.
+func ocspTestServer(t *testing.T, respond func(w http.ResponseWriter)) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		body, _ := io.ReadAll(r.Body)
+		var req ocspRequest
+		if r.Header.Get("Content-Type") != "application/ocsp-request" {
+			t.Errorf("Wrong content type %q", r.Header.Get("Content-Type"))
+		}
+		if _, err := asn1.Unmarshal(body, &req); err != nil || len(req.TBSRequest.RequestList) != 1 {
+			t.Errorf("Malformed OCSP request: %v", err)
+		}
+		respond(w)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestOCSP(t *testing.T) {
+	TimeNow = time.Now
+	var respond func(w http.ResponseWriter)
+	server, requests := ocspTestServer(t, func(w http.ResponseWriter) { respond(w) })
+	leaf, issuer, issuerKey := revocationTestChain(t, false, func(c *x509.Certificate) { c.OCSPServer = []string{server.URL} })
+	chain := []*x509.Certificate{leaf, issuer}
+	nextUpdate := time.Now().Add(time.Hour)
+	serve := func(resp []byte) {
+		respond = func(w http.ResponseWriter) { w.Write(resp) }
+	}
+
+	t.Run("Good status of an intermediate is cached", func(t *testing.T) {
+		intermediate, root, rootKey := revocationTestChain(t, true, func(c *x509.Certificate) { c.OCSPServer = []string{server.URL} })
+		tests := []struct {
+			chain    []*x509.Certificate
+			key      *ecdsa.PrivateKey
+			requests int32
+		}{
+			{chain, issuerKey, 2},
+			{[]*x509.Certificate{intermediate, root}, rootKey, 1},
+		}
+		for _, tt := range tests {
+			serve(ocspTestResponse(t, tt.chain[0], tt.chain[1], tt.key, "good", nextUpdate))
+			v := NewVerifier(WithOCSP(true), WithHTTPClient(server.Client()))
+			before := atomic.LoadInt32(requests)
+			for i := 0; i < 2; i++ {
+				if warnings, err := v.checkRevocation(context.Background(), tt.chain); err != nil || len(warnings) > 0 {
+					t.Fatalf("Not valid: %+v %v", err, warnings)
+				}
+			}
+			if n := atomic.LoadInt32(requests) - before; n != tt.requests {
+				t.Fatalf("Expected %d requests for %q, got %d", tt.requests, tt.chain[0].Subject.CommonName, n)
+			}
+		}
+	})
+
+	t.Run("Revoked certificate is rejected", func(t *testing.T) {
+		serve(ocspTestResponse(t, leaf, issuer, issuerKey, "revoked", nextUpdate))
+		v := NewVerifier(WithOCSP(true), WithRevocationPolicy(RevocationSoftFail), WithHTTPClient(server.Client()))
+		_, err := v.checkRevocation(context.Background(), chain)
+		var verr *utils.Error
+		if !errors.As(err, &verr) || verr.Type != utils.ErrCertificateRevoked.Type {
+			t.Fatalf("Expected a revocation error, got %+v", err)
+		}
+	})
+
+	t.Run("Unavailable status and failure policy", func(t *testing.T) {
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses := map[string][]byte{
+			"malformed":    []byte("not ocsp"),
+			"unknown":      ocspTestResponse(t, leaf, issuer, issuerKey, "unknown", nextUpdate),
+			"expired":      ocspTestResponse(t, leaf, issuer, issuerKey, "good", time.Now().Add(-time.Minute)),
+			"wrong signer": ocspTestResponse(t, leaf, issuer, otherKey, "good", nextUpdate),
+			"server error": nil,
+		}
+		for name, resp := range responses {
+			serve(resp)
+			if resp == nil {
+				respond = func(w http.ResponseWriter) { w.WriteHeader(http.StatusInternalServerError) }
+			}
+			hard := NewVerifier(WithOCSP(true), WithHTTPClient(server.Client()))
+			if _, err := hard.checkRevocation(context.Background(), chain); !errors.Is(err, errRevocationUnavailable) {
+				t.Fatalf("%s: expected an unavailable status with hard-fail, got %+v", name, err)
+			}
+			soft := NewVerifier(WithOCSP(true), WithRevocationPolicy(RevocationSoftFail), WithHTTPClient(server.Client()))
+			if warnings, err := soft.checkRevocation(context.Background(), chain); err != nil || len(warnings) != 1 {
+				t.Fatalf("%s: expected a warning with soft-fail, got %+v %v", name, err, warnings)
+			}
+		}
+	})
+
+	t.Run("No responder", func(t *testing.T) {
+		leaf, issuer, _ := revocationTestChain(t, false, func(*x509.Certificate) {})
+		chain := []*x509.Certificate{leaf, issuer}
+		hard := NewVerifier(WithOCSP(true), WithHTTPClient(server.Client()))
+		if _, err := hard.checkRevocation(context.Background(), chain); !errors.Is(err, errRevocationUnavailable) {
+			t.Fatalf("Expected an unavailable status with hard-fail, got %+v", err)
+		}
+		soft := NewVerifier(WithOCSP(true), WithRevocationPolicy(RevocationSoftFail), WithHTTPClient(server.Client()))
+		if warnings, err := soft.checkRevocation(context.Background(), chain); err != nil || len(warnings) != 1 {
+			t.Fatalf("Expected a warning with soft-fail, got %+v %v", err, warnings)
+		}
+	})
+
+	t.Run("Stapled response", func(t *testing.T) {
+		respond = func(w http.ResponseWriter) { w.WriteHeader(http.StatusInternalServerError) }
+		good := ocspTestResponse(t, leaf, issuer, issuerKey, "good", nextUpdate)
+		v := NewVerifier(WithOCSP(true), WithStapledOCSP(good)).withPolicy(&Policy{Offline: true})
+		before := atomic.LoadInt32(requests)
+		if _, err := v.checkRevocation(context.Background(), chain); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if atomic.LoadInt32(requests) != before {
+			t.Fatal("Expected the stapled response to be used")
+		}
+
+		expired := ocspTestResponse(t, leaf, issuer, issuerKey, "good", time.Now().Add(-time.Minute))
+		v = NewVerifier(WithOCSP(true), WithStapledOCSP(expired)).withPolicy(&Policy{Offline: true})
+		if _, err := v.checkRevocation(context.Background(), chain); !errors.Is(err, errRevocationUnavailable) {
+			t.Fatalf("Expected an expired stapled response to be ignored, got %+v", err)
+		}
+	})
+}
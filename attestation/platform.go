@@ -0,0 +1,42 @@
+package attestation
+
+import "strings"
+
+// Platform is an Apple platform that supports App Attest.
+//
+// The App ID is composed the same way on every platform, team ID and bundle ID separated by a
+// dot, and the environment is indicated by the same AAGUID values, so attestations from all
+// platforms verify alike. The only difference is the bundle ID of Mac Catalyst apps, which
+// Xcode prefixes with "maccatalyst." unless the app shares the iOS bundle ID.
+type Platform string
+
+const (
+	// PlatformIOS iOS and iPadOS apps
+	PlatformIOS Platform = "ios"
+	// PlatformMacOS native macOS apps
+	PlatformMacOS Platform = "macos"
+	// PlatformVisionOS visionOS apps
+	PlatformVisionOS Platform = "visionos"
+	// PlatformMacCatalyst iPad apps built for macOS with Mac Catalyst
+	PlatformMacCatalyst Platform = "maccatalyst"
+)
+
+// macCatalystPrefix is the prefix Xcode adds to the bundle ID of Mac Catalyst apps.
+const macCatalystPrefix = "maccatalyst."
+
+// AppID returns the App ID of the app with the given bundle ID on the platform.
+func (p Platform) AppID(teamID, bundleID string) string {
+	if p == PlatformMacCatalyst && !strings.HasPrefix(bundleID, macCatalystPrefix) {
+		bundleID = macCatalystPrefix + bundleID
+	}
+	return teamID + "." + bundleID
+}
+
+// splitAppID splits an App ID into team ID and bundle ID.
+func splitAppID(appID string) (teamID, bundleID string, ok bool) {
+	i := strings.IndexByte(appID, '.')
+	if i <= 0 || i == len(appID)-1 {
+		return "", "", false
+	}
+	return appID[:i], appID[i+1:], true
+}
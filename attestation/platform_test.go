@@ -0,0 +1,69 @@
+package attestation
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/testutil"
+)
+
+func TestPlatformAppID(t *testing.T) {
+	tests := []struct {
+		platform Platform
+		bundleID string
+		appID    string
+	}{
+		{PlatformIOS, "com.example.app", "TEAMID1234.com.example.app"},
+		{PlatformMacOS, "com.example.app", "TEAMID1234.com.example.app"},
+		{PlatformVisionOS, "com.example.app", "TEAMID1234.com.example.app"},
+		{PlatformMacCatalyst, "com.example.app", "TEAMID1234.maccatalyst.com.example.app"},
+		{PlatformMacCatalyst, "maccatalyst.com.example.app", "TEAMID1234.maccatalyst.com.example.app"},
+	}
+	for _, test := range tests {
+		if appID := test.platform.AppID("TEAMID1234", test.bundleID); appID != test.appID {
+			t.Errorf("%s %s: expected %s, got %s", test.platform, test.bundleID, test.appID, appID)
+		}
+	}
+}
+
+func TestMacCatalystAttestation(t *testing.T) {
+	TimeNow = time.Now
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	att, err := authority.Attest(device, testutil.AttestOptions{
+		AppID:      "TEAMID1234.maccatalyst.com.example.app",
+		ClientData: []byte("challenge"),
+		Production: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal(att.JSON(), &aar); err != nil {
+		t.Fatal(err)
+	}
+	v := NewVerifier(WithChainVerifier(X509ChainVerifier{Roots: authority.Roots()}))
+
+	policy := &Policy{AppID: "TEAMID1234.com.example.app", Production: true}
+	if _, err := v.VerifyPolicy(&aar, policy); err == nil {
+		t.Fatal("Expected the Mac Catalyst App ID to be rejected without the platform")
+	}
+	policy.Platforms = []Platform{PlatformIOS, PlatformMacCatalyst}
+	if len(policy.RPIDHashes()) != 2 {
+		t.Fatalf("Expected 2 RP ID hashes, got %d", len(policy.RPIDHashes()))
+	}
+	result, err := v.VerifyPolicy(&aar, policy)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if result.Environment != "production" {
+		t.Fatalf("Wrong environment: %s", result.Environment)
+	}
+}
@@ -0,0 +1,229 @@
+package attestation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// ChallengeLength is the length in bytes of challenges created by NewChallenge.
+const ChallengeLength = 32
+
+// Policy describes which attestations a relying party accepts.
+type Policy struct {
+	// The App ID, i.e. team ID and bundle ID separated by a dot
+	AppID string
+	// Other accepted App IDs, e.g. of app extensions or apps sharing the same backend
+	AppIDs []string
+	// Platforms the app ships on. The App IDs are also accepted as composed for each of
+	// these platforms, e.g. with the bundle ID prefix of Mac Catalyst apps.
+	Platforms []Platform
+	// Whether attestations are expected from the production environment
+	Production bool
+
+	// The following settings override those of the Verifier when they are set.
+
+	// Offline disables checks that need network access, such as fetching missing
+	// intermediate certificates.
+	Offline bool
+	// ClockSkew is the tolerance for certificate validity periods, see WithClockSkew.
+	ClockSkew time.Duration
+	// Revocation selects how the revocation status of the certificate chain is checked, e.g.
+	// RevocationCRL where OCSP responders cannot be reached, see WithOCSP and WithCRL.
+	Revocation RevocationMethod
+	// MaxAttestationAge is the freshness window of attestations, see WithMaxAttestationAge.
+	MaxAttestationAge time.Duration
+	// AllowRegisteredKey accepts attestations for key IDs that are already registered,
+	// see WithUniqueKeyIDs.
+	AllowRegisteredKey bool
+	// AllowReattestation lets VerifyRegistration replace the credential of a registered key
+	// ID with a new attestation of the same key, resetting the stored counter.
+	AllowReattestation bool
+}
+
+// RPIDHashes returns the SHA256 hashes of AppID and AppIDs, and of their variants for
+// Platforms, which are the RP ID hashes an attestation or assertion for the policy may
+// carry. Duplicate App IDs are only hashed once.
+func (p *Policy) RPIDHashes() [][]byte {
+	return newAppIDSet(p.appIDs()...).hashes
+}
+
+// appIDs returns AppID and AppIDs followed by their variants for Platforms.
+func (p *Policy) appIDs() []string {
+	appIDs := append([]string{p.AppID}, p.AppIDs...)
+	for _, appID := range appIDs[:len(appIDs):len(appIDs)] {
+		teamID, bundleID, ok := splitAppID(appID)
+		if !ok {
+			continue
+		}
+		for _, platform := range p.Platforms {
+			appIDs = append(appIDs, platform.AppID(teamID, bundleID))
+		}
+	}
+	return appIDs
+}
+
+// ChallengeStore keeps track of the one-time challenges issued to apps.
+type ChallengeStore interface {
+	// Consume atomically marks the challenge as used. It returns an error when the challenge
+	// was never issued, has expired or was already consumed.
+	Consume(ctx context.Context, challenge []byte) error
+}
+
+// CredentialStore persists the credentials of registered devices.
+type CredentialStore interface {
+	Save(ctx context.Context, cred *Credential) error
+}
+
+// NewChallenge returns a random challenge to send to the app before it performs an attestation.
+func NewChallenge() ([]byte, error) {
+	return NewChallengeFrom(rand.Reader)
+}
+
+// NewChallengeFrom is like NewChallenge, reading the challenge from r, e.g. a deterministic
+// reader in tests.
+func NewChallengeFrom(r io.Reader) ([]byte, error) {
+	challenge := make([]byte, ChallengeLength)
+	if _, err := io.ReadFull(r, challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// VerifyRegistration verifies a registration with a default Verifier. See Verifier.VerifyRegistration.
+func VerifyRegistration(ctx context.Context, store ChallengeStore, credStore CredentialStore, body []byte, policy *Policy) (*Credential, error) {
+	return NewVerifier().VerifyRegistration(ctx, store, credStore, body, policy)
+}
+
+// VerifyRegistration decodes an AuthenticatorAttestationResponse from the JSON body, verifies
+// the attestation against the policy, consumes the challenge and saves the credential.
+//
+// The client data of the attestation is the one-time challenge. It is consumed only once the
+// attestation verified successfully, so a failed attempt leaves the challenge usable. The
+// store's Consume is the point of atomicity: when the same challenge is submitted concurrently
+// only one registration is saved, and the credential is only saved after the challenge was
+// consumed. If saving fails the challenge stays consumed and the app must request a new one.
+//
+// When the credential store implements CredentialLoader, a key ID that is already registered
+// is rejected with ErrKeyRegistered, even with Policy.AllowRegisteredKey, so its credential
+// and counter are never overwritten. With Policy.AllowReattestation it may be attested again,
+// e.g. after the app lost its stored state. The new attestation must be for the stored public
+// key; the stored credential is then replaced, which resets its counter, and an Event with
+// OutcomeReattested is emitted. The credential store must also implement CredentialLoader.
+func (v *Verifier) VerifyRegistration(ctx context.Context, store ChallengeStore, credStore CredentialStore, body []byte, policy *Policy) (*Credential, error) {
+	if policy == nil {
+		return nil, utils.ErrBadRequest.WithDetails("Missing policy")
+	}
+
+	var aar AuthenticatorAttestationResponse
+	if err := json.Unmarshal(body, &aar); err != nil {
+		return nil, utils.ErrBadRequest.WithDetails(err.Error())
+	}
+	if len(aar.ClientData) == 0 {
+		return nil, utils.ErrBadRequest.WithDetails("Missing client data")
+	}
+
+	loader, ok := credStore.(CredentialLoader)
+	if policy.AllowReattestation && !ok {
+		return nil, utils.ErrBadRequest.WithDetails("Re-attestation requires a credential store that can load credentials")
+	}
+
+	result, err := v.VerifyPolicyContext(ctx, &aar, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing *Credential
+	if loader != nil {
+		if existing, err = loader.Load(ctx, result.KeyID); err != nil {
+			return nil, err
+		}
+		if existing != nil && !policy.AllowReattestation {
+			// Replacing the credential would reset its counter, reopening assertion replays.
+			return nil, utils.ErrKeyRegistered.WithDetails("A credential is already registered for the key ID")
+		}
+		if existing != nil {
+			if err := existing.MatchesAttestation(result); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := store.Consume(ctx, aar.ClientData); err != nil {
+		return nil, err
+	}
+
+	// The credential of a re-attested key starts over with the counter of the attestation.
+	cred := result.Credential()
+	if err := credStore.Save(ctx, cred); err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		v.emitReattestation(aar.KeyID, result.AppID, result.Environment)
+	}
+	return cred, nil
+}
+
+// MemoryChallengeStore is a ChallengeStore keeping challenges in memory.
+type MemoryChallengeStore struct {
+	mu         sync.Mutex
+	challenges map[string]struct{}
+}
+
+// NewMemoryChallengeStore returns an empty MemoryChallengeStore.
+func NewMemoryChallengeStore() *MemoryChallengeStore {
+	return &MemoryChallengeStore{challenges: make(map[string]struct{})}
+}
+
+// Issue records a challenge that was sent to an app.
+func (s *MemoryChallengeStore) Issue(challenge []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.challenges[string(challenge)] = struct{}{}
+}
+
+// Consume removes the challenge, failing when it is unknown or was already consumed.
+func (s *MemoryChallengeStore) Consume(ctx context.Context, challenge []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.challenges[string(challenge)]; !ok {
+		return utils.ErrChallengeMismatch.WithDetails("Challenge is unknown or was already used")
+	}
+	delete(s.challenges, string(challenge))
+	return nil
+}
+
+// MemoryCredentialStore is a CredentialStore keeping credentials in memory, keyed by key ID.
+type MemoryCredentialStore struct {
+	mu          sync.Mutex
+	credentials map[string]Credential
+}
+
+// NewMemoryCredentialStore returns an empty MemoryCredentialStore.
+func NewMemoryCredentialStore() *MemoryCredentialStore {
+	return &MemoryCredentialStore{credentials: make(map[string]Credential)}
+}
+
+// Save stores a copy of the credential.
+func (s *MemoryCredentialStore) Save(ctx context.Context, cred *Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credentials[string(cred.KeyID)] = *cred
+	return nil
+}
+
+// Load returns a copy of the credential with the given key ID, or nil if there is none.
+func (s *MemoryCredentialStore) Load(ctx context.Context, keyID []byte) (*Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cred, ok := s.credentials[string(keyID)]
+	if !ok {
+		return nil, nil
+	}
+	return &cred, nil
+}
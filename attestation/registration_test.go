@@ -0,0 +1,262 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	mathrand "math/rand"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestVerifyRegistration(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	ctx := context.Background()
+	policy := &Policy{AppID: "35MFYY2JY5.co.chiff.attestation-test"}
+
+	t.Run("Registration consumes the challenge", func(t *testing.T) {
+		challenges := NewMemoryChallengeStore()
+		challenges.Issue([]byte("attestation-test"))
+		credentials := NewMemoryCredentialStore()
+
+		cred, err := VerifyRegistration(ctx, challenges, credentials, []byte(attestation), policy)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		stored, _ := credentials.Load(ctx, cred.KeyID)
+		if stored == nil {
+			t.Fatal("Credential was not saved")
+		}
+
+		if _, err := VerifyRegistration(ctx, challenges, NewMemoryCredentialStore(), []byte(attestation), policy); err == nil {
+			t.Fatal("Expected replayed challenge to be rejected")
+		}
+	})
+
+	t.Run("Registered key is not overwritten", func(t *testing.T) {
+		challenges := NewMemoryChallengeStore()
+		credentials := NewMemoryCredentialStore()
+		challenges.Issue([]byte("attestation-test"))
+		cred, err := VerifyRegistration(ctx, challenges, credentials, []byte(attestation), policy)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		cred.Counter = 42
+		credentials.Save(ctx, cred)
+
+		// Without WithUniqueKeyIDs, and even when the policy accepts registered keys, the
+		// stored credential and its counter are kept.
+		allowRegistered := *policy
+		allowRegistered.AllowRegisteredKey = true
+		challenges.Issue([]byte("attestation-test"))
+		_, err = VerifyRegistration(ctx, challenges, credentials, []byte(attestation), &allowRegistered)
+		var verr *utils.Error
+		if !errors.As(err, &verr) || verr.Type != utils.ErrKeyRegistered.Type {
+			t.Fatalf("Expected the registered key to be rejected, got %+v", err)
+		}
+		if stored, _ := credentials.Load(ctx, cred.KeyID); stored.Counter != 42 {
+			t.Fatalf("Stored credential was overwritten, counter %d", stored.Counter)
+		}
+		if _, err := VerifyRegistration(ctx, challenges, NewMemoryCredentialStore(), []byte(attestation), policy); err != nil {
+			t.Fatalf("Challenge was consumed by the rejected registration: %+v", err)
+		}
+	})
+
+	t.Run("Unknown challenge is rejected", func(t *testing.T) {
+		credentials := NewMemoryCredentialStore()
+		if _, err := VerifyRegistration(ctx, NewMemoryChallengeStore(), credentials, []byte(attestation), policy); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if len(credentials.credentials) != 0 {
+			t.Fatal("Credential was saved")
+		}
+	})
+
+	t.Run("Failed verification keeps the challenge", func(t *testing.T) {
+		challenges := NewMemoryChallengeStore()
+		challenges.Issue([]byte("attestation-test"))
+		credentials := NewMemoryCredentialStore()
+
+		wrongPolicy := &Policy{AppID: "wrong.app.id"}
+		if _, err := VerifyRegistration(ctx, challenges, credentials, []byte(attestation), wrongPolicy); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if len(credentials.credentials) != 0 {
+			t.Fatal("Credential was saved")
+		}
+		if _, err := VerifyRegistration(ctx, challenges, credentials, []byte(attestation), policy); err != nil {
+			t.Fatalf("Challenge was consumed by the failed attempt: %+v", err)
+		}
+	})
+
+	t.Run("Re-attestation resets the counter", func(t *testing.T) {
+		challenges := NewMemoryChallengeStore()
+		credentials := NewMemoryCredentialStore()
+		var sink bytes.Buffer
+		v := NewVerifier(WithUniqueKeyIDs(credentials), WithEventSink(&sink))
+
+		challenges.Issue([]byte("attestation-test"))
+		cred, err := v.VerifyRegistration(ctx, challenges, credentials, []byte(attestation), policy)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		cred.Counter = 42
+		credentials.Save(ctx, cred)
+
+		challenges.Issue([]byte("attestation-test"))
+		if _, err := v.VerifyRegistration(ctx, challenges, credentials, []byte(attestation), policy); err == nil {
+			t.Fatal("Expected registered key to be rejected")
+		}
+
+		reattest := *policy
+		reattest.AllowReattestation = true
+		if _, err := v.VerifyRegistration(ctx, challenges, credentials, []byte(attestation), &reattest); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		stored, _ := credentials.Load(ctx, cred.KeyID)
+		if stored.Counter != 0 {
+			t.Fatalf("Counter was not reset: %d", stored.Counter)
+		}
+		if !bytes.Contains(sink.Bytes(), []byte(`"outcome":"`+OutcomeReattested+`"`)) {
+			t.Fatalf("Missing re-attestation event: %s", sink.String())
+		}
+
+		stored.PublicKey = []byte("other")
+		credentials.Save(ctx, stored)
+		challenges.Issue([]byte("attestation-test"))
+		if _, err := v.VerifyRegistration(ctx, challenges, credentials, []byte(attestation), &reattest); err == nil {
+			t.Fatal("Expected re-attestation of a different public key to be rejected")
+		}
+	})
+
+	t.Run("Malformed body is rejected", func(t *testing.T) {
+		if _, err := VerifyRegistration(ctx, NewMemoryChallengeStore(), NewMemoryCredentialStore(), []byte("{"), policy); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+}
+
+func TestNewChallenge(t *testing.T) {
+	a, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != ChallengeLength || string(a) == string(b) {
+		t.Fatalf("Challenges are not random: %x %x", a, b)
+	}
+
+	t.Run("Fixed random source", func(t *testing.T) {
+		a, _ := NewChallengeFrom(mathrand.New(mathrand.NewSource(1)))
+		b, _ := NewChallengeFrom(mathrand.New(mathrand.NewSource(1)))
+		if len(a) != ChallengeLength || string(a) != string(b) {
+			t.Fatalf("Challenges are not reproducible: %x %x", a, b)
+		}
+	})
+}
+
+func TestPolicyRPIDHashes(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	}
+	policy := &Policy{
+		AppID:  "35MFYY2JY5.other-app",
+		AppIDs: []string{"35MFYY2JY5.co.chiff.attestation-test", "35MFYY2JY5.other-app"},
+	}
+
+	hashes := policy.RPIDHashes()
+	expected := []string{"35MFYY2JY5.other-app", "35MFYY2JY5.co.chiff.attestation-test"}
+	if len(hashes) != len(expected) {
+		t.Fatalf("Expected %d hashes, got %d", len(expected), len(hashes))
+	}
+	for i, appID := range expected {
+		hash := sha256.Sum256([]byte(appID))
+		if !bytes.Equal(hashes[i], hash[:]) {
+			t.Errorf("Hash %d does not match %s", i, appID)
+		}
+	}
+
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewVerifier().VerifyPolicy(&aar, policy); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if _, err := NewVerifier().VerifyPolicy(&aar, &Policy{AppID: "35MFYY2JY5.other-app"}); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestPolicySettings(t *testing.T) {
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	appID := "35MFYY2JY5.co.chiff.attestation-test"
+
+	t.Run("Clock skew", func(t *testing.T) {
+		// One minute after the credential certificate expired
+		TimeNow = func() time.Time {
+			return time.Date(2021, 4, 17, 9, 56, 20, 0, time.UTC)
+		}
+		if _, err := NewVerifier().VerifyPolicy(&aar, &Policy{AppID: appID}); err == nil {
+			t.Fatal("Expected expired certificate to be rejected")
+		}
+		if _, err := NewVerifier().VerifyPolicy(&aar, &Policy{AppID: appID, ClockSkew: 5 * time.Minute}); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+	})
+
+	t.Run("Freshness window", func(t *testing.T) {
+		TimeNow = func() time.Time {
+			return time.Date(2021, 4, 14, 10, 55, 20, 0, time.UTC)
+		}
+		v := NewVerifier(WithMaxAttestationAge(2 * time.Hour))
+		if _, err := v.VerifyPolicy(&aar, &Policy{AppID: appID}); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if _, err := v.VerifyPolicy(&aar, &Policy{AppID: appID, MaxAttestationAge: time.Minute}); err == nil {
+			t.Fatal("Expected stale attestation to be rejected")
+		}
+	})
+	t.Run("Registered key", func(t *testing.T) {
+		TimeNow = func() time.Time {
+			return time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+		}
+		ctx := context.Background()
+		credentials := NewMemoryCredentialStore()
+		v := NewVerifier(WithUniqueKeyIDs(credentials))
+
+		result, err := v.VerifyPolicy(&aar, &Policy{AppID: appID})
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		credentials.Save(ctx, result.Credential())
+
+		var failed string
+		v = NewVerifier(WithUniqueKeyIDs(credentials), WithOnStep(func(step string, ok bool, detail string) {
+			if !ok {
+				failed = step
+			}
+		}))
+		if _, err := v.VerifyPolicy(&aar, &Policy{AppID: appID}); err == nil {
+			t.Fatal("Expected registered key to be rejected")
+		}
+		if failed != StepKeyUnique {
+			t.Fatalf("Expected step %s to fail, got %q", StepKeyUnique, failed)
+		}
+		if _, err := v.VerifyPolicy(&aar, &Policy{AppID: appID, AllowRegisteredKey: true}); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+	})
+}
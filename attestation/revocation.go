@@ -0,0 +1,129 @@
+package attestation
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// RevocationMethod selects how the revocation status of the certificate chain is checked.
+type RevocationMethod string
+
+const (
+	// RevocationNone does not check the revocation status. It disables the method of the
+	// Verifier when set in a Policy.
+	RevocationNone RevocationMethod = "none"
+	// RevocationOCSP queries OCSP responders, see WithOCSP.
+	RevocationOCSP RevocationMethod = "ocsp"
+	// RevocationCRL looks up the certificates in CRLs, see WithCRL.
+	RevocationCRL RevocationMethod = "crl"
+)
+
+// RevocationPolicy decides what happens when the revocation status of a certificate cannot
+// be determined, e.g. because the responder cannot be reached. Revoked certificates are
+// always rejected.
+type RevocationPolicy int
+
+const (
+	// RevocationHardFail rejects the attestation.
+	RevocationHardFail RevocationPolicy = iota
+	// RevocationSoftFail accepts the attestation and adds a warning to the AttestationResult.
+	RevocationSoftFail
+)
+
+// MarshalText encodes the policy as "hard-fail" or "soft-fail".
+func (p RevocationPolicy) MarshalText() ([]byte, error) {
+	switch p {
+	case RevocationHardFail:
+		return []byte("hard-fail"), nil
+	case RevocationSoftFail:
+		return []byte("soft-fail"), nil
+	}
+	return nil, fmt.Errorf("unknown revocation policy %d", int(p))
+}
+
+// UnmarshalText decodes a policy encoded by MarshalText.
+func (p *RevocationPolicy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "hard-fail":
+		*p = RevocationHardFail
+	case "soft-fail":
+		*p = RevocationSoftFail
+	default:
+		return fmt.Errorf("unknown revocation policy %q", text)
+	}
+	return nil
+}
+
+// WithRevocationPolicy sets what happens when the revocation status of a certificate cannot
+// be determined, RevocationHardFail by default.
+func WithRevocationPolicy(policy RevocationPolicy) Option {
+	return func(v *Verifier) {
+		v.revocationPolicy = policy
+	}
+}
+
+// setRevocation enables the method, or disables it if it is the configured one.
+func (v *Verifier) setRevocation(method RevocationMethod, enabled bool) {
+	if enabled {
+		v.revocation = method
+	} else if v.revocation == method {
+		v.revocation = ""
+	}
+}
+
+// errRevocationUnavailable marks errors that leave the revocation status undetermined,
+// which RevocationSoftFail tolerates.
+var errRevocationUnavailable = errors.New("revocation status unavailable")
+
+// revocationError wraps an error that leaves the revocation status undetermined.
+type revocationError struct {
+	err *utils.Error
+}
+
+func (e *revocationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *revocationError) Unwrap() []error {
+	return []error{e.err, errRevocationUnavailable}
+}
+
+func revocationUnavailable(method string, ct *x509.Certificate, format string, args ...interface{}) error {
+	return &revocationError{utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("%s status of certificate %q unavailable: %s", method, ct.Subject.CommonName, fmt.Sprintf(format, args...)))}
+}
+
+// checkRevocation checks the revocation status of every certificate of the chain but the
+// root with the configured method. It returns the warnings of undetermined statuses accepted
+// by RevocationSoftFail.
+func (v *Verifier) checkRevocation(ctx context.Context, chain []*x509.Certificate) ([]string, error) {
+	ocsp, crl := v.ocspChecker, v.crlChecker
+	if ocsp == nil {
+		ocsp = defaultOCSPChecker
+	}
+	if crl == nil {
+		crl = defaultCRLChecker
+	}
+	now := v.now()
+	var warnings []string
+	for i := 0; i+1 < len(chain); i++ {
+		var err error
+		switch v.revocation {
+		case RevocationOCSP:
+			err = ocsp.check(ctx, chain[i], chain[i+1], v.stapledOCSP, !v.offline, now, v.clockSkew)
+		case RevocationCRL:
+			err = crl.check(ctx, chain[i], chain[i+1], v.crls, !v.offline, now, v.clockSkew)
+		}
+		if err != nil && v.revocationPolicy == RevocationSoftFail && errors.Is(err, errRevocationUnavailable) {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		if err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
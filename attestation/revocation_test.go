@@ -0,0 +1,85 @@
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// revocationTestChain issues a credential certificate, or an intermediate if ca is set, from
+// the template modified by fn.
+func revocationTestChain(t *testing.T, ca bool, fn func(*x509.Certificate)) (leaf, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey) {
+	t.Helper()
+	now := time.Now()
+	issuerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Issuer"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, issuerTemplate, issuerTemplate, &issuerKey.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issuer, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "Credential"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	}
+	fn(leafTemplate)
+	if ca {
+		leafTemplate.BasicConstraintsValid, leafTemplate.IsCA = true, true
+		leafTemplate.KeyUsage = x509.KeyUsageCertSign
+	}
+	if der, err = x509.CreateCertificate(rand.Reader, leafTemplate, issuer, &leafKey.PublicKey, issuerKey); err != nil {
+		t.Fatal(err)
+	}
+	if leaf, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return leaf, issuer, issuerKey
+}
+
+func TestRevocationMethod(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		policy   RevocationMethod
+		expected RevocationMethod
+	}{
+		{"Disabled by default", nil, "", ""},
+		{"OCSP", []Option{WithOCSP(true)}, "", RevocationOCSP},
+		{"Last method wins", []Option{WithOCSP(true), WithCRL(true)}, "", RevocationCRL},
+		{"Disabling another method keeps the method", []Option{WithOCSP(true), WithCRL(false)}, "", RevocationOCSP},
+		{"Disabling the method", []Option{WithCRL(true), WithCRL(false)}, "", ""},
+		{"Policy selects the method", []Option{WithOCSP(true)}, RevocationCRL, RevocationCRL},
+		{"Policy disables the method", []Option{WithOCSP(true)}, RevocationNone, RevocationNone},
+	}
+	for _, tt := range tests {
+		v := NewVerifier(tt.opts...).withPolicy(&Policy{Revocation: tt.policy})
+		if v.revocation != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, v.revocation)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// AppleRootURL is where Apple publishes the App Attestation root certificate on its PKI page.
+const AppleRootURL = "https://www.apple.com/certificateauthority/Apple_App_Attestation_Root_CA.pem"
+
+// AppleRootFingerprint is the hex encoded SHA-256 fingerprint of the Apple App Attestation
+// root certificate, the embedded one.
+const AppleRootFingerprint = "1cb9823ba28ba6ad2d33a006941de2ae4f513ef1d4e831b9f7e0fa7b6242c932"
+
+// Maximum size of a downloaded CA certificate file.
+const maxRootCertificateSize = 64 << 10
+
+// RootUpdater keeps the trusted roots current in long-running services by downloading Apple's
+// published CA certificates on a schedule. Only certificates whose SHA-256 fingerprint is
+// pinned are trusted, and the roots are replaced only once all certificates were downloaded
+// and verified, so a failed update keeps the previous roots. Pass it to WithRootUpdater.
+type RootUpdater struct {
+	client       *http.Client
+	urls         []string
+	fingerprints [][]byte
+	interval     time.Duration
+	onError      func(err error)
+
+	mu        sync.RWMutex
+	roots     *x509.CertPool
+	updatedAt time.Time
+}
+
+// RootUpdaterOption configures a RootUpdater.
+type RootUpdaterOption func(*RootUpdater)
+
+// WithRootURLs downloads the CA certificates, PEM or DER encoded, from the given URLs instead
+// of AppleRootURL.
+func WithRootURLs(urls ...string) RootUpdaterOption {
+	return func(u *RootUpdater) {
+		u.urls = urls
+	}
+}
+
+// WithRootFingerprints pins the SHA-256 fingerprints of the certificates to trust instead of
+// AppleRootFingerprint. Pin the certificates Apple announces ahead of a rotation so they are
+// trusted as soon as they are published.
+func WithRootFingerprints(fingerprints ...[]byte) RootUpdaterOption {
+	return func(u *RootUpdater) {
+		u.fingerprints = fingerprints
+	}
+}
+
+// WithUpdateInterval sets the time between two updates, a day by default.
+func WithUpdateInterval(d time.Duration) RootUpdaterOption {
+	return func(u *RootUpdater) {
+		u.interval = d
+	}
+}
+
+// WithUpdaterHTTPClient downloads the certificates with client. A nil client uses a client
+// with a ten second timeout.
+func WithUpdaterHTTPClient(client *http.Client) RootUpdaterOption {
+	return func(u *RootUpdater) {
+		u.client = client
+	}
+}
+
+// WithUpdateErrorHandler calls fn with the errors of the updates of Run.
+func WithUpdateErrorHandler(fn func(err error)) RootUpdaterOption {
+	return func(u *RootUpdater) {
+		u.onError = fn
+	}
+}
+
+// NewRootUpdater returns a RootUpdater trusting the embedded Apple root until its first
+// successful update.
+func NewRootUpdater(opts ...RootUpdaterOption) *RootUpdater {
+	apple, _ := hex.DecodeString(AppleRootFingerprint)
+	u := &RootUpdater{
+		urls:         []string{AppleRootURL},
+		fingerprints: [][]byte{apple},
+		interval:     24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+	if u.client == nil {
+		u.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	u.roots, _ = appleRoots()
+	return u
+}
+
+// Roots returns the current trusted roots. The pool must not be modified.
+func (u *RootUpdater) Roots() *x509.CertPool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.roots
+}
+
+// UpdatedAt returns the time of the last successful update, zero before the first one.
+func (u *RootUpdater) UpdatedAt() time.Time {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.updatedAt
+}
+
+// Run updates the roots every interval until ctx is done, and returns the context's error.
+// Errors of the updates are reported to the error handler.
+func (u *RootUpdater) Run(ctx context.Context) error {
+	for {
+		if err := u.Update(ctx); err != nil && u.onError != nil && ctx.Err() == nil {
+			u.onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(u.interval):
+		}
+	}
+}
+
+// Update downloads the certificates of every URL and replaces the trusted roots with them.
+// It fails, keeping the previous roots, if a download fails or a certificate is not pinned
+// or has expired.
+func (u *RootUpdater) Update(ctx context.Context) error {
+	pool := x509.NewCertPool()
+	for _, url := range u.urls {
+		certs, err := u.download(ctx, url)
+		if err != nil {
+			return err
+		}
+		for _, ct := range certs {
+			if err := u.verifyCertificate(url, ct); err != nil {
+				return err
+			}
+			pool.AddCert(ct)
+		}
+	}
+
+	u.mu.Lock()
+	u.roots, u.updatedAt = pool, TimeNow()
+	u.mu.Unlock()
+	return nil
+}
+
+// download returns the certificates at url.
+func (u *RootUpdater) download(ctx context.Context, url string) ([]*x509.Certificate, error) {
+	fail := func(format string, args ...interface{}) error {
+		return utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Error downloading CA certificates from %s: %s", url, fmt.Sprintf(format, args...)))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fail("%v", err)
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fail("%v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fail("status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRootCertificateSize))
+	if err != nil {
+		return nil, fail("%v", err)
+	}
+
+	// Apple publishes PEM files, but DER is accepted as well.
+	certs, err := utils.ParseCertificates(data)
+	if err != nil {
+		return nil, fail("%v", err)
+	}
+	return certs, nil
+}
+
+// verifyCertificate checks that the certificate is pinned and has not expired.
+func (u *RootUpdater) verifyCertificate(url string, ct *x509.Certificate) error {
+	fingerprint := sha256.Sum256(ct.Raw)
+	pinned := false
+	for _, pin := range u.fingerprints {
+		pinned = pinned || bytes.Equal(fingerprint[:], pin)
+	}
+	if !pinned {
+		return utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("CA certificate %q from %s with fingerprint %s is not pinned", ct.Subject.CommonName, url, hex.EncodeToString(fingerprint[:])))
+	}
+	if now := TimeNow(); now.After(ct.NotAfter) {
+		return utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("CA certificate %q from %s expired at %s", ct.Subject.CommonName, url, ct.NotAfter.Format(time.RFC3339)))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package attestation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestRootUpdater(t *testing.T) {
+	TimeNow = time.Now
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	att, err := authority.Attest(device, testutil.AttestOptions{AppID: "TEAMID1234.com.example.app", ClientData: []byte("challenge")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal(att.JSON(), &aar); err != nil {
+		t.Fatal(err)
+	}
+
+	published := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: authority.Root.Raw})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if published == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(published)
+	}))
+	defer server.Close()
+
+	fingerprint := sha256.Sum256(authority.Root.Raw)
+	updater := NewRootUpdater(WithRootURLs(server.URL), WithRootFingerprints(fingerprint[:]), WithUpdaterHTTPClient(server.Client()))
+	v := NewVerifier(WithRootUpdater(updater))
+	if _, err := v.Verify(&aar, "TEAMID1234.com.example.app", false); err == nil {
+		t.Fatal("Expected the embedded Apple root to be trusted before the first update")
+	}
+	if err := updater.Update(context.Background()); err != nil {
+		t.Fatalf("Update failed: %+v", err)
+	}
+	if updater.UpdatedAt().IsZero() {
+		t.Fatal("Expected the update time to be recorded")
+	}
+	if _, err := v.Verify(&aar, "TEAMID1234.com.example.app", false); err != nil {
+		t.Fatalf("Not valid after the update: %+v", err)
+	}
+
+	t.Run("Failed updates keep the roots", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			published []byte
+		}{
+			{"Unpinned certificate", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: other.Root.Raw})},
+			{"No certificate", []byte("not a certificate")},
+			{"Download failure", nil},
+		}
+		for _, tt := range tests {
+			published = tt.published
+			err := updater.Update(context.Background())
+			var verr *utils.Error
+			if !errors.As(err, &verr) || verr.Type != utils.ErrAttestationCertificate.Type {
+				t.Fatalf("%s: expected a certificate error, got %+v", tt.name, err)
+			}
+			if _, err := v.Verify(&aar, "TEAMID1234.com.example.app", false); err != nil {
+				t.Fatalf("%s: expected the previous roots to be kept: %+v", tt.name, err)
+			}
+		}
+	})
+}
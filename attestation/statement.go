@@ -0,0 +1,61 @@
+package attestation
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// AppleAttestationStatement is the attestation statement of the apple-appattest format.
+type AppleAttestationStatement struct {
+	// The DER-encoded certificates of x5c, starting with the credential certificate
+	X5C [][]byte
+	// The credential certificate
+	Certificate *x509.Certificate
+	// The other certificates of x5c, i.e. Apple's intermediate certificate
+	Intermediates []*x509.Certificate
+	// The receipt to use with Apple's fraud metric service
+	Receipt []byte
+}
+
+// Unmarshal reads the statement from the decoded attStmt CBOR map and parses its certificates.
+func (s *AppleAttestationStatement) Unmarshal(attStmt map[string]interface{}) error {
+	x5c, err := certificateChain(attStmt)
+	if err != nil {
+		return err
+	}
+	receipt, ok := attStmt["receipt"].([]byte)
+	if !ok {
+		return utils.ErrAttestationFormat.WithDetails("Error retreiving receipt value")
+	}
+
+	certs := make([]*x509.Certificate, 0, len(x5c))
+	for _, cb := range x5c {
+		ct, err := x509.ParseCertificate(cb)
+		if err != nil {
+			return utils.ErrAttestationCertificate.WithDetails(fmt.Sprintf("Error parsing certificate from ASN.1 data: %+v", err))
+		}
+		certs = append(certs, ct)
+	}
+
+	*s = AppleAttestationStatement{
+		X5C:           x5c,
+		Certificate:   certs[0],
+		Intermediates: certs[1:],
+		Receipt:       receipt,
+	}
+	return nil
+}
+
+// AppleStatement returns the typed attestation statement of an apple-appattest attestation object.
+func (a *AttestationObject) AppleStatement() (*AppleAttestationStatement, error) {
+	if a.Format != attestationKey {
+		return nil, utils.ErrAttestationFormat.WithDetails(fmt.Sprintf("Wrong attestation format unsupported: %s", a.Format))
+	}
+	var s AppleAttestationStatement
+	if err := s.Unmarshal(a.AttStatement); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,52 @@
+package attestation
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAppleAttestationStatement(t *testing.T) {
+	aar := AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal([]byte(attestation), &aar); err != nil {
+		t.Fatal(err)
+	}
+	a, err := ParseAttestationObject(aar.AttestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := a.AppleStatement()
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if len(s.X5C) != 2 || len(s.Intermediates) != 1 {
+		t.Fatalf("Expected a credential and an intermediate certificate, got %d certificates", len(s.X5C))
+	}
+	if s.Intermediates[0].Subject.CommonName != AppleIntermediateCN {
+		t.Fatalf("Wrong intermediate: %s", s.Intermediates[0].Subject.CommonName)
+	}
+	if s.Certificate.SerialNumber.Text(16) != "178d4f4dc06" {
+		t.Fatalf("Wrong credential certificate: %s", s.Certificate.SerialNumber.Text(16))
+	}
+	if len(s.Receipt) == 0 {
+		t.Fatal("Missing receipt")
+	}
+
+	t.Run("Missing receipt", func(t *testing.T) {
+		var s AppleAttestationStatement
+		if err := s.Unmarshal(map[string]interface{}{"x5c": a.AttStatement["x5c"]}); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("Invalid certificate", func(t *testing.T) {
+		var s AppleAttestationStatement
+		attStmt := map[string]interface{}{
+			"x5c":     []interface{}{[]byte("not a certificate")},
+			"receipt": []byte("receipt"),
+		}
+		if err := s.Unmarshal(attStmt); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+}
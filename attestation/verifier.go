@@ -0,0 +1,840 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/receipt"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Names of the verification steps reported to a StepFunc, in the order they run.
+const (
+	StepParse       = "parse"
+	StepFormat      = "format"
+	StepKeyID       = "key_id"
+	StepAuthData    = "auth_data"
+	StepCertificate = "certificate"
+	StepRevocation  = "revocation"
+	StepFreshness   = "freshness"
+	StepNonce       = "nonce"
+	StepPublicKey   = "public_key"
+	StepReceipt     = "receipt"
+	StepKeyUnique   = "key_unique"
+)
+
+// StepFunc is invoked after each verification step. The detail is the error message
+// when the step failed and empty otherwise.
+type StepFunc func(step string, ok bool, detail string)
+
+// AttestationResult holds the data extracted from a successfully verified attestation, which
+// is everything needed to verify later assertions.
+type AttestationResult struct {
+	// The key identifier, i.e. the SHA256 hash of the public key. It marshals to URL-safe
+	// base64 so it can be echoed to the app in a JSON response.
+	KeyID utils.URLEncodedBase64 `json:"keyID"`
+	// The x963-encoded public key of the attested credential
+	PublicKey []byte `json:"publicKey"`
+	// The parsed public key of the attested credential
+	Key *ecdsa.PublicKey `json:"-"`
+	// The receipt to use with Apple's fraud metric service
+	Receipt []byte `json:"receipt"`
+	// The COSE algorithm of the attested public key
+	Algorithm authenticator.COSEAlgorithmIdentifier `json:"alg"`
+	// The environment the attestation was created in
+	Environment authenticator.Environment `json:"environment"`
+	// The counter of the authenticator data, which is 0 for attestations
+	Counter uint32 `json:"counter"`
+	// The App ID the attestation was created for
+	AppID string `json:"appID"`
+	// The credential certificate
+	Certificate *x509.Certificate `json:"-"`
+	// Details of the credential certificate, e.g. to store for audits
+	CertificateInfo CertificateInfo `json:"certificate"`
+	// Hints about the device from the credential certificate, such as its OS version. Nil if
+	// they cannot be parsed.
+	Device *DeviceInfo `json:"device,omitempty"`
+	// Checks that could not be completed but were tolerated, such as an OCSP status that
+	// was unavailable with RevocationSoftFail
+	Warnings []string `json:"warnings,omitempty"`
+	// Whether the attestation was verified against the roots of a test CA instead of Apple's,
+	// see WithTestRootCAs. Such results must not be trusted in production.
+	TestRoots bool `json:"testRoots,omitempty"`
+	// When the attestation was verified, by the clock of the verifier
+	VerifiedAt time.Time `json:"verifiedAt"`
+}
+
+// CertificateInfo describes the credential certificate of an attestation.
+type CertificateInfo struct {
+	// Hex encoded serial number, like Event.LeafSerial
+	SerialNumber string    `json:"serialNumber"`
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+}
+
+// newCertificateInfo returns the details of the certificate.
+func newCertificateInfo(ct *x509.Certificate) CertificateInfo {
+	info := CertificateInfo{
+		Subject:   ct.Subject.String(),
+		Issuer:    ct.Issuer.String(),
+		NotBefore: ct.NotBefore,
+		NotAfter:  ct.NotAfter,
+	}
+	if ct.SerialNumber != nil {
+		info.SerialNumber = ct.SerialNumber.Text(16)
+	}
+	return info
+}
+
+// VerificationResult is the former name of AttestationResult.
+//
+// Deprecated: Use AttestationResult.
+type VerificationResult = AttestationResult
+
+// Verifier verifies attestations. The zero value is ready to use.
+type Verifier struct {
+	onStep StepFunc
+	events *eventSink
+	maxAge time.Duration
+	chain  ChainVerifier
+	// Roots of the X509ChainVerifier, the embedded Apple root if nil
+	roots *x509.CertPool
+	// Whether roots are the roots of a test CA, see WithTestRootCAs
+	testRoots bool
+	// Provides the roots when set, see WithRootUpdater
+	rootUpdater *RootUpdater
+	// Known intermediates of the X509ChainVerifier, see WithIntermediates
+	intermediates []*x509.Certificate
+	// Required common name of the intermediate certificate, if not empty
+	intermediateCN string
+	// SPKI hashes of which the intermediate certificate must match one, if not empty
+	intermediatePins [][]byte
+	environment      authenticator.Environment
+	allowedAlgs      []authenticator.COSEAlgorithmIdentifier
+	clockSkew        time.Duration
+	clock            utils.Clock
+	blockedKeys      []crypto.PublicKey
+	acceptedEnvs     []authenticator.Environment
+	aiaFetch         bool
+	// Fetcher using the HTTP client of WithHTTPClient, if set
+	aiaFetcher *AIAFetcher
+	// Whether checks that need network access are disabled
+	offline bool
+	// Store of registered credentials whose key IDs are rejected, unless allowRegistered is set
+	registry        CredentialLoader
+	allowRegistered bool
+	// How to check the revocation status of the chain, not at all if empty
+	revocation       RevocationMethod
+	revocationPolicy RevocationPolicy
+	stapledOCSP      [][]byte
+	crls             []*x509.RevocationList
+	// Checkers using the HTTP client of WithHTTPClient, if set
+	ocspChecker *ocspChecker
+	crlChecker  *crlChecker
+	// Whether to tighten the basic constraints checks of an X509ChainVerifier
+	strictBasicConstraints bool
+	// Verifies the receipt, if set
+	receipts *receipt.Verifier
+}
+
+// Option configures a Verifier.
+type Option func(*Verifier)
+
+// NewVerifier returns a Verifier configured with the given options.
+func NewVerifier(opts ...Option) *Verifier {
+	v := &Verifier{chain: X509ChainVerifier{}}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// WithOnStep registers a callback invoked after each verification step, which is
+// useful for tests and instrumentation. Verification stops at the first failing step.
+func WithOnStep(fn StepFunc) Option {
+	return func(v *Verifier) {
+		v.onStep = fn
+	}
+}
+
+// WithChainVerifier replaces the verification of the x5c certificate chain, which defaults
+// to an X509ChainVerifier using the embedded Apple root certificate.
+func WithChainVerifier(cv ChainVerifier) Option {
+	return func(v *Verifier) {
+		v.chain = cv
+	}
+}
+
+// WithRootCAs trusts the given roots instead of the embedded Apple App Attestation root
+// certificate, e.g. Apple's roots distributed to air-gapped deployments. It only applies to
+// the X509ChainVerifier and does not replace roots set on it. utils.ParseRootCAs reads the
+// roots from PEM bundles or DER certificates. See WithTestRootCAs for the roots of a test CA.
+func WithRootCAs(roots *x509.CertPool) Option {
+	return func(v *Verifier) {
+		v.roots, v.testRoots, v.rootUpdater = roots, false, nil
+	}
+}
+
+// WithIntermediates provides known intermediate certificates to build chains with when x5c
+// lacks them, e.g. because a proxy stripped x5c down to the credential certificate. Without
+// certificates the embedded Apple intermediate, AppleIntermediateCertificate, is used. It only
+// applies to the X509ChainVerifier. Unlike WithAIAFetch it needs no network access.
+func WithIntermediates(certs ...*x509.Certificate) Option {
+	return func(v *Verifier) {
+		if len(certs) == 0 {
+			if apple, err := AppleIntermediateCertificate(); err == nil {
+				certs = []*x509.Certificate{apple}
+			}
+		}
+		v.intermediates = certs
+	}
+}
+
+// WithRootUpdater trusts the current roots of the updater, replaced as it downloads Apple's
+// published CA certificates, instead of the embedded Apple root. It only applies to the
+// X509ChainVerifier and does not replace roots set on it. The updater must be run separately,
+// see RootUpdater.Run.
+func WithRootUpdater(u *RootUpdater) Option {
+	return func(v *Verifier) {
+		v.roots, v.testRoots, v.rootUpdater = nil, false, u
+	}
+}
+
+// WithTestRootCAs trusts the roots of a test CA instead of the embedded Apple root, so that
+// synthetic attestations, e.g. of a testutil.Authority, verify in end-to-end tests without
+// real devices. Such attestations prove nothing about a device: the results of the verifier
+// are marked with TestRoots and a warning, whatever the environment of the attestation.
+func WithTestRootCAs(roots *x509.CertPool) Option {
+	return func(v *Verifier) {
+		v.roots, v.testRoots, v.rootUpdater = roots, true, nil
+	}
+}
+
+// WithRequiredIntermediateCN requires the intermediate certificate of the verified chain to
+// have the given subject common name. An empty cn requires AppleIntermediateCN.
+//
+// Apple may rotate the intermediate certificate and issue new ones under a different name
+// without notice. Pinning the name makes verification fail for all devices from then on,
+// so keep the configured value in sync with Apple's published certificates.
+func WithRequiredIntermediateCN(cn string) Option {
+	return func(v *Verifier) {
+		if cn == "" {
+			cn = AppleIntermediateCN
+		}
+		v.intermediateCN = cn
+	}
+}
+
+// WithPinnedIntermediates additionally requires the intermediate certificate of the verified
+// chain to have one of the given SPKIHash values, so that an unexpected intermediate is
+// rejected even if it chains to a trusted root. Without hashes the intermediate is pinned to
+// AppleIntermediateSPKIHash.
+//
+// Like WithRequiredIntermediateCN, pinning makes verification fail for all devices once
+// Apple rotates the intermediate certificate, so keep the pins in sync with Apple's
+// published certificates.
+func WithPinnedIntermediates(hashes ...[]byte) Option {
+	return func(v *Verifier) {
+		if len(hashes) == 0 {
+			apple, _ := base64.StdEncoding.DecodeString(AppleIntermediateSPKIHash)
+			hashes = [][]byte{apple}
+		}
+		v.intermediatePins = hashes
+	}
+}
+
+// WithStrictBasicConstraints enables X509ChainVerifier.StrictBasicConstraints. It only
+// applies to the X509ChainVerifier.
+func WithStrictBasicConstraints() Option {
+	return func(v *Verifier) {
+		v.strictBasicConstraints = true
+	}
+}
+
+// WithOffline disables the checks that need network access, like Policy.Offline: chains are
+// not completed with AIA fetches and the revocation status is only checked against stapled
+// OCSP responses and preloaded CRLs.
+func WithOffline(enabled bool) Option {
+	return func(v *Verifier) {
+		v.offline = enabled
+	}
+}
+
+// CredentialLoader looks up registered credentials, such as a MemoryCredentialStore.
+type CredentialLoader interface {
+	// Load returns the credential with the given key ID, or nil if there is none.
+	Load(ctx context.Context, keyID []byte) (*Credential, error)
+}
+
+// WithUniqueKeyIDs rejects attestations for key IDs that already have a credential in the
+// store, so a stolen attestation cannot be registered again under another account. A Policy
+// with AllowRegisteredKey set skips the check.
+func WithUniqueKeyIDs(store CredentialLoader) Option {
+	return func(v *Verifier) {
+		v.registry = store
+	}
+}
+
+// WithBlockedKeys rejects attestations of any of the given public keys, such as keys
+// published in documentation or sample code, or keys known to be compromised.
+func WithBlockedKeys(keys []crypto.PublicKey) Option {
+	return func(v *Verifier) {
+		v.blockedKeys = keys
+	}
+}
+
+// isBlocked reports whether the public key is one of the blocked keys.
+func (v *Verifier) isBlocked(pub crypto.PublicKey) bool {
+	key, ok := pub.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return false
+	}
+	for _, blocked := range v.blockedKeys {
+		if key.Equal(blocked) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithMaxAttestationAge rejects attestations whose credential certificate was issued more
+// than d before the current time. Apple issues the certificate when the attestation is
+// created, so this limits how long an attestation can be replayed for registration.
+// The check is disabled when d is zero, which is the default.
+func WithMaxAttestationAge(d time.Duration) Option {
+	return func(v *Verifier) {
+		v.maxAge = d
+	}
+}
+
+// WithReceiptVerification verifies the receipt of attestations with rv at the receipt step:
+// its signature must chain to a trusted root, it must not have expired and it must be an
+// attestation receipt for the attested App ID. The receipt is verified at the time of the
+// verifier's clock and within its clock skew, see WithClock and WithClockSkew.
+func WithReceiptVerification(rv *receipt.Verifier) Option {
+	return func(v *Verifier) {
+		v.receipts = rv
+	}
+}
+
+// verifyReceipt verifies the receipt of an attestation for the App ID.
+func (v *Verifier) verifyReceipt(data []byte, appID string) error {
+	r, err := v.receipts.VerifyAt(data, v.now(), v.clockSkew)
+	if err != nil {
+		return err
+	}
+	if r.Type() != receipt.TypeAttest {
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Receipt type %q is not %s", r.Type(), receipt.TypeAttest))
+	}
+	if r.AppID() != appID {
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Receipt was issued for App ID %q, not %q", r.AppID(), appID))
+	}
+	return nil
+}
+
+// VerifyAttestation verifies a CBOR-encoded attestation object using a default Verifier. See
+// Verifier.VerifyAttestation.
+func VerifyAttestation(attestationObject, keyID, clientDataHash []byte, appID string, production bool) (*AttestationResult, error) {
+	return NewVerifier().VerifyAttestation(attestationObject, keyID, clientDataHash, appID, production)
+}
+
+// VerifyAttestationBase64 verifies a base64-encoded attestation object using a default
+// Verifier. See Verifier.VerifyAttestationBase64.
+func VerifyAttestationBase64(attestationObject, keyID string, clientDataHash []byte, appID string, production bool) (*AttestationResult, error) {
+	return NewVerifier().VerifyAttestationBase64(attestationObject, keyID, clientDataHash, appID, production)
+}
+
+// VerifyAttestationReader verifies a JSON-encoded attestation read from r using a default
+// Verifier. See Verifier.VerifyReader.
+func VerifyAttestationReader(r io.Reader, maxSize int64, appID string, production bool) (*AttestationResult, error) {
+	return NewVerifier().VerifyReader(r, maxSize, appID, production)
+}
+
+// Verify verifies the attestation for the given App ID.
+func (v *Verifier) Verify(aar *AuthenticatorAttestationResponse, appID string, production bool) (*AttestationResult, error) {
+	return v.VerifyContext(context.Background(), aar, appID, production)
+}
+
+// VerifyContext is like Verify. The context bounds network requests and store lookups made
+// during verification, and verification stops with the context's error once it is done.
+func (v *Verifier) VerifyContext(ctx context.Context, aar *AuthenticatorAttestationResponse, appID string, production bool) (*AttestationResult, error) {
+	env := authenticator.EnvironmentOf(production)
+	result, leaf, err := v.verify(ctx, newAttestationInput(aar), newAppIDSet(appID), env)
+	v.emit(aar.KeyID, appID, env, leaf, err)
+	return result, err
+}
+
+// VerifyAppIDs verifies the attestation for any of the given App IDs, e.g. of an app, its App
+// Clip and its extensions. The matching App ID is reported in the result.
+func (v *Verifier) VerifyAppIDs(aar *AuthenticatorAttestationResponse, appIDs []string, production bool) (*AttestationResult, error) {
+	return v.VerifyAppIDsContext(context.Background(), aar, appIDs, production)
+}
+
+// VerifyAppIDsContext is like VerifyAppIDs, see VerifyContext.
+func (v *Verifier) VerifyAppIDsContext(ctx context.Context, aar *AuthenticatorAttestationResponse, appIDs []string, production bool) (*AttestationResult, error) {
+	env := authenticator.EnvironmentOf(production)
+	set := newAppIDSet(appIDs...)
+	result, leaf, err := v.verify(ctx, newAttestationInput(aar), set, env)
+	var appID string
+	if result != nil {
+		appID = result.AppID
+	} else if len(set.appIDs) > 0 {
+		appID = set.appIDs[0]
+	}
+	v.emit(aar.KeyID, appID, env, leaf, err)
+	return result, err
+}
+
+// VerifyReader decodes an AuthenticatorAttestationResponse from the JSON read from r, such as
+// a request body, and verifies it for the given App ID. Input larger than maxSize bytes is
+// rejected without being read completely.
+func (v *Verifier) VerifyReader(r io.Reader, maxSize int64, appID string, production bool) (*AttestationResult, error) {
+	return v.VerifyReaderContext(context.Background(), r, maxSize, appID, production)
+}
+
+// VerifyReaderContext is like VerifyReader, see VerifyContext.
+func (v *Verifier) VerifyReaderContext(ctx context.Context, r io.Reader, maxSize int64, appID string, production bool) (*AttestationResult, error) {
+	data, err := utils.ReadLimited(r, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	var aar AuthenticatorAttestationResponse
+	if err := json.Unmarshal(data, &aar); err != nil {
+		return nil, utils.ErrBadRequest.WithDetails(err.Error())
+	}
+	return v.VerifyContext(ctx, &aar, appID, production)
+}
+
+// VerifyPolicy verifies the attestation for any of the App IDs of the policy, with the checks
+// and tolerances of the policy.
+func (v *Verifier) VerifyPolicy(aar *AuthenticatorAttestationResponse, policy *Policy) (*AttestationResult, error) {
+	return v.VerifyPolicyContext(context.Background(), aar, policy)
+}
+
+// VerifyPolicyContext is like VerifyPolicy, see VerifyContext.
+func (v *Verifier) VerifyPolicyContext(ctx context.Context, aar *AuthenticatorAttestationResponse, policy *Policy) (*AttestationResult, error) {
+	env := authenticator.EnvironmentOf(policy.Production)
+	result, leaf, err := v.withPolicy(policy).verify(ctx, newAttestationInput(aar), newAppIDSet(policy.appIDs()...), env)
+	v.emit(aar.KeyID, policy.AppID, env, leaf, err)
+	return result, err
+}
+
+// VerifyAttestation runs the full verification of Apple's "Validating Apps That Connect to
+// Your Server" on a CBOR-encoded attestation object: the certificate chain, the nonce, the
+// key ID, the RP ID hash, the counter, the AAGUID and the credential ID. The keyID is the
+// decoded key identifier and clientDataHash the SHA256 hash of the client data the app
+// passed to attestKey.
+func (v *Verifier) VerifyAttestation(attestationObject, keyID, clientDataHash []byte, appID string, production bool) (*AttestationResult, error) {
+	return v.VerifyAttestationContext(context.Background(), attestationObject, keyID, clientDataHash, appID, production)
+}
+
+// VerifyAttestationContext is like VerifyAttestation, see VerifyContext.
+func (v *Verifier) VerifyAttestationContext(ctx context.Context, attestationObject, keyID, clientDataHash []byte, appID string, production bool) (*AttestationResult, error) {
+	in := attestationInput{object: attestationObject, keyID: keyID, clientDataHash: clientDataHash}
+	if len(keyID) == 0 {
+		in.keyIDErr = utils.ErrParsingData.WithDetails("Missing key ID")
+	}
+	env := authenticator.EnvironmentOf(production)
+	result, leaf, err := v.verify(ctx, in, newAppIDSet(appID), env)
+	v.emit(base64.StdEncoding.EncodeToString(keyID), appID, env, leaf, err)
+	return result, err
+}
+
+// VerifyDetect verifies the attestation for the given App ID in the environment indicated by
+// its AAGUID, which is reported in the result. The environment must be one of those accepted
+// with WithAcceptedEnvironments or WithEnvironment; if neither is configured only production
+// attestations are accepted.
+func (v *Verifier) VerifyDetect(aar *AuthenticatorAttestationResponse, appID string) (*AttestationResult, error) {
+	return v.VerifyDetectContext(context.Background(), aar, appID)
+}
+
+// VerifyDetectContext is like VerifyDetect, see VerifyContext.
+func (v *Verifier) VerifyDetectContext(ctx context.Context, aar *AuthenticatorAttestationResponse, appID string) (*AttestationResult, error) {
+	result, leaf, err := v.verify(ctx, newAttestationInput(aar), newAppIDSet(appID), "")
+	var env authenticator.Environment
+	if result != nil {
+		env = result.Environment
+	}
+	v.emit(aar.KeyID, appID, env, leaf, err)
+	return result, err
+}
+
+// VerifyAttestationBase64 verifies the attestation like VerifyAttestation, taking the
+// attestation object and key ID as sent by the app. Both may use standard or URL-safe
+// base64, with or without padding.
+func (v *Verifier) VerifyAttestationBase64(attestationObject, keyID string, clientDataHash []byte, appID string, production bool) (*AttestationResult, error) {
+	return v.VerifyAttestationBase64Context(context.Background(), attestationObject, keyID, clientDataHash, appID, production)
+}
+
+// VerifyAttestationBase64Context is like VerifyAttestationBase64, see VerifyContext.
+func (v *Verifier) VerifyAttestationBase64Context(ctx context.Context, attestationObject, keyID string, clientDataHash []byte, appID string, production bool) (*AttestationResult, error) {
+	object, err := utils.DecodeBase64(attestationObject)
+	if err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("The attestation object was not valid base64: %v", err))
+	}
+	keyIDData, err := utils.DecodeKeyID(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return v.VerifyAttestationContext(ctx, object, keyIDData, clientDataHash, appID, production)
+}
+
+// withPolicy returns a copy of the verifier with the settings of the policy applied.
+func (v *Verifier) withPolicy(policy *Policy) *Verifier {
+	pv := *v
+	if policy.Offline {
+		pv.offline = true
+	}
+	if policy.AllowRegisteredKey || policy.AllowReattestation {
+		pv.allowRegistered = true
+	}
+	if policy.ClockSkew > 0 {
+		pv.clockSkew = policy.ClockSkew
+	}
+	if policy.Revocation != "" {
+		pv.revocation = policy.Revocation
+	}
+	if policy.MaxAttestationAge > 0 {
+		pv.maxAge = policy.MaxAttestationAge
+	}
+	return &pv
+}
+
+// attestationInput holds the data to verify an attestation. An error decoding the key ID is
+// reported at the key ID step.
+type attestationInput struct {
+	object         []byte
+	keyID          []byte
+	keyIDErr       error
+	clientDataHash []byte
+}
+
+// newAttestationInput decodes the key ID of the response and hashes its client data.
+func newAttestationInput(aar *AuthenticatorAttestationResponse) attestationInput {
+	// Compute clientDataHash as the SHA256 hash of clientData.
+	clientDataHash := sha256.Sum256(aar.ClientData)
+	in := attestationInput{object: aar.AttestationObject, clientDataHash: clientDataHash[:]}
+
+	// Decode the key ID
+	keyID, err := base64.StdEncoding.DecodeString(aar.KeyID)
+	if err != nil {
+		in.keyIDErr = utils.ErrParsingData.WithDetails(fmt.Sprintf("The KeyID was not valid base64: %s", aar.KeyID))
+	}
+	in.keyID = keyID
+	return in
+}
+
+// verify runs the verification steps, accepting any of the given App IDs. The environment
+// is detected from the AAGUID if env is empty. An already done ctx fails verification before
+// the first step. The credential certificate is returned whenever
+// it could be parsed, even if verification failed.
+func (v *Verifier) verify(ctx context.Context, in attestationInput, appIDs appIDSet, env authenticator.Environment) (*AttestationResult, *x509.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	a, err := ParseAttestationObject(in.object)
+	if err = v.step(StepParse, err); err != nil {
+		return nil, nil, err
+	}
+
+	// Check if we have the right format.
+	if a.Format != attestationKey {
+		err = utils.ErrAttestationFormat.WithDetails(fmt.Sprintf("Wrong attestation format unsupported: %s", a.Format))
+	}
+	if err = v.step(StepFormat, err); err != nil {
+		return nil, nil, err
+	}
+
+	keyIdData := in.keyID
+	if err = v.step(StepKeyID, in.keyIDErr); err != nil {
+		return nil, nil, err
+	}
+
+	// Handle Steps 6 through 9
+	// 6. Compute the SHA256 hash of your app’s App ID
+	detect := env == ""
+	if detect {
+		env, err = a.AuthData.AttData.Environment()
+	}
+	if err == nil && !v.acceptsEnvironment(env, detect) {
+		err = utils.ErrVerification.WithDetails(fmt.Sprintf("Verifier does not accept the %s environment", env))
+	}
+	if err == nil && len(appIDs.hashes) == 0 {
+		err = utils.ErrVerification.WithDetails("No App ID to verify against")
+	}
+	matched := -1
+	if err == nil {
+		// Verify against the first App ID if none matches so the mismatch is reported.
+		matched = appIDs.match(a.AuthData.RPIDHash)
+		err = a.AuthData.Verify(appIDs.hashes[max(matched, 0)], keyIdData, env == authenticator.EnvironmentProduction)
+	}
+	if err = v.step(StepAuthData, err); err != nil {
+		return nil, nil, err
+	}
+
+	// Handle step 1 through 5
+	var credCert *x509.Certificate
+	chain, err := verifyCertificateChain(ctx, a, v.chainVerifier(), v.now(), v.clockSkew)
+	if len(chain) > 0 {
+		credCert = chain[0]
+	}
+	if err == nil && v.intermediateCN != "" {
+		err = verifyIntermediateCN(chain, v.intermediateCN)
+	}
+	if err == nil {
+		err = verifySignatureAlgorithms(chain)
+	}
+	if err == nil && len(v.intermediatePins) > 0 {
+		err = verifyIntermediatePin(chain, v.intermediatePins)
+	}
+	if err = v.step(StepCertificate, err); err != nil {
+		return nil, credCert, err
+	}
+
+	var warnings []string
+	if v.testRoots {
+		warnings = append(warnings, "Attestation was verified against the roots of a test CA, not Apple's")
+	}
+	if v.revocation != "" && v.revocation != RevocationNone {
+		var revocationWarnings []string
+		revocationWarnings, err = v.checkRevocation(ctx, chain)
+		if err = v.step(StepRevocation, err); err != nil {
+			return nil, credCert, err
+		}
+		warnings = append(warnings, revocationWarnings...)
+	}
+
+	if v.maxAge > 0 {
+		age := v.now().Sub(credCert.NotBefore)
+		if age > v.maxAge {
+			err = utils.ErrAttestationStale.WithDetails(fmt.Sprintf("Attestation is %s old, the maximum age is %s", age, v.maxAge))
+		}
+		if err = v.step(StepFreshness, err); err != nil {
+			return nil, credCert, err
+		}
+	}
+
+	err = VerifyNonce(credCert, a.RawAuthData, in.clientDataHash)
+	if err = v.step(StepNonce, err); err != nil {
+		return nil, credCert, err
+	}
+
+	publicKey, err := VerifyKeyID(credCert, keyIdData)
+	var alg authenticator.COSEAlgorithmIdentifier
+	if err == nil {
+		alg, err = a.AuthData.AttData.Algorithm()
+	}
+	if err == nil {
+		err = v.verifyKeyAlgorithm(credCert.PublicKey.(*ecdsa.PublicKey), alg)
+	}
+	if err == nil && v.isBlocked(credCert.PublicKey) {
+		err = utils.ErrBlockedKey.WithDetails("The attested public key is blocked")
+	}
+	if err = v.step(StepPublicKey, err); err != nil {
+		return nil, credCert, err
+	}
+
+	receipt, receiptPresent := a.AttStatement["receipt"].([]byte)
+	if !receiptPresent {
+		err = utils.ErrAttestationFormat.WithDetails("Error retreiving receipt value")
+	} else if v.receipts != nil {
+		err = v.verifyReceipt(receipt, appIDs.appIDs[matched])
+	}
+	if err = v.step(StepReceipt, err); err != nil {
+		return nil, credCert, err
+	}
+
+	// The check runs last so that unverified attestations cannot probe for registered keys.
+	if v.registry != nil && !v.allowRegistered {
+		var existing *Credential
+		existing, err = v.registry.Load(ctx, keyIdData)
+		if err == nil && existing != nil {
+			err = utils.ErrKeyRegistered.WithDetails("A credential is already registered for the key ID")
+		}
+		if err = v.step(StepKeyUnique, err); err != nil {
+			return nil, credCert, err
+		}
+	}
+
+	// The device hints are not verified by Apple's documented steps, so an extension that
+	// cannot be parsed only leaves them out.
+	device, deviceErr := ParseDeviceInfo(credCert)
+	if deviceErr != nil {
+		warnings = append(warnings, deviceErr.Error())
+	}
+
+	return &AttestationResult{
+		KeyID:           keyIdData,
+		PublicKey:       publicKey,
+		Key:             credCert.PublicKey.(*ecdsa.PublicKey),
+		Receipt:         receipt,
+		Algorithm:       alg,
+		Environment:     env,
+		AppID:           appIDs.appIDs[matched],
+		Counter:         a.AuthData.Counter,
+		Certificate:     credCert,
+		CertificateInfo: newCertificateInfo(credCert),
+		Device:          device,
+		Warnings:        warnings,
+		TestRoots:       v.testRoots,
+		VerifiedAt:      v.now(),
+	}, credCert, nil
+}
+
+// appIDSet holds App IDs and their SHA256 hashes, which are the RP ID hashes to accept.
+type appIDSet struct {
+	appIDs []string
+	hashes [][]byte
+}
+
+// newAppIDSet hashes the App IDs, skipping empty and duplicate ones.
+func newAppIDSet(appIDs ...string) appIDSet {
+	var set appIDSet
+	seen := make(map[string]bool, len(appIDs))
+	for _, appID := range appIDs {
+		if appID == "" || seen[appID] {
+			continue
+		}
+		seen[appID] = true
+		hash := sha256.Sum256([]byte(appID))
+		set.appIDs = append(set.appIDs, appID)
+		set.hashes = append(set.hashes, hash[:])
+	}
+	return set
+}
+
+// match returns the index of the App ID whose hash equals the RP ID hash, or -1.
+func (s appIDSet) match(rpIDHash []byte) int {
+	for i, hash := range s.hashes {
+		if bytes.Equal(hash, rpIDHash) {
+			return i
+		}
+	}
+	return -1
+}
+
+// acceptsEnvironment reports whether attestations for env are accepted. Without configured
+// environments, any environment is accepted when the caller chose it and only production
+// when it was detected.
+func (v *Verifier) acceptsEnvironment(env authenticator.Environment, detected bool) bool {
+	switch {
+	case len(v.acceptedEnvs) > 0:
+		for _, accepted := range v.acceptedEnvs {
+			if env == accepted {
+				return true
+			}
+		}
+		return false
+	case v.environment != "":
+		return env == v.environment
+	case detected:
+		return env == authenticator.EnvironmentProduction
+	default:
+		return true
+	}
+}
+
+// chainVerifier returns the configured ChainVerifier, applying the options that tune an
+// X509ChainVerifier.
+func (v *Verifier) chainVerifier() ChainVerifier {
+	chainVerifier := v.chain
+	if chainVerifier == nil {
+		chainVerifier = X509ChainVerifier{}
+	}
+	if x509Verifier, ok := chainVerifier.(X509ChainVerifier); ok {
+		if v.aiaFetch && x509Verifier.AIA == nil {
+			x509Verifier.AIA = defaultAIAFetcher
+			if v.aiaFetcher != nil {
+				x509Verifier.AIA = v.aiaFetcher
+			}
+		}
+		if v.offline {
+			x509Verifier.AIA = nil
+		}
+		if v.strictBasicConstraints {
+			x509Verifier.StrictBasicConstraints = true
+		}
+		if x509Verifier.Roots == nil {
+			x509Verifier.Roots = v.roots
+		}
+		if x509Verifier.Roots == nil && v.rootUpdater != nil {
+			x509Verifier.Roots = v.rootUpdater.Roots()
+		}
+		if len(v.intermediates) > 0 {
+			x509Verifier.Intermediates = append(slices.Clip(x509Verifier.Intermediates), v.intermediates...)
+		}
+		chainVerifier = x509Verifier
+	}
+	return chainVerifier
+}
+
+// algorithmCurves are the curves of the keys of the COSE algorithms.
+var algorithmCurves = map[authenticator.COSEAlgorithmIdentifier]elliptic.Curve{
+	authenticator.AlgES256: elliptic.P256(),
+	authenticator.AlgES384: elliptic.P384(),
+	authenticator.AlgES512: elliptic.P521(),
+}
+
+// verifyKeyAlgorithm checks that the COSE algorithm of the attested key is allowed, ES256
+// unless WithAllowedAlgorithms says otherwise, and that the key is on its curve.
+func (v *Verifier) verifyKeyAlgorithm(pub *ecdsa.PublicKey, alg authenticator.COSEAlgorithmIdentifier) error {
+	allowed := v.allowedAlgs
+	if len(allowed) == 0 {
+		allowed = []authenticator.COSEAlgorithmIdentifier{authenticator.AlgES256}
+	}
+	if !containsAlgorithm(allowed, alg) {
+		return utils.ErrUnsupportedAlgorithm.WithDetails(fmt.Sprintf("Public key algorithm %d is not allowed", alg))
+	}
+	curve, ok := algorithmCurves[alg]
+	if !ok {
+		return utils.ErrUnsupportedAlgorithm.WithDetails(fmt.Sprintf("Public key algorithm %d is not an ECDSA algorithm", alg))
+	}
+	if pub.Curve != curve {
+		return utils.ErrUnsupportedAlgorithm.WithDetails(fmt.Sprintf("Public key is on curve %s, algorithm %d uses %s", pub.Curve.Params().Name, alg, curve.Params().Name))
+	}
+	return nil
+}
+
+func containsAlgorithm(algs []authenticator.COSEAlgorithmIdentifier, alg authenticator.COSEAlgorithmIdentifier) bool {
+	for _, a := range algs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// now returns the time of the verifier's clock.
+func (v *Verifier) now() time.Time {
+	if v.clock != nil {
+		return v.clock.Now()
+	}
+	return TimeNow()
+}
+
+// step reports the outcome of a verification step and passes the error through.
+func (v *Verifier) step(name string, err error) error {
+	if v.onStep == nil {
+		return err
+	}
+	if err != nil {
+		v.onStep(name, false, err.Error())
+	} else {
+		v.onStep(name, true, "")
+	}
+	return err
+}
@@ -2,11 +2,13 @@ package authenticator
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 
+	"github.com/jyrodrigues/appattest/cose"
 	"github.com/jyrodrigues/appattest/utils"
-	"github.com/ugorji/go/codec"
 )
 
 var minAuthDataLength = 37
@@ -48,6 +50,73 @@ type AttestedCredentialData struct {
 	CredentialPublicKey []byte `json:"public_key"`
 }
 
+// Environment is the App Attest environment an attestation was created in, as indicated by the AAGUID.
+type Environment string
+
+const (
+	// EnvironmentDevelopment Attestations from development builds, with AAGUID appattestdevelop
+	EnvironmentDevelopment Environment = "development"
+	// EnvironmentProduction Attestations from production builds, with AAGUID appattest followed by seven 0x00 bytes
+	EnvironmentProduction Environment = "production"
+)
+
+// EnvironmentOf returns the environment for the production flag used by the verifiers.
+func EnvironmentOf(production bool) Environment {
+	if production {
+		return EnvironmentProduction
+	}
+	return EnvironmentDevelopment
+}
+
+// Environment returns the environment indicated by the AAGUID.
+func (d AttestedCredentialData) Environment() (Environment, error) {
+	switch {
+	case bytes.Equal(d.AAGUID, []byte("appattestdevelop")):
+		return EnvironmentDevelopment, nil
+	case bytes.Equal(d.AAGUID, append([]byte("appattest"), make([]byte, 7)...)):
+		return EnvironmentProduction, nil
+	default:
+		return "", utils.ErrVerification.WithDetails(fmt.Sprintf("Unknown AAGUID %x", d.AAGUID))
+	}
+}
+
+// COSEAlgorithmIdentifier identifies a cryptographic algorithm as registered in the IANA COSE
+// Algorithms registry. See §5.8.5. https://www.w3.org/TR/webauthn/#sctn-alg-identifier
+// It is the algorithm type of the cose package.
+type COSEAlgorithmIdentifier = cose.Algorithm
+
+const (
+	// AlgES256 ECDSA with SHA-256, the algorithm used by App Attest
+	AlgES256 = cose.AlgES256
+	// AlgES384 ECDSA with SHA-384
+	AlgES384 = cose.AlgES384
+	// AlgES512 ECDSA with SHA-512
+	AlgES512 = cose.AlgES512
+)
+
+// Key parses the credential public key as a COSE key.
+func (d AttestedCredentialData) Key() (*cose.Key, error) {
+	return cose.ParseKey(d.CredentialPublicKey)
+}
+
+// PublicKey returns the credential public key as an *ecdsa.PublicKey, see cose.Key.ECDSA.
+func (d AttestedCredentialData) PublicKey() (*ecdsa.PublicKey, error) {
+	key, err := d.Key()
+	if err != nil {
+		return nil, err
+	}
+	return key.ECDSA()
+}
+
+// Algorithm returns the alg parameter of the credential public key, see Key.
+func (d AttestedCredentialData) Algorithm() (COSEAlgorithmIdentifier, error) {
+	key, err := d.Key()
+	if err != nil {
+		return 0, err
+	}
+	return key.Alg, nil
+}
+
 // AuthenticatorAttachment https://www.w3.org/TR/webauthn/#platform-attachment
 type AuthenticatorAttachment string
 
@@ -146,7 +215,16 @@ func (flag AuthenticatorFlags) HasExtensions() bool {
 // devices with limited capabilities and low power requirements, with much simpler software stacks than the client platform.
 // The authenticator data structure is a byte array of 37 bytes or more, and is laid out in this table:
 // https://www.w3.org/TR/webauthn/#table-authData
+//
+// Unmarshal follows the specification strictly. Use UnmarshalWithMode to tolerate Apple's
+// known deviations.
 func (a *AuthenticatorData) Unmarshal(rawAuthData []byte) error {
+	return a.UnmarshalWithMode(rawAuthData, CompatibilityStrict)
+}
+
+// UnmarshalWithMode unmarshals the authenticator data like Unmarshal, tolerating the
+// deviations from the specification selected by mode.
+func (a *AuthenticatorData) UnmarshalWithMode(rawAuthData []byte, mode CompatibilityMode) error {
 	if minAuthDataLength > len(rawAuthData) {
 		err := utils.ErrBadRequest.WithDetails("Authenticator data length too short")
 		info := fmt.Sprintf("Expected data greater than %d bytes. Got %d bytes\n", minAuthDataLength, len(rawAuthData))
@@ -157,39 +235,139 @@ func (a *AuthenticatorData) Unmarshal(rawAuthData []byte) error {
 	a.Flags = AuthenticatorFlags(rawAuthData[32])
 	a.Counter = binary.BigEndian.Uint32(rawAuthData[33:37])
 
-	remaining := len(rawAuthData) - minAuthDataLength
+	// Per the WebAuthn layout the attested credential data comes first, followed by the extensions.
+	// Each section must be flagged and must decode completely, so data in any other order is rejected.
+	rest := rawAuthData[minAuthDataLength:]
+
+	if a.Flags.HasAttestedCredentialData() && len(rest) == 0 && mode&CompatibilityAssertionATFlag == 0 {
+		return utils.ErrBadRequest.WithDetails("Attested credential data flag set but authenticator data has no attested credential data")
+	}
+	if a.Flags.HasAttestedCredentialData() && len(rest) > 0 {
+		n, err := a.unmarshalAttestedData(rest)
+		if err != nil {
+			return err
+		}
+		rest = rest[n:]
+	}
+
+	if a.Flags.HasExtensions() {
+		if len(rest) == 0 {
+			return utils.ErrBadRequest.WithDetails("Extension data flag set but authenticator data has no extensions")
+		}
+		n, err := cborMapLength(rest)
+		if err != nil {
+			return utils.ErrBadRequest.WithDetails(fmt.Sprintf("Extensions at offset %d are not a valid CBOR map: %v", len(rawAuthData)-len(rest), err))
+		}
+		a.ExtData = rest[:n]
+		rest = rest[n:]
+	}
+
+	if len(rest) != 0 {
+		return utils.ErrBadRequest.WithDetails(fmt.Sprintf("Leftover bytes decoding AuthenticatorData: %d unexpected bytes after the flagged sections", len(rest)))
+	}
+
+	return nil
+}
+
+// HasATFlagWithoutData reports whether the attested credential data flag is set although the
+// authenticator data carries no attested credential data, the deviation tolerated by
+// CompatibilityAssertionATFlag.
+func (a *AuthenticatorData) HasATFlagWithoutData() bool {
+	return a.Flags.HasAttestedCredentialData() && len(a.AttData.AAGUID) == 0
+}
 
-	// Apple didn't read the W3C specification properly and sets the attestedCredentialData flag, while it's not present for an assertion. We'll just look a the length...
-	if len(rawAuthData) > minAuthDataLength {
-		a.unmarshalAttestedData(rawAuthData)
-		attDataLen := len(a.AttData.AAGUID) + 2 + len(a.AttData.CredentialID) + len(a.AttData.CredentialPublicKey)
-		remaining = remaining - attDataLen
+// UnmarshalAttested unmarshals authenticator data that must carry attested credential data,
+// such as the authenticator data of an attestation.
+func (a *AuthenticatorData) UnmarshalAttested(rawAuthData []byte) error {
+	if err := a.Unmarshal(rawAuthData); err != nil {
+		return err
 	}
 
-	if remaining != 0 {
-		return utils.ErrBadRequest.WithDetails("Leftover bytes decoding AuthenticatorData")
+	if !a.Flags.HasAttestedCredentialData() {
+		return utils.ErrAttestationFormat.WithDetails("Attestation missing attested credential data flag")
+	}
+
+	if len(rawAuthData) == minAuthDataLength {
+		return utils.ErrAttestationFormat.WithDetails("Attested credential data flag set but authenticator data has no attested credential data")
 	}
 
 	return nil
 }
 
-// If Attestation Data is present, unmarshall that into the appropriate public key structure
-func (a *AuthenticatorData) unmarshalAttestedData(rawAuthData []byte) {
-	a.AttData.AAGUID = rawAuthData[37:53]
-	idLength := binary.BigEndian.Uint16(rawAuthData[53:55])
-	a.AttData.CredentialID = rawAuthData[55 : 55+idLength]
-	a.AttData.CredentialPublicKey = unmarshalCredentialPublicKey(rawAuthData[55+idLength:])
+// CompatibilityMode selects known deviations of Apple's authenticator data from the WebAuthn
+// specification that UnmarshalWithMode tolerates. Modes can be combined.
+type CompatibilityMode uint
+
+const (
+	// CompatibilityStrict tolerates no deviation.
+	CompatibilityStrict CompatibilityMode = 0
+	// CompatibilityAssertionATFlag tolerates the attested credential data flag without attested
+	// credential data. Apple sets the flag on the authenticator data of assertions.
+	CompatibilityAssertionATFlag CompatibilityMode = 1 << 0
+)
+
+// CompatibilityApple tolerates all deviations known to occur in App Attest data.
+const CompatibilityApple = CompatibilityAssertionATFlag
+
+// If Attestation Data is present, unmarshall that into the appropriate public key structure.
+// It returns the number of bytes of attested credential data.
+func (a *AuthenticatorData) unmarshalAttestedData(attData []byte) (int, error) {
+	// AAGUID (16 bytes) followed by the credential ID length (2 bytes)
+	if len(attData) < 18 {
+		return 0, utils.ErrBadRequest.WithDetails(fmt.Sprintf("Attested credential data is truncated: %d bytes", len(attData)))
+	}
+	a.AttData.AAGUID = attData[:16]
+	idLength := int(binary.BigEndian.Uint16(attData[16:18]))
+	if len(attData) < 18+idLength {
+		return 0, utils.ErrBadRequest.WithDetails(fmt.Sprintf("Attested credential data is truncated: credential ID length %d exceeds the %d remaining bytes", idLength, len(attData)-18))
+	}
+	a.AttData.CredentialID = attData[18 : 18+idLength]
+
+	// The attested credential data directly follows the fixed fields of the authenticator data.
+	keyOffset := minAuthDataLength + 18 + idLength
+	keyBytes := attData[18+idLength:]
+	keyLength, err := cborMapLength(keyBytes)
+	if err != nil {
+		return 0, utils.ErrBadRequest.WithDetails(fmt.Sprintf("Credential public key at offset %d is not a valid CBOR map: %v", keyOffset, err))
+	}
+	if a.AttData.CredentialPublicKey, err = unmarshalCredentialPublicKey(keyBytes[:keyLength]); err != nil {
+		return 0, utils.ErrBadRequest.WithDetails(fmt.Sprintf("Credential public key at offset %d: %v", keyOffset, err))
+	}
+	return 18 + idLength + keyLength, nil
 }
 
-// Unmarshall the credential's Public Key into CBOR encoding
-func unmarshalCredentialPublicKey(keyBytes []byte) []byte {
-	var cborHandler codec.Handle = new(codec.CborHandle)
+// cborMajorTypes names the CBOR major types, the three high bits of the initial byte.
+var cborMajorTypes = [8]string{"an unsigned integer", "a negative integer", "a byte string", "a text string", "an array", "a map", "a tag", "a float or simple value"}
+
+// cborMapLength returns the length of the CBOR map at the start of data.
+func cborMapLength(data []byte) (int, error) {
+	// A map has major type 5 in the three high bits of the initial byte.
+	if len(data) == 0 {
+		return 0, fmt.Errorf("expected a map, found no data")
+	}
+	if major := data[0] >> 5; major != 5 {
+		return 0, fmt.Errorf("expected a map, found %s", cborMajorTypes[major])
+	}
 	var m interface{}
-	codec.NewDecoderBytes(keyBytes, cborHandler).Decode(&m)
-	var rawBytes []byte
-	enc := codec.NewEncoderBytes(&rawBytes, cborHandler)
-	enc.Encode(m)
-	return rawBytes
+	rest, err := utils.UnmarshalCBORFirst(data, &m)
+	if err != nil {
+		return 0, err
+	}
+	return len(data) - len(rest), nil
+}
+
+// unmarshalCredentialPublicKey decodes the credential public key, a CBOR map, and returns its
+// canonical encoding.
+func unmarshalCredentialPublicKey(keyBytes []byte) ([]byte, error) {
+	var m map[interface{}]interface{}
+	if err := utils.UnmarshalCBOR(keyBytes, &m); err != nil {
+		return nil, fmt.Errorf("error decoding: %v", err)
+	}
+	rawBytes, err := utils.MarshalCBOR(m)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding: %v", err)
+	}
+	return rawBytes, nil
 }
 
 // ResidentKeyRequired - Require that the key be private key resident to the client device
@@ -204,6 +382,18 @@ func ResidentKeyUnrequired() *bool {
 	return &required
 }
 
+// VerifyWithAppID verifies the authenticator data of an attestation like Verify, computing the
+// SHA256 hash of the App ID itself.
+func (a *AuthenticatorData) VerifyWithAppID(appID string, credentialID []byte, env Environment) error {
+	if env != EnvironmentDevelopment && env != EnvironmentProduction {
+		return utils.ErrVerification.WithDetails(fmt.Sprintf("Unknown environment %q", env))
+	}
+	appIDHash := sha256.Sum256([]byte(appID))
+	return a.Verify(appIDHash[:], credentialID, env == EnvironmentProduction)
+}
+
+// Verify verifies steps 6 to 9 of the attestation verification for the SHA256 hash of the App
+// ID. Prefer VerifyWithAppID unless the hash is computed in advance.
 func (a *AuthenticatorData) Verify(appIDHash []byte, credentialId []byte, production bool) error {
 
 	// 6. Compute the SHA256 hash of your app’s App ID, and verify that this is the same as the authenticator data’s RP ID hash.
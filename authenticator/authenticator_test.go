@@ -0,0 +1,219 @@
+package authenticator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jyrodrigues/appattest/cose"
+)
+
+func TestUnmarshalAttested(t *testing.T) {
+	t.Run("AT flag set without attested credential data", func(t *testing.T) {
+		raw := make([]byte, minAuthDataLength)
+		raw[32] = byte(FlagUserPresent | FlagAttestedCredentialData)
+
+		// Apple sets the AT flag on assertions, which is only accepted in compatibility mode.
+		var assertionData AuthenticatorData
+		if err := assertionData.Unmarshal(raw); err == nil {
+			t.Fatal("Expected strict Unmarshal to fail")
+		}
+		if err := assertionData.UnmarshalWithMode(raw, CompatibilityApple); err != nil {
+			t.Fatalf("Unmarshal failed: %+v", err)
+		}
+
+		var attestationData AuthenticatorData
+		if err := attestationData.UnmarshalAttested(raw); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("AT flag not set", func(t *testing.T) {
+		raw := make([]byte, minAuthDataLength)
+		var a AuthenticatorData
+		if err := a.UnmarshalAttested(raw); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("Attested credential data present", func(t *testing.T) {
+		var a AuthenticatorData
+		if err := a.UnmarshalAttested(attestedAuthData); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if !bytes.Equal(a.AttData.CredentialID, attestedAuthData[55:87]) {
+			t.Fatalf("Wrong credential ID: %x", a.AttData.CredentialID)
+		}
+		key, err := a.AttData.Key()
+		if err != nil || key.Crv != cose.CurveP256 || !bytes.Equal(key.X, bytes.Repeat([]byte{0x02}, 32)) {
+			t.Fatalf("Wrong key: %+v %v", key, err)
+		}
+		// The coordinates of the test key are not a point on P-256.
+		if _, err := a.AttData.PublicKey(); err == nil {
+			t.Fatal("Expected a point off the curve to be rejected")
+		}
+	})
+}
+
+func TestUnmarshalSectionOrder(t *testing.T) {
+	// CBOR encoding of the extensions map {"ext": 1}
+	extensions := []byte{0xa1, 0x63, 0x65, 0x78, 0x74, 0x01}
+	header := func(flags AuthenticatorFlags) []byte {
+		raw := make([]byte, minAuthDataLength)
+		raw[32] = byte(flags)
+		return raw
+	}
+
+	t.Run("Attested data followed by extensions", func(t *testing.T) {
+		raw := append(append([]byte{}, attestedAuthData...), extensions...)
+		raw[32] |= byte(FlagHasExtensions)
+		var a AuthenticatorData
+		if err := a.Unmarshal(raw); err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if !bytes.Equal(a.ExtData, extensions) {
+			t.Fatalf("Wrong extensions: %x", a.ExtData)
+		}
+	})
+
+	t.Run("Extensions before attested data", func(t *testing.T) {
+		raw := header(FlagAttestedCredentialData | FlagHasExtensions)
+		raw = append(raw, extensions...)
+		raw = append(raw, attestedAuthData[minAuthDataLength:]...)
+		var a AuthenticatorData
+		if err := a.Unmarshal(raw); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("Extensions flag without extensions", func(t *testing.T) {
+		raw := append([]byte{}, attestedAuthData...)
+		raw[32] |= byte(FlagHasExtensions)
+		var a AuthenticatorData
+		if err := a.Unmarshal(raw); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("Extensions without extensions flag", func(t *testing.T) {
+		raw := append(append([]byte{}, attestedAuthData...), extensions...)
+		var a AuthenticatorData
+		if err := a.Unmarshal(raw); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("Truncated attested data", func(t *testing.T) {
+		var a AuthenticatorData
+		if err := a.Unmarshal(attestedAuthData[:60]); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+}
+
+func TestCredentialPublicKeyErrors(t *testing.T) {
+	var a AuthenticatorData
+	if err := a.Unmarshal(attestedAuthData); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if !bytes.Equal(a.AttData.CredentialPublicKey, testCOSEKey) {
+		t.Fatalf("Re-encoding changed the key: %x", a.AttData.CredentialPublicKey)
+	}
+
+	keyOffset := len(attestedAuthData) - len(testCOSEKey)
+	tests := []struct {
+		name   string
+		raw    []byte
+		errors []string
+	}{
+		{"Not a map", append(append([]byte{}, attestedAuthData[:keyOffset]...), 0x81, 0x01), []string{fmt.Sprintf("offset %d", keyOffset), "found an array"}},
+		{"Truncated key", attestedAuthData[:keyOffset+20], []string{fmt.Sprintf("offset %d", keyOffset), "EOF"}},
+		{"No key", attestedAuthData[:keyOffset], []string{"found no data"}},
+	}
+	for _, tt := range tests {
+		var a AuthenticatorData
+		err := a.Unmarshal(tt.raw)
+		if err == nil {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+		for _, want := range tt.errors {
+			if !strings.Contains(err.Error(), want) {
+				t.Fatalf("%s: expected %q in %q", tt.name, want, err)
+			}
+		}
+	}
+}
+
+// attestedAuthData builds authenticator data with a 32 byte credential ID and an ES256 COSE key.
+var attestedAuthData = func() []byte {
+	raw := make([]byte, minAuthDataLength)
+	raw[32] = byte(FlagAttestedCredentialData)
+	raw = append(raw, []byte("appattestdevelop")...)
+	raw = append(raw, 0x00, 0x20)
+	raw = append(raw, bytes.Repeat([]byte{0x01}, 32)...)
+	return append(raw, testCOSEKey...)
+}()
+
+// testCOSEKey is the CBOR-encoded COSE key {1: 2, 3: -7, -1: 1, -2: x, -3: y}.
+var testCOSEKey = append(append(append(
+	[]byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20},
+	bytes.Repeat([]byte{0x02}, 32)...),
+	0x22, 0x58, 0x20),
+	bytes.Repeat([]byte{0x03}, 32)...)
+
+func TestVerifyWithAppID(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	appIDHash := sha256.Sum256([]byte(appID))
+	raw := append([]byte{}, attestedAuthData...)
+	copy(raw, appIDHash[:])
+	var a AuthenticatorData
+	if err := a.UnmarshalAttested(raw); err != nil {
+		t.Fatal(err)
+	}
+	credentialID := attestedAuthData[55:87]
+
+	tests := []struct {
+		appID        string
+		credentialID []byte
+		env          Environment
+	}{
+		{appID, credentialID, EnvironmentDevelopment},
+		{appID, credentialID, EnvironmentProduction},
+		{"TEAMID1234.com.example.other", credentialID, EnvironmentDevelopment},
+		{appID, bytes.Repeat([]byte{0x02}, 32), EnvironmentDevelopment},
+	}
+	for _, test := range tests {
+		hash := sha256.Sum256([]byte(test.appID))
+		expected := a.Verify(hash[:], test.credentialID, test.env == EnvironmentProduction)
+		got := a.VerifyWithAppID(test.appID, test.credentialID, test.env)
+		if (expected == nil) != (got == nil) {
+			t.Errorf("%s %s: Verify returned %v, VerifyWithAppID returned %v", test.appID, test.env, expected, got)
+		}
+	}
+	if err := a.VerifyWithAppID(appID, credentialID, EnvironmentDevelopment); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if err := a.VerifyWithAppID(appID, credentialID, "staging"); err == nil {
+		t.Fatal("Expected an unknown environment to be rejected")
+	}
+}
+
+func TestAttestedCredentialDataEnvironment(t *testing.T) {
+	tests := []struct {
+		aaguid []byte
+		env    Environment
+	}{
+		{[]byte("appattestdevelop"), EnvironmentDevelopment},
+		{append([]byte("appattest"), make([]byte, 7)...), EnvironmentProduction},
+		{[]byte("appattest"), ""},
+		{make([]byte, 16), ""},
+	}
+	for _, test := range tests {
+		env, err := AttestedCredentialData{AAGUID: test.aaguid}.Environment()
+		if env != test.env || (err == nil) != (test.env != "") {
+			t.Errorf("AAGUID %q: got %q, %v", test.aaguid, env, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package cose parses the COSE keys of attested credentials, such as the credential public
+// key of App Attest, which is an EC2 key on P-256 for ES256. See
+// https://tools.ietf.org/html/rfc8152#section-13
+package cose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// KeyType identifies the family of a key, the kty parameter.
+type KeyType int64
+
+// KeyTypeEC2 is an elliptic curve key with x and y coordinates, the only supported key type.
+const KeyTypeEC2 KeyType = 2
+
+// Algorithm identifies the algorithm a key is used with, the alg parameter, as registered in
+// the IANA COSE Algorithms registry.
+type Algorithm int64
+
+const (
+	// AlgES256 ECDSA with SHA-256, the algorithm used by App Attest
+	AlgES256 Algorithm = -7
+	// AlgES384 ECDSA with SHA-384
+	AlgES384 Algorithm = -35
+	// AlgES512 ECDSA with SHA-512
+	AlgES512 Algorithm = -36
+)
+
+// Curve identifies the elliptic curve of an EC2 key, the crv parameter.
+type Curve int64
+
+const (
+	// CurveP256 NIST P-256, the curve of App Attest keys
+	CurveP256 Curve = 1
+	// CurveP384 NIST P-384
+	CurveP384 Curve = 2
+	// CurveP521 NIST P-521
+	CurveP521 Curve = 3
+)
+
+// curves are the supported curves.
+var curves = map[Curve]elliptic.Curve{
+	CurveP256: elliptic.P256(),
+	CurveP384: elliptic.P384(),
+	CurveP521: elliptic.P521(),
+}
+
+// Key is a COSE EC2 key. The parameters are keyed by their labels in the CBOR map.
+type Key struct {
+	Kty KeyType   `cbor:"1,keyasint"`
+	Alg Algorithm `cbor:"3,keyasint"`
+	Crv Curve     `cbor:"-1,keyasint"`
+	// The big-endian x coordinate
+	X []byte `cbor:"-2,keyasint"`
+	// The big-endian y coordinate
+	Y []byte `cbor:"-3,keyasint"`
+}
+
+// ParseKey decodes a CBOR-encoded COSE key, such as
+// authenticator.AttestedCredentialData.CredentialPublicKey. The key must be an EC2 key with
+// the alg, crv, x and y parameters, as WebAuthn requires of credential public keys, and its
+// coordinates must have the size of the curve. It does not check that the point is on the
+// curve.
+func ParseKey(data []byte) (*Key, error) {
+	var key Key
+	if err := utils.UnmarshalCBOR(data, &key); err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Error decoding COSE key: %v", err))
+	}
+	if err := key.validate(); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// validate checks that the key has the parameters of an EC2 key.
+func (k *Key) validate() error {
+	switch {
+	case k.Kty == 0:
+		return utils.ErrParsingData.WithDetails("COSE key has no kty parameter")
+	case k.Kty != KeyTypeEC2:
+		return utils.ErrParsingData.WithDetails(fmt.Sprintf("COSE key type %d is not supported, expected an EC2 key", k.Kty))
+	case k.Alg == 0:
+		return utils.ErrParsingData.WithDetails("COSE key has no alg parameter")
+	case k.Crv == 0:
+		return utils.ErrParsingData.WithDetails("COSE key has no crv parameter")
+	}
+	curve, ok := curves[k.Crv]
+	if !ok {
+		return utils.ErrParsingData.WithDetails(fmt.Sprintf("COSE curve %d is not supported", k.Crv))
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(k.X) != size || len(k.Y) != size {
+		return utils.ErrParsingData.WithDetails(fmt.Sprintf("COSE key coordinates have %d and %d bytes, curve %d expects %d", len(k.X), len(k.Y), k.Crv, size))
+	}
+	return nil
+}
+
+// ECDSA returns the key as an *ecdsa.PublicKey, e.g. to verify assertion signatures. The key
+// must be for an ECDSA algorithm and its point must be on its curve.
+func (k *Key) ECDSA() (*ecdsa.PublicKey, error) {
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	switch k.Alg {
+	case AlgES256, AlgES384, AlgES512:
+	default:
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("COSE algorithm %d is not an ECDSA algorithm", k.Alg))
+	}
+	curve := curves[k.Crv]
+	pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(k.X), Y: new(big.Int).SetBytes(k.Y)}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, utils.ErrParsingData.WithDetails("COSE key is not a point on its curve")
+	}
+	return pub, nil
+}
+
+// MarshalPKIX returns the DER encoded SubjectPublicKeyInfo of the key, the standard encoding
+// to store it or pass it to other libraries, e.g. x509.ParsePKIXPublicKey.
+func (k *Key) MarshalPKIX() ([]byte, error) {
+	pub, err := k.ECDSA()
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Error encoding COSE key as PKIX: %v", err))
+	}
+	return der, nil
+}
+
+// MarshalPEM returns the SubjectPublicKeyInfo of the key in a "PUBLIC KEY" PEM block, as
+// OpenSSL and most other tools expect.
+func (k *Key) MarshalPEM() ([]byte, error) {
+	der, err := k.MarshalPKIX()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
@@ -0,0 +1,131 @@
+package cose
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestParseKey(t *testing.T) {
+	x, y := bytes.Repeat([]byte{0x02}, 32), bytes.Repeat([]byte{0x03}, 32)
+	encode := func(params map[int]interface{}) []byte {
+		data, err := utils.MarshalCBOR(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	valid := map[int]interface{}{1: 2, 3: -7, -1: 1, -2: x, -3: y}
+	without := func(label int, replacement interface{}) []byte {
+		params := make(map[int]interface{})
+		for l, v := range valid {
+			params[l] = v
+		}
+		delete(params, label)
+		if replacement != nil {
+			params[label] = replacement
+		}
+		return encode(params)
+	}
+
+	key, err := ParseKey(encode(valid))
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if key.Kty != KeyTypeEC2 || key.Alg != AlgES256 || key.Crv != CurveP256 || !bytes.Equal(key.X, x) || !bytes.Equal(key.Y, y) {
+		t.Fatalf("Wrong key: %+v", key)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"No kty", without(1, nil)},
+		{"OKP key", without(1, 1)},
+		{"No alg", without(3, nil)},
+		{"No crv", without(-1, nil)},
+		{"Unknown crv", without(-1, 6)},
+		{"Short x", without(-2, x[:31])},
+		{"No y", without(-3, nil)},
+		{"Text x", without(-2, "x")},
+		{"Empty", nil},
+		{"Not a map", []byte{0x81, 0x01}},
+		{"Trailing bytes", append(encode(valid), 0x01)},
+	}
+	for _, tt := range tests {
+		if _, err := ParseKey(tt.data); err == nil {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestECDSA(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y := make([]byte, 32), make([]byte, 32)
+	private.X.FillBytes(x)
+	private.Y.FillBytes(y)
+
+	key := &Key{Kty: KeyTypeEC2, Alg: AlgES256, Crv: CurveP256, X: x, Y: y}
+	pub, err := key.ECDSA()
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if !pub.Equal(&private.PublicKey) {
+		t.Fatal("Wrong public key")
+	}
+
+	tests := []struct {
+		name string
+		key  Key
+	}{
+		{"Not an ECDSA algorithm", Key{Kty: KeyTypeEC2, Alg: -8, Crv: CurveP256, X: x, Y: y}},
+		{"Point off the curve", Key{Kty: KeyTypeEC2, Alg: AlgES256, Crv: CurveP256, X: x, Y: x}},
+		{"Wrong curve", Key{Kty: KeyTypeEC2, Alg: AlgES256, Crv: CurveP384, X: x, Y: y}},
+	}
+	for _, tt := range tests {
+		if _, err := tt.key.ECDSA(); err == nil {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+		if _, err := tt.key.MarshalPEM(); err == nil {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestMarshalPKIX(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y := make([]byte, 32), make([]byte, 32)
+	private.X.FillBytes(x)
+	private.Y.FillBytes(y)
+	key := &Key{Kty: KeyTypeEC2, Alg: AlgES256, Crv: CurveP256, X: x, Y: y}
+
+	der, err := key.MarshalPKIX()
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil || !private.PublicKey.Equal(pub) {
+		t.Fatalf("Wrong PKIX key %v", err)
+	}
+
+	data, err := key.MarshalPEM()
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	block, rest := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" || !bytes.Equal(block.Bytes, der) || len(rest) != 0 {
+		t.Fatalf("Wrong PEM key %s", data)
+	}
+}
@@ -0,0 +1,111 @@
+package cose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// JWK is an EC public key as a JSON Web Key, see https://tools.ietf.org/html/rfc7517 and
+// https://tools.ietf.org/html/rfc7518#section-6.2
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	// The base64url encoded x coordinate, without padding
+	X string `json:"x"`
+	// The base64url encoded y coordinate, without padding
+	Y   string `json:"y"`
+	Alg string `json:"alg,omitempty"`
+}
+
+// jwkCurves are the JWK names of the supported curves.
+var jwkCurves = map[Curve]string{
+	CurveP256: "P-256",
+	CurveP384: "P-384",
+	CurveP521: "P-521",
+}
+
+// jwkAlgorithms are the JWK names of the supported algorithms.
+var jwkAlgorithms = map[Algorithm]string{
+	AlgES256: "ES256",
+	AlgES384: "ES384",
+	AlgES512: "ES512",
+}
+
+// curveAlgorithms are the ECDSA algorithms of the curves, for JWKs without alg.
+var curveAlgorithms = map[Curve]Algorithm{
+	CurveP256: AlgES256,
+	CurveP384: AlgES384,
+	CurveP521: AlgES512,
+}
+
+// JWK returns the key as a JSON Web Key, e.g. to publish it to services verifying signatures
+// with JWKs. json.Marshal encodes the result.
+func (k *Key) JWK() (*JWK, error) {
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	alg, ok := jwkAlgorithms[k.Alg]
+	if !ok {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("COSE algorithm %d has no JWK name", k.Alg))
+	}
+	return &JWK{
+		Kty: "EC",
+		Crv: jwkCurves[k.Crv],
+		X:   base64.RawURLEncoding.EncodeToString(k.X),
+		Y:   base64.RawURLEncoding.EncodeToString(k.Y),
+		Alg: alg,
+	}, nil
+}
+
+// Key converts the JSON Web Key back to a COSE key. A JWK without alg gets the ECDSA
+// algorithm of its curve, e.g. ES256 for P-256.
+func (j *JWK) Key() (*Key, error) {
+	if j.Kty != "EC" {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("JWK key type %q is not supported, expected EC", j.Kty))
+	}
+	key := &Key{Kty: KeyTypeEC2}
+	for crv, name := range jwkCurves {
+		if name == j.Crv {
+			key.Crv = crv
+		}
+	}
+	if key.Crv == 0 {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("JWK curve %q is not supported", j.Crv))
+	}
+	key.Alg = curveAlgorithms[key.Crv]
+	if j.Alg != "" {
+		key.Alg = 0
+		for alg, name := range jwkAlgorithms {
+			if name == j.Alg {
+				key.Alg = alg
+			}
+		}
+	}
+	if key.Alg == 0 {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("JWK algorithm %q is not supported", j.Alg))
+	}
+	var err error
+	if key.X, err = base64.RawURLEncoding.DecodeString(j.X); err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Error decoding JWK x coordinate: %v", err))
+	}
+	if key.Y, err = base64.RawURLEncoding.DecodeString(j.Y); err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Error decoding JWK y coordinate: %v", err))
+	}
+	if err := key.validate(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParseJWK decodes a JSON Web Key, such as one encoded from the result of Key.JWK, into a COSE
+// key.
+func ParseJWK(data []byte) (*Key, error) {
+	var jwk JWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Error decoding JWK: %v", err))
+	}
+	return jwk.Key()
+}
@@ -0,0 +1,57 @@
+package cose
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJWK(t *testing.T) {
+	x, y := bytes.Repeat([]byte{0xfb}, 32), bytes.Repeat([]byte{0x02}, 32)
+	key := &Key{Kty: KeyTypeEC2, Alg: AlgES256, Crv: CurveP256, X: x, Y: y}
+
+	jwk, err := key.JWK()
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	data, err := json.Marshal(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"kty":"EC","crv":"P-256","x":"-_v7-_v7-_v7-_v7-_v7-_v7-_v7-_v7-_v7-_v7-_s","y":"AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI","alg":"ES256"}`
+	if string(data) != expected {
+		t.Fatalf("Wrong JWK %s", data)
+	}
+
+	parsed, err := ParseJWK(data)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if parsed.Alg != AlgES256 || parsed.Crv != CurveP256 || !bytes.Equal(parsed.X, x) || !bytes.Equal(parsed.Y, y) {
+		t.Fatalf("Wrong key %+v", parsed)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"No alg", `{"kty":"EC","crv":"P-256","x":"AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI","y":"AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI"}`, ""},
+		{"RSA key", `{"kty":"RSA","n":"AQAB","e":"AQAB"}`, `JWK key type "RSA"`},
+		{"Unknown curve", `{"kty":"EC","crv":"secp256k1","x":"AgI","y":"AgI"}`, `JWK curve "secp256k1"`},
+		{"Unknown algorithm", `{"kty":"EC","crv":"P-256","x":"AgI","y":"AgI","alg":"ES256K"}`, `JWK algorithm "ES256K"`},
+		{"Padded coordinate", `{"kty":"EC","crv":"P-256","x":"AgI=","y":"AgI"}`, "x coordinate"},
+		{"Short coordinates", `{"kty":"EC","crv":"P-256","x":"AgI","y":"AgI"}`, "coordinates have 2 and 2 bytes"},
+		{"Not JSON", `EC`, "Error decoding JWK"},
+	}
+	for _, tt := range tests {
+		_, err := ParseJWK([]byte(tt.data))
+		if tt.err == "" && err != nil {
+			t.Fatalf("%s: not valid: %+v", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Fatalf("%s: expected an error about %q, got %+v", tt.name, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Package appattest provides entry points that work across attestations and assertions.
+// The verification itself lives in the attestation and assertion packages.
+package appattest
+
+import (
+	"io"
+
+	"github.com/jyrodrigues/appattest/assertion"
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Kind is the kind of a decoded App Attest object.
+type Kind int
+
+const (
+	// KindUnknown is returned when the input could not be decoded.
+	KindUnknown Kind = iota
+	// KindAttestation is an attestation object, decoded as *attestation.AttestationObject.
+	KindAttestation
+	// KindAssertion is an assertion, decoded as *assertion.Assertion.
+	KindAssertion
+	// KindAuthenticatorData is raw authenticator data without a signature or attestation
+	// statement, decoded as *authenticator.AuthenticatorData.
+	KindAuthenticatorData
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindAttestation:
+		return "attestation"
+	case KindAssertion:
+		return "assertion"
+	case KindAuthenticatorData:
+		return "authenticator_data"
+	default:
+		return "unknown"
+	}
+}
+
+// Decode reads a CBOR-encoded attestation object or assertion of at most maxSize bytes from r.
+// The kind is detected from the keys of the CBOR map: attestation objects have fmt, attStmt
+// and authData, assertions have signature and authenticatorData. Input that is not such a map
+// but parses as authenticator data is reported as KindAuthenticatorData. The returned value is
+// an *attestation.AttestationObject, an *assertion.Assertion or an
+// *authenticator.AuthenticatorData. Nothing is verified.
+func Decode(r io.Reader, maxSize int64) (Kind, interface{}, error) {
+	data, err := utils.ReadLimited(r, maxSize)
+	if err != nil {
+		return KindUnknown, nil, err
+	}
+	return decode(data)
+}
+
+func decode(data []byte) (Kind, interface{}, error) {
+	var fields map[string]interface{}
+	_, mapErr := utils.UnmarshalCBORFirst(data, &fields)
+
+	switch {
+	case mapErr == nil && hasKeys(fields, "fmt", "attStmt", "authData"):
+		a, err := attestation.ParseAttestationObject(data)
+		if err != nil {
+			return KindUnknown, nil, err
+		}
+		return KindAttestation, a, nil
+	case mapErr == nil && hasKeys(fields, "signature", "authenticatorData"):
+		var a assertion.Assertion
+		if err := a.Unmarshal(data); err != nil {
+			return KindUnknown, nil, err
+		}
+		return KindAssertion, &a, nil
+	}
+
+	// Authenticator data is not CBOR, although its first bytes may happen to decode as such.
+	var authData authenticator.AuthenticatorData
+	if err := authData.UnmarshalWithMode(data, authenticator.CompatibilityApple); err == nil {
+		return KindAuthenticatorData, &authData, nil
+	}
+	if mapErr != nil {
+		return KindUnknown, nil, utils.ErrParsingData.WithDetails(mapErr.Error())
+	}
+	return KindUnknown, nil, utils.ErrParsingData.WithDetails("Input is neither an attestation object nor an assertion")
+}
+
+func hasKeys(fields map[string]interface{}, keys ...string) bool {
+	for _, key := range keys {
+		if _, ok := fields[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
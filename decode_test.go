@@ -0,0 +1,75 @@
+package appattest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jyrodrigues/appattest/assertion"
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/testutil"
+)
+
+func TestDecode(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	att, err := authority.Attest(device, testutil.AttestOptions{AppID: appID, ClientData: []byte("challenge")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertionObject, err := device.Assert(appID, []byte(`{"challenge":"challenge"}`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Attestation", func(t *testing.T) {
+		kind, v, err := Decode(bytes.NewReader(att.Object), 1<<16)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		a, ok := v.(*attestation.AttestationObject)
+		if kind != KindAttestation || !ok {
+			t.Fatalf("Wrong kind %s: %T", kind, v)
+		}
+		if a.Format != "apple-appattest" || !bytes.Equal(a.AuthData.AttData.CredentialID, device.KeyID) {
+			t.Fatalf("Wrong attestation object: %+v", a)
+		}
+	})
+
+	t.Run("Assertion", func(t *testing.T) {
+		kind, v, err := Decode(bytes.NewReader(assertionObject), 1<<16)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		a, ok := v.(*assertion.Assertion)
+		if kind != KindAssertion || !ok {
+			t.Fatalf("Wrong kind %s: %T", kind, v)
+		}
+		if a.AuthenticatorData.Counter != 1 {
+			t.Fatalf("Wrong counter: %d", a.AuthenticatorData.Counter)
+		}
+	})
+
+	t.Run("Oversized input", func(t *testing.T) {
+		if _, _, err := Decode(bytes.NewReader(att.Object), int64(len(att.Object)-1)); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if _, _, err := Decode(bytes.NewReader(att.Object), int64(len(att.Object))); err != nil {
+			t.Fatalf("Input of exactly the maximum size should be accepted: %+v", err)
+		}
+	})
+
+	t.Run("Unknown map", func(t *testing.T) {
+		// CBOR encoding of {"a": 1}
+		kind, _, err := Decode(bytes.NewReader([]byte{0xa1, 0x61, 'a', 0x01}), 1<<16)
+		if err == nil || kind != KindUnknown {
+			t.Fatalf("Expected an error, got kind %s", kind)
+		}
+	})
+}
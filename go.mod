@@ -2,4 +2,6 @@ module github.com/jyrodrigues/appattest
 
 go 1.24
 
-require github.com/ugorji/go/codec v1.2.4
+require github.com/fxamacker/cbor/v2 v2.9.2
+
+require github.com/x448/float16 v0.8.4 // indirect
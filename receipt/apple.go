@@ -0,0 +1,90 @@
+package receipt
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"sync"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// appleRootCert is Apple Root CA - G3, the root of Apple's receipt signing chain, valid until
+// 2039.
+const appleRootCert = `-----BEGIN CERTIFICATE-----
+MIICQzCCAcmgAwIBAgIILcX8iNLFS5UwCgYIKoZIzj0EAwMwZzEbMBkGA1UEAwwS
+QXBwbGUgUm9vdCBDQSAtIEczMSYwJAYDVQQLDB1BcHBsZSBDZXJ0aWZpY2F0aW9u
+IEF1dGhvcml0eTETMBEGA1UECgwKQXBwbGUgSW5jLjELMAkGA1UEBhMCVVMwHhcN
+MTQwNDMwMTgxOTA2WhcNMzkwNDMwMTgxOTA2WjBnMRswGQYDVQQDDBJBcHBsZSBS
+b290IENBIC0gRzMxJjAkBgNVBAsMHUFwcGxlIENlcnRpZmljYXRpb24gQXV0aG9y
+aXR5MRMwEQYDVQQKDApBcHBsZSBJbmMuMQswCQYDVQQGEwJVUzB2MBAGByqGSM49
+AgEGBSuBBAAiA2IABJjpLz1AcqTtkyJygRMc3RCV8cWjTnHcFBbZDuWmBSp3ZHtf
+TjjTuxxEtX/1H7YyYl3J6YRbTzBPEVoA/VhYDKX1DyxNB0cTddqXl5dvMVztK517
+IDvYuVTZXpmkOlEKMaNCMEAwHQYDVR0OBBYEFLuw3qFYM4iapIqZ3r6966/ayySr
+MA8GA1UdEwEB/wQFMAMBAf8wDgYDVR0PAQH/BAQDAgEGMAoGCCqGSM49BAMDA2gA
+MGUCMQCD6cHEFl4aXTQY2e3v9GwOAEZLuN+yRhHFD/3meoyhpmvOwgPUnPWTxnS4
+at+qIxUCMG1mihDK1A3UT82NQz60imOlM27jbdoXt2QfyFMm+YhidDkLF1vLUagM
+6BgD56KyKA==
+-----END CERTIFICATE-----`
+
+// appleReceiptCACert is Apple Application Integration CA 5 - G1, the intermediate issuing the
+// receipt signing certificates, valid until 2034.
+const appleReceiptCACert = `-----BEGIN CERTIFICATE-----
+MIIC+TCCAn+gAwIBAgIQVvuD1Cv/jcM3mSO1Wq5uvTAKBggqhkjOPQQDAzBnMRsw
+GQYDVQQDDBJBcHBsZSBSb290IENBIC0gRzMxJjAkBgNVBAsMHUFwcGxlIENlcnRp
+ZmljYXRpb24gQXV0aG9yaXR5MRMwEQYDVQQKDApBcHBsZSBJbmMuMQswCQYDVQQG
+EwJVUzAeFw0xOTAzMjIxNzUzMzNaFw0zNDAzMjIwMDAwMDBaMHwxMDAuBgNVBAMM
+J0FwcGxlIEFwcGxpY2F0aW9uIEludGVncmF0aW9uIENBIDUgLSBHMTEmMCQGA1UE
+CwwdQXBwbGUgQ2VydGlmaWNhdGlvbiBBdXRob3JpdHkxEzARBgNVBAoMCkFwcGxl
+IEluYy4xCzAJBgNVBAYTAlVTMFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEks5j
+vX2GsasoCjsc4a/7BJSAkaz2Md+myyg1b0RL4SHlV90SjY26gnyVvkn6vjPKrs0E
+GfEvQyX69L6zy4N+uqOB9zCB9DAPBgNVHRMBAf8EBTADAQH/MB8GA1UdIwQYMBaA
+FLuw3qFYM4iapIqZ3r6966/ayySrMEYGCCsGAQUFBwEBBDowODA2BggrBgEFBQcw
+AYYqaHR0cDovL29jc3AuYXBwbGUuY29tL29jc3AwMy1hcHBsZXJvb3RjYWczMDcG
+A1UdHwQwMC4wLKAqoCiGJmh0dHA6Ly9jcmwuYXBwbGUuY29tL2FwcGxlcm9vdGNh
+ZzMuY3JsMB0GA1UdDgQWBBTZF/5LZ5A4S5L0287VV4AUC489yTAOBgNVHQ8BAf8E
+BAMCAQYwEAYKKoZIhvdjZAYCAwQCBQAwCgYIKoZIzj0EAwMDaAAwZQIxAI1vpp+h
+4OTsW05zipJ/PXhTmI/02h9YHsN1Sv44qEwqgxoaqg2mZG3huZPo0VVM7QIwZzss
+tOHoNwd3y9XsdqgaOlU7PzVqyMXmkrDhYb6ASWnkXyupbOERAqrMYdk4t3NK
+-----END CERTIFICATE-----`
+
+// AppleReceiptSignerCN is the common name of the certificates signing App Attest receipts.
+const AppleReceiptSignerCN = "Application Attestation Fraud Receipt Signing"
+
+// oidReceiptSigning marks the certificates Apple issues to sign App Attest receipts, as opposed
+// to the other certificates of Apple's application integration CA.
+var oidReceiptSigning = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 12, 15}
+
+// AppleRootCertificate returns the embedded Apple Root CA - G3 certificate, the default root of
+// the receipt signing chain.
+func AppleRootCertificate() (*x509.Certificate, error) {
+	return appleRoot()
+}
+
+// AppleReceiptCACertificate returns the embedded intermediate that issues Apple's receipt
+// signing certificates. By default the signing chain must go through it.
+func AppleReceiptCACertificate() (*x509.Certificate, error) {
+	return appleReceiptCA()
+}
+
+var appleRoot = sync.OnceValues(func() (*x509.Certificate, error) {
+	return parseEmbedded(appleRootCert, "root")
+})
+
+var appleReceiptCA = sync.OnceValues(func() (*x509.Certificate, error) {
+	return parseEmbedded(appleReceiptCACert, "receipt signing CA")
+})
+
+// parseEmbedded parses an embedded PEM certificate.
+func parseEmbedded(data, name string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, utils.ErrReceipt.WithDetails(fmt.Sprintf("Error decoding the Apple %s certificate", name))
+	}
+	ct, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, utils.ErrReceipt.WithDetails(fmt.Sprintf("Error parsing the Apple %s certificate: %v", name, err))
+	}
+	return ct, nil
+}
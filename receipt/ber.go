@@ -0,0 +1,155 @@
+package receipt
+
+import (
+	"fmt"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// maxBERDepth bounds the nesting of BER elements, well above the depth of a receipt.
+const maxBERDepth = 64
+
+// Identifiers of the BER elements berToDER rewrites.
+const (
+	berOctetString            = 0x04
+	berConstructedOctetString = 0x24
+	berIndefiniteLength       = 0x80
+)
+
+// berToDER re-encodes a BER element in DER as far as encoding/asn1 needs: Apple's receipts
+// use indefinite lengths and split their payload into a constructed OCTET STRING, which are
+// converted to definite lengths and a single primitive OCTET STRING. DER input is returned
+// unchanged.
+func berToDER(ber []byte) ([]byte, error) {
+	der, rest, err := convertBER(ber, 0)
+	if err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Receipt is not valid BER: %v", err))
+	}
+	if len(rest) != 0 {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("%d unexpected bytes after the receipt", len(rest)))
+	}
+	return der, nil
+}
+
+// convertBER converts the first element of data to DER and returns the remaining bytes.
+func convertBER(data []byte, depth int) ([]byte, []byte, error) {
+	if depth > maxBERDepth {
+		return nil, nil, fmt.Errorf("elements nested more than %d levels", maxBERDepth)
+	}
+	if len(data) < 2 {
+		return nil, nil, fmt.Errorf("truncated element")
+	}
+
+	i := 1
+	if data[0]&0x1f == 0x1f {
+		for ; ; i++ {
+			if i >= len(data) {
+				return nil, nil, fmt.Errorf("truncated tag")
+			}
+			if data[i]&0x80 == 0 {
+				i++
+				break
+			}
+		}
+	}
+	tag := data[:i]
+	constructed := data[0]&0x20 != 0
+	if i >= len(data) {
+		return nil, nil, fmt.Errorf("truncated length")
+	}
+	l := data[i]
+	i++
+
+	var children [][]byte
+	var contents, rest []byte
+	if l == berIndefiniteLength {
+		if !constructed {
+			return nil, nil, fmt.Errorf("indefinite length of a primitive element")
+		}
+		rest = data[i:]
+		for {
+			if len(rest) < 2 {
+				return nil, nil, fmt.Errorf("missing end-of-contents")
+			}
+			if rest[0] == 0 && rest[1] == 0 {
+				rest = rest[2:]
+				break
+			}
+			child, r, err := convertBER(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			children, rest = append(children, child), r
+		}
+	} else {
+		n := int(l)
+		if l&0x80 != 0 {
+			k := int(l & 0x7f)
+			if k > 4 || i+k > len(data) {
+				return nil, nil, fmt.Errorf("invalid length")
+			}
+			n = 0
+			for _, b := range data[i : i+k] {
+				n = n<<8 | int(b)
+			}
+			i += k
+		}
+		if n < 0 || n > len(data)-i {
+			return nil, nil, fmt.Errorf("truncated element")
+		}
+		body := data[i : i+n]
+		rest = data[i+n:]
+		if !constructed {
+			contents = body
+		}
+		for constructed && len(body) > 0 {
+			child, r, err := convertBER(body, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			children, body = append(children, child), r
+		}
+	}
+
+	if tag[0] == berConstructedOctetString {
+		// The chunks were converted first, so they are primitive OCTET STRINGs.
+		tag = []byte{berOctetString}
+		for _, child := range children {
+			if child[0] != berOctetString {
+				return nil, nil, fmt.Errorf("constructed OCTET STRING holds a %#x element", child[0])
+			}
+			contents = append(contents, child[derHeaderLength(child):]...)
+		}
+	} else {
+		for _, child := range children {
+			contents = append(contents, child...)
+		}
+	}
+
+	der := make([]byte, 0, len(tag)+5+len(contents))
+	der = append(der, tag...)
+	der = appendLength(der, len(contents))
+	return append(der, contents...), rest, nil
+}
+
+// appendLength appends the DER encoding of the length n.
+func appendLength(b []byte, n int) []byte {
+	if n < 0x80 {
+		return append(b, byte(n))
+	}
+	var octets []byte
+	for ; n > 0; n >>= 8 {
+		octets = append([]byte{byte(n)}, octets...)
+	}
+	b = append(b, 0x80|byte(len(octets)))
+	return append(b, octets...)
+}
+
+// derHeaderLength returns the length of the tag and length octets of a DER element with a
+// single-byte tag.
+func derHeaderLength(der []byte) int {
+	if der[1]&0x80 == 0 {
+		return 2
+	}
+	return 2 + int(der[1]&0x7f)
+}
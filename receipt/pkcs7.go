@@ -0,0 +1,74 @@
+package receipt
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// OIDs of the PKCS#7 content types. See https://tools.ietf.org/html/rfc2315#section-14
+var (
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// contentInfo is the outer PKCS#7 structure. Content is the explicitly tagged content, whose
+// Bytes hold the encoded signed data.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// signedData is the PKCS#7 signed-data content. See https://tools.ietf.org/html/rfc5652#section-5.1
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapsulatedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	SignerIdentifier   asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// parseSignedData decodes a BER- or DER-encoded PKCS#7 signed-data container.
+func parseSignedData(data []byte) (*signedData, error) {
+	der, err := berToDER(data)
+	if err != nil {
+		return nil, err
+	}
+	var info contentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Receipt is not a PKCS#7 container: %v", err))
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Receipt content type %v is not signed data", info.ContentType))
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Error decoding the receipt signed data: %v", err))
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidData) {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Receipt payload content type %v is not data", sd.ContentInfo.ContentType))
+	}
+	if len(sd.ContentInfo.Content) == 0 {
+		return nil, utils.ErrParsingData.WithDetails("Receipt has no payload")
+	}
+	return &sd, nil
+}
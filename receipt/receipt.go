@@ -0,0 +1,297 @@
+// Package receipt parses the receipts App Attest returns with attestations and that Apple's
+// fraud metric service exchanges for new receipts. A receipt is a PKCS#7 signed-data
+// container whose payload is an ASN.1 set of fields, see Apple's "Assessing Fraud Risk".
+package receipt
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Types of the documented receipt fields.
+const (
+	FieldAppID             = 2
+	FieldAttestedPublicKey = 3
+	FieldClientHash        = 4
+	FieldToken             = 5
+	FieldType              = 6
+	FieldCreationTime      = 12
+	FieldRiskMetric        = 17
+	FieldNotBefore         = 19
+	FieldExpirationTime    = 21
+)
+
+// Receipt types.
+const (
+	// TypeAttest is the type of receipts returned with attestations
+	TypeAttest = "ATTEST"
+	// TypeReceipt is the type of receipts returned by the fraud metric service
+	TypeReceipt = "RECEIPT"
+)
+
+// Field is a field of the receipt payload, a ReceiptAttribute in Apple's documentation.
+type Field struct {
+	Type    int    `json:"type"`
+	Version int    `json:"version"`
+	Value   []byte `json:"value"`
+}
+
+// documentedFields are the field types with accessors.
+var documentedFields = map[int]bool{
+	FieldAppID:             true,
+	FieldAttestedPublicKey: true,
+	FieldClientHash:        true,
+	FieldToken:             true,
+	FieldType:              true,
+	FieldCreationTime:      true,
+	FieldRiskMetric:        true,
+	FieldNotBefore:         true,
+	FieldExpirationTime:    true,
+}
+
+// Receipt is a parsed receipt. The documented fields are read with its accessors, which
+// return zero values for fields Apple does not include in a receipt.
+type Receipt struct {
+	// All fields of the payload in their encoded order, including undocumented ones
+	Fields []Field
+	// The encoded PKCS#7 container, as received
+	Raw []byte
+	// The signed payload, the DER-encoded set of fields
+	Payload []byte
+
+	creationTime   time.Time
+	notBefore      time.Time
+	expirationTime time.Time
+	riskMetric     int
+	hasRiskMetric  bool
+}
+
+// Parse decodes a BER- or DER-encoded receipt, as Apple sends them, without verifying its
+// signature. See Verify.
+func Parse(data []byte) (*Receipt, error) {
+	r, _, err := parse(data)
+	return r, err
+}
+
+// parse decodes the receipt and returns it with its PKCS#7 signed data.
+func parse(data []byte) (*Receipt, *signedData, error) {
+	sd, err := parseSignedData(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fields []Field
+	rest, err := asn1.UnmarshalWithParams(sd.ContentInfo.Content, &fields, "set")
+	if err != nil {
+		return nil, nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Error decoding the receipt payload: %v", err))
+	}
+	if len(rest) != 0 {
+		return nil, nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("%d unexpected bytes after the receipt payload", len(rest)))
+	}
+
+	r := &Receipt{Fields: fields, Raw: data, Payload: sd.ContentInfo.Content}
+	seen := make(map[int]bool)
+	for _, f := range fields {
+		if seen[f.Type] {
+			return nil, nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Receipt field %d is repeated", f.Type))
+		}
+		seen[f.Type] = true
+		if err := r.parseField(f); err != nil {
+			return nil, nil, err
+		}
+	}
+	return r, sd, nil
+}
+
+// parseField decodes the documented fields that are not strings or bytes.
+func (r *Receipt) parseField(f Field) error {
+	var err error
+	switch f.Type {
+	case FieldCreationTime:
+		r.creationTime, err = time.Parse(time.RFC3339, string(f.Value))
+	case FieldRiskMetric:
+		r.riskMetric, err = strconv.Atoi(string(f.Value))
+		r.hasRiskMetric = err == nil
+	case FieldNotBefore:
+		r.notBefore, err = time.Parse(time.RFC3339, string(f.Value))
+	case FieldExpirationTime:
+		r.expirationTime, err = time.Parse(time.RFC3339, string(f.Value))
+	}
+	if err != nil {
+		return utils.ErrParsingData.WithDetails(fmt.Sprintf("Invalid receipt field %d: %v", f.Type, err))
+	}
+	return nil
+}
+
+// Field returns the value of the field with the given type.
+func (r *Receipt) Field(typ int) ([]byte, bool) {
+	for _, f := range r.Fields {
+		if f.Type == typ {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// UnknownFields returns the fields without an accessor, which Apple may add to receipts
+// before this package documents them.
+func (r *Receipt) UnknownFields() []Field {
+	var unknown []Field
+	for _, f := range r.Fields {
+		if !documentedFields[f.Type] {
+			unknown = append(unknown, f)
+		}
+	}
+	return unknown
+}
+
+func (r *Receipt) stringField(typ int) string {
+	value, _ := r.Field(typ)
+	return string(value)
+}
+
+// AppID returns the App ID of the app the receipt was issued for.
+func (r *Receipt) AppID() string {
+	return r.stringField(FieldAppID)
+}
+
+// Type returns TypeAttest or TypeReceipt.
+func (r *Receipt) Type() string {
+	return r.stringField(FieldType)
+}
+
+// Token returns the token the fraud metric service uses to identify the receipt.
+func (r *Receipt) Token() string {
+	return r.stringField(FieldToken)
+}
+
+// ClientHash returns the client data hash of the attestation the receipt was issued for.
+func (r *Receipt) ClientHash() []byte {
+	value, _ := r.Field(FieldClientHash)
+	return value
+}
+
+// CreationTime returns when the receipt was issued.
+func (r *Receipt) CreationTime() time.Time {
+	return r.creationTime
+}
+
+// NotBefore returns the time from which the receipt can be exchanged for a new one.
+func (r *Receipt) NotBefore() time.Time {
+	return r.notBefore
+}
+
+// ExpirationTime returns when the receipt expires.
+func (r *Receipt) ExpirationTime() time.Time {
+	return r.expirationTime
+}
+
+// RiskMetric returns the number of attestations of the device in the last 30 days, and
+// whether the receipt has one: only receipts of the fraud metric service do.
+func (r *Receipt) RiskMetric() (int, bool) {
+	return r.riskMetric, r.hasRiskMetric
+}
+
+// AttestedPublicKey returns the public key the receipt was issued for. Apple stores the
+// credential certificate in the field, a PKIX public key is also accepted.
+func (r *Receipt) AttestedPublicKey() (crypto.PublicKey, error) {
+	value, ok := r.Field(FieldAttestedPublicKey)
+	if !ok {
+		return nil, utils.ErrParsingData.WithDetails("Receipt has no attested public key")
+	}
+	if cert, err := x509.ParseCertificate(value); err == nil {
+		return cert.PublicKey, nil
+	}
+	pub, err := x509.ParsePKIXPublicKey(value)
+	if err != nil {
+		return nil, utils.ErrParsingData.WithDetails(fmt.Sprintf("Invalid attested public key: %v", err))
+	}
+	return pub, nil
+}
+
+// Fields every receipt has, and the fields only receipts of either type have.
+var (
+	requiredFields = []int{FieldAppID, FieldAttestedPublicKey, FieldToken, FieldType, FieldCreationTime, FieldExpirationTime}
+	attestFields   = []int{FieldClientHash}
+	receiptFields  = []int{FieldRiskMetric, FieldNotBefore}
+)
+
+// Validate checks that the receipt has the fields its type requires. Both types have the App
+// ID, attested public key, token, creation and expiration times. ATTEST receipts also have
+// the client hash and no risk metric, RECEIPT receipts a risk metric and a Not Before time.
+func (r *Receipt) Validate() error {
+	required := requiredFields
+	switch r.Type() {
+	case TypeAttest:
+		if r.hasRiskMetric {
+			return utils.ErrReceipt.WithDetails("ATTEST receipt has a risk metric")
+		}
+		required = append(required[:len(required):len(required)], attestFields...)
+	case TypeReceipt:
+		required = append(required[:len(required):len(required)], receiptFields...)
+	default:
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Unknown receipt type %q", r.Type()))
+	}
+	for _, typ := range required {
+		if _, ok := r.Field(typ); !ok {
+			return utils.ErrReceipt.WithDetails(fmt.Sprintf("%s receipt has no field %d", r.Type(), typ))
+		}
+	}
+	return nil
+}
+
+// receiptJSON is the JSON encoding of a Receipt, with the decoded fields for inspection.
+type receiptJSON struct {
+	AppID          string    `json:"appId"`
+	Type           string    `json:"type"`
+	Token          string    `json:"token"`
+	ClientHash     []byte    `json:"clientHash"`
+	CreationTime   time.Time `json:"creationTime"`
+	NotBefore      time.Time `json:"notBefore"`
+	ExpirationTime time.Time `json:"expirationTime"`
+	RiskMetric     *int      `json:"riskMetric,omitempty"`
+	UnknownFields  []Field   `json:"unknownFields,omitempty"`
+	Raw            []byte    `json:"raw"`
+}
+
+// MarshalJSON encodes the receipt with its documented and unknown fields and the raw
+// container.
+func (r *Receipt) MarshalJSON() ([]byte, error) {
+	out := receiptJSON{
+		AppID:          r.AppID(),
+		Type:           r.Type(),
+		Token:          r.Token(),
+		ClientHash:     r.ClientHash(),
+		CreationTime:   r.creationTime,
+		NotBefore:      r.notBefore,
+		ExpirationTime: r.expirationTime,
+		UnknownFields:  r.UnknownFields(),
+		Raw:            r.Raw,
+	}
+	if r.hasRiskMetric {
+		out.RiskMetric = &r.riskMetric
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON parses the raw container of a receipt encoded with MarshalJSON. The decoded
+// fields are ignored.
+func (r *Receipt) UnmarshalJSON(data []byte) error {
+	var in receiptJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	parsed, err := Parse(in.Raw)
+	if err != nil {
+		return err
+	}
+	*r = *parsed
+	return nil
+}
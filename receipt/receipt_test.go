@@ -0,0 +1,100 @@
+package receipt
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/testutil"
+)
+
+func TestParse(t *testing.T) {
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2021, 4, 14, 9, 55, 20, 0, time.UTC)
+	data, err := authority.Receipt(testutil.ReceiptOptions{
+		AppID:             "TEAMID1234.com.example.app",
+		AttestedPublicKey: authority.Intermediate.Raw,
+		ClientHash:        []byte("client hash"),
+		Token:             "token",
+		Type:              TypeReceipt,
+		RiskMetric:        3,
+		CreationTime:      created,
+		ExtraFields:       map[int][]byte{42: []byte("new field")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if r.AppID() != "TEAMID1234.com.example.app" || r.Token() != "token" || r.Type() != TypeReceipt {
+		t.Fatalf("Wrong receipt: %+v", r)
+	}
+	if risk, ok := r.RiskMetric(); !ok || risk != 3 {
+		t.Fatalf("Wrong risk metric: %d", risk)
+	}
+	if !bytes.Equal(r.ClientHash(), []byte("client hash")) {
+		t.Fatalf("Wrong client hash: %x", r.ClientHash())
+	}
+	pub, err := r.AttestedPublicKey()
+	if err != nil {
+		t.Fatalf("Invalid attested public key: %+v", err)
+	}
+	if !pub.(*ecdsa.PublicKey).Equal(authority.Intermediate.PublicKey) {
+		t.Fatal("Wrong attested public key")
+	}
+	if !r.CreationTime().Equal(created) || !r.NotBefore().Equal(created.Add(24*time.Hour)) || !r.ExpirationTime().Equal(created.AddDate(0, 0, 90)) {
+		t.Fatalf("Wrong receipt times: %v %v %v", r.CreationTime(), r.NotBefore(), r.ExpirationTime())
+	}
+	if len(r.Fields) != 10 || !bytes.Equal(r.Raw, data) || len(r.Payload) == 0 {
+		t.Fatalf("Wrong receipt fields: %+v", r.Fields)
+	}
+	unknown := r.UnknownFields()
+	if len(unknown) != 1 || unknown[0].Type != 42 || unknown[0].Version != 1 || string(unknown[0].Value) != "new field" {
+		t.Fatalf("Wrong unknown fields: %+v", unknown)
+	}
+
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Receipt
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Error decoding %s: %+v", encoded, err)
+	}
+	if decoded.Token() != r.Token() || !decoded.ExpirationTime().Equal(r.ExpirationTime()) || len(decoded.UnknownFields()) != 1 {
+		t.Fatalf("Wrong decoded receipt: %s", encoded)
+	}
+
+	attest, err := authority.Receipt(testutil.ReceiptOptions{AppID: "TEAMID1234.com.example.app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := Parse(attest); err != nil || r.Type() != TypeAttest {
+		t.Fatalf("Wrong attestation receipt: %+v", err)
+	} else if _, ok := r.RiskMetric(); ok {
+		t.Fatal("Attestation receipt has a risk metric")
+	}
+
+	notSignedData, err := asn1.Marshal(contentInfo{ContentType: oidData})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"Not ASN.1":       []byte("receipt"),
+		"Not signed data": notSignedData,
+		"Trailing bytes":  append(append([]byte{}, data...), 0x00),
+	} {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package receipt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// OIDs of the signed attributes and algorithms of receipt signatures.
+var (
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidECPublicKey   = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSASHA384   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSASHA512   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+)
+
+// TimeNow is an indirection to allow tests to replace the current time
+var TimeNow = time.Now
+
+// Verifier verifies the signature and validity of receipts. The zero value is ready to use.
+type Verifier struct {
+	roots *x509.CertPool
+	clock utils.Clock
+	skew  time.Duration
+}
+
+// Option configures a Verifier.
+type Option func(*Verifier)
+
+// NewVerifier returns a Verifier configured with the given options.
+func NewVerifier(opts ...Option) *Verifier {
+	v := &Verifier{}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// WithRoots trusts receipts whose signing certificate chains to one of roots through the
+// certificates in the container, e.g. read with utils.ParseRootCAs, such as a test authority.
+// By default the signing chain must be the embedded Apple Root CA - G3 and Apple's receipt
+// signing CA, and the signer must be named AppleReceiptSignerCN.
+func WithRoots(roots *x509.CertPool) Option {
+	return func(v *Verifier) {
+		v.roots = roots
+	}
+}
+
+// WithClock sets the clock telling the current time to the checks of the signing chain and of
+// the expiration time. By default the system clock is used.
+func WithClock(clock utils.Clock) Option {
+	return func(v *Verifier) {
+		v.clock = clock
+	}
+}
+
+// WithClockSkew accepts signing certificates that are valid within d of the current time and
+// receipts that expired less than d ago, for servers whose clocks are slightly off.
+func WithClockSkew(d time.Duration) Option {
+	return func(v *Verifier) {
+		v.skew = d
+	}
+}
+
+// Verify parses the receipt and verifies it using a default Verifier. See Verifier.Verify.
+func Verify(data []byte) (*Receipt, error) {
+	return NewVerifier().Verify(data)
+}
+
+// Verify parses a receipt and verifies that its PKCS#7 signature is valid, that
+// the signing certificate chains to a trusted root using the certificates in the container,
+// that it has the fields of its type, see Receipt.Validate, and that it has not expired.
+func (v *Verifier) Verify(data []byte) (*Receipt, error) {
+	now := TimeNow()
+	if v.clock != nil {
+		now = v.clock.Now()
+	}
+	return v.VerifyAt(data, now, v.skew)
+}
+
+// VerifyAt is like Verify at the time now with a clock skew tolerance of skew, instead of the
+// clock and skew of the verifier, e.g. to use those of an attestation.Verifier.
+func (v *Verifier) VerifyAt(data []byte, now time.Time, skew time.Duration) (*Receipt, error) {
+	r, sd, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	if len(sd.Certificates.Bytes) > 0 {
+		if certs, err = x509.ParseCertificates(sd.Certificates.Bytes); err != nil {
+			return nil, utils.ErrReceipt.WithDetails(fmt.Sprintf("Error parsing the receipt certificates: %v", err))
+		}
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, utils.ErrReceipt.WithDetails(fmt.Sprintf("Receipt has %d signers, expected 1", len(sd.SignerInfos)))
+	}
+	si := sd.SignerInfos[0]
+	signer, err := findSigner(si, certs)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.checkSigner(signer); err != nil {
+		return nil, err
+	}
+	if err := verifySignature(si, signer, sd.ContentInfo.Content); err != nil {
+		return nil, err
+	}
+
+	if err := v.verifyChain(signer, certs, now, skew); err != nil {
+		return nil, err
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	if now.Add(-skew).After(r.expirationTime) {
+		return nil, utils.ErrReceiptExpired.WithDetails(fmt.Sprintf("Receipt expired at %s", r.expirationTime.Format(time.RFC3339)))
+	}
+	return r, nil
+}
+
+// VerifyRefresh verifies a receipt returned by Apple's server in exchange for previous, like
+// Verify and CheckRefresh.
+func (v *Verifier) VerifyRefresh(data []byte, previous *Receipt) (*Receipt, error) {
+	r, err := v.Verify(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckRefresh(previous, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// CheckRefresh checks that refreshed can replace previous: it must be a RECEIPT receipt for
+// the same App ID and key, issued after previous. This rejects an ATTEST receipt, or an older
+// receipt of the key, replayed as a refreshed one.
+func CheckRefresh(previous, refreshed *Receipt) error {
+	if refreshed.Type() != TypeReceipt {
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Refreshed receipt type %q is not %s", refreshed.Type(), TypeReceipt))
+	}
+	if refreshed.AppID() != previous.AppID() {
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Refreshed receipt was issued for App ID %q, not %q", refreshed.AppID(), previous.AppID()))
+	}
+	if !sameAttestedKey(previous, refreshed) {
+		return utils.ErrReceipt.WithDetails("Refreshed receipt was issued for another key")
+	}
+	if !refreshed.CreationTime().After(previous.CreationTime()) {
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Refreshed receipt was issued at %s, not after the previous receipt", refreshed.CreationTime().Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// sameAttestedKey reports whether the receipts were issued for the same key, which Apple may
+// encode differently in either receipt.
+func sameAttestedKey(a, b *Receipt) bool {
+	aValue, _ := a.Field(FieldAttestedPublicKey)
+	bValue, _ := b.Field(FieldAttestedPublicKey)
+	if bytes.Equal(aValue, bValue) {
+		return true
+	}
+	aKey, err := a.AttestedPublicKey()
+	if err != nil {
+		return false
+	}
+	bKey, err := b.AttestedPublicKey()
+	if err != nil {
+		return false
+	}
+	k, ok := aKey.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(bKey)
+}
+
+// verifyChain verifies that the signing certificate chains to a trusted root, at now or
+// within skew of it.
+func (v *Verifier) verifyChain(signer *x509.Certificate, certs []*x509.Certificate, now time.Time, skew time.Duration) error {
+	err := v.verifyChainAt(signer, certs, now)
+	if err != nil && skew > 0 {
+		for _, t := range []time.Time{now.Add(-skew), now.Add(skew)} {
+			if v.verifyChainAt(signer, certs, t) == nil {
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// checkSigner checks that the signing certificate was issued to sign receipts, which other
+// certificates of Apple's CAs, e.g. those of developers, are not.
+func (v *Verifier) checkSigner(signer *x509.Certificate) error {
+	marked := false
+	for _, ext := range signer.Extensions {
+		marked = marked || ext.Id.Equal(oidReceiptSigning)
+	}
+	switch {
+	case !marked:
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Certificate %q is not a receipt signing certificate", signer.Subject.CommonName))
+	case signer.IsCA || signer.KeyUsage&x509.KeyUsageDigitalSignature == 0:
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Certificate %q is not allowed to sign receipts", signer.Subject.CommonName))
+	case len(signer.ExtKeyUsage) > 0 || len(signer.UnknownExtKeyUsage) > 0:
+		// Apple's receipt signing certificates have no extended key usage.
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Receipt signing certificate %q has extended key usages", signer.Subject.CommonName))
+	case v.roots == nil && signer.Subject.CommonName != AppleReceiptSignerCN:
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Receipt signing certificate %q is not %q", signer.Subject.CommonName, AppleReceiptSignerCN))
+	}
+	return nil
+}
+
+// verifyChainAt verifies the signing chain at the time now.
+func (v *Verifier) verifyChainAt(signer *x509.Certificate, certs []*x509.Certificate, now time.Time) error {
+	roots, intermediates := v.roots, x509.NewCertPool()
+	if roots == nil {
+		// Only the embedded chain is trusted, whatever certificates the container holds.
+		root, err := appleRoot()
+		if err != nil {
+			return err
+		}
+		ca, err := appleReceiptCA()
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		roots.AddCert(root)
+		intermediates.AddCert(ca)
+	} else {
+		for _, cert := range certs {
+			if cert != signer {
+				intermediates.AddCert(cert)
+			}
+		}
+	}
+	// The signer has no extended key usage, so checkSigner checks its purpose instead.
+	_, err := signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Invalid receipt signing certificate: %v", err))
+	}
+	return nil
+}
+
+type issuerAndSerialNumber struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+// findSigner returns the certificate identified by the signer info.
+func findSigner(si signerInfo, certs []*x509.Certificate) (*x509.Certificate, error) {
+	sid := si.SignerIdentifier
+	for _, cert := range certs {
+		switch {
+		case sid.Class == asn1.ClassUniversal && sid.Tag == asn1.TagSequence:
+			var ias issuerAndSerialNumber
+			if _, err := asn1.Unmarshal(sid.FullBytes, &ias); err != nil {
+				return nil, utils.ErrReceipt.WithDetails(fmt.Sprintf("Error decoding the receipt signer: %v", err))
+			}
+			if bytes.Equal(ias.Issuer.FullBytes, cert.RawIssuer) && ias.Serial.Cmp(cert.SerialNumber) == 0 {
+				return cert, nil
+			}
+		case sid.Class == asn1.ClassContextSpecific && sid.Tag == 0:
+			if bytes.Equal(sid.Bytes, cert.SubjectKeyId) {
+				return cert, nil
+			}
+		}
+	}
+	return nil, utils.ErrReceipt.WithDetails("Receipt signing certificate not found")
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// verifySignature verifies the signature of the signer over the payload, or over the signed
+// attributes, which must then hold the digest of the payload.
+func verifySignature(si signerInfo, signer *x509.Certificate, payload []byte) error {
+	hash, ok := digestAlgorithms[si.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Unsupported receipt digest algorithm %v", si.DigestAlgorithm.Algorithm))
+	}
+	alg, err := signatureAlgorithm(si.SignatureAlgorithm, hash)
+	if err != nil {
+		return err
+	}
+
+	signed := payload
+	if len(si.SignedAttributes.FullBytes) > 0 {
+		var attrs []attribute
+		if _, err := asn1.UnmarshalWithParams(si.SignedAttributes.FullBytes, &attrs, "set,tag:0"); err != nil {
+			return utils.ErrReceipt.WithDetails(fmt.Sprintf("Error decoding the receipt signed attributes: %v", err))
+		}
+		if err := checkSignedAttributes(attrs, hash, payload); err != nil {
+			return err
+		}
+		// The signature covers the attributes with their SET tag instead of the implicit tag.
+		signed = append([]byte{0x31}, si.SignedAttributes.FullBytes[1:]...)
+	}
+
+	if err := signer.CheckSignature(alg, signed, si.Signature); err != nil {
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Invalid receipt signature: %v", err))
+	}
+	return nil
+}
+
+// checkSignedAttributes checks the content type and message digest attributes.
+func checkSignedAttributes(attrs []attribute, hash crypto.Hash, payload []byte) error {
+	var contentType asn1.ObjectIdentifier
+	var digest []byte
+	for _, attr := range attrs {
+		if len(attr.Values) != 1 {
+			continue
+		}
+		switch {
+		case attr.Type.Equal(oidContentType):
+			if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &contentType); err != nil {
+				return utils.ErrReceipt.WithDetails(fmt.Sprintf("Invalid receipt content type attribute: %v", err))
+			}
+		case attr.Type.Equal(oidMessageDigest):
+			if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &digest); err != nil {
+				return utils.ErrReceipt.WithDetails(fmt.Sprintf("Invalid receipt message digest attribute: %v", err))
+			}
+		}
+	}
+	if !contentType.Equal(oidData) {
+		return utils.ErrReceipt.WithDetails(fmt.Sprintf("Signed content type %v is not data", contentType))
+	}
+	h := hash.New()
+	h.Write(payload)
+	if !bytes.Equal(digest, h.Sum(nil)) {
+		return utils.ErrReceipt.WithDetails("Receipt payload does not match the signed digest")
+	}
+	return nil
+}
+
+var digestAlgorithms = map[string]crypto.Hash{
+	oidSHA256.String(): crypto.SHA256,
+	oidSHA384.String(): crypto.SHA384,
+	oidSHA512.String(): crypto.SHA512,
+}
+
+// signatureAlgorithm returns the x509 signature algorithm of the signer info, which either
+// names it or only names the key type and uses the digest algorithm.
+func signatureAlgorithm(id pkix.AlgorithmIdentifier, hash crypto.Hash) (x509.SignatureAlgorithm, error) {
+	switch {
+	case id.Algorithm.Equal(oidECDSASHA256):
+		return x509.ECDSAWithSHA256, nil
+	case id.Algorithm.Equal(oidECDSASHA384):
+		return x509.ECDSAWithSHA384, nil
+	case id.Algorithm.Equal(oidECDSASHA512):
+		return x509.ECDSAWithSHA512, nil
+	case id.Algorithm.Equal(oidSHA256WithRSA):
+		return x509.SHA256WithRSA, nil
+	case id.Algorithm.Equal(oidSHA384WithRSA):
+		return x509.SHA384WithRSA, nil
+	case id.Algorithm.Equal(oidSHA512WithRSA):
+		return x509.SHA512WithRSA, nil
+	case id.Algorithm.Equal(oidECPublicKey):
+		return map[crypto.Hash]x509.SignatureAlgorithm{
+			crypto.SHA256: x509.ECDSAWithSHA256, crypto.SHA384: x509.ECDSAWithSHA384, crypto.SHA512: x509.ECDSAWithSHA512,
+		}[hash], nil
+	case id.Algorithm.Equal(oidRSAEncryption):
+		return map[crypto.Hash]x509.SignatureAlgorithm{
+			crypto.SHA256: x509.SHA256WithRSA, crypto.SHA384: x509.SHA384WithRSA, crypto.SHA512: x509.SHA512WithRSA,
+		}[hash], nil
+	}
+	return x509.UnknownSignatureAlgorithm, utils.ErrReceipt.WithDetails(fmt.Sprintf("Unsupported receipt signature algorithm %v", id.Algorithm))
+}
@@ -0,0 +1,189 @@
+package receipt
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+func TestVerify(t *testing.T) {
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const appID = "TEAMID1234.com.example.app"
+	data, err := authority.Receipt(testutil.ReceiptOptions{AppID: appID, Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewVerifier(WithRoots(authority.Roots()))
+
+	t.Run("Valid receipt", func(t *testing.T) {
+		r, err := v.Verify(data)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if r.AppID() != appID {
+			t.Fatalf("Wrong App ID: %s", r.AppID())
+		}
+	})
+
+	t.Run("Untrusted signer", func(t *testing.T) {
+		if _, err := NewVerifier(WithRoots(other.Roots())).Verify(data); err == nil {
+			t.Fatal("Expected a receipt of another authority to be rejected")
+		}
+		// The test authority is not Apple, so the embedded Apple chain does not trust it either.
+		if _, err := Verify(data); err == nil {
+			t.Fatal("Expected a receipt of the test authority to be rejected with the Apple roots")
+		}
+	})
+
+	t.Run("Not a receipt signer", func(t *testing.T) {
+		// Apple's CAs also issue certificates to developers, which must not sign receipts.
+		developer := &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "Apple Development: Jane Doe", Organization: []string{"Apple Inc."}},
+			NotBefore:   authority.Intermediate.NotBefore,
+			NotAfter:    authority.Intermediate.NotAfter,
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		}
+		marked := &x509.Certificate{
+			Subject:         pkix.Name{CommonName: AppleReceiptSignerCN},
+			NotBefore:       authority.Intermediate.NotBefore,
+			NotAfter:        authority.Intermediate.NotAfter,
+			KeyUsage:        x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+			ExtraExtensions: []pkix.Extension{{Id: oidReceiptSigning, Value: []byte{0x05, 0x00}}},
+		}
+		for _, signer := range []*x509.Certificate{developer, marked} {
+			forged := mustReceipt(t, authority, testutil.ReceiptOptions{AppID: appID, Token: "token", Signer: signer})
+			_, err := v.Verify(forged)
+			var verr *utils.Error
+			if !errors.As(err, &verr) || verr.Type != utils.ErrReceipt.Type {
+				t.Fatalf("Expected a receipt signed by %q to be rejected, got %+v", signer.Subject.CommonName, err)
+			}
+		}
+	})
+
+	t.Run("Tampered payload", func(t *testing.T) {
+		tampered := bytes.Replace(data, []byte("TEAMID1234"), []byte("TEAMID9999"), 1)
+		_, err := v.Verify(tampered)
+		var verr *utils.Error
+		if !errors.As(err, &verr) || verr.Type != utils.ErrReceipt.Type {
+			t.Fatalf("Expected a receipt error, got %+v", err)
+		}
+	})
+
+	t.Run("Expired receipt", func(t *testing.T) {
+		TimeNow = func() time.Time { return time.Now().AddDate(0, 0, 91) }
+		defer func() { TimeNow = time.Now }()
+		if _, err := v.Verify(data); err == nil {
+			t.Fatal("Expected an expired receipt to be rejected")
+		}
+	})
+
+	t.Run("Clock and skew", func(t *testing.T) {
+		r, err := v.Verify(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expired := r.ExpirationTime().Add(time.Minute)
+		clock := utils.ClockFunc(func() time.Time { return expired })
+		if _, err := NewVerifier(WithRoots(authority.Roots()), WithClock(clock)).Verify(data); err == nil {
+			t.Fatal("Expected the clock to be used")
+		}
+		if _, err := NewVerifier(WithRoots(authority.Roots()), WithClock(clock), WithClockSkew(5*time.Minute)).Verify(data); err != nil {
+			t.Fatalf("Not valid within the skew: %+v", err)
+		}
+		if _, err := v.VerifyAt(data, expired, 0); err == nil {
+			t.Fatal("Expected VerifyAt to use the time given")
+		}
+		if _, err := v.VerifyAt(data, expired, 5*time.Minute); err != nil {
+			t.Fatalf("Not valid within the skew given: %+v", err)
+		}
+	})
+
+	t.Run("Refreshed receipt", func(t *testing.T) {
+		created := time.Now().AddDate(0, 0, -2)
+		previous, err := v.Verify(mustReceipt(t, authority, testutil.ReceiptOptions{AppID: appID, AttestedPublicKey: authority.Intermediate.Raw, CreationTime: created}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range []struct {
+			name  string
+			opts  testutil.ReceiptOptions
+			valid bool
+		}{
+			{"Valid", testutil.ReceiptOptions{Type: TypeReceipt, RiskMetric: 1}, true},
+			{"ATTEST receipt", testutil.ReceiptOptions{}, false},
+			{"Older receipt", testutil.ReceiptOptions{Type: TypeReceipt, CreationTime: created.Add(-time.Hour)}, false},
+			{"Other App ID", testutil.ReceiptOptions{Type: TypeReceipt, AppID: "TEAMID1234.com.example.other"}, false},
+			{"Other key", testutil.ReceiptOptions{Type: TypeReceipt, AttestedPublicKey: other.Intermediate.Raw}, false},
+		} {
+			if test.opts.AppID == "" {
+				test.opts.AppID = appID
+			}
+			if test.opts.AttestedPublicKey == nil {
+				test.opts.AttestedPublicKey = authority.Intermediate.Raw
+			}
+			_, err := v.VerifyRefresh(mustReceipt(t, authority, test.opts), previous)
+			if (err == nil) != test.valid {
+				t.Errorf("%s: expected valid %v, got %+v", test.name, test.valid, err)
+			}
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name   string
+		fields []Field
+		valid  bool
+	}{
+		{"Receipt", []Field{{Type: FieldType, Value: []byte(TypeReceipt)}, {Type: FieldRiskMetric}, {Type: FieldNotBefore}}, true},
+		{"Receipt without risk metric", []Field{{Type: FieldType, Value: []byte(TypeReceipt)}, {Type: FieldNotBefore}}, false},
+		{"Attestation", []Field{{Type: FieldType, Value: []byte(TypeAttest)}, {Type: FieldClientHash}}, true},
+		{"Attestation without client hash", []Field{{Type: FieldType, Value: []byte(TypeAttest)}}, false},
+		{"Unknown type", []Field{{Type: FieldType, Value: []byte("OTHER")}}, false},
+	} {
+		r := &Receipt{Fields: test.fields}
+		for _, typ := range []int{FieldAppID, FieldAttestedPublicKey, FieldToken, FieldCreationTime, FieldExpirationTime} {
+			r.Fields = append(r.Fields, Field{Type: typ})
+		}
+		if err := r.Validate(); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %+v", test.name, test.valid, err)
+		}
+	}
+
+	attest, err := Parse(mustReceipt(t, authority, testutil.ReceiptOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attest.hasRiskMetric = true
+	if err := attest.Validate(); err == nil {
+		t.Fatal("Expected an ATTEST receipt with a risk metric to be rejected")
+	}
+}
+
+func mustReceipt(t *testing.T, authority *testutil.Authority, opts testutil.ReceiptOptions) []byte {
+	t.Helper()
+	data, err := authority.Receipt(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
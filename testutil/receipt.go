@@ -0,0 +1,224 @@
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	// Marks Apple's receipt signing certificates
+	oidReceiptSigning = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 12, 15}
+)
+
+// ReceiptOptions describe the receipt to generate.
+type ReceiptOptions struct {
+	AppID string
+	// The value of the attested public key field, e.g. the DER-encoded credential certificate
+	AttestedPublicKey []byte
+	ClientHash        []byte
+	Token             string
+	// ATTEST if empty
+	Type string
+	// Only included in receipts whose type is not ATTEST
+	RiskMetric int
+	// Now if zero
+	CreationTime time.Time
+	// A day after the creation time if zero
+	NotBefore time.Time
+	// 90 days after the creation time if zero
+	ExpirationTime time.Time
+	// Values of additional fields by type, e.g. undocumented ones, with version 1
+	ExtraFields map[int][]byte
+	// The template of a certificate the intermediate issues to sign the receipt instead of the
+	// receipt signer, e.g. a certificate that is not meant to sign receipts
+	Signer *x509.Certificate
+}
+
+type receiptField struct {
+	Type    int
+	Version int
+	Value   []byte
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapsulatedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     []byte `asn1:"explicit,tag:0"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signerInfo struct {
+	Version            int
+	SignerIdentifier   issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttributes   asn1.RawValue `asn1:"tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// ReceiptSigner returns the certificate signing the authority's receipts, which is issued by
+// the intermediate on first use.
+func (a *Authority) ReceiptSigner() (*x509.Certificate, error) {
+	if a.receiptSigner != nil {
+		return a.receiptSigner, nil
+	}
+	key, err := a.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "Test App Attest Receipt Signing"},
+		NotBefore: a.Intermediate.NotBefore,
+		NotAfter:  a.Intermediate.NotAfter,
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+		// Apple marks its receipt signing certificates with an empty extension.
+		ExtraExtensions: []pkix.Extension{{Id: oidReceiptSigning, Value: []byte{0x05, 0x00}}},
+	}
+	cert, err := a.issue(template, a.Intermediate, &key.PublicKey, a.intermediateKey)
+	if err != nil {
+		return nil, err
+	}
+	a.receiptSigner, a.receiptKey = cert, key
+	return cert, nil
+}
+
+// Receipt generates a DER-encoded PKCS#7 receipt signed by the authority's receipt signer,
+// with the signer and the intermediate certificate in the container.
+func (a *Authority) Receipt(opts ReceiptOptions) ([]byte, error) {
+	signer, err := a.ReceiptSigner()
+	if err != nil {
+		return nil, err
+	}
+	signerKey := a.receiptKey
+	if opts.Signer != nil {
+		if signerKey, err = a.NewKey(); err != nil {
+			return nil, err
+		}
+		if signer, err = a.issue(opts.Signer, a.Intermediate, &signerKey.PublicKey, a.intermediateKey); err != nil {
+			return nil, err
+		}
+	}
+	payload, err := receiptPayload(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(payload)
+	contentType, err := asn1.Marshal(oidData)
+	if err != nil {
+		return nil, err
+	}
+	messageDigest, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := asn1.MarshalWithParams([]attribute{
+		{Type: oidContentType, Values: []asn1.RawValue{{FullBytes: contentType}}},
+		{Type: oidMessageDigest, Values: []asn1.RawValue{{FullBytes: messageDigest}}},
+	}, "set")
+	if err != nil {
+		return nil, err
+	}
+	// The signature covers the DER encoding of the attributes with the SET tag.
+	attrsDigest := sha256.Sum256(attrs)
+	signature, err := ecdsa.SignASN1(rand.Reader, signerKey, attrsDigest[:])
+	if err != nil {
+		return nil, err
+	}
+	var attrsSet asn1.RawValue
+	if _, err := asn1.Unmarshal(attrs, &attrsSet); err != nil {
+		return nil, err
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		ContentInfo:      encapsulatedContentInfo{ContentType: oidData, Content: payload},
+		Certificates: asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+			Bytes: append(append([]byte{}, signer.Raw...), a.Intermediate.Raw...),
+		},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SignerIdentifier:   issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: signer.RawIssuer}, Serial: signer.SerialNumber},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrsSet.Bytes},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidECDSASHA256},
+			Signature:          signature,
+		}},
+	}
+	sdDER, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: asn1.RawValue{
+		Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdDER,
+	}})
+}
+
+// receiptPayload encodes the fields of the receipt as an ASN.1 set.
+func receiptPayload(opts ReceiptOptions) ([]byte, error) {
+	if opts.Type == "" {
+		opts.Type = "ATTEST"
+	}
+	if opts.CreationTime.IsZero() {
+		opts.CreationTime = time.Now()
+	}
+	if opts.NotBefore.IsZero() {
+		opts.NotBefore = opts.CreationTime.Add(24 * time.Hour)
+	}
+	if opts.ExpirationTime.IsZero() {
+		opts.ExpirationTime = opts.CreationTime.AddDate(0, 0, 90)
+	}
+	fields := []receiptField{
+		{Type: 2, Version: 1, Value: []byte(opts.AppID)},
+		{Type: 3, Version: 1, Value: opts.AttestedPublicKey},
+		{Type: 4, Version: 1, Value: opts.ClientHash},
+		{Type: 5, Version: 1, Value: []byte(opts.Token)},
+		{Type: 6, Version: 1, Value: []byte(opts.Type)},
+		{Type: 12, Version: 1, Value: []byte(opts.CreationTime.UTC().Format(time.RFC3339))},
+		{Type: 19, Version: 1, Value: []byte(opts.NotBefore.UTC().Format(time.RFC3339))},
+		{Type: 21, Version: 1, Value: []byte(opts.ExpirationTime.UTC().Format(time.RFC3339))},
+	}
+	if opts.Type != "ATTEST" {
+		fields = append(fields, receiptField{Type: 17, Version: 1, Value: []byte(strconv.Itoa(opts.RiskMetric))})
+	}
+	for typ, value := range opts.ExtraFields {
+		fields = append(fields, receiptField{Type: typ, Version: 1, Value: value})
+	}
+	return asn1.MarshalWithParams(fields, "set")
+}
@@ -0,0 +1,297 @@
+// Package testutil generates synthetic App Attest attestations and assertions signed by a
+// test certificate authority, so verification can be tested end to end without devices.
+// Attestations from this package only verify against the authority's own roots and must
+// never be trusted in production.
+package testutil
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// The OID of the credential certificate extension holding the nonce.
+var nonceOID = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 2}
+
+// Authority is a test certificate authority issuing credential certificates through an
+// intermediate, like Apple's App Attest CA.
+type Authority struct {
+	Root         *x509.Certificate
+	Intermediate *x509.Certificate
+
+	rootKey         *ecdsa.PrivateKey
+	intermediateKey *ecdsa.PrivateKey
+	rand            io.Reader
+
+	receiptSigner *x509.Certificate
+	receiptKey    *ecdsa.PrivateKey
+}
+
+// NewAuthority creates a root and an intermediate certificate, valid from a year ago for ten
+// years. Keys and serial numbers are read from rand, which defaults to crypto/rand.Reader,
+// so a fixed reader produces the same keys and key IDs on every run. Signatures always use
+// the crypto packages' own randomness.
+func NewAuthority(rand io.Reader) (*Authority, error) {
+	a := &Authority{rand: rand}
+	if a.rand == nil {
+		a.rand = defaultRand
+	}
+
+	var err error
+	if a.rootKey, err = a.NewKey(); err != nil {
+		return nil, err
+	}
+	if a.intermediateKey, err = a.NewKey(); err != nil {
+		return nil, err
+	}
+
+	notBefore := time.Now().AddDate(-1, 0, 0)
+	root := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test App Attestation Root CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if a.Root, err = a.issue(root, root, &a.rootKey.PublicKey, a.rootKey); err != nil {
+		return nil, err
+	}
+
+	intermediate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test App Attestation CA 1"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	if a.Intermediate, err = a.issue(intermediate, a.Root, &a.intermediateKey.PublicKey, a.rootKey); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+var defaultRand = rand.Reader
+
+// Roots returns a pool containing the authority's root certificate.
+func (a *Authority) Roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(a.Root)
+	return pool
+}
+
+// NewKey returns a P-256 key derived from the authority's random source.
+func (a *Authority) NewKey() (*ecdsa.PrivateKey, error) {
+	seed := make([]byte, 32)
+	for {
+		if _, err := io.ReadFull(a.rand, seed); err != nil {
+			return nil, err
+		}
+		// Rejects the rare scalars that are out of range.
+		key, err := ecdh.P256().NewPrivateKey(seed)
+		if err != nil {
+			continue
+		}
+		x, y := elliptic.Unmarshal(elliptic.P256(), key.PublicKey().Bytes())
+		return &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y},
+			D:         new(big.Int).SetBytes(seed),
+		}, nil
+	}
+}
+
+// issue signs the template with the parent's key, using a serial number from the random source.
+func (a *Authority) issue(template, parent *x509.Certificate, pub *ecdsa.PublicKey, priv *ecdsa.PrivateKey) (*x509.Certificate, error) {
+	serial := make([]byte, 8)
+	if _, err := io.ReadFull(a.rand, serial); err != nil {
+		return nil, err
+	}
+	template.SerialNumber = new(big.Int).SetBytes(serial)
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, priv)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// Device is an App Attest key generated by the authority.
+type Device struct {
+	Key *ecdsa.PrivateKey
+	// SHA256 hash of the x963-encoded public key
+	KeyID []byte
+}
+
+// NewDevice generates a device key.
+func (a *Authority) NewDevice() (*Device, error) {
+	key, err := a.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	return newDevice(key), nil
+}
+
+func newDevice(key *ecdsa.PrivateKey) *Device {
+	keyID := sha256.Sum256(elliptic.Marshal(key.Curve, key.X, key.Y))
+	return &Device{Key: key, KeyID: keyID[:]}
+}
+
+// PublicKey returns the x963-encoded public key of the device.
+func (d *Device) PublicKey() []byte {
+	return elliptic.Marshal(d.Key.Curve, d.Key.X, d.Key.Y)
+}
+
+// AttestOptions describe the attestation to generate.
+type AttestOptions struct {
+	AppID      string
+	ClientData []byte
+	Production bool
+	// Start of the credential certificate validity, now if zero. It is valid for three days.
+	NotBefore time.Time
+	Receipt   []byte
+	// Modify is called with the credential certificate template before it is signed.
+	Modify func(leaf *x509.Certificate)
+	// OmitIntermediate leaves the intermediate certificate out of x5c.
+	OmitIntermediate bool
+}
+
+// Attestation is a generated attestation.
+type Attestation struct {
+	// The CBOR-encoded attestation object
+	Object     []byte
+	KeyID      []byte
+	ClientData []byte
+	Leaf       *x509.Certificate
+}
+
+// JSON returns the attestation as the JSON body accepted by attestation.AuthenticatorAttestationResponse.
+func (att *Attestation) JSON() []byte {
+	body, _ := json.Marshal(map[string]string{
+		"attestationObject": base64.RawURLEncoding.EncodeToString(att.Object),
+		"keyID":             base64.StdEncoding.EncodeToString(att.KeyID),
+		"clientData":        base64.RawURLEncoding.EncodeToString(att.ClientData),
+	})
+	return body
+}
+
+// Attest generates an attestation of the device's key.
+func (a *Authority) Attest(d *Device, opts AttestOptions) (*Attestation, error) {
+	authData := AuthData(opts.AppID, byte(0x40), 0)
+	aaguid := make([]byte, 16)
+	if opts.Production {
+		copy(aaguid, "appattest")
+	} else {
+		copy(aaguid, "appattestdevelop")
+	}
+	authData = append(authData, aaguid...)
+	authData = append(authData, byte(len(d.KeyID)>>8), byte(len(d.KeyID)))
+	authData = append(authData, d.KeyID...)
+	authData = append(authData, COSEKey(&d.Key.PublicKey)...)
+
+	clientDataHash := sha256.Sum256(opts.ClientData)
+	nonce := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	octet, err := asn1.Marshal(nonce[:])
+	if err != nil {
+		return nil, err
+	}
+	extension, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: octet}})
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := opts.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now()
+	}
+	template := &x509.Certificate{
+		Subject:         pkix.Name{CommonName: base64.RawURLEncoding.EncodeToString(d.KeyID)},
+		NotBefore:       notBefore,
+		NotAfter:        notBefore.AddDate(0, 0, 3),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{{Id: nonceOID, Value: extension}},
+	}
+	if opts.Modify != nil {
+		opts.Modify(template)
+	}
+	leaf, err := a.issue(template, a.Intermediate, &d.Key.PublicKey, a.intermediateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	x5c := []interface{}{leaf.Raw}
+	if !opts.OmitIntermediate {
+		x5c = append(x5c, a.Intermediate.Raw)
+	}
+	receipt := opts.Receipt
+	if receipt == nil {
+		receipt = []byte{}
+	}
+	object, err := encode(map[string]interface{}{
+		"fmt":      "apple-appattest",
+		"attStmt":  map[string]interface{}{"x5c": x5c, "receipt": receipt},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Attestation{Object: object, KeyID: d.KeyID, ClientData: opts.ClientData, Leaf: leaf}, nil
+}
+
+// Assert generates an assertion over the client data.
+func (d *Device) Assert(appID string, clientData []byte, counter uint32) ([]byte, error) {
+	// Apple sets the attested credential data flag on assertions.
+	authData := AuthData(appID, byte(0x40), counter)
+	clientDataHash := sha256.Sum256(clientData)
+	nonce := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	return d.AssertNonce(authData, nonce[:])
+}
+
+// AssertNonce signs the nonce and returns the assertion for the given authenticator data.
+func (d *Device) AssertNonce(authData []byte, nonce []byte) ([]byte, error) {
+	digest := sha256.Sum256(nonce)
+	signature, err := ecdsa.SignASN1(rand.Reader, d.Key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return encode(map[string]interface{}{
+		"signature":         signature,
+		"authenticatorData": authData,
+	})
+}
+
+// AuthData returns the 37 bytes of authenticator data without attested credential data.
+func AuthData(appID string, flags byte, counter uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(appID))
+	authData := append(rpIDHash[:], flags)
+	return append(authData, byte(counter>>24), byte(counter>>16), byte(counter>>8), byte(counter))
+}
+
+// COSEKey returns the CBOR-encoded COSE key of an ES256 public key.
+func COSEKey(pub *ecdsa.PublicKey) []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+	key := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	key = append(key, x...)
+	key = append(key, 0x22, 0x58, 0x20)
+	return append(key, y...)
+}
+
+func encode(v interface{}) ([]byte, error) {
+	return utils.MarshalCBOR(v)
+}
@@ -0,0 +1,79 @@
+package testutil_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"github.com/jyrodrigues/appattest/assertion"
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/testutil"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+const appID = "TEAMID1234.com.example.app"
+
+func TestFixedRandomSource(t *testing.T) {
+	generate := func() (*testutil.Authority, *testutil.Device) {
+		authority, err := testutil.NewAuthority(rand.New(rand.NewSource(42)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		device, err := authority.NewDevice()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return authority, device
+	}
+
+	a1, d1 := generate()
+	a2, d2 := generate()
+	if !bytes.Equal(d1.KeyID, d2.KeyID) {
+		t.Fatalf("Key IDs differ: %x %x", d1.KeyID, d2.KeyID)
+	}
+	if a1.Root.SerialNumber.Cmp(a2.Root.SerialNumber) != 0 || !bytes.Equal(a1.Root.RawSubjectPublicKeyInfo, a2.Root.RawSubjectPublicKeyInfo) {
+		t.Fatal("Roots differ")
+	}
+}
+
+func TestGeneratedAttestationVerifies(t *testing.T) {
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	att, err := authority.Attest(device, testutil.AttestOptions{AppID: appID, ClientData: []byte("challenge")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aar := attestation.AuthenticatorAttestationResponse{}
+	if err := json.Unmarshal(att.JSON(), &aar); err != nil {
+		t.Fatal(err)
+	}
+	v := attestation.NewVerifier(attestation.WithChainVerifier(attestation.X509ChainVerifier{Roots: authority.Roots()}))
+	result, err := v.Verify(&aar, appID, false)
+	if err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	if !bytes.Equal(result.PublicKey, device.PublicKey()) {
+		t.Fatal("Wrong public key")
+	}
+
+	clientData := []byte(`{"challenge":"assertion"}`)
+	object, err := device.Assert(appID, clientData, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asr := assertion.AuthenticatorAssertionResponse{
+		RawClientData: utils.URLEncodedBase64(clientData),
+		Assertion:     utils.URLEncodedBase64(object),
+	}
+	if _, err := asr.Verify("assertion", appID, 0, result.PublicKey); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+}
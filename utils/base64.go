@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"reflect"
+	"strings"
 )
 
 // URLEncodedBase64 represents a byte slice holding URL-encoded base64 data.
@@ -37,3 +38,13 @@ func (data URLEncodedBase64) MarshalJSON() ([]byte, error) {
 	}
 	return []byte(`"` + base64.RawURLEncoding.EncodeToString(data) + `"`), nil
 }
+
+// DecodeBase64 decodes standard or URL-safe base64, with or without padding, as sent by
+// clients that do not agree on one encoding.
+func DecodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -29,3 +29,20 @@ const base64JSON = `{
 	"unpadded": "dW5wYWRkZWRkYXRh",
 	"padded": "cGFkZGVkZGF0YQ=="
 }`
+
+func TestDecodeBase64(t *testing.T) {
+	// 0xfb 0xff encodes to +/8= in standard and -_8= in URL-safe base64.
+	expected := []byte{0xfb, 0xff}
+	for _, s := range []string{"+/8=", "+/8", "-_8=", "-_8"} {
+		decoded, err := DecodeBase64(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if string(decoded) != string(expected) {
+			t.Fatalf("%s: wrong value %x", s, decoded)
+		}
+	}
+	if _, err := DecodeBase64("+_8"); err == nil {
+		t.Fatal("Expected mixed encodings to be rejected")
+	}
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"github.com/fxamacker/cbor/v2"
+)
+
+var (
+	// cborEncMode encodes CBOR in the canonical form of CTAP2, which sorts map keys like the
+	// COSE keys Apple produces, so re-encoding a key does not change its bytes.
+	cborEncMode cbor.EncMode
+	// cborDecMode matches struct fields by their exact name or json tag.
+	cborDecMode cbor.DecMode
+)
+
+func init() {
+	var err error
+	if cborEncMode, err = cbor.CTAP2EncOptions().EncMode(); err != nil {
+		panic(err)
+	}
+	if cborDecMode, err = (cbor.DecOptions{FieldNameMatching: cbor.FieldNameMatchingCaseSensitive}).DecMode(); err != nil {
+		panic(err)
+	}
+}
+
+// MarshalCBOR returns the canonical CBOR encoding of v.
+func MarshalCBOR(v interface{}) ([]byte, error) {
+	return cborEncMode.Marshal(v)
+}
+
+// UnmarshalCBOR decodes the single CBOR data item of data into v. Trailing bytes are an error.
+func UnmarshalCBOR(data []byte, v interface{}) error {
+	return cborDecMode.Unmarshal(data, v)
+}
+
+// UnmarshalCBORFirst decodes the first CBOR data item of data into v and returns the bytes
+// that follow it.
+func UnmarshalCBORFirst(data []byte, v interface{}) ([]byte, error) {
+	return cborDecMode.UnmarshalFirst(data, v)
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCBOR(t *testing.T) {
+	// A COSE key with its labels in Apple's order: 1, 3, -1, -2, -3.
+	key := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x41, 0xaa, 0x22, 0x41, 0xbb}
+	var decoded interface{}
+	if err := UnmarshalCBOR(key, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := MarshalCBOR(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, key) {
+		t.Fatalf("Re-encoding changed the key: %x", encoded)
+	}
+
+	trailing := append(append([]byte{}, key...), 0x01)
+	if err := UnmarshalCBOR(trailing, &decoded); err == nil {
+		t.Fatal("Expected trailing bytes to be rejected")
+	}
+	rest, err := UnmarshalCBORFirst(trailing, &decoded)
+	if err != nil || !bytes.Equal(rest, []byte{0x01}) {
+		t.Fatalf("Expected the trailing byte to be returned, got %x %v", rest, err)
+	}
+}
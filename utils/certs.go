@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+)
+
+// ParseCertificates parses a PEM bundle, which may hold other blocks such as comments or
+// keys, or one or more concatenated DER certificates. Errors tell the position of the
+// offending certificate, counting from 1.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, ErrBadRequest.WithDetails("No certificate found")
+	}
+	if data[0] == 0x30 {
+		// A DER certificate starts with a SEQUENCE.
+		var certs []*x509.Certificate
+		for rest := data; len(rest) > 0; {
+			var raw asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &raw); err != nil {
+				return nil, ErrBadRequest.WithDetails(fmt.Sprintf("DER certificate %d is malformed: %v", len(certs)+1, err))
+			}
+			ct, err := x509.ParseCertificate(raw.FullBytes)
+			if err != nil {
+				return nil, ErrBadRequest.WithDetails(fmt.Sprintf("DER certificate %d is malformed: %v", len(certs)+1, err))
+			}
+			certs = append(certs, ct)
+		}
+		return certs, nil
+	}
+
+	var certs []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ct, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, ErrBadRequest.WithDetails(fmt.Sprintf("Certificate %d of the PEM bundle is malformed: %v", len(certs)+1, err))
+		}
+		certs = append(certs, ct)
+	}
+	if len(certs) == 0 {
+		return nil, ErrBadRequest.WithDetails("No PEM certificate found")
+	}
+	return certs, nil
+}
+
+// ParseRootCAs returns a pool of the root certificates in data, in any format
+// ParseCertificates accepts, e.g. to pass to WithRootCAs of the verifiers. Every certificate
+// must be a CA.
+func ParseRootCAs(data []byte) (*x509.CertPool, error) {
+	certs, err := ParseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	for i, ct := range certs {
+		if !ct.IsCA {
+			return nil, ErrBadRequest.WithDetails(fmt.Sprintf("Certificate %d %q is not a CA", i+1, ct.Subject.CommonName))
+		}
+		pool.AddCert(ct)
+	}
+	return pool, nil
+}
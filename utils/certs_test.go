@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, cn string, ca bool) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestParseCertificates(t *testing.T) {
+	root1, root2, leaf := testCertificate(t, "Root 1", true), testCertificate(t, "Root 2", true), testCertificate(t, "Leaf", false)
+	encode := func(blockType string, der []byte) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	}
+	bundle := bytes.Join([][]byte{[]byte("# Test roots\n"), encode("CERTIFICATE", root1), encode("PRIVATE KEY", []byte{1}), encode("CERTIFICATE", root2)}, nil)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		certs int
+		err   string
+	}{
+		{"Single PEM", encode("CERTIFICATE", root1), 1, ""},
+		{"PEM bundle", bundle, 2, ""},
+		{"Single DER", root1, 1, ""},
+		{"Concatenated DER", append(append([]byte{}, root1...), root2...), 2, ""},
+		{"Malformed PEM certificate", append(encode("CERTIFICATE", root1), encode("CERTIFICATE", []byte{0x30, 0})...), 0, "Certificate 2 of the PEM bundle"},
+		{"Truncated DER", append(append([]byte{}, root1...), root2[:20]...), 0, "DER certificate 2"},
+		{"Not a certificate", []byte("not a certificate"), 0, "No PEM certificate"},
+		{"Empty", nil, 0, "No certificate"},
+	}
+	for _, tt := range tests {
+		certs, err := ParseCertificates(tt.data)
+		if tt.err == "" && (err != nil || len(certs) != tt.certs) {
+			t.Fatalf("%s: expected %d certificates, got %d %+v", tt.name, tt.certs, len(certs), err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Fatalf("%s: expected an error about %q, got %+v", tt.name, tt.err, err)
+		}
+	}
+
+	if _, err := ParseRootCAs(bundle); err != nil {
+		t.Fatalf("Not valid: %+v", err)
+	}
+	_, err := ParseRootCAs(append(encode("CERTIFICATE", root1), encode("CERTIFICATE", leaf)...))
+	if err == nil || !strings.Contains(err.Error(), `Certificate 2 "Leaf" is not a CA`) {
+		t.Fatalf("Expected the leaf to be rejected, got %+v", err)
+	}
+}
@@ -0,0 +1,18 @@
+package utils
+
+import "time"
+
+// Clock tells the current time to the time-dependent checks of the verifiers, such as
+// certificate validity and receipt expiry, e.g. to use a time synchronized with a trusted
+// source instead of the system clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a function such as time.Now to a Clock.
+type ClockFunc func() time.Time
+
+// Now returns f().
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
@@ -38,10 +38,74 @@ var (
 		Type:    "invalid_attestation",
 		Details: "Invalid attestation format",
 	}
+	ErrNonceMismatch = &Error{
+		Type:    "nonce_mismatch",
+		Details: "The nonce in the credential certificate does not match the attestation",
+	}
+	ErrAttestationStale = &Error{
+		Type:    "stale_attestation",
+		Details: "Attestation is older than the maximum allowed age",
+	}
+	ErrBlockedKey = &Error{
+		Type:    "blocked_key",
+		Details: "The attested public key is blocked",
+	}
 	ErrAttestationCertificate = &Error{
 		Type:    "invalid_certificate",
 		Details: "Invalid attestation certificate",
 	}
+	ErrCertificateRevoked = &Error{
+		Type:    "revoked_certificate",
+		Details: "A certificate of the attestation chain is revoked",
+	}
+	ErrCredentialMismatch = &Error{
+		Type:    "credential_mismatch",
+		Details: "Stored credential does not match the attestation",
+	}
+	ErrAssertionDenied = &Error{
+		Type:    "assertion_denied",
+		Details: "The assertion was denied",
+	}
+	ErrKeyRegistered = &Error{
+		Type:    "key_already_registered",
+		Details: "A credential is already registered for the key ID",
+	}
+	ErrUnknownCredential = &Error{
+		Type:    "unknown_credential",
+		Details: "No credential is stored for the key ID",
+	}
+	ErrAlgorithmMismatch = &Error{
+		Type:    "algorithm_mismatch",
+		Details: "Assertion was not signed with the attested algorithm",
+	}
+	ErrUnsupportedAlgorithm = &Error{
+		Type:    "unsupported_algorithm",
+		Details: "The attestation uses a key or signature algorithm other than App Attest's",
+	}
+	ErrAssertionReplay = &Error{
+		Type:    "replayed_assertion",
+		Details: "Assertion was already used",
+	}
+	ErrReceipt = &Error{
+		Type:    "invalid_receipt",
+		Details: "The receipt is not valid",
+	}
+	ErrReceiptExpired = &Error{
+		Type:    "receipt_expired",
+		Details: "The receipt has expired, the key must be attested again",
+	}
+	ErrAppleServer = &Error{
+		Type:    "apple_server_error",
+		Details: "Apple's server rejected the request",
+	}
+	ErrRateLimited = &Error{
+		Type:    "rate_limited",
+		Details: "Apple's server throttled the requests",
+	}
+	ErrNoRiskMetric = &Error{
+		Type:    "no_risk_metric",
+		Details: "The receipt has no risk metric",
+	}
 	ErrAssertionSignature = &Error{
 		Type:    "invalid_signature",
 		Details: "Assertion Signature against auth data and client hash is not valid",
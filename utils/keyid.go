@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// KeyIDLength is the length in bytes of a key ID, the SHA256 hash of the public key.
+const KeyIDLength = 32
+
+// KeyIDString encodes a key ID as unpadded URL-safe base64, which is stable and safe to use
+// as a database key or in URLs.
+func KeyIDString(keyID []byte) string {
+	return base64.RawURLEncoding.EncodeToString(keyID)
+}
+
+// KeyIDFromString decodes a key ID encoded by KeyIDString and checks its length.
+func KeyIDFromString(s string) ([]byte, error) {
+	keyID, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrParsingData.WithDetails(fmt.Sprintf("Key ID is not valid URL-safe base64: %v", err))
+	}
+	if len(keyID) != KeyIDLength {
+		return nil, ErrParsingData.WithDetails(fmt.Sprintf("Key ID must be %d bytes, not %d", KeyIDLength, len(keyID)))
+	}
+	return keyID, nil
+}
+
+// DecodeKeyID decodes a key ID in any base64 variant, such as the standard base64 key ID
+// that DCAppAttestService returns, and checks its length.
+func DecodeKeyID(s string) ([]byte, error) {
+	keyID, err := DecodeBase64(s)
+	if err != nil {
+		return nil, ErrParsingData.WithDetails(fmt.Sprintf("Key ID is not valid base64: %v", err))
+	}
+	if len(keyID) != KeyIDLength {
+		return nil, ErrParsingData.WithDetails(fmt.Sprintf("Key ID must be %d bytes, not %d", KeyIDLength, len(keyID)))
+	}
+	return keyID, nil
+}
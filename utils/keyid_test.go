@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+func TestKeyIDString(t *testing.T) {
+	keyID := sha256.Sum256([]byte("public key"))
+
+	s := KeyIDString(keyID[:])
+	if len(s) != 43 {
+		t.Fatalf("Expected 43 characters, got %d: %s", len(s), s)
+	}
+	decoded, err := KeyIDFromString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, keyID[:]) {
+		t.Fatalf("Round trip changed the key ID: %x", decoded)
+	}
+
+	for _, invalid := range []string{"", "not base64!", KeyIDString(keyID[:31]), KeyIDString(append(keyID[:], 0)), s + "="} {
+		if _, err := KeyIDFromString(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestDecodeKeyID(t *testing.T) {
+	keyID := sha256.Sum256([]byte("public key"))
+	for _, s := range []string{
+		base64.StdEncoding.EncodeToString(keyID[:]),
+		base64.RawStdEncoding.EncodeToString(keyID[:]),
+		base64.URLEncoding.EncodeToString(keyID[:]),
+		base64.RawURLEncoding.EncodeToString(keyID[:]),
+	} {
+		decoded, err := DecodeKeyID(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if !bytes.Equal(decoded, keyID[:]) {
+			t.Fatalf("%s: wrong key ID %x", s, decoded)
+		}
+	}
+	if _, err := DecodeKeyID(base64.StdEncoding.EncodeToString(keyID[:16])); err == nil {
+		t.Fatal("Expected a short key ID to be rejected")
+	}
+}
@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+)
+
+// ReadLimited reads r until EOF, failing as soon as more than maxSize bytes were read, so
+// that oversized input is never buffered completely.
+func ReadLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, ErrBadRequest.WithDetails(err.Error())
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrBadRequest.WithDetails(fmt.Sprintf("Input exceeds the maximum size of %d bytes", maxSize))
+	}
+	return data, nil
+}
@@ -0,0 +1,16 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadLimited(t *testing.T) {
+	data, err := ReadLimited(strings.NewReader("12345"), 5)
+	if err != nil || string(data) != "12345" {
+		t.Fatalf("Expected input of the maximum size to be read, got %q, %v", data, err)
+	}
+	if _, err := ReadLimited(strings.NewReader("123456"), 5); err == nil {
+		t.Fatal("Expected oversized input to be rejected")
+	}
+}
@@ -0,0 +1,90 @@
+package appattest
+
+import (
+	"context"
+	"crypto/sha256"
+
+	"github.com/jyrodrigues/appattest/assertion"
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/utils"
+)
+
+// Expected holds the values a payload is verified against. Which fields are used depends on
+// the kind of the payload.
+type Expected struct {
+	// The App ID, i.e. team ID and bundle ID separated by a dot
+	AppID string
+	// Whether attestations are expected from the production environment
+	Production bool
+	// The client data the app passed to attestKey or generateAssertion
+	ClientData []byte
+
+	// The decoded key identifier sent along with an attestation
+	KeyID []byte
+
+	// The challenge expected in the JSON client data of an assertion
+	Challenge string
+	// The credential stored for the key after attestation, which assertions are verified with
+	Credential *attestation.Credential
+}
+
+// Result is the outcome of DetectAndVerify. Kind tells which of the results is set.
+type Result struct {
+	Kind        Kind
+	Attestation *attestation.AttestationResult
+	Assertion   *assertion.AssertionResult
+}
+
+// Verifiers are the verifiers DetectAndVerify routes payloads to. A nil verifier is replaced
+// by a default one.
+type Verifiers struct {
+	Attestation *attestation.Verifier
+	Assertion   *assertion.Verifier
+}
+
+// DetectAndVerify verifies a payload with default verifiers. See Verifiers.DetectAndVerify.
+func DetectAndVerify(ctx context.Context, payload []byte, expected *Expected) (*Result, error) {
+	return Verifiers{}.DetectAndVerify(ctx, payload, expected)
+}
+
+// DetectAndVerify detects whether the CBOR-encoded payload is an attestation object or an
+// assertion, like Decode, and verifies it with the matching verifier. Raw authenticator data
+// carries no signature and cannot be verified on its own: it is rejected, with the detected
+// kind reported in the result.
+func (vs Verifiers) DetectAndVerify(ctx context.Context, payload []byte, expected *Expected) (*Result, error) {
+	if expected == nil {
+		return nil, utils.ErrBadRequest.WithDetails("Missing expected values")
+	}
+	kind, _, err := decode(payload)
+	if err != nil {
+		return &Result{Kind: kind}, err
+	}
+	result := &Result{Kind: kind}
+
+	switch kind {
+	case KindAttestation:
+		v := vs.Attestation
+		if v == nil {
+			v = attestation.NewVerifier()
+		}
+		clientDataHash := sha256.Sum256(expected.ClientData)
+		result.Attestation, err = v.VerifyAttestationContext(ctx, payload, expected.KeyID, clientDataHash[:], expected.AppID, expected.Production)
+	case KindAssertion:
+		if expected.Credential == nil {
+			return result, utils.ErrBadRequest.WithDetails("Missing credential to verify the assertion with")
+		}
+		v := vs.Assertion
+		if v == nil {
+			v = assertion.NewVerifier()
+		}
+		aar := &assertion.AuthenticatorAssertionResponse{RawClientData: expected.ClientData, Assertion: payload}
+		var counter uint32
+		counter, err = v.VerifyCredentialContext(ctx, aar, expected.Challenge, expected.AppID, expected.Credential)
+		if err == nil {
+			result.Assertion = &assertion.AssertionResult{Counter: counter}
+		}
+	default:
+		err = utils.ErrParsingData.WithDetails("Authenticator data alone cannot be verified")
+	}
+	return result, err
+}
@@ -0,0 +1,72 @@
+package appattest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jyrodrigues/appattest/attestation"
+	"github.com/jyrodrigues/appattest/authenticator"
+	"github.com/jyrodrigues/appattest/testutil"
+)
+
+func TestDetectAndVerify(t *testing.T) {
+	const appID = "TEAMID1234.com.example.app"
+	ctx := context.Background()
+	authority, err := testutil.NewAuthority(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := authority.NewDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	att, err := authority.Attest(device, testutil.AttestOptions{AppID: appID, ClientData: []byte("challenge")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := []byte(`{"challenge":"challenge"}`)
+	assertionObject, err := device.Assert(appID, clientData, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs := Verifiers{
+		Attestation: attestation.NewVerifier(attestation.WithChainVerifier(attestation.X509ChainVerifier{Roots: authority.Roots()})),
+	}
+
+	t.Run("Attestation", func(t *testing.T) {
+		result, err := vs.DetectAndVerify(ctx, att.Object, &Expected{AppID: appID, ClientData: att.ClientData, KeyID: device.KeyID})
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if result.Kind != KindAttestation || result.Attestation == nil || result.Assertion != nil {
+			t.Fatalf("Wrong result: %+v", result)
+		}
+	})
+
+	t.Run("Assertion", func(t *testing.T) {
+		cred := &attestation.Credential{KeyID: device.KeyID, PublicKey: device.PublicKey(), Algorithm: authenticator.AlgES256}
+		expected := &Expected{AppID: appID, ClientData: clientData, Challenge: "challenge", Credential: cred}
+		result, err := vs.DetectAndVerify(ctx, assertionObject, expected)
+		if err != nil {
+			t.Fatalf("Not valid: %+v", err)
+		}
+		if result.Kind != KindAssertion || result.Assertion == nil || result.Assertion.Counter != 1 {
+			t.Fatalf("Wrong result: %+v", result)
+		}
+
+		expected.Credential = nil
+		if _, err := vs.DetectAndVerify(ctx, assertionObject, expected); err == nil {
+			t.Fatal("Expected an error without a credential")
+		}
+	})
+
+	t.Run("Authenticator data", func(t *testing.T) {
+		result, err := vs.DetectAndVerify(ctx, testutil.AuthData(appID, 0x01, 1), &Expected{AppID: appID})
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		if result.Kind != KindAuthenticatorData {
+			t.Fatalf("Wrong kind %s", result.Kind)
+		}
+	})
+}